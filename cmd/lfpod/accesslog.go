@@ -0,0 +1,62 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLog logs every request either to the standard logger or, if
+// out is set, to out in the combined log format.
+type AccessLog struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (a *AccessLog) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		if a.out == nil {
+			log.Printf("%s %s %s %d %d %s", r.RemoteAddr, r.Method, redactSecrets(r.URL.RequestURI()),
+				sw.status, sw.bytes, time.Since(start).Round(time.Millisecond))
+			return
+		}
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		if _, err := io.WriteString(a.out, combinedLogLine(r, sw, start)); err != nil {
+			log.Print(err)
+		}
+	})
+}
+
+func combinedLogLine(r *http.Request, sw *statusWriter, start time.Time) string {
+	host := clientIP(r)
+	user := "-"
+	if name, _, ok := r.BasicAuth(); ok && name != "" {
+		user = name
+	}
+	referer := r.Referer()
+	if referer == "" {
+		referer = "-"
+	}
+	size := "-"
+	if sw.bytes > 0 {
+		size = strconv.FormatInt(sw.bytes, 10)
+	}
+	return fmt.Sprintf("%s - %s [%s] \"%s %s %s\" %d %s %q %q\n",
+		host, user, start.Format("02/Jan/2006:15:04:05 -0700"),
+		r.Method, redactSecrets(r.URL.RequestURI()), r.Proto, sw.status, size,
+		referer, r.UserAgent())
+}
@@ -0,0 +1,126 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+
+	"github.com/lfpod/youtube"
+)
+
+var (
+	errNoVideo         = errors.New("no YouTube video URL or id")
+	errVideoDownloaded = errors.New("video is already downloaded")
+	errVideoQueued     = errors.New("video is already queued")
+	errVideoBlocked    = errors.New("video is blocked after permanent failures")
+)
+
+// parseVideoId returns the video id of a YouTube video URL or id.
+func parseVideoId(s string) (string, error) {
+	ids := inboxVideoIds(s)
+	if len(ids) != 1 {
+		return "", errNoVideo
+	}
+	return ids[0], nil
+}
+
+// queueVideo queues a video of any channel for download into a feed,
+// listed in it with the videos of the channel feed. Titles and dates
+// come from the video metadata.
+func queueVideo(ctx context.Context, feed ConfFeed, videoId, reason string) error {
+	switch {
+	case feed.HasAudioFile(videoId) || downloadArchive.Has(feed.ChannelId, videoId) || pruned.Has(videoId):
+		return errVideoDownloaded
+	case retries.Has(videoId):
+		return errVideoQueued
+	case blocked.Has(videoId):
+		return errVideoBlocked
+	}
+	retries.Queue(feed.ChannelId, &youtube.Entry{VideoId: videoId}, reason)
+	logStore(episodes.SetAdded(ctx, feed.ChannelId, videoId))
+	return nil
+}
+
+// videoFeed returns the feed named name, or the inbox feed if name is
+// empty.
+func videoFeed(conf *Conf, name string) (ConfFeed, error) {
+	if name == "" {
+		if feed, ok := conf.InboxFeed(); ok {
+			return feed, nil
+		}
+		return ConfFeed{}, errors.New("feed is required without an inbox feed")
+	}
+	if feed, ok := findFeed(conf.AllFeeds(), name); ok {
+		return feed, nil
+	}
+	return ConfFeed{}, fmt.Errorf("feed %q not found", name)
+}
+
+func apiVideoAddHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	videoId, err := parseVideoId(r.FormValue("url"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error(), r.FormValue("url"))
+		return
+	}
+	name := r.FormValue("feed")
+	feed, err := videoFeed(conf, name)
+	if err != nil && name == "" {
+		apiError(w, http.StatusBadRequest, err.Error(), nil)
+		return
+	} else if err != nil {
+		apiError(w, http.StatusNotFound, "feed not found", name)
+		return
+	}
+	if serveOnly {
+		apiError(w, http.StatusServiceUnavailable, "updates run in a separate process", nil)
+		return
+	}
+	if err := queueVideo(r.Context(), feed, videoId, "added"); err != nil {
+		apiError(w, http.StatusConflict, err.Error(), videoId)
+		return
+	}
+	triggerUpdate(UpdateRequest{ChannelId: feed.ChannelId})
+	writeJSON(w, http.StatusAccepted, struct {
+		VideoId string `json:"video_id"`
+		Feed    string `json:"feed"`
+		Channel string `json:"channel"`
+	}{videoId, feed.Name, feed.ChannelId})
+}
+
+// runAddVideo implements the add-video command, downloading the video
+// with an update of the feed.
+func runAddVideo(ctx context.Context, conf *Conf, args []string) error {
+	fs := flag.NewFlagSet("add-video", flag.ExitOnError)
+	name := fs.String("feed", "", "Feed name, slug or channel id, the inbox feed if empty.")
+	verbose := fs.Bool("v", false, "Log pipeline details to stderr.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod add-video [-feed name] [-v] <video URL or id>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("video is required")
+	}
+	videoId, err := parseVideoId(fs.Arg(0))
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	feed, err := videoFeed(conf, *name)
+	if err != nil {
+		return err
+	}
+	if err := queueVideo(ctx, feed, videoId, "added"); err != nil {
+		return fmt.Errorf("%s: %w", videoId, err)
+	}
+	update := []string{feed.ChannelId}
+	if *verbose {
+		update = append([]string{"-v"}, update...)
+	}
+	return runUpdate(ctx, conf, update)
+}
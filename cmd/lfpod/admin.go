@@ -0,0 +1,137 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/lfpod/store"
+)
+
+var adminTemplate = template.Must(template.New("admin").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>lfpod</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: auto; padding: 1em; }
+table { border-collapse: collapse; width: 100%; }
+td, th { text-align: left; padding: .2em .5em; border-bottom: 1px solid #ddd; }
+form.inline { display: inline; }
+</style>
+</head>
+<body>
+<h1>lfpod</h1>
+{{if .Paused}}<p><b>Updates are paused.</b></p>{{end}}
+<form method="post" action="admin/update"><button>Update now</button></form>
+
+<h2>Feeds</h2>
+<table>
+<tr><th>Name</th><th>Channel</th><th>Keywords</th><th>Downloads</th><th></th></tr>
+{{range .Feeds}}
+<tr>
+<td>{{.Name}}{{if .Disabled}} (disabled){{end}}</td>
+<td><a href="https://www.youtube.com/channel/{{.ChannelId}}">{{.ChannelId}}</a></td>
+<td>{{range $i, $k := .Keywords}}{{if $i}}, {{end}}{{$k}}{{end}}</td>
+<td>{{with index $.Plays .ChannelId}}{{.Downloads}}{{if .Last}}, last {{.Last.Format "2006-01-02"}}{{end}}{{end}}</td>
+<td><form class="inline" method="post" action="admin/feeds/{{.ChannelId}}/delete"><button>Remove</button></form></td>
+</tr>
+{{end}}
+</table>
+
+<h2>Add feed</h2>
+<form method="post" action="admin/feeds">
+<p><label>Name <input name="name" required></label></p>
+<p><label>Channel id <input name="channel_id" required pattern="UC[A-Za-z0-9_-]{22}"></label></p>
+<p><label>Keywords, comma separated <input name="keywords"></label></p>
+<p><button>Add</button></p>
+</form>
+
+<h2>Recent episodes</h2>
+<table>
+<tr><th>Published</th><th>Channel</th><th>Title</th><th>Status</th><th>Downloads</th></tr>
+{{range .Episodes}}
+<tr><td>{{.Published}}</td><td>{{.ChannelId}}</td><td>{{.Title}}</td><td>{{.Status}}</td><td>{{index $.Downloads .VideoId}}</td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+func adminGetHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Paused    bool
+		Feeds     []ConfFeed
+		Episodes  []store.Episode
+		Plays     map[string]FeedPlays
+		Downloads map[string]int
+	}{pause.Active(), conf.AllFeeds(), listEpisodes(r.Context(), ""), map[string]FeedPlays{}, map[string]int{}}
+	report := playReport(conf)
+	for _, f := range report.Feeds {
+		data.Plays[f.ChannelId] = f
+	}
+	for _, s := range report.Episodes {
+		data.Downloads[s.VideoId] = s.Downloads
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := adminTemplate.Execute(w, data); err != nil {
+		log.Print(err)
+	}
+}
+
+func adminAddFeedHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	feed := ConfFeed{
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		ChannelId: strings.TrimSpace(r.FormValue("channel_id")),
+	}
+	for _, k := range strings.Split(r.FormValue("keywords"), ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			feed.Keywords = append(feed.Keywords, k)
+		}
+	}
+	if msg := validateFeed(feed); msg != "" {
+		http.Error(w, msg, http.StatusBadRequest)
+		return
+	}
+	added, err := conf.AddFeeds([]ConfFeed{feed})
+	if err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if added == 0 {
+		http.Error(w, "channel "+feed.ChannelId+" already has a feed", http.StatusConflict)
+		return
+	}
+	log.Print("feed ", feed.Name, " added")
+	triggerUpdate(UpdateRequest{ChannelId: feed.ChannelId})
+	http.Redirect(w, r, "../admin", http.StatusSeeOther)
+}
+
+func adminDeleteFeedHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	channelId := mux.Vars(r)["channelId"]
+	if _, err := conf.DeleteFeed(channelId); err != nil {
+		log.Print(err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Print("feed ", channelId, " removed")
+	http.Redirect(w, r, "../../../admin", http.StatusSeeOther)
+}
+
+func adminUpdateHandler(w http.ResponseWriter, r *http.Request) {
+	triggerUpdate(UpdateRequest{})
+	http.Redirect(w, r, "../admin", http.StatusSeeOther)
+}
+
+func confHandlerWrapper(conf *Conf, handler func(*Conf, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		handler(conf, w, r)
+	}
+}
@@ -0,0 +1,29 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAdminAddFeed(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.ConfFeedsFile = "feeds.json"
+	conf.Feeds[0].Keywords = []string{"news"}
+	form := url.Values{"name": {"other"}, "channel_id": {testChannelId}}
+	r := httptest.NewRequest(http.MethodPost, "/admin/feeds", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	adminAddFeedHandler(conf, w, r)
+	if w.Code != http.StatusConflict {
+		t.Errorf("adding a known channel: status %d", w.Code)
+	}
+	if feeds := conf.AllFeeds(); len(feeds) != 1 || feeds[0].Name != "test" || len(feeds[0].Keywords) != 1 {
+		t.Errorf("feed replaced: %+v", feeds)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lfpod/youtube"
+)
+
+// Bitrates of alternate enclosures, in kbit/s.
+const (
+	minAlternateBitrate = 6
+	maxAlternateBitrate = 512
+)
+
+// alternatePrefix returns the start of the file names of the alternate
+// enclosures of a video, <video id>.alt<kbps>k.<format>. Like parts they
+// are not audio files to the archive scans.
+func alternatePrefix(videoId string) string {
+	return videoId + ".alt"
+}
+
+// alternateFileName returns the audio of a video at another bitrate.
+func alternateFileName(channelId, videoId string, kbps int, ext string) string {
+	return filepath.Join("audio", channelId, fmt.Sprintf("%s%dk.%s", alternatePrefix(videoId), kbps, ext))
+}
+
+// recodeAlternates recodes the downloaded audio of a video at the
+// alternate bitrates of the feed. A failed bitrate is left out.
+func recodeAlternates(ctx context.Context, feed *ConfFeed, entry *youtube.Entry, info *episodeMetadata, fileIn string) {
+	removeAlternates(feed.ChannelId, entry.VideoId)
+	for _, kbps := range feed.AlternateBitrates {
+		name := alternateFileName(feed.ChannelId, entry.VideoId, kbps, feed.AudioFormat().Ext)
+		if err := recodeAudio(ctx, feed, entry, info, fileIn, name, strconv.Itoa(kbps)+"k"); err != nil {
+			log.Printf("%s %dk alternate: %v", entry.VideoId, kbps, err)
+			continue
+		}
+		if err := storage.Store(name); err != nil {
+			log.Print(entry.VideoId, " upload: ", err)
+		}
+	}
+}
+
+// removeAlternates deletes the alternate enclosures of a video.
+func removeAlternates(channelId, videoId string) {
+	names, _ := filepath.Glob(filepath.Join("audio", channelId, alternatePrefix(videoId)+"*"))
+	for _, name := range names {
+		storage.Remove(name)
+		os.Remove(name)
+	}
+}
+
+// AlternateEnclosure is the audio of an episode at another bitrate.
+type AlternateEnclosure struct {
+	File    string
+	Format  AudioFormat
+	Size    int64
+	Bitrate int
+}
+
+// episodeAlternates returns the alternate enclosures of a video, lowest
+// bitrate first.
+func episodeAlternates(channelId, videoId string) []AlternateEnclosure {
+	files := episodeIndex.files(channelId)
+	list := []AlternateEnclosure{}
+	for _, name := range episodeIndex.WithPrefix(channelId, alternatePrefix(videoId)) {
+		rate, ext, ok := strings.Cut(strings.TrimPrefix(name, alternatePrefix(videoId)), "k.")
+		kbps, err := strconv.Atoi(rate)
+		format, known := audioFormats[ext]
+		if !ok || err != nil || !known {
+			continue
+		}
+		list = append(list, AlternateEnclosure{filepath.Join("audio", channelId, name), format, files[name].size, kbps * 1000})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Bitrate < list[j].Bitrate })
+	return list
+}
+
+// bitrate returns the bitrate of the format in bit/s.
+func (f AudioFormat) bitrate() int {
+	kbps, _ := strconv.Atoi(strings.TrimSuffix(f.Rate, "k"))
+	return kbps * 1000
+}
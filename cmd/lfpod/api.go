@@ -0,0 +1,642 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Token required by the management API and the admin UI. When it is
+// empty, only requests from loopback addresses are allowed.
+var apiToken string
+
+// Token required instead of apiToken by requests changing state, so the
+// API token given to read-only clients cannot manage the server. It is
+// accepted for reading too.
+var adminToken string
+
+func requestToken(r *http.Request) string {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+func isLoopback(r *http.Request) bool {
+	ip := net.ParseIP(clientIP(r))
+	return ip != nil && ip.IsLoopback()
+}
+
+func isMutating(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return false
+	}
+	return true
+}
+
+// allowedTokens returns the tokens accepted for a request, none if
+// neither is configured.
+func allowedTokens(r *http.Request) []string {
+	tokens := []string{}
+	if adminToken != "" {
+		tokens = append(tokens, adminToken)
+	}
+	if apiToken != "" && (adminToken == "" || !isMutating(r)) {
+		tokens = append(tokens, apiToken)
+	}
+	return tokens
+}
+
+// requestHost returns the host a request was sent to, the one
+// forwarded by a trusted proxy if any.
+func requestHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if ip := net.ParseIP(host); ip != nil && inNetworks(ip, trustedProxies) {
+		if h := r.Header.Get("X-Forwarded-Host"); h != "" {
+			return h
+		}
+	}
+	return r.Host
+}
+
+// sameOrigin reports whether a request comes from a page of this
+// server, of an origin listed for CORS, or from no page at all.
+// Browsers send the Origin header with cross-site requests, form posts
+// included, and pages cannot forge it.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || r.Context().Value(corsOriginKey{}) != nil {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, requestHost(r))
+}
+
+// isLocalHost reports whether a request names the server by address or
+// as localhost. Pages of a domain rebound to a loopback address send
+// their own domain name, the browser treating them as same-origin.
+func isLocalHost(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		host = r.Host
+	}
+	host = strings.TrimSuffix(strings.Trim(host, "[]"), ".")
+	return net.ParseIP(host) != nil || host == "localhost" || strings.HasSuffix(host, ".localhost")
+}
+
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !sameOrigin(r) {
+			apiError(w, http.StatusForbidden, "cross-origin requests are not allowed", r.Header.Get("Origin"))
+			return
+		}
+		tokens := allowedTokens(r)
+		if len(tokens) == 0 {
+			if !isLoopback(r) {
+				apiError(w, http.StatusForbidden, "API token is not configured, only local access allowed", nil)
+				return
+			}
+			if !isLocalHost(r) {
+				apiError(w, http.StatusForbidden, "API token is not configured, only localhost is allowed as host", r.Host)
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		token := requestToken(r)
+		for _, t := range tokens {
+			if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+				next.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Basic realm="lfpod"`)
+		if apiToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(apiToken)) == 1 {
+			apiError(w, http.StatusForbidden, "the admin token is required for changes", nil)
+			return
+		}
+		apiError(w, http.StatusUnauthorized, "invalid or missing token", nil)
+	})
+}
+
+// jsonBodyMiddleware answers 415 to requests with a body that is not
+// JSON. Browsers send form and text bodies cross-site without asking
+// the server first, JSON ones only if it allows them, which it does not.
+func jsonBodyMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength != 0 {
+			mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+			if mediaType != "application/json" {
+				apiError(w, http.StatusUnsupportedMediaType, "request body must be application/json", r.Header.Get("Content-Type"))
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkAdminToken reports an admin token shared with the API or a
+// profile feed, which are stored by less trusted clients.
+func checkAdminToken(profiles []Profile) error {
+	if adminToken == "" {
+		return nil
+	}
+	if adminToken == apiToken {
+		return errors.New("-admin-token must differ from -api-token")
+	}
+	for _, p := range profiles {
+		if adminToken == p.Token || containsString(p.Secrets, adminToken) {
+			return fmt.Errorf("-admin-token must differ from the token and secrets of profile %s", p.Name)
+		}
+	}
+	return nil
+}
+
+// APIError is the body of every error response of the API.
+type APIError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	Retryable bool        `json:"retryable"`
+}
+
+var apiErrorCodes = map[int]string{
+	http.StatusBadRequest:          "bad_request",
+	http.StatusUnauthorized:        "unauthorized",
+	http.StatusForbidden:           "forbidden",
+	http.StatusNotFound:            "not_found",
+	http.StatusMethodNotAllowed:    "method_not_allowed",
+	http.StatusConflict:            "conflict",
+	http.StatusTooManyRequests:     "rate_limited",
+	http.StatusInternalServerError: "internal",
+	http.StatusBadGateway:          "upstream_error",
+	http.StatusServiceUnavailable:  "unavailable",
+}
+
+// apiError writes an error envelope, the error code is derived from
+// the HTTP status. Server-side and throttling errors are retryable.
+func apiError(w http.ResponseWriter, status int, message string, details interface{}) {
+	code, ok := apiErrorCodes[status]
+	if !ok {
+		code = strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+	}
+	retryable := status >= 500 || status == http.StatusTooManyRequests
+	writeJSON(w, status, struct {
+		Error APIError `json:"error"`
+	}{APIError{code, message, details, retryable}})
+}
+
+func apiNotFoundHandler(w http.ResponseWriter, r *http.Request) {
+	apiError(w, http.StatusNotFound, "no such endpoint", r.URL.Path)
+}
+
+func apiMethodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
+	apiError(w, http.StatusMethodNotAllowed, "method not allowed", r.Method)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Print(err)
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInt(list []int, n int) bool {
+	for _, v := range list {
+		if v == n {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFloat(list []float64, f float64) bool {
+	for _, v := range list {
+		if v == f {
+			return true
+		}
+	}
+	return false
+}
+
+func validateFeed(feed ConfFeed) string {
+	if feed.Name == "" {
+		return "name is required"
+	}
+	if feed.ChannelId == "" {
+		return "channel_id is required"
+	}
+	if strings.ContainsAny(feed.ChannelId, `/\.`) {
+		return "invalid channel_id"
+	}
+	if feed.Slug != "" && (!slugRegexp.MatchString(feed.Slug) || feed.Slug == "archive") {
+		return "slug must be lowercase letters, digits and dashes"
+	}
+	if _, err := parseMatch(feed.Match); err != nil {
+		return "invalid match: " + err.Error()
+	}
+	if f, ok := audioFormats[feed.Format]; feed.Format != "" && (!ok || f.Video) {
+		return "unknown format " + feed.Format
+	}
+	if feed.Media != "" && !containsString(mediaTypes, feed.Media) {
+		return fmt.Sprintf("media must be one of %v", mediaTypes)
+	}
+	if feed.VideoHeightMax < 0 {
+		return "video_height must not be negative"
+	}
+	if feed.IsVideo() && (feed.Format != "" || feed.Speed > 0 && feed.Speed != 1 || feed.TrimSilence != 0) {
+		return "format, speed and trim_silence are for audio feeds only"
+	}
+	for _, kbps := range feed.AlternateBitrates {
+		if kbps < minAlternateBitrate || kbps > maxAlternateBitrate {
+			return fmt.Sprintf("alternate_bitrates must be from %d to %d", minAlternateBitrate, maxAlternateBitrate)
+		}
+	}
+	if feed.IsVideo() && len(feed.AlternateBitrates) > 0 {
+		return "alternate_bitrates are for audio feeds only"
+	}
+	if feed.MusicBitrate != 0 && (feed.MusicBitrate < minAlternateBitrate || feed.MusicBitrate > maxAlternateBitrate) {
+		return fmt.Sprintf("music_bitrate must be from %d to %d", minAlternateBitrate, maxAlternateBitrate)
+	}
+	if feed.IsVideo() && feed.MusicBitrate != 0 {
+		return "music_bitrate is for audio feeds only"
+	}
+	if rates := feed.AudioFormat().SampleRates(); feed.SampleRate != 0 && !containsInt(rates, feed.SampleRate) {
+		return fmt.Sprintf("sample_rate of %s must be one of %v", feed.AudioFormat().Ext, rates)
+	}
+	if feed.OpusVBR != "" && !containsString(opusVBRModes, feed.OpusVBR) {
+		return "opus_vbr must be one of " + strings.Join(opusVBRModes, ", ")
+	}
+	if feed.OpusApplication != "" && !containsString(opusApplications, feed.OpusApplication) {
+		return "opus_application must be one of " + strings.Join(opusApplications, ", ")
+	}
+	if d := feed.OpusFrameDuration; d != 0 && !containsFloat(opusFrameDurations, d) {
+		return fmt.Sprintf("opus_frame_duration must be one of %v", opusFrameDurations)
+	}
+	if feed.Schedule != "" {
+		if _, err := parseCron(feed.Schedule); err != nil {
+			return err.Error()
+		}
+	}
+	if feed.Jitter < 0 {
+		return "jitter must not be negative"
+	}
+	if err := checkSeasons(feed); err != nil {
+		return err.Error()
+	}
+	if err := checkDirectoryInfo(feed); err != nil {
+		return err.Error()
+	}
+	if feed.Speed != 0 && (feed.Speed < 0.25 || feed.Speed > 4) {
+		return "speed must be from 0.25 to 4"
+	}
+	if feed.KeepEpisodes < 0 || feed.MaxAge < 0 || feed.MaxStorage < 0 {
+		return "retention limits must not be negative"
+	}
+	if feed.Weight < 0 {
+		return "weight must not be negative"
+	}
+	if feed.MaxFileSize < 0 {
+		return "max_file_size must not be negative"
+	}
+	for _, lang := range feed.CaptionLangs {
+		if lang == "" || strings.ContainsAny(lang, ", ") {
+			return fmt.Sprintf("invalid caption language %q", lang)
+		}
+	}
+	if feed.OnRemoved != "" && feed.OnRemoved != "keep" && feed.OnRemoved != "delete" {
+		return `on_removed must be "keep" or "delete"`
+	}
+	if feed.TrimSilence > 0 {
+		return "trim_silence must be a negative dB threshold"
+	}
+	if feed.Proxy != "" {
+		if _, err := parseProxy(feed.Proxy); err != nil {
+			return err.Error()
+		}
+	}
+	if feed.GeoVerificationProxy != "" {
+		if _, err := parseProxy(feed.GeoVerificationProxy); err != nil {
+			return "geo_verification_proxy: " + err.Error()
+		}
+	}
+	if feed.GeoCountry != "" && !countryCodeRegexp.MatchString(feed.GeoCountry) {
+		return "geo_country must be a two-letter country code"
+	}
+	for _, category := range feed.SponsorBlock {
+		if !containsString(sponsorBlockCategories, category) {
+			return "unknown sponsorblock category " + category
+		}
+	}
+	return ""
+}
+
+// checkRawArgs rejects raw yt-dlp and ffmpeg arguments and the yt-dlp
+// cookies and proxies set through the API: options like --exec run
+// commands on the host, and yt-dlp writes the cookie jar back to the
+// cookies file, so it could overwrite any file lfpod can write. They
+// are set in the configuration file only, a feed read from the API with
+// the admin token is accepted back with them unchanged.
+func checkRawArgs(feed, old ConfFeed) string {
+	if strings.Join(feed.DownloaderArgs, "\x00") != strings.Join(old.DownloaderArgs, "\x00") ||
+		strings.Join(feed.ConverterArgs, "\x00") != strings.Join(old.ConverterArgs, "\x00") ||
+		feed.AudioFilters != old.AudioFilters {
+		return "downloader_args, converter_args and audio_filters are set in the configuration file only"
+	}
+	if feed.Cookies != old.Cookies || feed.CookiesFromBrowser != old.CookiesFromBrowser ||
+		feed.Proxy != old.Proxy || feed.GeoVerificationProxy != old.GeoVerificationProxy {
+		return "cookies, cookies_from_browser, proxy and geo_verification_proxy are set in the configuration file only"
+	}
+	return ""
+}
+
+// isAdmin reports whether a request carries the admin token, any
+// accepted token if there is none.
+func isAdmin(r *http.Request) bool {
+	return adminToken == "" || subtle.ConstantTimeCompare([]byte(requestToken(r)), []byte(adminToken)) == 1
+}
+
+// redactFeed hides the cookies and the proxy credentials of a feed
+// from read-only API clients.
+func redactFeed(feed ConfFeed) ConfFeed {
+	feed.Cookies, feed.CookiesFromBrowser = "", ""
+	for _, proxy := range []*string{&feed.Proxy, &feed.GeoVerificationProxy} {
+		if u, err := url.Parse(*proxy); err == nil && u.User != nil {
+			u.User = nil
+			*proxy = u.String()
+		}
+	}
+	return feed
+}
+
+func apiFeedsGetHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	feeds := conf.AllFeeds()
+	if !isAdmin(r) {
+		for i := range feeds {
+			feeds[i] = redactFeed(feeds[i])
+		}
+	}
+	writeJSON(w, http.StatusOK, feeds)
+}
+
+func apiFeedGetHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	feed, ok := conf.GetFeed(mux.Vars(r)["id"])
+	if !ok {
+		apiError(w, http.StatusNotFound, "feed not found", mux.Vars(r)["id"])
+		return
+	}
+	if !isAdmin(r) {
+		feed = redactFeed(feed)
+	}
+	writeJSON(w, http.StatusOK, feed)
+}
+
+func apiFeedStatsHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	feed, ok := conf.GetFeed(mux.Vars(r)["id"])
+	if !ok {
+		apiError(w, http.StatusNotFound, "feed not found", mux.Vars(r)["id"])
+		return
+	}
+	writeJSON(w, http.StatusOK, loopStatus.FeedStats(feed))
+}
+
+func apiFeedPutHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	feed := ConfFeed{}
+	if err := json.NewDecoder(r.Body).Decode(&feed); err != nil {
+		apiError(w, http.StatusBadRequest, "invalid feed JSON", err.Error())
+		return
+	}
+	if id, ok := mux.Vars(r)["id"]; ok {
+		if feed.ChannelId == "" {
+			feed.ChannelId = id
+		} else if feed.ChannelId != id {
+			apiError(w, http.StatusBadRequest, "channel_id does not match the URL", feed.ChannelId)
+			return
+		}
+		if _, ok := conf.GetFeed(id); !ok {
+			apiError(w, http.StatusNotFound, "feed not found", mux.Vars(r)["id"])
+			return
+		}
+	}
+	if msg := validateFeed(feed); msg != "" {
+		apiError(w, http.StatusBadRequest, msg, nil)
+		return
+	}
+	old, _ := conf.GetFeed(feed.ChannelId)
+	if msg := checkRawArgs(feed, old); msg != "" {
+		apiError(w, http.StatusForbidden, msg, nil)
+		return
+	}
+	if other, ok := conf.FeedBySlug(feed.Slug); ok && other.ChannelId != feed.ChannelId {
+		apiError(w, http.StatusConflict, "slug is used by another feed", other.Name)
+		return
+	}
+	_, exists := conf.GetFeed(feed.ChannelId)
+	if err := conf.PutFeed(feed); err != nil {
+		log.Print(err)
+		apiError(w, http.StatusInternalServerError, "cannot save configuration", err.Error())
+		return
+	}
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+		log.Print("feed ", feed.Name, " added")
+		triggerUpdate(UpdateRequest{ChannelId: feed.ChannelId})
+	} else {
+		log.Print("feed ", feed.Name, " updated")
+	}
+	writeJSON(w, status, feed)
+}
+
+func apiFeedDeleteHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	found, err := conf.DeleteFeed(id)
+	if err != nil {
+		log.Print(err)
+		apiError(w, http.StatusInternalServerError, "cannot save configuration", err.Error())
+		return
+	}
+	if !found {
+		apiError(w, http.StatusNotFound, "feed not found", mux.Vars(r)["id"])
+		return
+	}
+	log.Print("feed ", id, " removed")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func apiEpisodesGetHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if _, ok := conf.GetFeed(id); !ok {
+		apiError(w, http.StatusNotFound, "feed not found", mux.Vars(r)["id"])
+		return
+	}
+	writeJSON(w, http.StatusOK, listEpisodes(r.Context(), id))
+}
+
+// apiUpdateHandler wakes the update loop. If an update is already
+// running, the request is queued with queue=1 and rejected otherwise.
+func apiUpdateHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	channelId := r.FormValue("channel")
+	if channelId != "" {
+		if _, ok := conf.GetFeed(channelId); !ok {
+			apiError(w, http.StatusNotFound, "feed not found", channelId)
+			return
+		}
+	}
+	queue := r.FormValue("queue")
+	if updateRunning.Load() && queue != "1" && queue != "true" {
+		apiError(w, http.StatusConflict, "update is already running", nil)
+		return
+	}
+	backfill, refresh := r.FormValue("backfill"), r.FormValue("refresh")
+	req := UpdateRequest{
+		ChannelId: channelId,
+		Backfill:  backfill == "1" || backfill == "true",
+		Refresh:   refresh == "1" || refresh == "true",
+	}
+	if serveOnly {
+		apiError(w, http.StatusServiceUnavailable, "updates run in a separate process", nil)
+		return
+	}
+	if !triggerUpdate(req) {
+		apiError(w, http.StatusConflict, "update is already queued", nil)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, struct {
+		Channel  string `json:"channel,omitempty"`
+		Backfill bool   `json:"backfill"`
+		Refresh  bool   `json:"refresh"`
+		Queued   bool   `json:"queued"`
+	}{channelId, req.Backfill, req.Refresh, updateRunning.Load()})
+}
+
+type apiParam struct {
+	Name        string
+	Description string
+}
+
+// apiRoute describes an API endpoint, it is used both to register the
+// handler and to build the OpenAPI document.
+type apiRoute struct {
+	Method   string
+	Path     string
+	Summary  string
+	Query    []apiParam
+	Request  string
+	Status   int
+	Response string
+	Handler  http.HandlerFunc
+}
+
+func apiRoutes(conf *Conf) []apiRoute {
+	return []apiRoute{
+		{"GET", "/feeds", "List feeds", nil, "", http.StatusOK, "FeedList",
+			confHandlerWrapper(conf, apiFeedsGetHandler)},
+		{"POST", "/feeds", "Add or replace a feed", nil, "Feed", http.StatusCreated, "Feed",
+			confHandlerWrapper(conf, apiFeedPutHandler)},
+		{"GET", "/feeds/{id}", "Get a feed", nil, "", http.StatusOK, "Feed",
+			confHandlerWrapper(conf, apiFeedGetHandler)},
+		{"PUT", "/feeds/{id}", "Update a feed", nil, "Feed", http.StatusOK, "Feed",
+			confHandlerWrapper(conf, apiFeedPutHandler)},
+		{"DELETE", "/feeds/{id}", "Remove a feed", nil, "", http.StatusNoContent, "",
+			confHandlerWrapper(conf, apiFeedDeleteHandler)},
+		{"GET", "/feeds/{id}/episodes", "List recently seen episodes of a feed", nil, "", http.StatusOK, "EpisodeList",
+			confHandlerWrapper(conf, apiEpisodesGetHandler)},
+		{"GET", "/feeds/{id}/stats", "Get the health of a feed", nil, "", http.StatusOK, "FeedStats",
+			confHandlerWrapper(conf, apiFeedStatsHandler)},
+		{"DELETE", "/episodes/{channelId}/{videoId}", "Delete a downloaded episode", []apiParam{
+			{"refetch", "Download the video again on the next update, 1 or true."},
+		}, "", http.StatusNoContent, "", confHandlerWrapper(conf, apiEpisodeDeleteHandler)},
+		{"POST", "/episodes/{channelId}/{videoId}/redownload", "Delete an episode and download it again", nil, "",
+			http.StatusAccepted, "Redownload", confHandlerWrapper(conf, apiEpisodeRedownloadHandler)},
+		{"POST", "/update", "Start an update", []apiParam{
+			{"channel", "Update only the feed with this channel id."},
+			{"queue", "Queue the update if one is already running, 1 or true."},
+			{"backfill", "Download videos older than the feed ignore_older_than window, 1 or true."},
+			{"refresh", "Read titles, descriptions and thumbnails of downloaded episodes from YouTube again, 1 or true."},
+		}, "", http.StatusAccepted, "UpdateStatus", confHandlerWrapper(conf, apiUpdateHandler)},
+		{"POST", "/videos", "Download a single video into a feed", []apiParam{
+			{"url", "YouTube video URL or id."},
+			{"feed", "Feed name, slug or channel id, the inbox feed if omitted."},
+		}, "", http.StatusAccepted, "AddedVideo", confHandlerWrapper(conf, apiVideoAddHandler)},
+		{"POST", "/share/{channelId}/{videoId}", "Create a share link for an episode", []apiParam{
+			{"ttl", "Link lifetime as duration, 168h by default."},
+		}, "", http.StatusCreated, "ShareLink", confHandlerWrapper(conf, apiShareHandler)},
+		{"GET", "/discover", "Search YouTube channels", []apiParam{
+			{"q", "Search query."},
+			{"limit", "Maximum number of channels, 5 by default."},
+		}, "", http.StatusOK, "ChannelCandidateList", apiDiscoverHandler},
+		{"GET", "/search", "Search YouTube channels, or resolve a channel handle or URL", []apiParam{
+			{"q", "Search query, @handle or channel URL."},
+			{"limit", "Maximum number of channels, 5 by default."},
+		}, "", http.StatusOK, "ChannelCandidateList", apiDiscoverHandler},
+		{"GET", "/status", "Show what the update loop is doing", nil, "", http.StatusOK, "Status",
+			confHandlerWrapper(conf, apiStatusHandler)},
+		{"GET", "/version", "Show the lfpod version", nil, "", http.StatusOK, "Version", apiVersionHandler},
+		{"GET", "/pause", "Get the pause state", nil, "", http.StatusOK, "PauseStatus", pauseHandler},
+		{"POST", "/pause", "Pause updates", []apiParam{
+			{"until", "RFC 3339 time or duration to pause for, pause until resumed if omitted."},
+		}, "", http.StatusOK, "PauseStatus", pauseHandler},
+		{"DELETE", "/pause", "Resume updates", nil, "", http.StatusOK, "PauseStatus", pauseHandler},
+		{"GET", "/sync", "List episode changes since a cursor", []apiParam{
+			{"since", "Cursor returned by the previous call, all known episodes are listed if omitted."},
+		}, "", http.StatusOK, "SyncResult", confHandlerWrapper(conf, apiSyncHandler)},
+		{"GET", "/events", "Stream pipeline and server events as server-sent events", nil, "", http.StatusOK, "",
+			apiEventsHandler},
+		{"GET", "/blocked", "List videos not downloaded anymore after permanent failures", nil, "", http.StatusOK,
+			"BlockedVideoList", apiBlockedHandler},
+		{"DELETE", "/blocked", "Unblock all videos", nil, "", http.StatusNoContent, "", apiBlockedHandler},
+		{"DELETE", "/blocked/{videoId}", "Unblock a video", nil, "", http.StatusNoContent, "", apiBlockedHandler},
+		{"GET", "/websub", "List WebSub push notification subscriptions", nil, "", http.StatusOK,
+			"WebSubSubscriptionList", apiWebSubHandler},
+		{"POST", "/profiles/{name}/secret", "Rotate the capability URL secret of a profile", []apiParam{
+			{"keep", "Keep the previous secrets valid, 1 or true."},
+		}, "", http.StatusOK, "ProfileSecret", confHandlerWrapper(conf, apiRotateSecretHandler)},
+		{"GET", "/stats", "Report completed downloads of episodes and their feeds", nil, "", http.StatusOK, "PlayReport",
+			confHandlerWrapper(conf, apiStatsHandler)},
+		{"GET", "/backup", "Download a backup of the configuration and state as a gzipped tarball", []apiParam{
+			{"audio", "Include the audio files, 1 or true."},
+		}, "", http.StatusOK, "", confHandlerWrapper(conf, apiBackupHandler)},
+		{"GET", "/gc", "Report what storage garbage collection strategies would delete", []apiParam{
+			{"strategy", "Report only this strategy: oldest, least-played, proportional or pinned."},
+			{"max_storage", "Storage limit in MB, the configured one by default."},
+		}, "", http.StatusOK, "GCReportList", confHandlerWrapper(conf, apiGCHandler)},
+	}
+}
+
+func addAPIRoutes(r *mux.Router, conf *Conf) {
+	r.NotFoundHandler = http.HandlerFunc(apiNotFoundHandler)
+	r.MethodNotAllowedHandler = http.HandlerFunc(apiMethodNotAllowedHandler)
+	for _, route := range apiRoutes(conf) {
+		r.HandleFunc(route.Path, route.Handler).Methods(route.Method)
+	}
+}
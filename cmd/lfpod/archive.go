@@ -0,0 +1,115 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// downloadArchiveFileName returns the yt-dlp download archive of a
+// channel, listing every video ever downloaded for it.
+func downloadArchiveFileName(channelId string) string {
+	return filepath.Join("audio", channelId, "downloaded.txt")
+}
+
+// DownloadArchive reads the yt-dlp download archives, so videos deleted
+// since are not downloaded again. yt-dlp appends to the files itself,
+// Add records downloads it did not, e.g. files predating the archive.
+type DownloadArchive struct {
+	mu       sync.Mutex
+	channels map[string]archivedVideos
+}
+
+type archivedVideos struct {
+	modTime time.Time
+	size    int64
+	videos  map[string]bool
+}
+
+var downloadArchive = DownloadArchive{channels: map[string]archivedVideos{}}
+
+// load returns the archive of a channel, reading the file again if it
+// has changed. Lines are "youtube <video id>".
+func (a *DownloadArchive) load(channelId string) map[string]bool {
+	name := downloadArchiveFileName(channelId)
+	info, err := os.Stat(name)
+	if err != nil {
+		delete(a.channels, channelId)
+		return map[string]bool{}
+	}
+	if c, ok := a.channels[channelId]; ok && c.modTime.Equal(info.ModTime()) && c.size == info.Size() {
+		return c.videos
+	}
+	videos := map[string]bool{}
+	f, err := os.Open(name)
+	if err != nil {
+		return videos
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if extractor, id, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " "); ok && extractor == "youtube" {
+			videos[id] = true
+		}
+	}
+	a.channels[channelId] = archivedVideos{info.ModTime(), info.Size(), videos}
+	return videos
+}
+
+func (a *DownloadArchive) Has(channelId, videoId string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.load(channelId)[videoId]
+}
+
+// Remove deletes a video from the archive of a channel, so it can be
+// downloaded again.
+func (a *DownloadArchive) Remove(channelId, videoId string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.load(channelId)[videoId] {
+		return nil
+	}
+	name := downloadArchiveFileName(channelId)
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if strings.TrimSpace(line) != "youtube "+videoId {
+			lines = append(lines, line)
+		}
+	}
+	fileTmp := name + ".tmp"
+	if err := os.WriteFile(fileTmp, []byte(strings.Join(append(lines, ""), "\n")), 0640); err != nil {
+		return err
+	}
+	// The modification time may not change within its granularity.
+	delete(a.channels, channelId)
+	return os.Rename(fileTmp, name)
+}
+
+func (a *DownloadArchive) Add(channelId, videoId string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.load(channelId)[videoId] {
+		return nil
+	}
+	f, err := os.OpenFile(downloadArchiveFileName(channelId), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString("youtube " + videoId + "\n")
+	delete(a.channels, channelId)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
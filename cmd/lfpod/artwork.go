@@ -0,0 +1,103 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/lfpod/youtube"
+)
+
+// Width in pixels episode artwork is scaled down to.
+const artworkWidth = 600
+
+// artworkFileName returns the episode artwork of a video.
+func artworkFileName(channelId, videoId string) string {
+	return filepath.Join("audio", channelId, videoId+".jpg")
+}
+
+// downloadArtwork fetches the video thumbnail listed in the channel feed
+// and recompresses it into the episode artwork.
+func downloadArtwork(feed *ConfFeed, entry *youtube.Entry) (string, error) {
+	if entry.Media == nil || entry.Media.Thumbnail.URL == "" {
+		return "", errors.New("no thumbnail")
+	}
+	res, err := feedClient(feed).Get(entry.Media.Thumbnail.URL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", errors.New("server response status " + res.Status)
+	}
+	fileTmp := entry.VideoId + ".thumb"
+	f, err := os.Create(fileTmp)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(fileTmp)
+	_, err = io.Copy(f, res.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	name := artworkFileName(feed.ChannelId, entry.VideoId)
+	cmd := exec.Command(converter, "-v", "error", "-i", fileTmp,
+		"-vf", "scale='min("+strconv.Itoa(artworkWidth)+",iw)':-2", "-frames:v", "1", "-q:v", "4",
+		"-f", "image2", "-y", name)
+	if out, err := runCommand(cmd, entry.VideoId, "artwork"); err != nil {
+		os.Remove(name)
+		return "", fmt.Errorf("%v: %s", err, out)
+	}
+	return name, nil
+}
+
+// artworkArgs returns ffmpeg input and output arguments embedding
+// artwork as cover art. MP4 takes it as an attached picture stream from
+// input index input, Ogg as a FLAC picture block in a Vorbis comment.
+// CAF has no cover art.
+func artworkArgs(format AudioFormat, artwork string, input int) ([]string, []string, error) {
+	switch format.Ext {
+	case "m4a":
+		return []string{"-i", artwork},
+			[]string{"-map", strconv.Itoa(input) + ":v", "-c:v", "copy", "-disposition:v", "attached_pic"}, nil
+	case "opus":
+		data, err := os.ReadFile(artwork)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, []string{"-metadata", "METADATA_BLOCK_PICTURE=" + flacPicture(data)}, nil
+	}
+	return nil, nil, nil
+}
+
+// flacPicture returns a base64 FLAC picture block of a JPEG front cover.
+func flacPicture(data []byte) string {
+	var width, height uint32
+	if conf, err := jpeg.DecodeConfig(bytes.NewReader(data)); err == nil {
+		width, height = uint32(conf.Width), uint32(conf.Height)
+	}
+	const mime = "image/jpeg"
+	var b bytes.Buffer
+	for _, v := range []interface{}{
+		uint32(3), uint32(len(mime)), []byte(mime), uint32(0),
+		width, height, uint32(24), uint32(0), uint32(len(data)), data,
+	} {
+		binary.Write(&b, binary.BigEndian, v)
+	}
+	return base64.StdEncoding.EncodeToString(b.Bytes())
+}
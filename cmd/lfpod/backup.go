@@ -0,0 +1,284 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Name of the configuration file in backups.
+const backupConfName = "ytfeeds.json"
+
+// Name of the episode database in backups, copied from a snapshot as
+// it changes while being read.
+const backupEpisodeDbName = "episodes.db"
+
+// stateFiles maps the names of the state files in backups to their
+// paths, set from the flags.
+var stateFiles = map[string]string{}
+
+// isAudioData reports whether a file of the audio directory is audio,
+// left out of backups unless asked for, rather than metadata like the
+// download archive, sidecars, chapters and artwork.
+func isAudioData(name string) bool {
+	base := filepath.Base(name)
+	for _, ext := range audioFormatNames {
+		if strings.HasSuffix(base, "."+ext) {
+			return true
+		}
+	}
+	return strings.Contains(base, ".orig.")
+}
+
+// BackupStats counts the files of a backup.
+type BackupStats struct {
+	Files int
+	Size  int64
+}
+
+// writeBackup writes a gzipped tarball of the configuration file, the
+// state files and the metadata of the audio directory, with the audio
+// too if audio is set.
+func writeBackup(ctx context.Context, w io.Writer, confFile string, audio bool) (BackupStats, error) {
+	stats := BackupStats{}
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	add := func(name, file string) error {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name, Mode: 0640, Size: info.Size(), ModTime: info.ModTime(), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		// Files growing while copied are cut at their size when opened.
+		if _, err := io.CopyN(tw, f, info.Size()); err != nil {
+			return err
+		}
+		stats.Files++
+		stats.Size += info.Size()
+		return nil
+	}
+	if err := add(backupConfName, confFile); err != nil {
+		return stats, err
+	}
+	names := []string{}
+	for name := range stateFiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		file := stateFiles[name]
+		if name == backupEpisodeDbName {
+			snapshot := file + ".tmp"
+			os.Remove(snapshot)
+			if err := episodes.Snapshot(ctx, snapshot); err != nil {
+				return stats, err
+			}
+			defer os.Remove(snapshot)
+			file = snapshot
+		}
+		if err := add(name, file); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return stats, err
+		}
+	}
+	err := filepath.WalkDir("audio", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() || strings.Contains(d.Name(), ".tmp") || (!audio && isAudioData(name)) {
+			return err
+		}
+		if err := add(filepath.ToSlash(name), name); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return stats, err
+	}
+	if err := tw.Close(); err != nil {
+		return stats, err
+	}
+	return stats, gz.Close()
+}
+
+// restoreBackup extracts a backup over the configuration file, the
+// state files and the audio directory. An existing configuration file
+// is only replaced with force.
+func restoreBackup(r io.Reader, confFile string, force bool) (BackupStats, error) {
+	stats := BackupStats{}
+	if !force && fileExists(confFile) {
+		return stats, fmt.Errorf("%s exists, restore with -force to replace it", confFile)
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return stats, err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return stats, err
+		}
+		name := path.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			continue
+		}
+		dest := stateFiles[name]
+		switch {
+		case name == backupConfName:
+			dest = confFile
+		case strings.HasPrefix(name, "audio/"):
+			dest = filepath.FromSlash(name)
+		case dest == "":
+			log.Print("restore: skipped ", name)
+			continue
+		}
+		if err := restoreFile(tr, dest, hdr.ModTime); err != nil {
+			return stats, err
+		}
+		if name == backupEpisodeDbName {
+			// The write-ahead log of the replaced database.
+			os.Remove(dest + "-wal")
+			os.Remove(dest + "-shm")
+		}
+		stats.Files++
+		stats.Size += hdr.Size
+	}
+	return stats, nil
+}
+
+// restoreFile replaces file with the content of r.
+func restoreFile(r io.Reader, file string, modTime time.Time) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0750); err != nil {
+		return err
+	}
+	fileTmp := file + ".tmp"
+	f, err := os.OpenFile(fileTmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		os.Chtimes(fileTmp, modTime, modTime)
+		err = os.Rename(fileTmp, file)
+	}
+	if err != nil {
+		os.Remove(fileTmp)
+	}
+	return err
+}
+
+// apiBackupHandler streams a backup. It holds the configuration with
+// its secrets, so the admin token is required if there is one.
+func apiBackupHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	if !isAdmin(r) {
+		apiError(w, http.StatusForbidden, "the admin token is required for backups", nil)
+		return
+	}
+	plays.Save()
+	audio := r.FormValue("audio")
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+backupFileName()+`"`)
+	if _, err := writeBackup(r.Context(), w, conf.ConfFeedsFile, audio == "1" || audio == "true"); err != nil {
+		// The status is sent already, the truncated download fails.
+		log.Print("backup: ", err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+func backupFileName() string {
+	return "lfpod-backup-" + time.Now().Format("20060102") + ".tar.gz"
+}
+
+// runBackup implements the backup command.
+func runBackup(ctx context.Context, confFile string, args []string) error {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	audio := fs.Bool("audio", false, "Include the audio files, only metadata is backed up otherwise.")
+	out := fs.String("o", backupFileName(), "Output file, - for standard output.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod backup [-audio] [-o file]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return errors.New("too many arguments")
+	}
+	*out = argPath(*out)
+	w := io.Writer(os.Stdout)
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	stats, err := writeBackup(ctx, w, confFile, *audio)
+	if err != nil {
+		if *out != "-" {
+			os.Remove(*out)
+		}
+		return err
+	}
+	if *out != "-" {
+		fmt.Printf("%d files, %s backed up to %s\n", stats.Files, formatMB(stats.Size), *out)
+	}
+	return nil
+}
+
+// runRestore implements the restore command.
+func runRestore(confFile string, args []string) error {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	force := fs.Bool("force", false, "Replace the existing configuration and state.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod restore [-force] <backup file or ->")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("backup file is required")
+	}
+	r := io.Reader(os.Stdin)
+	if fs.Arg(0) != "-" {
+		f, err := os.Open(argPath(fs.Arg(0)))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	stats, err := restoreBackup(r, confFile, *force)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d files, %s restored\n", stats.Files, formatMB(stats.Size))
+	return nil
+}
@@ -0,0 +1,147 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Entries per channel, as many as YouTube channel feeds have.
+const benchFeedEntries = 15
+
+// benchArchive creates an archive of n episodes of fileSize bytes in
+// the working directory, and a mock YouTube server with the feeds of
+// its channels. Feeds are fetched from the server until it is closed.
+func benchArchive(n, fileSize int) (*Conf, *httptest.Server, []string, error) {
+	conf := &Conf{ServerAddress: "podcast.test"}
+	channels := map[string][]byte{}
+	names := []string{}
+	data := make([]byte, fileSize)
+	published := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; len(names) < n; i++ {
+		channelId := fmt.Sprintf("UCbench%04d", i)
+		if err := os.MkdirAll(filepath.Join("audio", channelId), 0750); err != nil {
+			return nil, nil, nil, err
+		}
+		conf.Feeds = append(conf.Feeds, ConfFeed{Name: channelId, ChannelId: channelId})
+		var xml bytes.Buffer
+		xml.WriteString(`<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns:media="http://search.yahoo.com/mrss/" xmlns="http://www.w3.org/2005/Atom">`)
+		for j := 0; j < benchFeedEntries && len(names) < n; j++ {
+			videoId := fmt.Sprintf("v%04d%06d", i, j)
+			published = published.Add(time.Hour)
+			fmt.Fprintf(&xml, "<entry><yt:videoId>%s</yt:videoId><title>Episode %d of channel %d</title>"+
+				"<published>%s</published><media:group><media:description>Description</media:description></media:group></entry>",
+				videoId, j, i, published.Format(time.RFC3339))
+			name := filepath.Join(channelId, videoId+".opus")
+			if err := os.WriteFile(filepath.Join("audio", name), data, 0640); err != nil {
+				return nil, nil, nil, err
+			}
+			names = append(names, name)
+		}
+		xml.WriteString("</feed>")
+		channels[channelId] = xml.Bytes()
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if data, ok := channels[r.FormValue("channel_id")]; ok {
+			w.Write(data)
+		} else {
+			http.NotFound(w, r)
+		}
+	}))
+	feedBaseURL = server.URL + "/feeds/videos.xml?channel_id="
+	return conf, server, names, nil
+}
+
+func benchmarkFeed(conf *Conf) func(b *testing.B) {
+	return func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			w := httptest.NewRecorder()
+			feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed", nil))
+			if w.Code != http.StatusOK {
+				b.Fatal("feed status ", w.Code)
+			}
+		}
+	}
+}
+
+func benchmarkAudio(names []string, fileSize int) func(b *testing.B) {
+	return func(b *testing.B) {
+		handler := http.FileServer(http.Dir("audio"))
+		b.SetBytes(int64(fileSize))
+		for i := 0; i < b.N; i++ {
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/"+filepath.ToSlash(names[i%len(names)]), nil))
+			if w.Code != http.StatusOK {
+				b.Fatal("audio status ", w.Code)
+			}
+		}
+	}
+}
+
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	sizes := fs.String("sizes", "15,150,1500", "Comma separated archive sizes in episodes.")
+	fileSize := fs.Int("file-size", 1<<20, "Size of audio files in bytes.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod bench [-sizes n,...] [-file-size bytes]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	counts := []int{}
+	for _, s := range strings.Split(*sizes, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			return errors.New("invalid archive size " + s)
+		}
+		counts = append(counts, n)
+	}
+
+	savedBaseURL := feedBaseURL
+	defer func() { feedBaseURL = savedBaseURL }()
+	for _, n := range counts {
+		if err := benchSize(n, *fileSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// benchSize runs the benchmarks on an archive of n episodes in a
+// temporary directory.
+func benchSize(n, fileSize int) error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	dir, err := os.MkdirTemp("", "lfpod-bench-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	defer os.Chdir(wd)
+	conf, server, names, err := benchArchive(n, fileSize)
+	if err != nil {
+		return err
+	}
+	defer server.Close()
+	res := testing.Benchmark(benchmarkFeed(conf))
+	fmt.Printf("feed   %6d episodes %s %s\n", n, res, res.MemString())
+	res = testing.Benchmark(benchmarkAudio(names, fileSize))
+	fmt.Printf("audio  %6d episodes %s\n", n, res)
+	return nil
+}
@@ -0,0 +1,58 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+var benchSizes = []int{15, 150, 1500}
+
+const benchFileSize = 1 << 20
+
+func setupBench(b *testing.B, n int) (*Conf, []string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := os.Chdir(b.TempDir()); err != nil {
+		b.Fatal(err)
+	}
+	savedBaseURL := feedBaseURL
+	conf, server, names, err := benchArchive(n, benchFileSize)
+	b.Cleanup(func() {
+		if server != nil {
+			server.Close()
+		}
+		feedBaseURL = savedBaseURL
+		os.Chdir(wd)
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	return conf, names
+}
+
+func BenchmarkFeed(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			conf, _ := setupBench(b, n)
+			b.ReportAllocs()
+			b.ResetTimer()
+			benchmarkFeed(conf)(b)
+		})
+	}
+}
+
+func BenchmarkAudio(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			_, names := setupBench(b, n)
+			b.ResetTimer()
+			benchmarkAudio(names, benchFileSize)(b)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Audio files are stored by content hash in this directory, with the
+// files under audio hard links to them, empty to disable. It has to be
+// on the same file system as the audio directory.
+var blobDir string
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// storeBlob links an audio file to its content blob. If the blob exists,
+// the file is replaced by a link to it, so identical files share space.
+// It returns the number of bytes freed.
+func storeBlob(name string) (int64, error) {
+	sum, err := hashFile(name)
+	if err != nil {
+		return 0, err
+	}
+	blob := filepath.Join(blobDir, sum[:2], sum+filepath.Ext(name))
+	blobInfo, err := os.Stat(blob)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(blob), 0750); err != nil {
+			return 0, err
+		}
+		return 0, os.Link(name, blob)
+	} else if err != nil {
+		return 0, err
+	}
+	info, err := os.Stat(name)
+	if err != nil || os.SameFile(info, blobInfo) {
+		return 0, err
+	}
+	fileTmp := name + ".link"
+	if err := os.Link(blob, fileTmp); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(fileTmp, name); err != nil {
+		os.Remove(fileTmp)
+		return 0, err
+	}
+	return info.Size(), nil
+}
+
+// pruneBlobs deletes blobs no audio file links to anymore, it returns
+// the number of bytes freed. Link counts are known on Unix systems only,
+// -blob-dir is refused elsewhere.
+func pruneBlobs() (int64, error) {
+	if blobDir == "" {
+		return 0, nil
+	}
+	var freed int64
+	err := filepath.WalkDir(blobDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if linkCount(info) == 1 {
+			if err := os.Remove(path); err != nil {
+				return err
+			}
+			freed += info.Size()
+		}
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		err = nil
+	}
+	return freed, err
+}
+
+// runDedupe moves an existing archive to content addressed storage.
+func runDedupe(conf *Conf, args []string) error {
+	fs := flag.NewFlagSet("dedupe", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod -blob-dir dir dedupe")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if blobDir == "" {
+		return errors.New("-blob-dir is required")
+	}
+	var files int
+	var saved int64
+	for _, feed := range conf.AllFeeds() {
+		entries, err := os.ReadDir(filepath.Join("audio", feed.ChannelId))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			_, ext, ok := strings.Cut(e.Name(), ".")
+			if !ok || !containsString(audioFormatNames, ext) {
+				continue
+			}
+			n, err := storeBlob(filepath.Join("audio", feed.ChannelId, e.Name()))
+			if err != nil {
+				return err
+			}
+			files++
+			saved += n
+		}
+	}
+	pruned, err := pruneBlobs()
+	if err != nil {
+		log.Print(err)
+	}
+	fmt.Printf("%d files stored by content, %.1f MB saved, %.1f MB of unused blobs deleted\n",
+		files, float64(saved)/1e6, float64(pruned)/1e6)
+	return nil
+}
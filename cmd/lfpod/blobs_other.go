@@ -0,0 +1,14 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !unix
+
+package main
+
+import "os"
+
+const linkCountsKnown = false
+
+func linkCount(info os.FileInfo) int {
+	return 0
+}
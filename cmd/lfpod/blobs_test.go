@@ -0,0 +1,59 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlobs(t *testing.T) {
+	if !linkCountsKnown {
+		t.Skip("link counts not known")
+	}
+	dir := t.TempDir()
+	blobDir = filepath.Join(dir, "blobs")
+	t.Cleanup(func() { blobDir = "" })
+	audio := []byte("same audio")
+	files := []string{filepath.Join(dir, "vid00000001.opus"), filepath.Join(dir, "vid00000002.opus")}
+	for _, name := range files {
+		if err := os.WriteFile(name, audio, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, want := range []int64{0, int64(len(audio))} {
+		if freed, err := storeBlob(files[i]); err != nil || freed != want {
+			t.Fatalf("storing %s freed %d, %v, want %d", files[i], freed, err, want)
+		}
+	}
+	// Storing again changes nothing.
+	if freed, err := storeBlob(files[1]); err != nil || freed != 0 {
+		t.Errorf("storing again freed %d, %v", freed, err)
+	}
+	a, errA := os.Stat(files[0])
+	b, errB := os.Stat(files[1])
+	if errA != nil || errB != nil || !os.SameFile(a, b) {
+		t.Fatalf("identical files not deduplicated: %v %v", errA, errB)
+	}
+	if data, _ := os.ReadFile(files[1]); string(data) != string(audio) {
+		t.Errorf("deduplicated file reads %q", data)
+	}
+	blobs, _ := filepath.Glob(filepath.Join(blobDir, "*", "*.opus"))
+	if len(blobs) != 1 {
+		t.Fatalf("%d blobs, want 1", len(blobs))
+	}
+
+	for i, want := range []int64{0, 0, int64(len(audio))} {
+		if freed, err := pruneBlobs(); err != nil || freed != want {
+			t.Errorf("pruning with %d files deleted freed %d, %v, want %d", i, freed, err, want)
+		}
+		if i < len(files) {
+			os.Remove(files[i])
+		}
+	}
+	if _, err := os.Stat(blobs[0]); !os.IsNotExist(err) {
+		t.Errorf("unused blob not pruned: %v", err)
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Whether linkCount knows the links to files, so unused blobs can be
+// pruned.
+const linkCountsKnown = true
+
+// linkCount returns the number of hard links to a file, 0 if unknown.
+func linkCount(info os.FileInfo) int {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(st.Nlink)
+	}
+	return 0
+}
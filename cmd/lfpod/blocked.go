@@ -0,0 +1,162 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lfpod/youtube"
+)
+
+// BlockedVideo is a video not downloaded anymore after a permanent
+// failure.
+type BlockedVideo struct {
+	ChannelId string    `json:"channel_id"`
+	VideoId   string    `json:"video_id"`
+	Title     string    `json:"title"`
+	Reason    string    `json:"reason"`
+	Time      time.Time `json:"time"`
+}
+
+// Blocklist keeps permanently failed videos, so they are not tried
+// again every update while still in the channel feed. The list is
+// saved to file if set.
+type Blocklist struct {
+	mu     sync.Mutex
+	file   string
+	videos map[string]BlockedVideo
+}
+
+var blocked = Blocklist{videos: map[string]BlockedVideo{}}
+
+func (b *Blocklist) Load(file string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.file = file
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	list := []BlockedVideo{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for _, v := range list {
+		b.videos[v.VideoId] = v
+	}
+	return nil
+}
+
+func (b *Blocklist) save() {
+	if b.file == "" {
+		return
+	}
+	data, err := json.MarshalIndent(b.list(), "", "    ")
+	if err == nil {
+		fileTmp := b.file + ".tmp"
+		if err = os.WriteFile(fileTmp, append(data, '\n'), 0640); err == nil {
+			err = os.Rename(fileTmp, b.file)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+func (b *Blocklist) list() []BlockedVideo {
+	list := []BlockedVideo{}
+	for _, v := range b.videos {
+		list = append(list, v)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Time.Before(list[j].Time)
+	})
+	return list
+}
+
+func (b *Blocklist) Has(videoId string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, ok := b.videos[videoId]
+	return ok
+}
+
+func (b *Blocklist) Add(channelId string, entry *youtube.Entry, reason string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.videos[entry.VideoId] = BlockedVideo{channelId, entry.VideoId, entry.Title, reason, time.Now().UTC()}
+	log.Printf("%s blocked: %s", entry.VideoId, reason)
+	b.save()
+}
+
+// Remove unblocks videos, all of them if none are given. It returns the
+// number of videos unblocked.
+func (b *Blocklist) Remove(videoIds ...string) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	n := 0
+	if len(videoIds) == 0 {
+		n = len(b.videos)
+		b.videos = map[string]BlockedVideo{}
+	}
+	for _, id := range videoIds {
+		if _, ok := b.videos[id]; ok {
+			delete(b.videos, id)
+			n++
+		}
+	}
+	if n > 0 {
+		b.save()
+	}
+	return n
+}
+
+func (b *Blocklist) List() []BlockedVideo {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.list()
+}
+
+func apiBlockedHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		writeJSON(w, http.StatusOK, blocked.List())
+		return
+	}
+	if videoId, ok := mux.Vars(r)["videoId"]; ok {
+		if blocked.Remove(videoId) == 0 {
+			apiError(w, http.StatusNotFound, "video not blocked", videoId)
+			return
+		}
+	} else {
+		blocked.Remove()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// runBlocked lists blocked videos, or unblocks the given ones, or all
+// with -clear.
+func runBlocked(args []string) error {
+	if len(args) == 0 {
+		for _, v := range blocked.List() {
+			fmt.Printf("%s  %s  %s  %s\n", v.Time.Local().Format("2006-01-02"), v.VideoId, v.Title, v.Reason)
+		}
+		return nil
+	}
+	if args[0] == "-clear" {
+		args = nil
+	}
+	fmt.Printf("%d videos unblocked\n", blocked.Remove(args...))
+	return nil
+}
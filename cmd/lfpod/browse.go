@@ -0,0 +1,90 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+)
+
+var browseTemplate = template.Must(template.New("browse").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>lfpod episodes</title>
+<style>
+body { font-family: sans-serif; max-width: 50em; margin: auto; padding: 1em; }
+nav a { margin-right: 1em; }
+.episode { margin: 1em 0; }
+.episode p { margin: .2em 0; color: #666; font-size: small; }
+audio { width: 100%; }
+</style>
+</head>
+<body>
+<h1>Episodes</h1>
+<nav>{{range .}}<a href="#{{.ChannelId}}">{{.Title}}</a> {{end}}</nav>
+{{range .}}
+<h2 id="{{.ChannelId}}">{{.Title}} <a href="feed/{{.ChannelId}}" title="Podcast feed">&#x1F517;</a></h2>
+{{range .Episodes}}
+<div class="episode">
+<b>{{.Title}}</b>
+<p>{{.Date}} &middot; {{.Size}}{{if .Duration}} &middot; {{.Duration}}{{end}} &middot; <a href="{{.URL}}" download>Download</a></p>
+<audio controls preload="none" src="{{.URL}}"></audio>
+</div>
+{{else}}
+<p>No episodes downloaded.</p>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+type browseChannel struct {
+	ChannelId string
+	Title     string
+	Episodes  []browseEpisode
+}
+
+type browseEpisode struct {
+	Title    string
+	Date     string
+	Size     string
+	Duration time.Duration
+	// Audio URL relative to the page.
+	URL string
+}
+
+// browseHandler serves a page of the downloaded episodes by channel with
+// an audio player each, for listening without a podcast app.
+func browseHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	confFeeds := conf.GetFeeds()
+	durations := map[string]time.Duration{}
+	for _, ep := range listEpisodes(r.Context(), "") {
+		durations[ep.VideoId] = time.Duration(ep.Duration * float64(time.Second))
+	}
+	channels := []browseChannel{}
+	index := map[string]int{}
+	for _, feed := range confFeeds {
+		index[feed.ChannelId] = len(channels)
+		channels = append(channels, browseChannel{ChannelId: feed.ChannelId, Title: feed.Title()})
+	}
+	for _, ep := range archivedEpisodes(r.Context(), confFeeds, conf.FetchConcurrency) {
+		c := &channels[index[ep.ChannelId]]
+		c.Episodes = append(c.Episodes, browseEpisode{
+			Title:    ep.Title,
+			Date:     ep.Published.Local().Format("2006-01-02"),
+			Size:     formatMB(ep.Size),
+			Duration: durations[ep.VideoId].Round(time.Second),
+			URL:      "audio/" + ep.ChannelId + "/" + filepath.Base(ep.File),
+		})
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := browseTemplate.Execute(w, channels); err != nil {
+		log.Print(err)
+	}
+}
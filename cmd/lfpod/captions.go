@@ -0,0 +1,84 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lfpod/pipeline"
+)
+
+// Captions are downloaded in these languages unless the feed sets
+// others.
+var defaultCaptionLanguages = []string{"en"}
+
+// Timeout of a captions download.
+const captionsTimeout = 2 * time.Minute
+
+// captionsPrefix returns the start of the caption file names of a
+// video, <video id>.captions.<language>.vtt.
+func captionsPrefix(videoId string) string {
+	return videoId + ".captions."
+}
+
+// CaptionLanguages returns the languages of captions downloaded for
+// the feed.
+func (f *ConfFeed) CaptionLanguages() []string {
+	if len(f.CaptionLangs) > 0 {
+		return f.CaptionLangs
+	}
+	return defaultCaptionLanguages
+}
+
+// downloadCaptions writes the captions of a video, those of the channel
+// or else the automatic ones, as VTT files next to its audio file. It
+// returns the languages written, none if the video has no captions.
+func downloadCaptions(ctx context.Context, feed *ConfFeed, videoId string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, captionsTimeout)
+	defer cancel()
+	dir := filepath.Join("audio", feed.ChannelId)
+	removeCaptions(feed.ChannelId, videoId)
+	args := []string{"--skip-download", "--write-subs", "--write-auto-subs",
+		"--sub-langs", strings.Join(feed.CaptionLanguages(), ","), "--sub-format", "vtt/best", "--convert-subs", "vtt",
+		"-o", "subtitle:" + filepath.Join(dir, captionsPrefix("%(id)s")+"%(ext)s")}
+	args = append(append(args, downloaderExtraArgs...), feed.DownloaderArgs...)
+	cmd := exec.CommandContext(ctx, downloader, feedDownloaderArgs(feed, append(args, "--", videoId)...)...)
+	cmd.Dir, _ = os.Getwd()
+	out, err := runCommand(cmd, videoId, "captions")
+	if err != nil {
+		if err := pipeline.RateLimitError(out); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%v: %s", err, lastLine(out))
+	}
+	return captionLanguages(feed.ChannelId, videoId), nil
+}
+
+// captionLanguages returns the languages of the caption files of a
+// video.
+func captionLanguages(channelId, videoId string) []string {
+	names, _ := filepath.Glob(filepath.Join("audio", channelId, captionsPrefix(videoId)+"*.vtt"))
+	languages := []string{}
+	for _, name := range names {
+		lang := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), captionsPrefix(videoId)), ".vtt")
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// removeCaptions deletes the caption files of a video.
+func removeCaptions(channelId, videoId string) {
+	names, _ := filepath.Glob(filepath.Join("audio", channelId, captionsPrefix(videoId)+"*"))
+	for _, name := range names {
+		os.Remove(name)
+	}
+}
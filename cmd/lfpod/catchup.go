@@ -0,0 +1,48 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log"
+	"time"
+)
+
+// catchUpInterval returns the time between videos of an update pass
+// with n videos, 0 if the pass is not throttled. Passes with more
+// videos than rate per hour, typically the first one after a long
+// downtime, are spread evenly at rate videos per hour.
+func catchUpInterval(n, rate int) time.Duration {
+	if rate <= 0 || n <= rate {
+		return 0
+	}
+	return time.Hour / time.Duration(rate)
+}
+
+// waitCatchUp sleeps between throttled videos, beating the update
+// heartbeat so the update loop is not considered stuck.
+func waitCatchUp(d time.Duration) {
+	for deadline := time.Now().Add(d); ; {
+		updateHeartbeat.Beat()
+		left := time.Until(deadline)
+		if left <= 0 {
+			return
+		}
+		if left > time.Minute {
+			left = time.Minute
+		}
+		time.Sleep(left)
+	}
+}
+
+// catchUpProgress logs and exports throttled videos left.
+func catchUpProgress(done, total int, interval time.Duration) {
+	left := total - done
+	metrics.Set("lfpod_catchup_remaining_videos", "", float64(left))
+	if left == 0 {
+		log.Printf("catch-up done, all %d videos queued", total)
+		return
+	}
+	log.Printf("catch-up %d/%d videos, about %s left", done, total,
+		(time.Duration(left) * interval).Round(time.Minute))
+}
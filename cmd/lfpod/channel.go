@@ -0,0 +1,193 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+// ChannelInfo describes a channel audio directory, so the archive on
+// disk stays usable by other tools without the lfpod state files.
+type ChannelInfo struct {
+	Name         string           `json:"name"`
+	ChannelId    string           `json:"channel_id"`
+	ChannelTitle string           `json:"channel_title,omitempty"`
+	Author       string           `json:"author,omitempty"`
+	URL          string           `json:"url"`
+	Artwork      string           `json:"artwork,omitempty"`
+	Filters      ChannelFilters   `json:"filters"`
+	Stats        ChannelStats     `json:"stats"`
+	Episodes     []ChannelEpisode `json:"episodes"`
+}
+
+// ChannelFilters are the feed settings selecting downloaded videos.
+type ChannelFilters struct {
+	Keywords         []string `json:"keywords,omitempty"`
+	PriorityKeywords []string `json:"priority_keywords,omitempty"`
+	Match            string   `json:"match,omitempty"`
+	MatchDescription bool     `json:"match_description,omitempty"`
+	IgnoreOlderThan  int      `json:"ignore_older_than,omitempty"`
+	SponsorBlock     []string `json:"sponsorblock,omitempty"`
+}
+
+type ChannelStats struct {
+	Episodes int       `json:"episodes"`
+	Size     int64     `json:"size"`
+	Updated  time.Time `json:"updated"`
+}
+
+// ChannelEpisode is an audio file in the channel directory.
+type ChannelEpisode struct {
+	VideoId     string `json:"video_id"`
+	Title       string `json:"title"`
+	Published   string `json:"published,omitempty"`
+	Description string `json:"description,omitempty"`
+	Thumbnail   string `json:"thumbnail,omitempty"`
+	File        string `json:"file"`
+	Size        int64  `json:"size"`
+	// Last metadata refresh from YouTube, RFC 3339.
+	Refreshed string           `json:"refreshed,omitempty"`
+	History   []MetadataChange `json:"history,omitempty"`
+	// Time the video was found deleted or private on YouTube, RFC 3339.
+	Removed string `json:"removed,omitempty"`
+}
+
+func channelInfoFileName(channelId string) string {
+	return filepath.Join("audio", channelId, "channel.json")
+}
+
+// readChannelInfo returns the channel.json of a channel, empty if there
+// is none.
+func readChannelInfo(channelId string) ChannelInfo {
+	info := ChannelInfo{}
+	if data, err := os.ReadFile(channelInfoFileName(channelId)); err == nil {
+		json.Unmarshal(data, &info)
+	}
+	return info
+}
+
+// writeChannelInfo writes the channel.json of a feed. Episode metadata
+// comes from the channel feed, the episodes seen since start or the
+// previous channel.json, so titles outlive the channel feed window.
+func writeChannelInfo(ctx context.Context, feed *ConfFeed, ytfeed *youtube.Feed) error {
+	dir := filepath.Join("audio", feed.ChannelId)
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	known := map[string]ChannelEpisode{}
+	prev := readChannelInfo(feed.ChannelId)
+	for _, ep := range prev.Episodes {
+		known[ep.VideoId] = ep
+	}
+	update := func(videoId, published string, m episodeMetadata) {
+		ep := known[videoId]
+		if ep.Published == "" {
+			ep.Published = published
+		}
+		if ep.setMetadata(m, time.Now().UTC()) {
+			log.Printf("%s %s metadata changed upstream", feed.Name, videoId)
+		}
+		known[videoId] = ep
+	}
+	for _, ep := range listEpisodes(ctx, feed.ChannelId) {
+		update(ep.VideoId, ep.Published, episodeMetadata{Title: ep.Title})
+	}
+	info := ChannelInfo{
+		Name:         feed.Title(),
+		ChannelId:    feed.ChannelId,
+		ChannelTitle: prev.ChannelTitle,
+		Author:       prev.Author,
+		URL:          "https://www.youtube.com/channel/" + feed.ChannelId,
+		Artwork:      prev.Artwork,
+		Filters: ChannelFilters{
+			Keywords:         feed.Keywords,
+			PriorityKeywords: feed.PriorityKeywords,
+			Match:            feed.Match,
+			MatchDescription: feed.MatchDescription,
+			IgnoreOlderThan:  feed.IgnoreOlderThan,
+			SponsorBlock:     feed.SponsorBlock,
+		},
+		Episodes: []ChannelEpisode{},
+	}
+	if ytfeed != nil {
+		if ytfeed.Title != "" {
+			info.ChannelTitle = ytfeed.Title
+		}
+		if ytfeed.Author.Name != "" {
+			info.Author = ytfeed.Author.Name
+		}
+		for _, entry := range ytfeed.Entries {
+			m := episodeMetadata{Title: entry.Title}
+			if entry.Media != nil {
+				m.Description, m.Thumbnail = entry.Media.Description, entry.Media.Thumbnail.URL
+			}
+			update(entry.VideoId, entry.Published, m)
+		}
+	}
+	for _, f := range files {
+		videoId, ext, ok := strings.Cut(f.Name(), ".")
+		if !ok || !containsString(audioFormatNames, ext) {
+			continue
+		}
+		stat, err := f.Info()
+		if err != nil {
+			continue
+		}
+		ep := known[videoId]
+		if ep.Title == "" {
+			ep.Title = videoId
+		}
+		ep.VideoId, ep.File, ep.Size = videoId, f.Name(), stat.Size()
+		info.Episodes = append(info.Episodes, ep)
+		info.Stats.Episodes++
+		info.Stats.Size += ep.Size
+	}
+	sort.SliceStable(info.Episodes, func(i, j int) bool {
+		return info.Episodes[i].Published > info.Episodes[j].Published
+	})
+	info.Stats.Updated = time.Now().UTC()
+	return saveChannelInfo(&info)
+}
+
+func saveChannelInfo(info *ChannelInfo) error {
+	data, err := json.MarshalIndent(info, "", "    ")
+	if err != nil {
+		return err
+	}
+	fileTmp := channelInfoFileName(info.ChannelId) + ".tmp"
+	if err := os.WriteFile(fileTmp, append(data, '\n'), 0640); err != nil {
+		return err
+	}
+	return os.Rename(fileTmp, channelInfoFileName(info.ChannelId))
+}
+
+// ShowInfo returns the podcast title and author of a feed: the ones
+// configured, else the channel title and author from the channel feed
+// of the last update or channel.json. The title falls back to the feed
+// name.
+func (f *ConfFeed) ShowInfo() (title, author string) {
+	title, author = f.PodcastTitle, f.PodcastAuthor
+	if title != "" && author != "" {
+		return title, author
+	}
+	channelTitle, channelAuthor := "", ""
+	if c, ok := cachedChannelFeed(f.ChannelId); ok && c.feed != nil {
+		channelTitle, channelAuthor = c.feed.Title, c.feed.Author.Name
+	}
+	if channelTitle == "" || channelAuthor == "" {
+		info := readChannelInfo(f.ChannelId)
+		channelTitle, channelAuthor = firstNonEmpty(channelTitle, info.ChannelTitle), firstNonEmpty(channelAuthor, info.Author)
+	}
+	return firstNonEmpty(title, channelTitle, f.Title()), firstNonEmpty(author, channelAuthor)
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Chapter struct {
+	Start time.Duration
+	End   time.Duration
+	Title string
+}
+
+// Timestamps at the start of a description line, like "1:02:03 Title"
+// or "(02:03) - Title".
+var chapterLineRegexp = regexp.MustCompile(`^\s*[\[(]?((?:\d{1,2}:)?\d{1,2}:\d{2})[\])]?\s*[-–—:|.]?\s*(.+?)\s*$`)
+
+// parseChapters returns chapters listed in a video description. As on
+// YouTube, the list has to start at 0:00, be in ascending order and
+// have at least three chapters. The last chapter ends at duration.
+func parseChapters(description string, duration time.Duration) []Chapter {
+	chapters := []Chapter{}
+	for _, line := range strings.Split(description, "\n") {
+		m := chapterLineRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		var start time.Duration
+		for _, part := range strings.Split(m[1], ":") {
+			n, _ := strconv.Atoi(part)
+			start = start*60 + time.Duration(n)*time.Second
+		}
+		if len(chapters) == 0 && start != 0 {
+			return nil
+		}
+		if n := len(chapters); n > 0 {
+			if start <= chapters[n-1].Start {
+				return nil
+			}
+			chapters[n-1].End = start
+		}
+		chapters = append(chapters, Chapter{Start: start, Title: m[2]})
+	}
+	if len(chapters) < 3 || duration <= chapters[len(chapters)-1].Start {
+		return nil
+	}
+	chapters[len(chapters)-1].End = duration
+	return chapters
+}
+
+// scaleChapters adjusts chapter times to a playback speed factor.
+func scaleChapters(chapters []Chapter, speed float64) []Chapter {
+	scaled := []Chapter{}
+	for _, c := range chapters {
+		c.Start = time.Duration(float64(c.Start) / speed)
+		c.End = time.Duration(float64(c.End) / speed)
+		scaled = append(scaled, c)
+	}
+	return scaled
+}
+
+// ffmetadata returns a title and chapters in the ffmpeg metadata format.
+func ffmetadata(title string, chapters []Chapter) string {
+	escape := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", `\`+"\n")
+	var b strings.Builder
+	fmt.Fprintf(&b, ";FFMETADATA1\ntitle=%s\n", escape.Replace(title))
+	for _, c := range chapters {
+		fmt.Fprintf(&b, "\n[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			c.Start.Milliseconds(), c.End.Milliseconds(), escape.Replace(c.Title))
+	}
+	return b.String()
+}
+
+// chaptersFileName returns the podcast:chapters JSON document of a video.
+func chaptersFileName(channelId, videoId string) string {
+	return filepath.Join("audio", channelId, videoId+".chapters.json")
+}
+
+// writeChapters writes chapters in the Podcasting 2.0 JSON chapters
+// format.
+func writeChapters(name string, chapters []Chapter) error {
+	type jsonChapter struct {
+		StartTime float64 `json:"startTime"`
+		EndTime   float64 `json:"endTime"`
+		Title     string  `json:"title"`
+	}
+	doc := struct {
+		Version  string        `json:"version"`
+		Chapters []jsonChapter `json:"chapters"`
+	}{Version: "1.2.0"}
+	for _, c := range chapters {
+		doc.Chapters = append(doc.Chapters, jsonChapter{c.Start.Seconds(), c.End.Seconds(), c.Title})
+	}
+	data, err := json.MarshalIndent(doc, "", "    ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(name, append(data, '\n'), 0640)
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/lfpod/youtube"
+)
+
+const commandsUsage = `usage: lfpod [flags] [command [arguments]]
+
+commands:
+  serve             download and serve feeds, the default
+  update            run a single update pass and exit
+  add-video <video> download a single video into a feed
+  add <channel>     add a channel to the configuration
+  remove <feed>     remove a feed from the configuration
+  list              list feeds and their downloaded episodes
+  delete <feed> <video>
+                    delete a downloaded episode
+  search <query>    search YouTube channels, or resolve a @handle or URL
+  import <file>     import subscriptions
+  gc                collect garbage over the storage limit
+  digest            concatenate episodes into a digest file
+  dedupe            hard link identical audio files
+  blocked           list or unblock permanently failed videos
+  backup            back up the configuration and state
+  restore <file>    restore a backup
+  bench             benchmark recoding settings
+  ctl <command>     manage a running server through its API
+
+Run lfpod <command> -h for the arguments of a command.
+
+flags:
+`
+
+func usage() {
+	fmt.Fprint(flag.CommandLine.Output(), commandsUsage)
+	flag.PrintDefaults()
+}
+
+// parseChannelId returns the channel id of a channel id or channel URL.
+func parseChannelId(s string) (string, error) {
+	if id := youtube.ChannelIdRegexp.FindString(s); id != "" {
+		return id, nil
+	}
+	return "", fmt.Errorf("no channel id in %q, find it with lfpod search", s)
+}
+
+// runAdd implements the add command.
+func runAdd(ctx context.Context, conf *Conf, args []string) error {
+	fs := flag.NewFlagSet("add", flag.ExitOnError)
+	name := fs.String("name", "", "Feed name, the channel title if empty.")
+	keywords := fs.String("k", "", "Comma separated keywords of downloaded videos, all videos if empty.")
+	format := fs.String("format", "", "Audio format: opus, caf or m4a.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod add [-name name] [-k keywords] [-format format] <channel id, @handle or URL>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("channel is required")
+	}
+	channelId, err := parseChannelId(fs.Arg(0))
+	if err != nil && channelHandleRegexp.MatchString(fs.Arg(0)) {
+		var c ChannelCandidate
+		if c, err = resolveChannel(ctx, fs.Arg(0)); err == nil {
+			channelId = c.ChannelId
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if feed, ok := conf.GetFeed(channelId); ok {
+		return fmt.Errorf("channel %s is already configured as %s", channelId, feed.Title())
+	}
+	feed := ConfFeed{Name: *name, ChannelId: channelId, Format: *format}
+	for _, k := range strings.Split(*keywords, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			feed.Keywords = append(feed.Keywords, k)
+		}
+	}
+	if feed.Name == "" {
+		ytfeed, err := readChannel(ctx, &feed)
+		if err != nil || ytfeed.Title == "" {
+			return fmt.Errorf("cannot read the channel title, set -name: %v", err)
+		}
+		feed.Name = ytfeed.Title
+	}
+	if msg := validateFeed(feed); msg != "" {
+		return errors.New(msg)
+	}
+	if err := conf.PutFeed(feed); err != nil {
+		return err
+	}
+	fmt.Printf("feed %s added to %s\n", feed.Name, conf.ConfFeedsFile)
+	return nil
+}
+
+// runRemove implements the remove command.
+func runRemove(conf *Conf, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: lfpod remove <feed name or channel id>")
+	}
+	feed, ok := conf.FindFeed(args[0])
+	if !ok {
+		return fmt.Errorf("feed %q not found", args[0])
+	}
+	if _, err := conf.DeleteFeed(feed.ChannelId); err != nil {
+		return err
+	}
+	fmt.Printf("feed %s removed from %s, downloaded audio is kept\n", feed.Title(), conf.ConfFeedsFile)
+	return nil
+}
+
+// runList implements the list command.
+func runList(conf *Conf, args []string) error {
+	if len(args) != 0 {
+		return errors.New("usage: lfpod list")
+	}
+	files, err := scanArchive(conf)
+	if err != nil {
+		return err
+	}
+	counts, sizes := map[string]int{}, map[string]int64{}
+	for _, f := range files {
+		counts[f.ChannelId]++
+		sizes[f.ChannelId] += f.Size
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCHANNEL\tEPISODES\tSIZE\tKEYWORDS")
+	for _, feed := range conf.AllFeeds() {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", feed.Title(), feed.ChannelId,
+			counts[feed.ChannelId], formatMB(sizes[feed.ChannelId]), strings.Join(feed.Keywords, ", "))
+	}
+	return w.Flush()
+}
@@ -0,0 +1,74 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/lfpod/server"
+)
+
+// FeedVersion changes whenever the generated feed may change: a new
+// episode is published or the configuration is edited.
+type FeedVersion struct {
+	mu       sync.Mutex
+	epoch    int64
+	version  int64
+	modified time.Time
+}
+
+var feedVersion = FeedVersion{
+	epoch:    time.Now().UnixNano(),
+	modified: time.Now().Truncate(time.Second),
+}
+
+func (v *FeedVersion) Bump() {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.version++
+	v.modified = time.Now().Truncate(time.Second)
+}
+
+// Current returns a counter increased by each change.
+func (v *FeedVersion) Current() int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.version
+}
+
+// Get returns the entity tag and modification time of the feed at the
+// URL of r. Tags are weak, feeds are equivalent rather than identical
+// byte for byte across encodings, and differ by URL, so each feed, year
+// archive and page is validated on its own.
+func (v *FeedVersion) Get(conf *Conf, r *http.Request) (etag string, modified time.Time) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	h := fnv.New32a()
+	io.WriteString(h, r.URL.RequestURI())
+	etag = fmt.Sprintf("%x-%x-%x", v.epoch, v.version, h.Sum32())
+	modified = v.modified
+	if conf.FeedStats {
+		// The description shows the last update time.
+		if last := lastUpdate.Last(); !last.IsZero() {
+			etag += fmt.Sprintf("-%x", last.Unix())
+			if last.After(modified) {
+				modified = last.Truncate(time.Second)
+			}
+		}
+	}
+	return `W/"` + etag + `"`, modified
+}
+
+// conditionalFeedHandler answers 304 Not Modified when the client
+// already has the current version of the feed, without generating it.
+func conditionalFeedHandler(conf *Conf, next http.HandlerFunc) http.HandlerFunc {
+	return server.Conditional(func(r *http.Request) (string, time.Time) {
+		return feedVersion.Get(conf, r)
+	}, next)
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestFeedVersion(t *testing.T) {
+	conf := &Conf{}
+	v := &FeedVersion{epoch: 1}
+	etag := func(target string) string {
+		tag, _ := v.Get(conf, httptest.NewRequest("GET", target, nil))
+		return tag
+	}
+	feed := etag("/feed/news")
+	if !strings.HasPrefix(feed, `W/"`) {
+		t.Errorf("strong tag %s", feed)
+	}
+	for _, target := range []string{"/feed", "/feed/talks", "/feed/news/archive/2023", "/feed/news?page=2"} {
+		if etag(target) == feed {
+			t.Errorf("%s tagged like /feed/news", target)
+		}
+	}
+	if etag("/feed/news") != feed {
+		t.Error("tag changed without a change of the feed")
+	}
+	v.Bump()
+	if etag("/feed/news") == feed {
+		t.Error("tag kept after a change of the feed")
+	}
+}
+
+func TestConditionalFeedHandler(t *testing.T) {
+	conf := &Conf{}
+	generated := 0
+	h := conditionalFeedHandler(conf, func(w http.ResponseWriter, r *http.Request) {
+		generated++
+		io.WriteString(w, "<feed></feed>")
+	})
+	get := func(target, etag string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", target, nil)
+		if etag != "" {
+			r.Header.Set("If-None-Match", etag)
+		}
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	etag := get("/feed?page=1", "").Header().Get("ETag")
+	for _, tc := range []struct {
+		target, etag string
+		want         int
+	}{
+		{"/feed?page=1", etag, http.StatusNotModified},
+		// As sent back by clients that got the tag as a strong one.
+		{"/feed?page=1", strings.TrimPrefix(etag, "W/"), http.StatusNotModified},
+		{"/feed?page=2", etag, http.StatusOK},
+		{"/feed/archive/2023", etag, http.StatusOK},
+	} {
+		if w := get(tc.target, tc.etag); w.Code != tc.want {
+			t.Errorf("%s with %s: status %d, want %d", tc.target, tc.etag, w.Code, tc.want)
+		}
+	}
+	if generated != 3 {
+		t.Errorf("generated %d times, want 3", generated)
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+)
+
+// Content kinds of episodes.
+const (
+	ContentSpeech = "speech"
+	ContentMusic  = "music"
+)
+
+// Speech pauses between phrases several times a minute, music rarely
+// drops to silence. Pauses are quieter than contentPauseNoise dB for at
+// least contentPauseMin, counted over the first contentSample of the
+// audio.
+const (
+	contentPauseNoise     = -30.0
+	contentPauseMin       = 200 * time.Millisecond
+	contentSample         = 10 * time.Minute
+	speechPausesPerMinute = 6.0
+)
+
+// classifyContent tells whether fileIn is speech or music by how often
+// it pauses.
+func classifyContent(videoId, fileIn string) (string, error) {
+	duration, err := probeDuration(fileIn)
+	if err != nil {
+		return "", err
+	}
+	if duration > contentSample {
+		duration = contentSample
+	}
+	if duration < time.Second {
+		return "", fmt.Errorf("audio too short to classify")
+	}
+	af := fmt.Sprintf("silencedetect=noise=%gdB:d=%g", contentPauseNoise, contentPauseMin.Seconds())
+	args := []string{"-hide_banner", "-nostats", "-t", fmt.Sprintf("%g", duration.Seconds()), "-i", fileIn, "-af", af}
+	cmd := exec.Command(converter, append(append(args, threadArgs()...), "-f", "null", "-")...)
+	out, err := runCommand(cmd, videoId, "content")
+	if err != nil {
+		return "", fmt.Errorf("%v: %s", err, out)
+	}
+	pauses := 0
+	for _, m := range silenceRegexp.FindAllStringSubmatch(string(out), -1) {
+		if m[1] == "start" {
+			pauses++
+		}
+	}
+	if float64(pauses)/duration.Minutes() >= speechPausesPerMinute {
+		return ContentSpeech, nil
+	}
+	return ContentMusic, nil
+}
+
+// contentRate returns the bitrate of the audio of fileIn for the
+// format of the feed, the music bitrate of the feed if it is music.
+func contentRate(feed *ConfFeed, videoId, fileIn string) string {
+	rate := feed.AudioFormat().Rate
+	if feed.MusicBitrate == 0 || feed.IsVideo() {
+		return rate
+	}
+	content, err := classifyContent(videoId, fileIn)
+	if err != nil {
+		log.Printf("%s content detection failed: %v", videoId, err)
+		return rate
+	}
+	if content == ContentMusic {
+		rate = fmt.Sprintf("%dk", feed.MusicBitrate)
+	}
+	log.Printf("%s is %s, recoding at %s", videoId, content, rate)
+	return rate
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Browsers cache preflight results this long, in seconds.
+const corsMaxAge = "600"
+
+// ConfCORS lets web pages of other origins read the feeds, audio and
+// API.
+type ConfCORS struct {
+	// Allowed origins, e.g. https://app.example.com, or * for any.
+	Origins []string `json:"origins"`
+	// Allowed methods, GET and HEAD if empty.
+	Methods []string `json:"methods,omitempty"`
+	// Allowed request headers, Authorization if empty.
+	Headers []string `json:"headers,omitempty"`
+}
+
+// validate checks the origins are * or scheme://host[:port].
+func (c *ConfCORS) validate() error {
+	if len(c.Origins) == 0 {
+		return fmt.Errorf("cors: origins are required")
+	}
+	for _, origin := range c.Origins {
+		if origin == "*" {
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" || u.RawQuery != "" {
+			return fmt.Errorf("cors: invalid origin %q, want scheme://host[:port]", origin)
+		}
+	}
+	return nil
+}
+
+func (c *ConfCORS) allows(origin string) bool {
+	return containsString(c.Origins, "*") || containsFold(c.Origins, origin)
+}
+
+func containsFold(list []string, s string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// corsOriginKey marks the requests of origins listed by name, which
+// the API accepts like those of its own pages. * only allows reading.
+type corsOriginKey struct{}
+
+// corsMiddleware adds CORS headers to the responses to allowed origins
+// and answers their preflight requests, before authentication, which
+// preflights do not carry.
+func corsMiddleware(c *ConfCORS, next http.Handler) http.Handler {
+	if c == nil {
+		return next
+	}
+	methods, headers := "GET, HEAD", "Authorization"
+	if len(c.Methods) > 0 {
+		methods = strings.ToUpper(strings.Join(c.Methods, ", "))
+	}
+	if len(c.Headers) > 0 {
+		headers = strings.Join(c.Headers, ", ")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		w.Header().Add("Vary", "Origin")
+		if origin == "" || !c.allows(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+			w.Header().Set("Access-Control-Max-Age", corsMaxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Access-Control-Expose-Headers", "ETag, Last-Modified, Link, Content-Length, Content-Range")
+		if containsFold(c.Origins, origin) {
+			r = r.WithContext(context.WithValue(r.Context(), corsOriginKey{}, origin))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,48 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORS(t *testing.T) {
+	defer func(token string) { apiToken = token }(apiToken)
+	apiToken = "secret"
+	cors := &ConfCORS{Origins: []string{"https://app.example.com"}}
+	if err := cors.validate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := (&ConfCORS{Origins: []string{"https://app.example.com/feeds"}}).validate(); err == nil {
+		t.Error("origin with a path accepted")
+	}
+	h := corsMiddleware(cors, authMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	request := func(method, origin string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/api/status", nil)
+		r.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			r.Header.Set("Access-Control-Request-Method", "GET")
+		} else {
+			r.Header.Set("Authorization", "Bearer secret")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+	// Preflights carry no token.
+	w := request(http.MethodOptions, "https://app.example.com")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" ||
+		w.Header().Get("Access-Control-Allow-Headers") != "Authorization" {
+		t.Errorf("preflight %d %v", w.Code, w.Header())
+	}
+	if w := request(http.MethodGet, "https://app.example.com"); w.Code != http.StatusOK ||
+		w.Header().Get("Access-Control-Allow-Origin") != "https://app.example.com" {
+		t.Errorf("GET %d %v", w.Code, w.Header())
+	}
+	if w := request(http.MethodOptions, "https://evil.example.com"); w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("other origin allowed: %v", w.Header())
+	}
+}
@@ -0,0 +1,318 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const ctlUsage = `usage: lfpod ctl [-server URL] [-token token] <command> [arguments]
+
+Manages a running lfpod server through its management API.
+
+commands:
+  add [-name name] [-k keywords] [-format format] <channel>
+                    add a channel id, @handle or URL
+  remove <feed>     remove a feed by name or channel id
+  list              list feeds
+  update [feed]     update all feeds or one right away
+  add-video [-feed name] <video>
+                    download a single video URL or id into a feed
+  status            show what the update loop is doing
+
+The server and token default to $LFPOD_SERVER and $LFPOD_ADMIN_TOKEN or
+$LFPOD_API_TOKEN, then to server and token in ctl.json of the lfpod user
+configuration directory.
+
+flags:
+`
+
+// ctlConfig is ctl.json in the user configuration directory.
+type ctlConfig struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+}
+
+// ctlClient calls the management API of a server.
+type ctlClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// readCtlConfig reads ctl.json, if any.
+func readCtlConfig() ctlConfig {
+	c := ctlConfig{}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return c
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "lfpod", "ctl.json")); err == nil {
+		json.Unmarshal(data, &c)
+	}
+	return c
+}
+
+// call sends a request to the API and decodes the response into out, if
+// not nil. API errors are returned with their message.
+func (c *ctlClient) call(method, path string, query url.Values, body, out interface{}) error {
+	u := strings.TrimSuffix(c.server, "/") + "/api" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		apiErr := struct {
+			Error APIError `json:"error"`
+		}{}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error.Message != "" {
+			if apiErr.Error.Details != nil {
+				return fmt.Errorf("%s: %v", apiErr.Error.Message, apiErr.Error.Details)
+			}
+			return errors.New(apiErr.Error.Message)
+		}
+		return fmt.Errorf("%s %s: %s", method, u, resp.Status)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// findFeed returns the feed of the server with a name or channel id.
+func (c *ctlClient) findFeed(name string) (ConfFeed, error) {
+	feeds := []ConfFeed{}
+	if err := c.call(http.MethodGet, "/feeds", nil, nil, &feeds); err != nil {
+		return ConfFeed{}, err
+	}
+	if feed, ok := findFeed(feeds, name); ok {
+		return feed, nil
+	}
+	for _, feed := range feeds {
+		if feed.ChannelId == name {
+			return feed, nil
+		}
+	}
+	return ConfFeed{}, fmt.Errorf("feed %q not found", name)
+}
+
+// runCtl implements the ctl command.
+func runCtl(args []string) error {
+	file := readCtlConfig()
+	fs := flag.NewFlagSet("ctl", flag.ExitOnError)
+	server := fs.String("server", firstNonEmpty(os.Getenv("LFPOD_SERVER"), file.Server), "Server URL, e.g. https://pod.example.com.")
+	token := fs.String("token", firstNonEmpty(os.Getenv("LFPOD_ADMIN_TOKEN"), os.Getenv("LFPOD_API_TOKEN"), file.Token), "Admin or API token of the server.")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), ctlUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("command is required")
+	}
+	if *server == "" {
+		return errors.New("server is required, set -server or $LFPOD_SERVER")
+	}
+	c := &ctlClient{server: *server, token: *token, client: &http.Client{Timeout: time.Minute}}
+	args = fs.Args()[1:]
+	switch fs.Arg(0) {
+	case "add":
+		return c.add(args)
+	case "remove":
+		if len(args) != 1 {
+			return errors.New("usage: lfpod ctl remove <feed name or channel id>")
+		}
+		feed, err := c.findFeed(args[0])
+		if err != nil {
+			return err
+		}
+		if err := c.call(http.MethodDelete, "/feeds/"+url.PathEscape(feed.ChannelId), nil, nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("feed %s removed, downloaded audio is kept\n", feed.Title())
+		return nil
+	case "list":
+		feeds := []ConfFeed{}
+		if err := c.call(http.MethodGet, "/feeds", nil, nil, &feeds); err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tCHANNEL\tKEYWORDS")
+		for _, feed := range feeds {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", feed.Title(), feed.ChannelId, strings.Join(feed.Keywords, ", "))
+		}
+		return w.Flush()
+	case "update":
+		query := url.Values{"queue": {"1"}}
+		if len(args) > 1 {
+			return errors.New("usage: lfpod ctl update [feed name or channel id]")
+		} else if len(args) == 1 {
+			feed, err := c.findFeed(args[0])
+			if err != nil {
+				return err
+			}
+			query.Set("channel", feed.ChannelId)
+		}
+		if err := c.call(http.MethodPost, "/update", query, nil, nil); err != nil {
+			return err
+		}
+		fmt.Println("update started")
+		return nil
+	case "add-video":
+		return c.addVideo(args)
+	case "status":
+		return c.status()
+	}
+	return fmt.Errorf("unknown ctl command %q", fs.Arg(0))
+}
+
+// addVideo queues a single video for download into a feed.
+func (c *ctlClient) addVideo(args []string) error {
+	fs := flag.NewFlagSet("ctl add-video", flag.ExitOnError)
+	name := fs.String("feed", "", "Feed name, slug or channel id, the inbox feed if empty.")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: lfpod ctl add-video [-feed name] <video URL or id>")
+	}
+	query := url.Values{"url": {fs.Arg(0)}}
+	if *name != "" {
+		query.Set("feed", *name)
+	}
+	added := struct {
+		VideoId string `json:"video_id"`
+		Feed    string `json:"feed"`
+	}{}
+	if err := c.call(http.MethodPost, "/videos", query, nil, &added); err != nil {
+		return err
+	}
+	fmt.Printf("%s queued for %s\n", added.VideoId, added.Feed)
+	return nil
+}
+
+// add adds a feed, resolving handles, URLs and the feed name with the
+// channel search of the server.
+func (c *ctlClient) add(args []string) error {
+	fs := flag.NewFlagSet("ctl add", flag.ExitOnError)
+	name := fs.String("name", "", "Feed name, the channel title if empty.")
+	keywords := fs.String("k", "", "Comma separated keywords of downloaded videos, all videos if empty.")
+	format := fs.String("format", "", "Audio format: opus, caf or m4a.")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: lfpod ctl add [-name name] [-k keywords] [-format format] <channel id, @handle or URL>")
+	}
+	feed := ConfFeed{Name: *name, Format: *format}
+	feed.ChannelId, _ = parseChannelId(fs.Arg(0))
+	if feed.ChannelId == "" || feed.Name == "" {
+		q := fs.Arg(0)
+		if feed.ChannelId != "" {
+			q = "https://www.youtube.com/channel/" + feed.ChannelId
+		}
+		candidates := []ChannelCandidate{}
+		if err := c.call(http.MethodGet, "/search", url.Values{"q": {q}, "limit": {"1"}}, nil, &candidates); err != nil {
+			return err
+		}
+		if len(candidates) == 0 {
+			return fmt.Errorf("channel %s not found", fs.Arg(0))
+		}
+		feed.ChannelId = candidates[0].ChannelId
+		if feed.Name == "" {
+			feed.Name = candidates[0].Title
+		}
+	}
+	for _, k := range strings.Split(*keywords, ",") {
+		if k = strings.TrimSpace(k); k != "" {
+			feed.Keywords = append(feed.Keywords, k)
+		}
+	}
+	if err := c.call(http.MethodPost, "/feeds", nil, feed, nil); err != nil {
+		return err
+	}
+	fmt.Printf("feed %s added\n", feed.Name)
+	return nil
+}
+
+// status prints the update loop status.
+func (c *ctlClient) status() error {
+	s := LoopStatus{}
+	if err := c.call(http.MethodGet, "/status", nil, nil, &s); err != nil {
+		return err
+	}
+	state := "idle"
+	switch {
+	case s.Paused:
+		state = "paused"
+	case s.Running:
+		state = "updating"
+	}
+	fmt.Println("state:", state)
+	if s.ThrottledUntil != nil {
+		fmt.Printf("rate limited until %s: %s\n", s.ThrottledUntil.Local().Format(time.RFC1123), s.ThrottleReason)
+	}
+	if s.QuietUntil != nil {
+		fmt.Println("quiet hours until", s.QuietUntil.Local().Format(time.RFC1123))
+	}
+	if s.LastUpdate != nil {
+		fmt.Println("last update:", s.LastUpdate.Local().Format(time.RFC1123))
+	}
+	for _, j := range s.Active {
+		fmt.Printf("processing: %s %s %q\n", j.Feed, j.VideoId, j.Title)
+	}
+	if len(s.Queued) > 0 {
+		fmt.Printf("queued: %d videos\n", len(s.Queued))
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "FEED\tLAST SUCCESS\tLAST ERROR")
+	for _, f := range s.Feeds {
+		success := "-"
+		if f.LastSuccess != nil {
+			success = f.LastSuccess.Local().Format("2006-01-02 15:04")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", f.Name, success, f.LastError)
+	}
+	return w.Flush()
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,63 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// workDir is the working directory lfpod was started in, before
+// enterDataDir changed to the data directory.
+var workDir string
+
+// enterDataDir changes to the data directory, so audio, state and
+// temporary files are kept under it. It is dir, or the data_dir of the
+// configuration file relative to the file, the working directory if
+// both are empty. The configuration file name is made absolute first.
+func enterDataDir(confFile *string, dir string) error {
+	abs, err := filepath.Abs(*confFile)
+	if err != nil {
+		return err
+	}
+	if workDir, err = os.Getwd(); err != nil {
+		return err
+	}
+	*confFile = abs
+	if dir == "" {
+		conf := ConfFeeds{}
+		if data, err := os.ReadFile(abs); err == nil && json.Unmarshal(data, &conf) == nil && conf.DataDir != "" {
+			dir = conf.DataDir
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(filepath.Dir(abs), dir)
+			}
+		}
+	}
+	if dir == "" {
+		return nil
+	}
+	if dir, err = filepath.Abs(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return err
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	log.Print("data directory ", dir)
+	return nil
+}
+
+// argPath returns a file name given on the command line of a command
+// relative to the directory lfpod was started in, not the data
+// directory. - for standard input or output is kept.
+func argPath(name string) string {
+	if name == "-" || filepath.IsAbs(name) || workDir == "" {
+		return name
+	}
+	return filepath.Join(workDir, name)
+}
@@ -0,0 +1,156 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+	"github.com/lfpod/youtube"
+)
+
+var errEpisodeNotFound = errors.New("episode not found")
+
+// deleteEpisode deletes the audio and sidecar files of a downloaded
+// episode, dropping it from the feeds. The video is kept in the
+// download archive so it is not downloaded again, or removed from it
+// with refetch to download it again while in the channel feed.
+func deleteEpisode(ctx context.Context, feed ConfFeed, videoId string, refetch bool) (ArchiveFile, error) {
+	name, _, ok := feed.FindAudioFile(videoId)
+	if !ok {
+		return ArchiveFile{}, errEpisodeNotFound
+	}
+	info, err := os.Stat(name)
+	if err != nil {
+		return ArchiveFile{}, err
+	}
+	f := ArchiveFile{ChannelId: feed.ChannelId, VideoId: videoId, Path: name, Size: info.Size(), Modified: info.ModTime()}
+	if err := deleteEpisodeFiles(ctx, f); err != nil {
+		return ArchiveFile{}, err
+	}
+	if refetch {
+		err = downloadArchive.Remove(feed.ChannelId, videoId)
+	} else {
+		err = downloadArchive.Add(feed.ChannelId, videoId)
+	}
+	if err != nil {
+		log.Print(err)
+	}
+	if _, err := pruneBlobs(); err != nil {
+		log.Print(err)
+	}
+	events.Publish(Event{Type: EventEpisodeDeleted, Feed: feed.Name, ChannelId: feed.ChannelId, VideoId: videoId, Size: f.Size})
+	feedVersion.Bump()
+	return f, nil
+}
+
+func apiEpisodeDeleteHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	feed, ok := conf.GetFeed(vars["channelId"])
+	if !ok {
+		apiError(w, http.StatusNotFound, "feed not found", vars["channelId"])
+		return
+	}
+	refetch := r.FormValue("refetch")
+	_, err := deleteEpisode(r.Context(), feed, vars["videoId"], refetch == "1" || refetch == "true")
+	if errors.Is(err, errEpisodeNotFound) {
+		apiError(w, http.StatusNotFound, err.Error(), vars["videoId"])
+		return
+	} else if err != nil {
+		apiError(w, http.StatusInternalServerError, "episode not deleted", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// redownloadEpisode deletes the audio of a video, if downloaded, clears
+// its archive, pruned, blocked and failed state and queues it for a
+// fresh download and recode. It reports whether an audio file was
+// deleted.
+func redownloadEpisode(ctx context.Context, feed ConfFeed, videoId string) (bool, error) {
+	_, err := deleteEpisode(ctx, feed, videoId, true)
+	if err != nil && !errors.Is(err, errEpisodeNotFound) {
+		return false, err
+	}
+	deleted := err == nil
+	if !deleted {
+		if err := downloadArchive.Remove(feed.ChannelId, videoId); err != nil {
+			log.Print(err)
+		}
+	}
+	pruned.Remove(videoId)
+	blocked.Remove(videoId)
+	// The attempts of a failed video start over.
+	retries.Done(videoId)
+	entry := &youtube.Entry{VideoId: videoId}
+	for _, ep := range listEpisodes(ctx, feed.ChannelId) {
+		if ep.VideoId == videoId {
+			entry = &youtube.Entry{VideoId: videoId, Title: ep.Title, Published: ep.Published, Media: &youtube.Media{Description: ep.Description}}
+		}
+	}
+	retries.Queue(feed.ChannelId, entry, "redownload")
+	log.Print("queued ", videoId, " for download again")
+	return deleted, nil
+}
+
+func apiEpisodeRedownloadHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	feed, ok := conf.GetFeed(vars["channelId"])
+	if !ok {
+		apiError(w, http.StatusNotFound, "feed not found", vars["channelId"])
+		return
+	}
+	videoId, err := parseVideoId(vars["videoId"])
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err.Error(), vars["videoId"])
+		return
+	}
+	if serveOnly {
+		apiError(w, http.StatusServiceUnavailable, "updates run in a separate process", nil)
+		return
+	}
+	deleted, err := redownloadEpisode(r.Context(), feed, videoId)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "episode not deleted", err.Error())
+		return
+	}
+	triggerUpdate(UpdateRequest{ChannelId: feed.ChannelId})
+	writeJSON(w, http.StatusAccepted, struct {
+		VideoId string `json:"video_id"`
+		Feed    string `json:"feed"`
+		Channel string `json:"channel"`
+		Deleted bool   `json:"deleted"`
+	}{videoId, feed.Name, feed.ChannelId, deleted})
+}
+
+// runDelete implements the delete command.
+func runDelete(ctx context.Context, conf *Conf, args []string) error {
+	fs := flag.NewFlagSet("delete", flag.ExitOnError)
+	refetch := fs.Bool("refetch", false, "Download the video again on the next update while in the channel feed.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod delete [-refetch] <feed name or channel id> <video id>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("feed and video id are required")
+	}
+	feed, ok := conf.FindFeed(fs.Arg(0))
+	if !ok {
+		return fmt.Errorf("feed %q not found", fs.Arg(0))
+	}
+	f, err := deleteEpisode(ctx, feed, fs.Arg(1), *refetch)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(1), err)
+	}
+	fmt.Printf("deleted %s, %s freed\n", f.Path, formatMB(f.Size))
+	return nil
+}
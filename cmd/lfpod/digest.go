@@ -0,0 +1,173 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DigestEpisode is an episode in a digest.
+type DigestEpisode struct {
+	FeedEpisode
+	Duration time.Duration
+}
+
+// digestEpisodes returns the downloaded episodes of a feed published
+// in [from, to), oldest first. Uploaded episodes are left out, only
+// placeholders of them are kept locally.
+func digestEpisodes(ctx context.Context, feed ConfFeed, from, to time.Time) []DigestEpisode {
+	list := []DigestEpisode{}
+	archived := archivedEpisodes(ctx, []ConfFeed{feed}, 1)
+	for i := len(archived) - 1; i >= 0; i-- {
+		ep := archived[i]
+		if ep.Published.Before(from) || (!to.IsZero() && !ep.Published.Before(to)) {
+			continue
+		}
+		if isHollow(ep.File) {
+			continue
+		}
+		list = append(list, DigestEpisode{FeedEpisode: ep})
+	}
+	return list
+}
+
+func probeDuration(file string) (time.Duration, error) {
+	cmd := exec.Command(probe, "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", file)
+	out, err := runCommand(cmd, "", "probe")
+	if err != nil {
+		return 0, fmt.Errorf("%s: %v: %s", file, err, out)
+	}
+	sec, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration %q", file, out)
+	}
+	return time.Duration(sec * float64(time.Second)), nil
+}
+
+// cueSheet returns a cue sheet with a track per episode in file.
+func cueSheet(title, file string, list []DigestEpisode) string {
+	quote := strings.NewReplacer(`"`, "'", "\n", " ")
+	var b strings.Builder
+	fileType := "WAVE"
+	if strings.EqualFold(filepath.Ext(file), ".mp3") {
+		fileType = "MP3"
+	}
+	fmt.Fprintf(&b, "TITLE \"%s\"\nFILE \"%s\" %s\n", quote.Replace(title), quote.Replace(filepath.Base(file)), fileType)
+	var start time.Duration
+	for i, ep := range list {
+		// Cue sheet times are minutes, seconds and frames of 1/75 s.
+		frames := start.Milliseconds() * 75 / 1000
+		fmt.Fprintf(&b, "  TRACK %02d AUDIO\n    TITLE \"%s\"\n    INDEX 01 %02d:%02d:%02d\n",
+			i+1, quote.Replace(ep.Title), frames/75/60, frames/75%60, frames%75)
+		start += ep.Duration
+	}
+	return b.String()
+}
+
+// concatList returns the file list for the ffmpeg concat demuxer.
+func concatList(list []DigestEpisode) (string, error) {
+	var b strings.Builder
+	b.WriteString("ffconcat version 1.0\n")
+	for _, ep := range list {
+		abs, err := filepath.Abs(ep.File)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "file '%s'\n", strings.ReplaceAll(abs, "'", `'\''`))
+	}
+	return b.String(), nil
+}
+
+func buildDigest(title, output, bitrate string, list []DigestEpisode) error {
+	dir, err := os.MkdirTemp("", "lfpod-digest-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	concat, err := concatList(list)
+	if err != nil {
+		return err
+	}
+	listFile := filepath.Join(dir, "list.txt")
+	metaFile := filepath.Join(dir, "metadata.txt")
+	if err := os.WriteFile(listFile, []byte(concat), 0600); err != nil {
+		return err
+	}
+	chapters := []Chapter{}
+	var start time.Duration
+	for _, ep := range list {
+		chapters = append(chapters, Chapter{start, start + ep.Duration, ep.Title})
+		start += ep.Duration
+	}
+	if err := os.WriteFile(metaFile, []byte(ffmetadata(title, chapters)), 0600); err != nil {
+		return err
+	}
+	cmd := exec.Command(converter, "-v", "error", "-f", "concat", "-safe", "0", "-i", listFile,
+		"-i", metaFile, "-map", "0:a", "-map_metadata", "1", "-map_chapters", "1",
+		"-b:a", bitrate, "-y", output)
+	if out, err := runCommand(cmd, "", "digest"); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	cue := strings.TrimSuffix(output, filepath.Ext(output)) + ".cue"
+	return os.WriteFile(cue, []byte(cueSheet(title, output, list)), 0644)
+}
+
+func runDigest(ctx context.Context, conf *Conf, args []string) error {
+	fs := flag.NewFlagSet("digest", flag.ExitOnError)
+	fromDate := fs.String("from", "", "First publication date, YYYY-MM-DD.")
+	toDate := fs.String("to", "", "Last publication date, YYYY-MM-DD.")
+	output := fs.String("o", "digest.mp3", "Output file, its extension selects the format.")
+	bitrate := fs.String("b", "64k", "Output bitrate.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod digest [-from date] [-to date] [-o file] [-b bitrate] <feed name or channel id>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("feed is required")
+	}
+	feed, ok := conf.FindFeed(fs.Arg(0))
+	if !ok {
+		return fmt.Errorf("feed %q not found", fs.Arg(0))
+	}
+	var from, to time.Time
+	var err error
+	if *fromDate != "" {
+		if from, err = time.Parse("2006-01-02", *fromDate); err != nil {
+			return err
+		}
+	}
+	if *toDate != "" {
+		if to, err = time.Parse("2006-01-02", *toDate); err != nil {
+			return err
+		}
+		to = to.AddDate(0, 0, 1)
+	}
+	list := digestEpisodes(ctx, feed, from, to)
+	if len(list) == 0 {
+		return errors.New("no episodes in range")
+	}
+	for i := range list {
+		if list[i].Duration, err = probeDuration(list[i].File); err != nil {
+			return err
+		}
+	}
+	title := feed.Title() + " " + list[0].Published.Format("2006-01-02") + " – " + list[len(list)-1].Published.Format("2006-01-02")
+	if err := buildDigest(title, *output, *bitrate, list); err != nil {
+		return err
+	}
+	fmt.Printf("%d episodes written to %s\n", len(list), *output)
+	return nil
+}
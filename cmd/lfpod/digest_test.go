@@ -0,0 +1,74 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func digestEpisode(title, file string, duration time.Duration) DigestEpisode {
+	return DigestEpisode{FeedEpisode: FeedEpisode{Title: title, File: file}, Duration: duration}
+}
+
+func TestCueSheet(t *testing.T) {
+	for _, tc := range []struct {
+		name, title, file string
+		list              []DigestEpisode
+		want              string
+	}{
+		{"empty", "Digest", "digest.wav", nil, "TITLE \"Digest\"\nFILE \"digest.wav\" WAVE\n"},
+		{
+			"tracks", "News", "out/digest.MP3",
+			[]DigestEpisode{
+				digestEpisode("First", "a.opus", 61*time.Second+500*time.Millisecond),
+				digestEpisode("Second", "b.opus", time.Hour),
+				digestEpisode("Third", "c.opus", time.Second),
+			},
+			"TITLE \"News\"\nFILE \"digest.MP3\" MP3\n" +
+				"  TRACK 01 AUDIO\n    TITLE \"First\"\n    INDEX 01 00:00:00\n" +
+				// 61.5 s is 1 minute, 1 second and 37 frames of 1/75 s.
+				"  TRACK 02 AUDIO\n    TITLE \"Second\"\n    INDEX 01 01:01:37\n" +
+				// Minutes go on past an hour.
+				"  TRACK 03 AUDIO\n    TITLE \"Third\"\n    INDEX 01 61:01:37\n",
+		},
+		{
+			"quotes", "\"Late\" show", "it's.m4a",
+			[]DigestEpisode{digestEpisode("Say \"hi\"\nagain", "a.opus", time.Second)},
+			"TITLE \"'Late' show\"\nFILE \"it's.m4a\" WAVE\n" +
+				"  TRACK 01 AUDIO\n    TITLE \"Say 'hi' again\"\n    INDEX 01 00:00:00\n",
+		},
+	} {
+		if got := cueSheet(tc.title, tc.file, tc.list); got != tc.want {
+			t.Errorf("%s: cue sheet\n%s\nwant\n%s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestConcatList(t *testing.T) {
+	dir := t.TempDir()
+	for _, tc := range []struct {
+		name string
+		list []DigestEpisode
+		want string
+	}{
+		{"empty", nil, "ffconcat version 1.0\n"},
+		{
+			"files", []DigestEpisode{digestEpisode("", filepath.Join(dir, "a.opus"), 0), digestEpisode("", filepath.Join(dir, "it's.opus"), 0)},
+			"ffconcat version 1.0\nfile '" + filepath.Join(dir, "a.opus") + "'\nfile '" + filepath.Join(dir, `it'\''s.opus`) + "'\n",
+		},
+	} {
+		got, err := concatList(tc.list)
+		if err != nil || got != tc.want {
+			t.Errorf("%s: list %q, %v, want %q", tc.name, got, err, tc.want)
+		}
+	}
+	// Relative paths are made absolute, the list is in another directory.
+	got, err := concatList([]DigestEpisode{digestEpisode("", filepath.Join("audio", "a.opus"), 0)})
+	abs, _ := filepath.Abs(filepath.Join("audio", "a.opus"))
+	if err != nil || got != "ffconcat version 1.0\nfile '"+abs+"'\n" {
+		t.Errorf("relative path listed as %q, %v", got, err)
+	}
+}
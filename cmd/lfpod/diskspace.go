@@ -0,0 +1,34 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Downloads wait while the download or audio directory has less free
+// space than this many bytes, 0 to never.
+var minFreeSpace int64 = 500 << 20
+
+var errLowDiskSpace = errors.New("low disk space")
+
+// checkFreeSpace returns errLowDiskSpace if a download could fill the
+// disk. File systems whose free space is unknown pass.
+func checkFreeSpace() error {
+	if minFreeSpace <= 0 {
+		return nil
+	}
+	for _, dir := range []string{downloadDir, "audio"} {
+		free, ok := freeSpace(dir)
+		if !ok {
+			continue
+		}
+		metrics.Set("lfpod_free_disk_bytes", labels("dir", dir), float64(free))
+		if free < minFreeSpace {
+			return fmt.Errorf("%w: %d MB free in %s, want %d MB", errLowDiskSpace, free>>20, dir, minFreeSpace>>20)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,10 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package main
+
+func freeSpace(dir string) (int64, bool) {
+	return 0, false
+}
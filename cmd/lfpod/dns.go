@@ -0,0 +1,155 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// lookupFunc resolves a host name to IP addresses for the given
+// network, "ip", "ip4" or "ip6".
+type lookupFunc func(ctx context.Context, network, host string) ([]net.IP, error)
+
+// newLookup returns a resolver for a DNS server URL:
+// https://host/dns-query for DNS-over-HTTPS (JSON API) or tls://host:853
+// for DNS-over-TLS. An empty URL selects the system resolver.
+func newLookup(server string) (lookupFunc, error) {
+	if server == "" {
+		return net.DefaultResolver.LookupIP, nil
+	}
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "https":
+		return dohLookup(server), nil
+	case "tls":
+		host := u.Host
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "853")
+		}
+		return dotLookup(host, u.Hostname()), nil
+	}
+	return nil, fmt.Errorf("unsupported DNS server %q, use https:// or tls://", server)
+}
+
+func dotLookup(address, serverName string) lookupFunc {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := &tls.Dialer{Config: &tls.Config{ServerName: serverName}}
+			return d.DialContext(ctx, "tcp", address)
+		},
+	}
+	return resolver.LookupIP
+}
+
+type dohAnswer struct {
+	Type int    `json:"type"`
+	Data string `json:"data"`
+}
+
+type dohResponse struct {
+	Status int         `json:"Status"`
+	Answer []dohAnswer `json:"Answer"`
+}
+
+func dohLookup(server string) lookupFunc {
+	client := &http.Client{Timeout: 5 * time.Second}
+	query := func(ctx context.Context, host, qtype string) ([]net.IP, error) {
+		u, _ := url.Parse(server)
+		q := u.Query()
+		q.Set("name", host)
+		q.Set("type", qtype)
+		u.RawQuery = q.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/dns-json")
+		res, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			return nil, errors.New("DNS server response status " + res.Status)
+		}
+		doh := dohResponse{}
+		if err := json.NewDecoder(res.Body).Decode(&doh); err != nil {
+			return nil, err
+		}
+		ips := []net.IP{}
+		for _, a := range doh.Answer {
+			if ip := net.ParseIP(a.Data); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+		return ips, nil
+	}
+	return func(ctx context.Context, network, host string) ([]net.IP, error) {
+		ips := []net.IP{}
+		if network != "ip6" {
+			if res, err := query(ctx, host, "A"); err == nil {
+				ips = append(ips, res...)
+			} else if network == "ip4" {
+				return nil, err
+			}
+		}
+		if network != "ip4" {
+			res, err := query(ctx, host, "AAAA")
+			if err != nil && len(ips) == 0 {
+				return nil, err
+			}
+			ips = append(ips, res...)
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("no addresses found for %s", host)
+		}
+		return ips, nil
+	}
+}
+
+// dialContext resolves host names with lookup and dials the resolved
+// addresses in order, restricting them to the IP version of network.
+func dialContext(dialer *net.Dialer, lookup lookupFunc) func(ctx context.Context, network, address string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) != nil {
+			return dialer.DialContext(ctx, network, address)
+		}
+		ipNetwork := "ip"
+		if strings.HasSuffix(network, "4") || strings.HasSuffix(network, "6") {
+			ipNetwork += network[len(network)-1:]
+		}
+		ips, err := lookup(ctx, ipNetwork, host)
+		if err != nil {
+			return nil, err
+		}
+		var firstErr error
+		for _, ip := range ips {
+			conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(ip.String(), port))
+			if err == nil {
+				return conn, nil
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+		return nil, firstErr
+	}
+}
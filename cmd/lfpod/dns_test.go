@@ -0,0 +1,141 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNewLookup(t *testing.T) {
+	for _, tc := range []struct {
+		server string
+		ok     bool
+	}{
+		{"", true},
+		{"https://dns.example/dns-query", true},
+		{"tls://1.1.1.1", true},
+		{"tls://dns.example:5353", true},
+		{"udp://1.1.1.1", false},
+		{"1.1.1.1", false},
+		{"https://dns example", false},
+	} {
+		lookup, err := newLookup(tc.server)
+		if (err == nil) != tc.ok || (err == nil) != (lookup != nil) {
+			t.Errorf("%q: %v", tc.server, err)
+		}
+	}
+}
+
+func TestDohLookup(t *testing.T) {
+	answers := map[string]string{
+		"both.example A":    `{"Status":0,"Answer":[{"type":5,"data":"alias.example."},{"type":1,"data":"192.0.2.1"}]}`,
+		"both.example AAAA": `{"Status":0,"Answer":[{"type":28,"data":"2001:db8::1"}]}`,
+		"v4.example A":      `{"Status":0,"Answer":[{"type":1,"data":"192.0.2.2"}]}`,
+		"v4.example AAAA":   `{"Status":0}`,
+		"v6.example AAAA":   `{"Status":0,"Answer":[{"type":28,"data":"2001:db8::2"}]}`,
+		"none.example A":    `{"Status":3}`,
+		"none.example AAAA": `{"Status":3}`,
+	}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept") != "application/dns-json" {
+			t.Errorf("Accept %q", r.Header.Get("Accept"))
+		}
+		answer, ok := answers[r.URL.Query().Get("name")+" "+r.URL.Query().Get("type")]
+		if !ok {
+			http.Error(w, "server failure", http.StatusBadGateway)
+			return
+		}
+		fmt.Fprint(w, answer)
+	}))
+	defer srv.Close()
+	lookup := dohLookup(srv.URL + "/dns-query")
+	for _, tc := range []struct {
+		network, host string
+		want          string
+	}{
+		{"ip", "both.example", "192.0.2.1 2001:db8::1"},
+		{"ip4", "both.example", "192.0.2.1"},
+		{"ip6", "both.example", "2001:db8::1"},
+		{"ip", "v4.example", "192.0.2.2"},
+		{"ip6", "v4.example", ""},
+		// The A query fails, the AAAA one answers.
+		{"ip", "v6.example", "2001:db8::2"},
+		{"ip4", "v6.example", ""},
+		{"ip", "none.example", ""},
+	} {
+		ips, err := lookup(context.Background(), tc.network, tc.host)
+		got := []string{}
+		for _, ip := range ips {
+			got = append(got, ip.String())
+		}
+		if strings.Join(got, " ") != tc.want || (err == nil) != (tc.want != "") {
+			t.Errorf("%s %s: %v, %v, want %q", tc.network, tc.host, got, err, tc.want)
+		}
+	}
+}
+
+func TestDialContext(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	// A closed port to fail on before the listening one.
+	closed, err := net.Listen("tcp4", "127.0.0.2:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	closedAddr := closed.Addr().(*net.TCPAddr)
+	closed.Close()
+	for _, tc := range []struct {
+		network, address string
+		ips              []string
+		lookupNetwork    string
+		ok               bool
+	}{
+		{"tcp", "127.0.0.1:" + port, nil, "", true},
+		{"tcp", "lfpod.test:" + port, []string{"127.0.0.1"}, "ip", true},
+		{"tcp4", "lfpod.test:" + port, []string{"127.0.0.1"}, "ip4", true},
+		{"tcp6", "lfpod.test:" + port, nil, "ip6", false},
+		{"tcp", fmt.Sprintf("lfpod.test:%d", closedAddr.Port), []string{"127.0.0.2"}, "ip", false},
+		{"tcp", "lfpod.test", nil, "", false},
+	} {
+		looked := ""
+		lookup := func(ctx context.Context, network, host string) ([]net.IP, error) {
+			looked = network
+			if len(tc.ips) == 0 {
+				return nil, errors.New("no addresses")
+			}
+			ips := []net.IP{}
+			for _, ip := range tc.ips {
+				ips = append(ips, net.ParseIP(ip))
+			}
+			return ips, nil
+		}
+		conn, err := dialContext(&net.Dialer{}, lookup)(context.Background(), tc.network, tc.address)
+		if err == nil {
+			conn.Close()
+		}
+		if (err == nil) != tc.ok || looked != tc.lookupNetwork {
+			t.Errorf("%s %s: %v, looked up %q, want %q", tc.network, tc.address, err, looked, tc.lookupNetwork)
+		}
+	}
+}
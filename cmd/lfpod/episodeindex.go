@@ -0,0 +1,113 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type indexedFile struct {
+	size    int64
+	modTime time.Time
+}
+
+// EpisodeIndex keeps the listing of the channel audio directories in
+// memory, so feed requests do not stat every file, which is slow on
+// network storage. A channel is listed again on first use after the
+// feed version changed: the pipeline bumps it with every new or deleted
+// episode and the archive watcher with changes made by other processes.
+type EpisodeIndex struct {
+	mu       sync.Mutex
+	version  int64
+	channels map[string]map[string]indexedFile
+}
+
+var episodeIndex = EpisodeIndex{channels: map[string]map[string]indexedFile{}}
+
+// files returns the files of the audio directory of a channel by name.
+func (x *EpisodeIndex) files(channelId string) map[string]indexedFile {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if v := feedVersion.Current(); v != x.version {
+		x.version = v
+		x.channels = map[string]map[string]indexedFile{}
+	}
+	if files, ok := x.channels[channelId]; ok {
+		return files
+	}
+	files := map[string]indexedFile{}
+	entries, _ := os.ReadDir(filepath.Join("audio", channelId))
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		if info, err := e.Info(); err == nil {
+			files[e.Name()] = indexedFile{info.Size(), info.ModTime()}
+		}
+	}
+	x.channels[channelId] = files
+	return files
+}
+
+// Invalidate drops the listings, the directories are read again.
+func (x *EpisodeIndex) Invalidate() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.channels = map[string]map[string]indexedFile{}
+}
+
+// Has reports whether a file of the audio directory of a channel exists.
+func (x *EpisodeIndex) Has(channelId, name string) bool {
+	_, ok := x.files(channelId)[name]
+	return ok
+}
+
+// FindAudio returns the audio file of a video like
+// ConfFeed.FindAudioFile, with its size and modification time.
+func (x *EpisodeIndex) FindAudio(feed *ConfFeed, videoId string) (string, AudioFormat, indexedFile, bool) {
+	files := x.files(feed.ChannelId)
+	want := feed.AudioFormat()
+	if f, ok := files[videoId+"."+want.Ext]; ok {
+		return getAudioFileName(feed.ChannelId, videoId, want.Ext), want, f, true
+	}
+	for _, ext := range audioFormatNames {
+		if f, ok := files[videoId+"."+ext]; ok {
+			return getAudioFileName(feed.ChannelId, videoId, ext), audioFormats[ext], f, true
+		}
+	}
+	return "", AudioFormat{}, indexedFile{}, false
+}
+
+// WithPrefix returns the names of the files of the audio directory of a
+// channel starting with prefix, sorted.
+func (x *EpisodeIndex) WithPrefix(channelId, prefix string) []string {
+	names := []string{}
+	for name := range x.files(channelId) {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// VideoIds returns the ids of the videos of a channel with audio files.
+func (x *EpisodeIndex) VideoIds(channelId string) []string {
+	ids := []string{}
+	seen := map[string]bool{}
+	for name := range x.files(channelId) {
+		videoId, ext, ok := strings.Cut(name, ".")
+		if ok && containsString(audioFormatNames, ext) && !seen[videoId] {
+			seen[videoId] = true
+			ids = append(ids, videoId)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
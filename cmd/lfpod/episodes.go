@@ -0,0 +1,82 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lfpod/store"
+	"github.com/lfpod/youtube"
+)
+
+// episodes keeps the last known pipeline status and metadata of videos
+// seen by the update loop. It is in memory until loadEpisodes opens the
+// database file.
+var episodes = memoryEpisodes()
+
+func memoryEpisodes() *store.Store {
+	s, err := store.Open(context.Background(), ":memory:")
+	if err != nil {
+		log.Fatal(err)
+	}
+	return s
+}
+
+// loadEpisodes opens the episode database in file.
+func loadEpisodes(ctx context.Context, file string) error {
+	s, err := store.Open(ctx, file)
+	if err != nil {
+		return err
+	}
+	episodes.Close()
+	episodes = s
+	return nil
+}
+
+// logStore logs failed changes of the episode store, the pipeline goes
+// on without them.
+func logStore(err error) {
+	if err != nil {
+		log.Print("episode store: ", err)
+	}
+}
+
+// listEpisodes returns the stored episodes of a channel, or of all
+// channels if channelId is empty, newest first. None are listed if the
+// store fails.
+func listEpisodes(ctx context.Context, channelId string) []store.Episode {
+	list, err := episodes.List(ctx, channelId)
+	logStore(err)
+	return list
+}
+
+// Episodes not ready and unchanged this long are dropped from the store
+// once they left the channel feed.
+const episodeExpiry = 30 * 24 * time.Hour
+
+// pruneEpisodes drops the stale episodes of the channels read by an
+// update pass that left their channel feed and are not queued for a
+// retry, so the store does not keep every video ever seen. Channels
+// whose feed failed keep theirs.
+func pruneEpisodes(ctx context.Context, feeds []ConfFeed, ytfeeds []*youtube.Feed) {
+	channels, current := map[string]bool{}, map[string]bool{}
+	for i, ytfeed := range ytfeeds {
+		if ytfeed == nil {
+			continue
+		}
+		channels[feeds[i].ChannelId] = true
+		for _, entry := range ytfeed.Entries {
+			current[entry.VideoId] = true
+		}
+	}
+	n, err := episodes.Prune(ctx, time.Now().Add(-episodeExpiry), func(ep store.Episode) bool {
+		return ep.ChannelId != "" && !channels[ep.ChannelId] || current[ep.VideoId] || retries.Has(ep.VideoId)
+	})
+	logStore(err)
+	if n > 0 {
+		log.Printf("pruned %d episodes from the store", n)
+	}
+}
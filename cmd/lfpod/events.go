@@ -0,0 +1,156 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Event types published on the event bus.
+const (
+	EventEpisodeDiscovered  = "episode.discovered"
+	EventDownloadStarted    = "download.started"
+	EventDownloadFinished   = "download.finished"
+	EventDownloadFailed     = "download.failed"
+	EventFeedGenerated      = "feed.generated"
+	EventPruneExecuted      = "prune.executed"
+	EventFeedFailed         = "feed.failed"
+	EventEpisodeDeleted     = "episode.deleted"
+	EventDownloaderUpdated  = "downloader.updated"
+	EventDownloaderOutdated = "downloader.outdated"
+)
+
+// Event is something the update loop or the server did.
+type Event struct {
+	Type      string    `json:"type"`
+	Time      time.Time `json:"time"`
+	Feed      string    `json:"feed,omitempty"`
+	ChannelId string    `json:"channel_id,omitempty"`
+	VideoId   string    `json:"video_id,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	// Bytes downloaded or freed, episodes in a generated feed or
+	// files pruned.
+	Size  int64 `json:"size,omitempty"`
+	Count int   `json:"count,omitempty"`
+}
+
+// jobEvent returns an event about the video of a job.
+func jobEvent(typ string, job Job) Event {
+	return Event{Type: typ, Feed: job.Feed.Name, ChannelId: job.Feed.ChannelId, VideoId: job.Entry.VideoId, Title: job.Entry.Title}
+}
+
+// EventBus delivers events to subscribers in order of publication.
+// Subscribers are called synchronously and must not block.
+type EventBus struct {
+	mu     sync.Mutex
+	nextId int
+	subs   map[int]func(Event)
+}
+
+var events = EventBus{subs: map[int]func(Event){}}
+
+// Subscribe calls fn for every event published until the returned
+// function is called.
+func (b *EventBus) Subscribe(fn func(Event)) func() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextId
+	b.nextId++
+	b.subs[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subs, id)
+	}
+}
+
+func (b *EventBus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	// Publishing is serialized, so subscribers see events in order.
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, fn := range b.subs {
+		fn(e)
+	}
+}
+
+// countEvents keeps the pipeline counters of the metrics endpoint.
+func countEvents(e Event) {
+	l := labels("feed", e.Feed)
+	switch e.Type {
+	case EventEpisodeDiscovered:
+		metrics.Add("lfpod_videos_discovered_total", l, 1)
+	case EventDownloadFinished:
+		metrics.Add("lfpod_downloads_succeeded_total", l, 1)
+	case EventDownloadFailed:
+		metrics.Add("lfpod_downloads_failed_total", l, 1)
+	}
+}
+
+// openEventLog appends every event to file as a JSON line, an audit
+// trail of what lfpod did.
+func openEventLog(file string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0640)
+	if err != nil {
+		return err
+	}
+	events.Subscribe(func(e Event) {
+		data, err := json.Marshal(e)
+		if err == nil {
+			_, err = f.Write(append(data, '\n'))
+		}
+		if err != nil {
+			log.Print("event log: ", err)
+		}
+	})
+	return nil
+}
+
+// Events buffered per server-sent events client, more are dropped if
+// the client does not keep up.
+const eventStreamBuffer = 64
+
+// apiEventsHandler streams events as server-sent events.
+func apiEventsHandler(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	ch := make(chan Event, eventStreamBuffer)
+	unsubscribe := events.Subscribe(func(e Event) {
+		select {
+		case ch <- e:
+		default:
+		}
+	})
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+	keepAlive := time.NewTicker(30 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e := <-ch:
+			data, _ := json.Marshal(e)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Type, data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
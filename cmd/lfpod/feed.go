@@ -0,0 +1,575 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lfpod/server"
+	"github.com/lfpod/store"
+	"github.com/lfpod/youtube"
+)
+
+// FeedEpisode is an episode with a downloaded audio file.
+type FeedEpisode struct {
+	ChannelId   string
+	VideoId     string
+	Title       string
+	Description string
+	Published   time.Time
+	File        string
+	Format      AudioFormat
+	Size        int64
+	// Chapters document, empty if none.
+	Chapters string
+	// Artwork image, empty if none.
+	Artwork string
+	// Transcript files, if any.
+	Transcripts []Transcript
+	// Parts of an episode split by length, published instead of it.
+	Parts []SidecarPart
+	// The audio at other bitrates, if any.
+	Alternates []AlternateEnclosure
+	// Duration if known, 0 otherwise.
+	Duration time.Duration
+}
+
+type Transcript struct {
+	File     string
+	MimeType string
+	// Language and podcast:transcript rel of YouTube captions.
+	Language string
+	Rel      string
+}
+
+// channelEntries returns the entries of the current channel feeds by
+// video id.
+func channelEntries(ctx context.Context, confFeeds []ConfFeed, concurrency int) map[string]*youtube.Entry {
+	entries := map[string]*youtube.Entry{}
+	for i, ytfeed := range servedFeeds(ctx, confFeeds, concurrency) {
+		if ytfeed == nil {
+			continue
+		}
+		for _, entry := range filterFeed(*ytfeed, &confFeeds[i]).Entries {
+			entries[entry.VideoId] = entry
+		}
+	}
+	return entries
+}
+
+// archiveMetadata looks up the metadata of downloaded videos in the
+// channel feeds, or the sidecars, channel.json and the episode store
+// for videos no longer in them.
+type archiveMetadata struct {
+	entries map[string]*youtube.Entry
+	known   map[string]store.Episode
+	stored  map[string]map[string]ChannelEpisode
+}
+
+func newArchiveMetadata(ctx context.Context, entries map[string]*youtube.Entry) *archiveMetadata {
+	m := &archiveMetadata{entries: entries, known: map[string]store.Episode{}, stored: map[string]map[string]ChannelEpisode{}}
+	for _, ep := range listEpisodes(ctx, "") {
+		m.known[ep.VideoId] = ep
+	}
+	return m
+}
+
+// entry returns the metadata of a video of a channel. Without any, the
+// video id stands in for the title.
+func (m *archiveMetadata) entry(channelId, videoId string) *youtube.Entry {
+	if entry, ok := m.entries[videoId]; ok {
+		return entry
+	}
+	stored, ok := m.stored[channelId]
+	if !ok {
+		stored = map[string]ChannelEpisode{}
+		for _, ep := range readChannelInfo(channelId).Episodes {
+			stored[ep.VideoId] = ep
+		}
+		m.stored[channelId] = stored
+	}
+	entry := &youtube.Entry{VideoId: videoId, Title: videoId}
+	if sidecar, ok := readSidecar(channelId, videoId); ok {
+		entry.Title, entry.Published = sidecar.Title, sidecar.Published
+		entry.Media = &youtube.Media{Description: sidecar.Description}
+	} else if ep, ok := stored[videoId]; ok {
+		entry.Title, entry.Published = ep.Title, ep.Published
+		entry.Media = &youtube.Media{Description: ep.Description}
+	} else if ep, ok := m.known[videoId]; ok {
+		entry.Title, entry.Published = ep.Title, ep.Published
+		entry.Media = &youtube.Media{Description: ep.Description}
+	}
+	return entry
+}
+
+// lists reports whether a feed lists a downloaded video: inbox feeds
+// list all of their channel, other feeds those they select and those
+// added to them on their own.
+func (feed *ConfFeed) lists(entry *youtube.Entry, added map[string]bool) bool {
+	return feed.Inbox || added[entry.VideoId] || feed.Selects(entry)
+}
+
+// archivedEpisodes returns all downloaded episodes, newest first, with
+// the metadata of archiveMetadata. Otherwise the file time stands in
+// for the publication time. Each feed lists the episodes it selects,
+// and those added to its channel on their own; videos of several feeds
+// are listed once.
+func archivedEpisodes(ctx context.Context, confFeeds []ConfFeed, concurrency int) []FeedEpisode {
+	meta := newArchiveMetadata(ctx, channelEntries(ctx, confFeeds, concurrency))
+	list := []FeedEpisode{}
+	seen := map[string]bool{}
+	for _, feed := range confFeeds {
+		videoIds := episodeIndex.VideoIds(feed.ChannelId)
+		if len(videoIds) == 0 {
+			continue
+		}
+		added, err := episodes.Added(ctx, feed.ChannelId)
+		logStore(err)
+		for _, videoId := range videoIds {
+			if seen[videoId] {
+				continue
+			}
+			entry := meta.entry(feed.ChannelId, videoId)
+			if !feed.lists(entry, added) {
+				continue
+			}
+			seen[videoId] = true
+			if ep, ok := feedEpisode(&feed, videoId, entry); ok {
+				list = append(list, ep)
+			}
+		}
+	}
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].Published.After(list[j].Published)
+	})
+	return list
+}
+
+func feedEpisode(feed *ConfFeed, videoId string, entry *youtube.Entry) (FeedEpisode, bool) {
+	name, format, info, ok := episodeIndex.FindAudio(feed, videoId)
+	if !ok {
+		return FeedEpisode{}, false
+	}
+	ep := FeedEpisode{
+		ChannelId: feed.ChannelId,
+		VideoId:   videoId,
+		Title:     entry.Title,
+		File:      name,
+		Format:    format,
+		Size:      info.size,
+	}
+	if entry.Media != nil {
+		ep.Description = entry.Media.Description
+	}
+	indexed := func(name string) bool {
+		return episodeIndex.Has(feed.ChannelId, filepath.Base(name))
+	}
+	if name := chaptersFileName(feed.ChannelId, videoId); indexed(name) {
+		ep.Chapters = name
+	}
+	for _, f := range transcriptFormats {
+		if name := transcriptFileName(feed.ChannelId, videoId, f.Ext); indexed(name) {
+			ep.Transcripts = append(ep.Transcripts, Transcript{File: name, MimeType: f.MimeType})
+		}
+	}
+	for _, name := range episodeIndex.WithPrefix(feed.ChannelId, captionsPrefix(videoId)) {
+		if lang, ok := strings.CutSuffix(strings.TrimPrefix(name, captionsPrefix(videoId)), ".vtt"); ok {
+			ep.Transcripts = append(ep.Transcripts, Transcript{File: filepath.Join("audio", feed.ChannelId, name),
+				MimeType: "text/vtt", Language: lang, Rel: "captions"})
+		}
+	}
+	if name := artworkFileName(feed.ChannelId, videoId); indexed(name) {
+		ep.Artwork = name
+	}
+	ep.Alternates = episodeAlternates(feed.ChannelId, videoId)
+	if sidecar, ok := readSidecar(feed.ChannelId, videoId); ok {
+		if indexed(partFileName(feed.ChannelId, videoId, 1, format.Ext)) {
+			for _, p := range sidecar.Parts {
+				if episodeIndex.Has(feed.ChannelId, p.File) {
+					ep.Parts = append(ep.Parts, p)
+				}
+			}
+		}
+		ep.Description = removedNote(sidecar.Removed, ep.Description)
+	}
+	var err error
+	if ep.Published, err = time.Parse(time.RFC3339, entry.Published); err != nil {
+		ep.Published = info.modTime
+	}
+	return ep, true
+}
+
+// feedQuery holds the filters of a feed request, set by the channel,
+// keyword, since and max query parameters, and the title of the
+// filtered feed.
+type feedQuery struct {
+	channels []string
+	keywords []string
+	since    time.Time
+	max      int
+	title    string
+}
+
+func parseFeedQuery(q url.Values) (feedQuery, error) {
+	fq := feedQuery{channels: q["channel"], keywords: q["keyword"], title: q.Get("title")}
+	if s := q.Get("since"); s != "" {
+		var err error
+		if fq.since, err = time.Parse("2006-01-02", s); err != nil {
+			if fq.since, err = time.Parse(time.RFC3339, s); err != nil {
+				return fq, fmt.Errorf("invalid since %q, want YYYY-MM-DD or RFC 3339 time", s)
+			}
+		}
+	}
+	if s := q.Get("max"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return fq, fmt.Errorf("invalid max %q", s)
+		}
+		fq.max = n
+	}
+	return fq, nil
+}
+
+func (fq feedQuery) empty() bool {
+	return len(fq.channels) == 0 && len(fq.keywords) == 0 && fq.since.IsZero() && fq.max == 0
+}
+
+// feeds returns the feeds selected by channel id or name.
+func (fq feedQuery) feeds(confFeeds []ConfFeed) ([]ConfFeed, error) {
+	if len(fq.channels) == 0 {
+		return confFeeds, nil
+	}
+	selected := []ConfFeed{}
+	for _, name := range fq.channels {
+		found := false
+		for _, feed := range confFeeds {
+			if feed.ChannelId == name || feed.Name == name {
+				selected = append(selected, feed)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown channel %q", name)
+		}
+	}
+	return selected, nil
+}
+
+// filter returns the episodes matching any keyword and published since,
+// at most max of them.
+func (fq feedQuery) filter(list []FeedEpisode) []FeedEpisode {
+	filtered := []FeedEpisode{}
+	for _, ep := range list {
+		if fq.max > 0 && len(filtered) == fq.max {
+			break
+		}
+		if len(fq.keywords) > 0 && !youtube.MatchKeywords(ep.Title, fq.keywords) {
+			continue
+		}
+		if ep.Published.Before(fq.since) {
+			continue
+		}
+		filtered = append(filtered, ep)
+	}
+	return filtered
+}
+
+// interleaveEpisodes orders a date ordered list by smooth weighted
+// round-robin over channels, so a prolific channel does not bury the
+// others. Channels take turns in proportion to their weights, each
+// keeping its own newest first order.
+func interleaveEpisodes(list []FeedEpisode, weights map[string]int) []FeedEpisode {
+	queues := map[string][]FeedEpisode{}
+	channels := []string{}
+	for _, ep := range list {
+		if _, ok := queues[ep.ChannelId]; !ok {
+			channels = append(channels, ep.ChannelId)
+		}
+		queues[ep.ChannelId] = append(queues[ep.ChannelId], ep)
+	}
+	weight := func(channelId string) int {
+		if w, ok := weights[channelId]; ok && w > 0 {
+			return w
+		}
+		return 1
+	}
+	current := map[string]int{}
+	interleaved := []FeedEpisode{}
+	for len(interleaved) < len(list) {
+		best, total := "", 0
+		for _, id := range channels {
+			if len(queues[id]) == 0 {
+				continue
+			}
+			current[id] += weight(id)
+			total += weight(id)
+			if best == "" || current[id] > current[best] {
+				best = id
+			}
+		}
+		current[best] -= total
+		interleaved = append(interleaved, queues[best][0])
+		queues[best] = queues[best][1:]
+	}
+	return interleaved
+}
+
+// archiveYears returns the years of episodes, newest first.
+func archiveYears(list []FeedEpisode) []int {
+	years := []int{}
+	for _, ep := range list {
+		if y := ep.Published.Year(); len(years) == 0 || years[len(years)-1] != y {
+			years = append(years, y)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(years)))
+	return years
+}
+
+// feedGetHandler serves the podcast feeds: the combined feed, the feeds
+// of profiles and single channels, their yearly archives and pages.
+func feedGetHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	title, elem := combinedTitle, []string{"feed"}
+	confFeeds := conf.GetFeeds()
+	// Profile feeds are requested with the profile token, links carry it.
+	linkQuery := ""
+	audioElem := []string{"audio"}
+	if user := vars["user"]; user != "" {
+		profile, ok := conf.GetProfile(user)
+		if !ok || !profile.authorized(r) {
+			http.NotFound(w, r)
+			return
+		}
+		title, elem, confFeeds = profile.FeedTitle(), []string{"u", user, "feed"}, profile.Feeds
+		if profile.Token != "" {
+			linkQuery = "token=" + url.QueryEscape(profile.Token)
+		}
+		if !profile.public() {
+			audioElem = []string{"u", user, "audio"}
+		}
+	}
+	// Capability URLs carry the profile secret in the path, so do the
+	// audio links.
+	if secret := vars["secret"]; secret != "" {
+		profile, ok := conf.ProfileBySecret(secret)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		title, elem, confFeeds = profile.FeedTitle(), []string{"t", secret, "feed"}, profile.Feeds
+		audioElem = []string{"t", secret, "audio"}
+	}
+	audioLink := func(channelId, name string) string {
+		return withQuery(conf.URL(append(audioElem, conf.audioPathName(channelId), filepath.Base(name))...), linkQuery)
+	}
+	if name := vars["name"]; name != "" {
+		feed, ok := findFeed(confFeeds, name)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		// Feeds with a slug moved there from their channel id URLs.
+		if feed.Slug != "" && name != feed.Slug {
+			to := append(elem, feed.Slug)
+			if year := vars["year"]; year != "" {
+				to = append(to, "archive", year)
+			}
+			http.Redirect(w, r, withQuery(conf.URL(to...), r.URL.RawQuery), http.StatusMovedPermanently)
+			return
+		}
+		elem, confFeeds = append(elem, name), []ConfFeed{feed}
+	}
+	// Feeds of a single channel are shown under its name.
+	author := ""
+	if len(confFeeds) == 1 && (vars["name"] != "" || title == combinedTitle) {
+		title, author = confFeeds[0].ShowInfo()
+	}
+	values := r.URL.Query()
+	values.Del("token")
+	query, err := parseFeedQuery(values)
+	if err == nil {
+		confFeeds, err = query.feeds(confFeeds)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	path := withQuery(conf.URL(elem...), linkQuery)
+	archiveURL := func(year int) string {
+		return withQuery(conf.URL(append(elem, "archive", strconv.Itoa(year))...), linkQuery)
+	}
+	paged := &server.Feed{}
+	// Episodes are listed from the audio index and the episode store,
+	// not just the channel feed window.
+	list := archivedEpisodes(r.Context(), confFeeds, conf.FetchConcurrency)
+	numbers := episodeNumbers(r.Context(), list)
+	list = expandParts(list)
+	if !query.empty() {
+		// Filtered feeds are single documents without archives or pages.
+		list = query.filter(list)
+		path = withQuery(path, values.Encode())
+		if query.title != "" {
+			title = query.title
+		}
+	} else if max := conf.FeedMaxItems; max > 0 && len(list) > max {
+		// Older episodes go to yearly archives, see RFC 5005.
+		current, older := list[:max], list[max:]
+		years := archiveYears(older)
+		if vars["year"] == "" {
+			list = current
+			paged.AddLink("prev-archive", archiveURL(years[0]))
+		} else {
+			year, _ := strconv.Atoi(vars["year"])
+			i := sort.Search(len(years), func(i int) bool { return years[i] <= year })
+			if i == len(years) || years[i] != year {
+				http.NotFound(w, r)
+				return
+			}
+			list = []FeedEpisode{}
+			for _, ep := range older {
+				if ep.Published.Year() == year {
+					list = append(list, ep)
+				}
+			}
+			paged.SetArchive()
+			paged.AddLink("current", path)
+			if i+1 < len(years) {
+				paged.AddLink("prev-archive", archiveURL(years[i+1]))
+			}
+			if i > 0 {
+				paged.AddLink("next-archive", archiveURL(years[i-1]))
+			}
+			path = archiveURL(year)
+		}
+	}
+	if vars["year"] != "" && paged.Archive == nil {
+		http.NotFound(w, r)
+		return
+	}
+	if conf.FeedOrder == "interleave" && vars["name"] == "" && paged.Archive == nil {
+		weights := map[string]int{}
+		for _, feed := range confFeeds {
+			weights[feed.ChannelId] = feed.Weight
+		}
+		list = interleaveEpisodes(list, weights)
+	}
+	if size := conf.FeedPageSize; size > 0 && paged.Archive == nil && query.empty() {
+		// Paged feed, see RFC 5005.
+		page := 1
+		if s := r.FormValue("page"); s != "" {
+			page, _ = strconv.Atoi(s)
+		}
+		start, end, pages, ok := server.Page(len(list), size, page)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		pageURL := func(page int) string {
+			if page == 1 {
+				return path
+			}
+			return withQuery(path, "page="+strconv.Itoa(page))
+		}
+		paged.AddPageLinks(page, pages, pageURL)
+		list = list[start:end]
+		path = pageURL(page)
+	}
+	feedsById := map[string]*ConfFeed{}
+	for i := range confFeeds {
+		feedsById[confFeeds[i].ChannelId] = &confFeeds[i]
+	}
+	entries := []server.Episode{}
+	var totalSize int64
+	for _, ep := range list {
+		totalSize += ep.Size
+		entry := server.Episode{
+			Title:       ep.Title,
+			Description: ep.Description,
+			Published:   ep.Published,
+			URL:         audioURL(conf, ep.File),
+			MimeType:    ep.Format.MimeType,
+			Size:        ep.Size,
+			Duration:    ep.Duration,
+			Number:      numbers[ep.VideoId],
+			Image:       placeholderURL(conf, ep.ChannelId),
+		}
+		if _, stored := storage.URL(ep.File); !stored {
+			entry.URL = audioLink(ep.ChannelId, ep.File)
+		}
+		if feed, ok := feedsById[ep.ChannelId]; ok {
+			entry.Season, entry.Explicit = feed.Season(ep.Published), feed.Explicit
+		}
+		if ep.Chapters != "" {
+			entry.Chapters = audioLink(ep.ChannelId, ep.Chapters)
+		}
+		if ep.Artwork != "" {
+			entry.Image = audioLink(ep.ChannelId, ep.Artwork)
+		}
+		if len(ep.Alternates) > 0 {
+			entry.Alternates = []*server.AlternateEnclosure{
+				server.NewAlternateEnclosure(audioLink(ep.ChannelId, ep.File), ep.Format.MimeType, ep.Size, ep.Format.bitrate(), true),
+			}
+			for _, alt := range ep.Alternates {
+				entry.Alternates = append(entry.Alternates,
+					server.NewAlternateEnclosure(audioLink(ep.ChannelId, alt.File), alt.Format.MimeType, alt.Size, alt.Bitrate, false))
+			}
+		}
+		for _, t := range ep.Transcripts {
+			entry.Transcripts = append(entry.Transcripts, &server.Transcript{
+				URL:      audioLink(ep.ChannelId, t.File),
+				Type:     t.MimeType,
+				Language: t.Language,
+				Rel:      t.Rel,
+			})
+		}
+		entries = append(entries, entry)
+	}
+	podcast := server.Podcast{
+		Title:     title,
+		Link:      path,
+		Author:    author,
+		Logo:      placeholderURL(conf, ""),
+		Generator: &server.Generator{URI: "https://github.com/gruzdev/lfpod", Version: buildVersion().Version, Name: "lfpod"},
+	}
+	if conf.FeedStats {
+		podcast.Description = feedStats(len(entries), totalSize)
+	}
+	if len(confFeeds) == 1 && vars["name"] != "" {
+		podcast.Logo = placeholderURL(conf, confFeeds[0].ChannelId)
+	}
+	paged.SetEpisodes(podcast, entries)
+	if len(confFeeds) == 1 && vars["name"] != "" {
+		setChannel(paged, &confFeeds[0])
+	}
+	// A configured author goes before the owner, the channel after.
+	if paged.ItunesAuthor == "" || (len(confFeeds) == 1 && confFeeds[0].PodcastAuthor != "") {
+		paged.ItunesAuthor = author
+	}
+	if err := server.WriteFeed(w, paged); err != nil {
+		log.Print(title, " feed: ", err)
+		return
+	}
+	events.Publish(Event{Type: EventFeedGenerated, Feed: vars["name"], Title: title, Count: len(paged.Entries)})
+}
+
+// feedStats describes the archive health for the feed description.
+func feedStats(episodes int, size int64) string {
+	stats := fmt.Sprintf("%d episodes, %.1f MB", episodes, float64(size)/1e6)
+	if last := lastUpdate.Last(); !last.IsZero() {
+		stats += ", last update " + last.UTC().Format("2006-01-02 15:04 MST")
+	}
+	return stats
+}
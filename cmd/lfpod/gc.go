@@ -0,0 +1,462 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ArchiveFile is an audio file considered for garbage collection.
+type ArchiveFile struct {
+	ChannelId string    `json:"channel_id"`
+	VideoId   string    `json:"video_id"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Modified  time.Time `json:"modified"`
+	Plays     int       `json:"plays"`
+	Pinned    bool      `json:"pinned"`
+}
+
+// GCStrategy selects files to delete to free at least excess bytes.
+type GCStrategy interface {
+	Select(files []ArchiveFile, excess int64) []ArchiveFile
+}
+
+// takeUntil returns the leading files of at least excess bytes.
+func takeUntil(files []ArchiveFile, excess int64) []ArchiveFile {
+	var freed int64
+	for i, f := range files {
+		if freed >= excess {
+			return files[:i]
+		}
+		freed += f.Size
+	}
+	return files
+}
+
+func sortOldestFirst(files []ArchiveFile) []ArchiveFile {
+	sorted := append([]ArchiveFile{}, files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Modified.Before(sorted[j].Modified)
+	})
+	return sorted
+}
+
+type oldestFirst struct{}
+
+func (oldestFirst) Select(files []ArchiveFile, excess int64) []ArchiveFile {
+	return takeUntil(sortOldestFirst(files), excess)
+}
+
+// leastPlayed deletes the least played files first, the oldest of
+// equally played ones.
+type leastPlayed struct{}
+
+func (leastPlayed) Select(files []ArchiveFile, excess int64) []ArchiveFile {
+	sorted := sortOldestFirst(files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Plays < sorted[j].Plays
+	})
+	return takeUntil(sorted, excess)
+}
+
+// proportional deletes the oldest file of the feed taking the most
+// space until enough is freed, so feeds end up with similar shares.
+type proportional struct{}
+
+func (proportional) Select(files []ArchiveFile, excess int64) []ArchiveFile {
+	byFeed := map[string][]ArchiveFile{}
+	usage := map[string]int64{}
+	channels := []string{}
+	for _, f := range sortOldestFirst(files) {
+		if _, ok := byFeed[f.ChannelId]; !ok {
+			channels = append(channels, f.ChannelId)
+		}
+		byFeed[f.ChannelId] = append(byFeed[f.ChannelId], f)
+		usage[f.ChannelId] += f.Size
+	}
+	selected := []ArchiveFile{}
+	var freed int64
+	for freed < excess {
+		largest := ""
+		for _, id := range channels {
+			if len(byFeed[id]) > 0 && (largest == "" || usage[id] > usage[largest]) {
+				largest = id
+			}
+		}
+		if largest == "" {
+			break
+		}
+		f := byFeed[largest][0]
+		byFeed[largest] = byFeed[largest][1:]
+		usage[largest] -= f.Size
+		freed += f.Size
+		selected = append(selected, f)
+	}
+	return selected
+}
+
+// pinnedAware never deletes pinned files and leaves the rest to next.
+type pinnedAware struct {
+	next GCStrategy
+}
+
+func (s pinnedAware) Select(files []ArchiveFile, excess int64) []ArchiveFile {
+	unpinned := []ArchiveFile{}
+	for _, f := range files {
+		if !f.Pinned {
+			unpinned = append(unpinned, f)
+		}
+	}
+	return s.next.Select(unpinned, excess)
+}
+
+// No strategy deletes pinned files, "pinned" is "oldest" under its
+// former name.
+var gcStrategies = map[string]GCStrategy{
+	"oldest":       pinnedAware{oldestFirst{}},
+	"least-played": pinnedAware{leastPlayed{}},
+	"proportional": pinnedAware{proportional{}},
+	"pinned":       pinnedAware{oldestFirst{}},
+}
+
+// Order of strategies in reports.
+var gcStrategyNames = []string{"oldest", "least-played", "proportional", "pinned"}
+
+// PrunedSet keeps videos deleted by garbage collection, so they are not
+// downloaded again while still in the channel feed.
+type PrunedSet struct {
+	mu     sync.Mutex
+	file   string
+	videos map[string]bool
+}
+
+var pruned = PrunedSet{videos: map[string]bool{}}
+
+func (p *PrunedSet) Load(file string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.file = file
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	videos := []string{}
+	if err := json.Unmarshal(data, &videos); err != nil {
+		return err
+	}
+	for _, v := range videos {
+		p.videos[v] = true
+	}
+	return nil
+}
+
+func (p *PrunedSet) Has(videoId string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.videos[videoId]
+}
+
+func (p *PrunedSet) Add(videoIds ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, v := range videoIds {
+		p.videos[v] = true
+	}
+	p.save()
+}
+
+// Remove lets videos be downloaded again.
+func (p *PrunedSet) Remove(videoIds ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.videos)
+	for _, v := range videoIds {
+		delete(p.videos, v)
+	}
+	if len(p.videos) != n {
+		p.save()
+	}
+}
+
+func (p *PrunedSet) save() {
+	if p.file == "" {
+		return
+	}
+	videos := []string{}
+	for v := range p.videos {
+		videos = append(videos, v)
+	}
+	sort.Strings(videos)
+	data, err := json.MarshalIndent(videos, "", "    ")
+	if err == nil {
+		fileTmp := p.file + ".tmp"
+		if err = os.WriteFile(fileTmp, append(data, '\n'), 0640); err == nil {
+			err = os.Rename(fileTmp, p.file)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// scanArchive returns the audio files of the configured feeds. Files
+// pinned by any feed of their channel are pinned.
+func scanArchive(conf *Conf) ([]ArchiveFile, error) {
+	// Feeds of the same channel share its directory.
+	channels := []string{}
+	pinned := map[string]map[string]bool{}
+	for _, feed := range conf.AllFeeds() {
+		if _, ok := pinned[feed.ChannelId]; !ok {
+			channels = append(channels, feed.ChannelId)
+			pinned[feed.ChannelId] = map[string]bool{}
+		}
+		for _, videoId := range feed.Pinned {
+			pinned[feed.ChannelId][videoId] = true
+		}
+	}
+	files := []ArchiveFile{}
+	for _, channelId := range channels {
+		entries, err := os.ReadDir(filepath.Join("audio", channelId))
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		// Parts of split episodes, alternate enclosures and kept
+		// downloads are deleted with them.
+		partSizes := map[string]int64{}
+		first := len(files)
+		for _, e := range entries {
+			videoId, ext, ok := strings.Cut(e.Name(), ".")
+			if !ok {
+				continue
+			}
+			if strings.HasPrefix(ext, "part") || strings.HasPrefix(ext, "alt") || strings.HasPrefix(ext, "orig") {
+				if info, err := e.Info(); err == nil {
+					partSizes[videoId] += info.Size()
+				}
+				continue
+			}
+			if !containsString(audioFormatNames, ext) {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			files = append(files, ArchiveFile{
+				ChannelId: channelId,
+				VideoId:   videoId,
+				Path:      filepath.Join("audio", channelId, e.Name()),
+				Size:      info.Size(),
+				Modified:  info.ModTime(),
+				Plays:     plays.Get(videoId),
+				Pinned:    pinned[channelId][videoId],
+			})
+		}
+		for i := first; i < len(files); i++ {
+			files[i].Size += partSizes[files[i].VideoId]
+		}
+	}
+	return files, nil
+}
+
+// GCReport lists the files a strategy deletes to fit the archive into
+// the storage limit.
+type GCReport struct {
+	Strategy string        `json:"strategy"`
+	Total    int64         `json:"total"`
+	Limit    int64         `json:"limit"`
+	Freed    int64         `json:"freed"`
+	Delete   []ArchiveFile `json:"delete"`
+}
+
+func gcReport(files []ArchiveFile, strategy string, limit int64) GCReport {
+	report := GCReport{Strategy: strategy, Limit: limit, Delete: []ArchiveFile{}}
+	for _, f := range files {
+		report.Total += f.Size
+	}
+	if limit > 0 && report.Total > limit {
+		report.Delete = gcStrategies[strategy].Select(files, report.Total-limit)
+	}
+	for _, f := range report.Delete {
+		report.Freed += f.Size
+	}
+	return report
+}
+
+// collectGarbage deletes files beyond the feed retention limits, then
+// files selected by the configured strategy when the archive exceeds
+// the storage limit.
+func collectGarbage(ctx context.Context, conf *Conf) {
+	if conf.MaxStorage <= 0 && !conf.hasRetention() {
+		return
+	}
+	files, err := scanArchive(conf)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if expired := expiredFiles(ctx, conf, files); len(expired) > 0 {
+		n, freed := deleteArchiveFiles(ctx, expired)
+		log.Printf("retention limits reached, %d files of %.1f MB deleted", n, float64(freed)/1e6)
+		files = withoutFiles(files, expired)
+	}
+	if conf.MaxStorage <= 0 {
+		return
+	}
+	report := gcReport(files, conf.GCStrategy, conf.MaxStorage)
+	if len(report.Delete) == 0 {
+		return
+	}
+	n, freed := deleteArchiveFiles(ctx, report.Delete)
+	log.Printf("storage limit exceeded, %d files of %.1f MB deleted", n, float64(freed)/1e6)
+}
+
+func withoutFiles(files, removed []ArchiveFile) []ArchiveFile {
+	skip := map[string]bool{}
+	for _, f := range removed {
+		skip[f.Path] = true
+	}
+	kept := []ArchiveFile{}
+	for _, f := range files {
+		if !skip[f.Path] {
+			kept = append(kept, f)
+		}
+	}
+	return kept
+}
+
+// deleteEpisodeFiles deletes an audio file, its copy in object storage
+// and its sidecar files.
+func deleteEpisodeFiles(ctx context.Context, f ArchiveFile) error {
+	if err := storage.Remove(f.Path); err != nil {
+		return err
+	}
+	if err := os.Remove(f.Path); err != nil {
+		return err
+	}
+	os.Remove(chaptersFileName(f.ChannelId, f.VideoId))
+	os.Remove(sidecarFileName(f.ChannelId, f.VideoId))
+	os.Remove(artworkFileName(f.ChannelId, f.VideoId))
+	for _, t := range transcriptFormats {
+		os.Remove(transcriptFileName(f.ChannelId, f.VideoId, t.Ext))
+	}
+	removeCaptions(f.ChannelId, f.VideoId)
+	removeParts(f.ChannelId, f.VideoId)
+	removeAlternates(f.ChannelId, f.VideoId)
+	removeOriginal(f.ChannelId, f.VideoId)
+	log.Print("deleted ", f.Path)
+	logStore(episodes.SetDeleted(ctx, f.VideoId))
+	return nil
+}
+
+// deleteArchiveFiles deletes audio files with their sidecar files,
+// marking the videos pruned so they are not downloaded again. It
+// returns the number of files and bytes deleted.
+func deleteArchiveFiles(ctx context.Context, files []ArchiveFile) (int, int64) {
+	videoIds := []string{}
+	var freed int64
+	for _, f := range files {
+		if err := deleteEpisodeFiles(ctx, f); err != nil {
+			log.Print(err)
+			continue
+		}
+		videoIds = append(videoIds, f.VideoId)
+		freed += f.Size
+	}
+	pruned.Add(videoIds...)
+	if _, err := pruneBlobs(); err != nil {
+		log.Print(err)
+	}
+	events.Publish(Event{Type: EventPruneExecuted, Count: len(videoIds), Size: freed})
+	feedVersion.Bump()
+	return len(videoIds), freed
+}
+
+func apiGCHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	limit := conf.MaxStorage
+	if s := r.FormValue("max_storage"); s != "" {
+		mb, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || mb < 0 {
+			apiError(w, http.StatusBadRequest, "invalid max_storage", s)
+			return
+		}
+		limit = mb << 20
+	}
+	names := gcStrategyNames
+	if s := r.FormValue("strategy"); s != "" {
+		if _, ok := gcStrategies[s]; !ok {
+			apiError(w, http.StatusBadRequest, "unknown strategy", s)
+			return
+		}
+		names = []string{s}
+	}
+	files, err := scanArchive(conf)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "archive scan failed", err.Error())
+		return
+	}
+	reports := []GCReport{}
+	for _, name := range names {
+		reports = append(reports, gcReport(files, name, limit))
+	}
+	writeJSON(w, http.StatusOK, reports)
+}
+
+func runGC(ctx context.Context, conf *Conf, args []string) error {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := fs.Bool("n", false, "Only report what each strategy would delete, play counts are only known to the server.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod [-max-storage MB] [-gc-strategy name] [-keep-episodes n] [-max-age days] gc [-n]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if conf.MaxStorage <= 0 && !conf.hasRetention() {
+		return errors.New("-max-storage or retention limits are required")
+	}
+	if !*dryRun {
+		collectGarbage(ctx, conf)
+		return nil
+	}
+	files, err := scanArchive(conf)
+	if err != nil {
+		return err
+	}
+	if expired := expiredFiles(ctx, conf, files); len(expired) > 0 {
+		fmt.Printf("retention: %d files\n", len(expired))
+		for _, f := range expired {
+			fmt.Printf("    %s  %.1f MB  %s\n", f.Modified.Format("2006-01-02"), float64(f.Size)/1e6, f.Path)
+		}
+		files = withoutFiles(files, expired)
+	}
+	if conf.MaxStorage <= 0 {
+		return nil
+	}
+	for _, name := range gcStrategyNames {
+		report := gcReport(files, name, conf.MaxStorage)
+		fmt.Printf("%s: %d files, %.1f MB of %.1f MB archive\n", name, len(report.Delete),
+			float64(report.Freed)/1e6, float64(report.Total)/1e6)
+		for _, f := range report.Delete {
+			fmt.Printf("    %s  %.1f MB  %s\n", f.Modified.Format("2006-01-02"), float64(f.Size)/1e6, f.Path)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// gcTestFiles returns files of two channels, a of 3 files of 10 bytes
+// and b of 2 files of 40 bytes, a day apart with the oldest first, and
+// a2 pinned.
+func gcTestFiles() []ArchiveFile {
+	day := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	file := func(channelId, videoId string, size int64, days, plays int) ArchiveFile {
+		return ArchiveFile{ChannelId: channelId, VideoId: videoId, Path: videoId, Size: size,
+			Modified: day.AddDate(0, 0, days), Plays: plays}
+	}
+	files := []ArchiveFile{
+		file("b", "b1", 40, 0, 5),
+		file("a", "a1", 10, 1, 0),
+		file("a", "a2", 10, 2, 0),
+		file("b", "b2", 40, 3, 1),
+		file("a", "a3", 10, 4, 2),
+	}
+	files[2].Pinned = true
+	return files
+}
+
+func videoIds(files []ArchiveFile) string {
+	ids := []string{}
+	for _, f := range files {
+		ids = append(ids, f.VideoId)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestTakeUntil(t *testing.T) {
+	files := gcTestFiles()
+	for _, tc := range []struct {
+		excess int64
+		want   string
+	}{
+		{0, ""},
+		{1, "b1"},
+		{40, "b1"},
+		{41, "b1 a1"},
+		{1000, "b1 a1 a2 b2 a3"},
+	} {
+		if got := videoIds(takeUntil(files, tc.excess)); got != tc.want {
+			t.Errorf("takeUntil %d: %q, want %q", tc.excess, got, tc.want)
+		}
+	}
+}
+
+func TestGCStrategies(t *testing.T) {
+	for _, tc := range []struct {
+		strategy string
+		excess   int64
+		want     string
+	}{
+		{"oldest", 45, "b1 a1"},
+		{"oldest", 55, "b1 a1 b2"},
+		{"pinned", 55, "b1 a1 b2"},
+		{"least-played", 15, "a1 b2"},
+		{"least-played", 60, "a1 b2 a3"},
+		// b takes more space than a until both of its files are gone.
+		{"proportional", 40, "b1"},
+		{"proportional", 55, "b1 b2"},
+		{"proportional", 1000, "b1 b2 a1 a3"},
+	} {
+		got := videoIds(gcStrategies[tc.strategy].Select(gcTestFiles(), tc.excess))
+		if got != tc.want {
+			t.Errorf("%s freeing %d: %q, want %q", tc.strategy, tc.excess, got, tc.want)
+		}
+	}
+	for _, name := range gcStrategyNames {
+		for _, f := range gcStrategies[name].Select(gcTestFiles(), 1000) {
+			if f.Pinned {
+				t.Errorf("%s deletes pinned %s", name, f.VideoId)
+			}
+		}
+	}
+}
+
+func TestProportionalShares(t *testing.T) {
+	files := []ArchiveFile{}
+	for i, size := range []int64{30, 30, 30, 10, 10, 10} {
+		channelId := "a"
+		if i >= 3 {
+			channelId = "b"
+		}
+		files = append(files, ArchiveFile{ChannelId: channelId, VideoId: channelId + string(rune('1'+i%3)), Size: size,
+			Modified: time.Unix(int64(i), 0)})
+	}
+	// a takes 90 bytes, b 30: a gives up two files before b ties.
+	if got := videoIds(proportional{}.Select(files, 60)); got != "a1 a2" {
+		t.Errorf("proportional selected %q", got)
+	}
+	if got := videoIds(proportional{}.Select(files, 70)); got != "a1 a2 a3" {
+		t.Errorf("proportional selected %q", got)
+	}
+}
+
+func TestScanArchive(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	dir := filepath.Join("audio", "UCshared")
+	os.MkdirAll(dir, 0755)
+	for name, size := range map[string]int{
+		"vid00000001.opus": 10, "vid00000001.part1.opus": 5, "vid00000002.m4a": 20,
+		"vid00000003.opus": 30, "vid00000003.orig.webm": 7, "vid00000004.json": 1,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	conf := &Conf{ConfFeeds: ConfFeeds{Feeds: []ConfFeed{
+		{Name: "news", ChannelId: "UCshared", Pinned: []string{"vid00000001"}},
+		{Name: "reviews", ChannelId: "UCshared", Pinned: []string{"vid00000003"}},
+	}}}
+	files, err := scanArchive(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]ArchiveFile{}
+	for _, f := range files {
+		if _, ok := got[f.VideoId]; ok {
+			t.Errorf("%s scanned twice", f.VideoId)
+		}
+		got[f.VideoId] = f
+	}
+	for videoId, want := range map[string]ArchiveFile{
+		"vid00000001": {Size: 15, Pinned: true},
+		"vid00000002": {Size: 20},
+		"vid00000003": {Size: 37, Pinned: true},
+	} {
+		f, ok := got[videoId]
+		if !ok || f.Size != want.Size || f.Pinned != want.Pinned || f.ChannelId != "UCshared" {
+			t.Errorf("%s scanned as %+v", videoId, f)
+		}
+	}
+	if len(got) != 3 {
+		t.Errorf("scanned %d files, want 3", len(got))
+	}
+}
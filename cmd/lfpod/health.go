@@ -0,0 +1,121 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const updateInterval = 30 * time.Minute
+
+// The update loop is considered stuck when it has shown no progress
+// for this long. Downloads report progress per entry, so the timeout
+// only has to cover the sleep between cycles and a single video.
+const updateStuckTimeout = 2 * updateInterval
+
+type Heartbeat struct {
+	mu   sync.Mutex
+	last time.Time
+}
+
+func (h *Heartbeat) Beat() {
+	h.mu.Lock()
+	h.last = time.Now()
+	h.mu.Unlock()
+}
+
+func (h *Heartbeat) Last() time.Time {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.last
+}
+
+var updateHeartbeat Heartbeat
+
+// Completion time of the last update that was not paused.
+var lastUpdate Heartbeat
+
+func checkStorage() error {
+	f, err := os.CreateTemp("audio", ".readyz-*")
+	if err != nil {
+		return err
+	}
+	name := f.Name()
+	f.Close()
+	return os.Remove(name)
+}
+
+// checkConfig reports a configuration file that can no longer be read
+// or parsed, e.g. after a broken manual edit, which the next start
+// would fail on.
+func checkConfig(conf *Conf) error {
+	if conf.ConfFeedsFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(conf.ConfFeedsFile)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &ConfFeeds{})
+}
+
+func checkUpdateLoop() error {
+	last := updateHeartbeat.Last()
+	if last.IsZero() {
+		return fmt.Errorf("not started")
+	}
+	if since := time.Since(last); since > updateStuckTimeout {
+		return fmt.Errorf("no progress for %s", since.Round(time.Second))
+	}
+	return nil
+}
+
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, "ok")
+}
+
+func readyzHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	type check struct {
+		name string
+		err  error
+	}
+	checks := []check{{"config", checkConfig(conf)}}
+	checks = append(checks, check{"storage", checkStorage()})
+	for _, name := range []string{downloader, converter, probe} {
+		_, err := exec.LookPath(name)
+		checks = append(checks, check{name, err})
+	}
+	if !serveOnly {
+		checks = append(checks, check{"update loop", checkUpdateLoop()})
+	}
+
+	status := http.StatusOK
+	for _, c := range checks {
+		if c.err != nil {
+			status = http.StatusServiceUnavailable
+		}
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	for _, c := range checks {
+		if c.err != nil {
+			fmt.Fprintf(w, "%s: %v\n", c.name, c.err)
+		} else {
+			fmt.Fprintf(w, "%s: ok\n", c.name)
+		}
+	}
+}
+
+func readyzHandlerWrapper(conf *Conf) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		readyzHandler(conf, w, r)
+	}
+}
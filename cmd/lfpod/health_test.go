@@ -0,0 +1,29 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckConfig(t *testing.T) {
+	conf := &Conf{ConfFeedsFile: filepath.Join(t.TempDir(), "ytfeeds.json")}
+	if err := checkConfig(conf); err == nil {
+		t.Error("missing configuration file passed")
+	}
+	if err := os.WriteFile(conf.ConfFeedsFile, []byte(`{"ytfeeds": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkConfig(conf); err == nil {
+		t.Error("broken configuration file passed")
+	}
+	if err := os.WriteFile(conf.ConfFeedsFile, []byte(`{"ytfeeds": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkConfig(conf); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/lfpod/youtube"
+)
+
+// importTakeout reads subscriptions.csv of a Google Takeout export:
+// Channel Id,Channel Url,Channel Title.
+func importTakeout(data []byte) ([]ConfFeed, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	feeds := []ConfFeed{}
+	for i, rec := range records {
+		if len(rec) < 3 {
+			return nil, fmt.Errorf("line %d: expected 3 fields", i+1)
+		}
+		if !youtube.ChannelIdRegexp.MatchString(rec[0]) {
+			// Header line.
+			continue
+		}
+		feeds = append(feeds, ConfFeed{Name: strings.TrimSpace(rec[2]), ChannelId: rec[0]})
+	}
+	return feeds, nil
+}
+
+// importNewPipe reads a NewPipe subscriptions export.
+func importNewPipe(data []byte) ([]ConfFeed, error) {
+	export := struct {
+		Subscriptions []struct {
+			ServiceId int    `json:"service_id"`
+			URL       string `json:"url"`
+			Name      string `json:"name"`
+		} `json:"subscriptions"`
+	}{}
+	if err := json.Unmarshal(data, &export); err != nil {
+		return nil, err
+	}
+	feeds := []ConfFeed{}
+	for _, sub := range export.Subscriptions {
+		// Service 0 is YouTube.
+		if sub.ServiceId != 0 {
+			continue
+		}
+		if id := youtube.ChannelIdRegexp.FindString(sub.URL); id != "" {
+			feeds = append(feeds, ConfFeed{Name: sub.Name, ChannelId: id})
+		}
+	}
+	return feeds, nil
+}
+
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr"`
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	HTMLURL  string        `xml:"htmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+// importOPML reads an OPML subscription list, as exported by feed
+// readers and podcast apps, taking outlines of YouTube channel feeds or
+// pages. Outlines may be nested in categories.
+func importOPML(data []byte) ([]ConfFeed, error) {
+	opml := struct {
+		Outlines []opmlOutline `xml:"body>outline"`
+	}{}
+	if err := xml.Unmarshal(data, &opml); err != nil {
+		return nil, err
+	}
+	feeds := []ConfFeed{}
+	var walk func([]opmlOutline)
+	walk = func(outlines []opmlOutline) {
+		for _, o := range outlines {
+			walk(o.Outlines)
+			id := youtube.ChannelIdRegexp.FindString(o.XMLURL)
+			if id == "" {
+				id = youtube.ChannelIdRegexp.FindString(o.HTMLURL)
+			}
+			if id == "" {
+				continue
+			}
+			name := o.Title
+			if name == "" {
+				name = o.Text
+			}
+			feeds = append(feeds, ConfFeed{Name: strings.TrimSpace(name), ChannelId: id})
+		}
+	}
+	walk(opml.Outlines)
+	return feeds, nil
+}
+
+// importSubscriptions reads the channels of a subscriptions file, each
+// channel once.
+func importSubscriptions(fileName string) ([]ConfFeed, error) {
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	var feeds []ConfFeed
+	switch trimmed := bytes.TrimSpace(data); {
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		feeds, err = importNewPipe(data)
+	case bytes.HasPrefix(trimmed, []byte("<")):
+		feeds, err = importOPML(data)
+	default:
+		feeds, err = importTakeout(data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	unique := []ConfFeed{}
+	seen := map[string]bool{}
+	for _, feed := range feeds {
+		if !seen[feed.ChannelId] {
+			seen[feed.ChannelId] = true
+			unique = append(unique, feed)
+		}
+	}
+	return unique, nil
+}
+
+// selectFeeds asks whether to import each feed.
+func selectFeeds(feeds []ConfFeed, in io.Reader, out io.Writer) []ConfFeed {
+	selected := []ConfFeed{}
+	scanner := bufio.NewScanner(in)
+	for _, feed := range feeds {
+		fmt.Fprintf(out, "import %s (%s)? [Y/n] ", feed.Name, feed.ChannelId)
+		if !scanner.Scan() {
+			break
+		}
+		answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+		if answer == "" || answer == "y" || answer == "yes" {
+			selected = append(selected, feed)
+		}
+	}
+	return selected
+}
+
+// runImport implements the import command.
+func runImport(conf *Conf, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	interactive := fs.Bool("i", false, "Ask before importing each channel.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod import [-i] <subscriptions.csv|newpipe.json|subscriptions.opml>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("subscriptions file is required")
+	}
+	feeds, err := importSubscriptions(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	candidates := []ConfFeed{}
+	for _, feed := range feeds {
+		if _, ok := conf.GetFeed(feed.ChannelId); !ok {
+			candidates = append(candidates, feed)
+		}
+	}
+	fmt.Printf("%d subscriptions found, %d not configured yet\n", len(feeds), len(candidates))
+	if *interactive {
+		candidates = selectFeeds(candidates, os.Stdin, os.Stdout)
+	}
+	added, err := conf.AddFeeds(candidates)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d feeds added to %s\n", added, conf.ConfFeedsFile)
+	return nil
+}
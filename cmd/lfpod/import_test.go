@@ -0,0 +1,33 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestImportOPML(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "subscriptions.opml")
+	opml := `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="1.1"><body><outline text="YouTube Subscriptions">
+ <outline text="News" title="News" type="rss" xmlUrl="https://www.youtube.com/feeds/videos.xml?channel_id=UC0123456789abcdefghijkl"/>
+ <outline text="Music" htmlUrl="https://www.youtube.com/channel/UCabcdefghijkl0123456789"/>
+ <outline text="News again" xmlUrl="https://www.youtube.com/feeds/videos.xml?channel_id=UC0123456789abcdefghijkl"/>
+ <outline text="Blog" xmlUrl="https://example.com/feed.xml"/>
+</outline></body></opml>`
+	if err := os.WriteFile(name, []byte(opml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	feeds, err := importSubscriptions(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ConfFeed{{Name: "News", ChannelId: "UC0123456789abcdefghijkl"}, {Name: "Music", ChannelId: "UCabcdefghijkl0123456789"}}
+	if fmt.Sprint(feeds) != fmt.Sprint(want) {
+		t.Errorf("imported %+v, want %+v", feeds, want)
+	}
+}
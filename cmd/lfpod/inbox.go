@@ -0,0 +1,149 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The inbox file or directory is checked for changes this often.
+var inboxPollInterval = 30 * time.Second
+
+var (
+	videoURLRegexp = regexp.MustCompile(`(?:youtube\.com/(?:watch\?(?:[^ ]*&)?v=|shorts/|live/|embed/)|youtu\.be/)([A-Za-z0-9_-]{11})`)
+	videoIdRegexp  = regexp.MustCompile(`^[A-Za-z0-9_-]{11}$`)
+)
+
+// inboxVideoIds returns the ids of the videos in text, YouTube video
+// URLs or bare ids, each once in order of appearance.
+func inboxVideoIds(text string) []string {
+	ids := []string{}
+	seen := map[string]bool{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		found := []string{}
+		if videoIdRegexp.MatchString(line) {
+			found = append(found, line)
+		}
+		for _, m := range videoURLRegexp.FindAllStringSubmatch(line, -1) {
+			found = append(found, m[1])
+		}
+		for _, id := range found {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids
+}
+
+// InboxFeed returns the feed receiving the videos of the inbox, the
+// first one marked inbox.
+func (c *Conf) InboxFeed() (ConfFeed, bool) {
+	for _, feed := range c.AllFeeds() {
+		if feed.Inbox {
+			return feed, true
+		}
+	}
+	return ConfFeed{}, false
+}
+
+// Inbox queues the videos listed in a file, or the files of a
+// directory, e.g. synced from a phone, for download into the inbox
+// feed. The files are only read, videos are queued once.
+type Inbox struct {
+	mu   sync.Mutex
+	path string
+	// Modification time of the inbox when last read.
+	read time.Time
+}
+
+var inbox Inbox
+
+// inboxFiles returns the files of the inbox, hidden and temporary files
+// of sync tools left out.
+func inboxFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	files := []string{}
+	for _, e := range entries {
+		if name := e.Name(); e.Type().IsRegular() && !strings.HasPrefix(name, ".") && !strings.HasPrefix(name, "~") {
+			files = append(files, filepath.Join(path, name))
+		}
+	}
+	return files, nil
+}
+
+// Scan reads the inbox if it changed since the last scan and queues the
+// videos not downloaded or queued yet. It returns the number queued.
+func (x *Inbox) Scan(ctx context.Context, conf *Conf) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	feed, ok := conf.InboxFeed()
+	if x.path == "" || !ok {
+		return 0
+	}
+	files, err := inboxFiles(x.path)
+	if err != nil {
+		log.Print("inbox: ", err)
+		return 0
+	}
+	var changed time.Time
+	text := []string{}
+	for _, name := range files {
+		info, err := os.Stat(name)
+		if err != nil {
+			continue
+		}
+		if info.ModTime().After(changed) {
+			changed = info.ModTime()
+		}
+		if data, err := os.ReadFile(name); err == nil {
+			text = append(text, string(data))
+		}
+	}
+	if !changed.After(x.read) {
+		return 0
+	}
+	x.read = changed
+	queued := 0
+	for _, videoId := range inboxVideoIds(strings.Join(text, "\n")) {
+		if queueVideo(ctx, feed, videoId, "inbox") != nil {
+			continue
+		}
+		log.Print("inbox: queued ", videoId, " for ", feed.Name)
+		queued++
+	}
+	return queued
+}
+
+// watchInbox checks the inbox until the process ends, starting an
+// update of the inbox feed when videos are queued.
+func watchInbox(ctx context.Context, conf *Conf) {
+	for {
+		if inbox.Scan(ctx, conf) > 0 {
+			if feed, ok := conf.InboxFeed(); ok {
+				triggerUpdate(UpdateRequest{ChannelId: feed.ChannelId})
+			}
+		}
+		time.Sleep(inboxPollInterval)
+	}
+}
@@ -0,0 +1,2765 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lfpod/store"
+	"github.com/lfpod/youtube"
+)
+
+// The integration tests run the update pipeline against a mock YouTube
+// server. The test binary itself stands in for yt-dlp, ffmpeg and
+// ffprobe, it is linked under their names and dispatches on its name.
+
+const testChannelId = "UCtest"
+
+// Canned media, the probe stub prints the file content, so it is what
+// ffprobe reports about the recoded file.
+var testMedia = map[string]string{
+	"vid00000001": "format_name=ogg\ncodec_name=opus\n",
+	"vid00000002": "format_name=mov,mp4,m4a,3gp,3g2,mj2\ncodec_name=aac\n",
+}
+
+func TestMain(m *testing.M) {
+	switch filepath.Base(os.Args[0]) {
+	case "yt-dlp":
+		os.Exit(stubDownloader(os.Args[1:]))
+	case "ffmpeg":
+		os.Exit(stubConverter(os.Args[1:]))
+	case "ffprobe":
+		os.Exit(stubProbe(os.Args[1:]))
+	case "whisper-cli":
+		os.Exit(stubTranscriber(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+func stubDownloader(args []string) int {
+	switch args[0] {
+	case "-U":
+		if os.Getenv("LFPOD_TEST_PIP") != "" {
+			os.Stderr.WriteString("ERROR: You installed yt-dlp with pip or using the wheel from PyPi; Use that to update\n")
+			return 1
+		}
+		os.Stdout.WriteString("Current version: stable@2023.01.01\nLatest version: stable@2024.04.09\nUpdated yt-dlp to stable@2024.04.09\n")
+		return 100
+	case "--version":
+		os.Stdout.WriteString("2023.01.01\n")
+		return 0
+	}
+	videoId := args[len(args)-1]
+	if containsString(args, "--write-subs") {
+		for i, arg := range args[:len(args)-1] {
+			if arg == "-o" {
+				out := strings.TrimPrefix(args[i+1], "subtitle:")
+				out = strings.NewReplacer("%(id)s", videoId, "%(ext)s", "en.vtt").Replace(out)
+				if os.WriteFile(out, []byte("WEBVTT\n"), 0644) != nil {
+					return 1
+				}
+			}
+		}
+		return 0
+	}
+	for _, arg := range args {
+		if arg == "--dump-json" {
+			if os.Getenv("LFPOD_TEST_REMOVED") == videoId {
+				fmt.Fprintf(os.Stderr, "ERROR: [youtube] %s: Private video. Sign in if you've been granted access to this video\n", videoId)
+				return 1
+			}
+			status := `"live_status": "not_live", "duration": 900.5, "tags": ["news", "daily"]`
+			if os.Getenv("LFPOD_TEST_UPCOMING") == videoId {
+				// A ten minute premiere in an hour.
+				status = fmt.Sprintf(`"live_status": "is_upcoming", "release_timestamp": %d, "duration": 600`, time.Now().Add(time.Hour).Unix())
+			}
+			if os.Getenv("LFPOD_TEST_LIVE") == videoId {
+				status = `"live_status": "is_live"`
+			}
+			fmt.Printf(`{"id": %q, "title": "Edited %s", "description": "New description", "uploader": "Test channel", %s}`+"\n",
+				videoId, videoId, status)
+			return 0
+		}
+	}
+	if os.Getenv("LFPOD_TEST_LARGE") == videoId && containsString(args, "--max-filesize") {
+		fmt.Printf("[download] File is larger than max-filesize (95000000 bytes > 1048576 bytes). Aborting.\n")
+		return 0
+	}
+	if os.Getenv("LFPOD_TEST_LIVE") == videoId && !containsString(args, "--live-from-start") {
+		os.Stderr.WriteString("ERROR: live stream downloaded as a video\n")
+		return 1
+	}
+	if os.Getenv("LFPOD_TEST_PRIVATE") == videoId {
+		fmt.Fprintf(os.Stderr, "ERROR: [youtube] %s: Private video. Sign in if you've been granted access to this video\n", videoId)
+		return 1
+	}
+	res, err := http.Get(os.Getenv("LFPOD_TEST_SERVER") + "/media/" + videoId)
+	if err != nil || res.StatusCode != http.StatusOK {
+		return 1
+	}
+	defer res.Body.Close()
+	out := videoId
+	for i, arg := range args {
+		if arg == "-o" && i+1 < len(args) {
+			out = strings.ReplaceAll(args[i+1], "%(id)s", videoId)
+		}
+	}
+	if os.Getenv("LFPOD_TEST_SLOW") == videoId {
+		return stubSlowDownload(res.Body, out)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, res.Body); err != nil {
+		return 1
+	}
+	return 0
+}
+
+// stubSlowDownload continues the partial download of a video a byte at
+// a time, taking longer than the stall timeout of the test, or stops
+// writing after a byte with LFPOD_TEST_STALL.
+func stubSlowDownload(body io.Reader, out string) int {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return 1
+	}
+	part := out + ".webm.part"
+	f, err := os.OpenFile(part, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return 1
+	}
+	fi, err := f.Stat()
+	if err != nil || fi.Size() > int64(len(data)) {
+		return 1
+	}
+	if name := os.Getenv("LFPOD_TEST_DOWNLOAD_LOG"); name != "" {
+		if os.WriteFile(name, []byte(strconv.FormatInt(fi.Size(), 10)), 0644) != nil {
+			return 1
+		}
+	}
+	for _, b := range data[fi.Size():] {
+		if _, err := f.Write([]byte{b}); err != nil {
+			return 1
+		}
+		if os.Getenv("LFPOD_TEST_STALL") != "" {
+			time.Sleep(time.Minute)
+		}
+		time.Sleep(40 * time.Millisecond)
+	}
+	if f.Close() != nil || os.Rename(part, out) != nil {
+		return 1
+	}
+	return 0
+}
+
+func stubConverter(args []string) int {
+	for i, arg := range args {
+		if arg == "segment" && i > 0 && args[i-1] == "-f" {
+			// Two parts of the input.
+			pattern := args[len(args)-1]
+			for n := 1; n <= 2; n++ {
+				if os.WriteFile(fmt.Sprintf(pattern, n), []byte("part"), 0644) != nil {
+					return 1
+				}
+			}
+			return 0
+		}
+	}
+	if name := os.Getenv("LFPOD_TEST_CONVERTER_LOG"); name != "" {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return 1
+		}
+		fmt.Fprintln(f, strings.Join(args, " "))
+		f.Close()
+	}
+	for _, arg := range args {
+		speech := os.Getenv("LFPOD_TEST_SPEECH")
+		if strings.HasPrefix(arg, "silencedetect") && speech != "" && strings.Contains(strings.Join(args, " "), speech) {
+			// Speech pauses every few seconds.
+			for i := 0; i < 100; i++ {
+				fmt.Printf("[silencedetect @ 0x1] silence_start: %d\n", i*5)
+			}
+			return 0
+		}
+		if strings.Contains(arg, "loudnorm=") && strings.Contains(arg, "print_format=json") {
+			// The measurement, LFPOD_TEST_LOUDNESS is printed.
+			out := os.Getenv("LFPOD_TEST_LOUDNESS")
+			if out == "fail" {
+				return 1
+			}
+			os.Stderr.WriteString(out)
+			return 0
+		}
+		if strings.HasPrefix(arg, "silencedetect") {
+			// Ten seconds of leading silence, trailing silence from 890 s.
+			os.Stdout.WriteString("[silencedetect @ 0x1] silence_start: 0\n" +
+				"[silencedetect @ 0x1] silence_end: 10 | silence_duration: 10\n" +
+				"[silencedetect @ 0x1] silence_start: 890\n")
+			return 0
+		}
+	}
+	for i, arg := range args {
+		if arg == "-i" && i+1 < len(args) {
+			data, err := os.ReadFile(args[i+1])
+			if err != nil {
+				return 1
+			}
+			if out := args[len(args)-1]; out == "pipe:1" {
+				os.Stdout.Write(data)
+			} else if os.WriteFile(out, data, 0644) != nil {
+				return 1
+			}
+			return 0
+		}
+	}
+	return 1
+}
+
+func stubProbe(args []string) int {
+	file := filepath.Base(args[len(args)-1])
+	for _, arg := range args {
+		if arg == "-count_packets" && strings.HasPrefix(file, os.Getenv("LFPOD_TEST_TRUNCATED")+".") {
+			os.Stdout.WriteString("[ogg @ 0x1] Packet corrupt\n450.2\n")
+			return 0
+		}
+		if arg == "format=duration" {
+			os.Stdout.WriteString("900.5\n")
+			return 0
+		}
+	}
+	data, err := os.ReadFile(args[len(args)-1])
+	if err != nil {
+		return 1
+	}
+	os.Stdout.Write(data)
+	return 0
+}
+
+func stubTranscriber(args []string) int {
+	prefix := ""
+	for i, arg := range args {
+		if arg == "-of" && i+1 < len(args) {
+			prefix = args[i+1]
+		}
+	}
+	for _, ext := range []string{"vtt", "srt"} {
+		if os.WriteFile(prefix+"."+ext, []byte("transcript\n"), 0644) != nil {
+			return 1
+		}
+	}
+	return 0
+}
+
+func newMockYouTube(t *testing.T) *httptest.Server {
+	channel, err := os.ReadFile(filepath.Join("testdata", "channel.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feeds/videos.xml", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("channel_id") != testChannelId {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(bytes.ReplaceAll(channel, []byte("http://mock.test"), []byte("http://"+r.Host)))
+	})
+	mux.HandleFunc("/youtube/v3/playlistItems", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("key") != "test-key" || r.FormValue("playlistId") != "UUtest" {
+			http.Error(w, `{"error": {"message": "bad request"}}`, http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"items": [
+			{"snippet": {"title": "Daily news", "description": "0:00 Intro\n1:30 News\n12:05 Weather",
+				"thumbnails": {"default": {"url": "http://`+r.Host+`/thumbs/small.jpg"}, "high": {"url": "http://`+r.Host+`/thumbs/vid00000001.jpg"}}},
+			 "contentDetails": {"videoId": "vid00000001", "videoPublishedAt": "2023-05-02T10:00:00Z"}},
+			{"snippet": {"title": "Private video"}, "contentDetails": {"videoId": "vid00000003"}}
+		], "nextPageToken": "page2"}`)
+	})
+	mux.HandleFunc("/youtube/v3/search", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("type") != "channel" || r.FormValue("q") == "" {
+			http.Error(w, `{"error": {"message": "bad request"}}`, http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"items": [{"snippet": {"channelId": "UCtest", "channelTitle": "Test channel"}}]}`)
+	})
+	mux.HandleFunc("/youtube/v3/channels", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("forHandle") != "@testchannel" {
+			io.WriteString(w, `{"items": []}`)
+			return
+		}
+		io.WriteString(w, `{"items": [{"id": "UCtest", "snippet": {"title": "Test channel"}}]}`)
+	})
+	mux.HandleFunc("/youtube/v3/videos", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"items": [{"id": "vid00000001", "contentDetails": {"duration": "PT20M"}}]}`)
+	})
+	mux.HandleFunc("/thumbs/", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "thumbnail")
+	})
+	mux.HandleFunc("/media/", func(w http.ResponseWriter, r *http.Request) {
+		media, ok := testMedia[strings.TrimPrefix(r.URL.Path, "/media/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, media)
+	})
+	return httptest.NewServer(mux)
+}
+
+// setupPipeline points the pipeline to a mock server and stub tools and
+// runs the test in a scratch working directory.
+func setupPipeline(t *testing.T) *Conf {
+	server := newMockYouTube(t)
+	t.Cleanup(server.Close)
+	t.Setenv("LFPOD_TEST_SERVER", server.URL)
+
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	for _, name := range []string{"yt-dlp", "ffmpeg", "ffprobe", "whisper-cli"} {
+		if err := os.Symlink(self, filepath.Join(bin, name)); err != nil {
+			t.Skip("symlinks not supported: ", err)
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	saved := []string{feedBaseURL, downloader, converter, probe, transcriber, whisperModel}
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		feedBaseURL, downloader, converter, probe = saved[0], saved[1], saved[2], saved[3]
+		transcriber, whisperModel = saved[4], saved[5]
+	})
+	feedBaseURL = server.URL + "/feeds/videos.xml?channel_id="
+	downloader = filepath.Join(bin, "yt-dlp")
+	converter = filepath.Join(bin, "ffmpeg")
+	probe = filepath.Join(bin, "ffprobe")
+	transcriber = filepath.Join(bin, "whisper-cli")
+	whisperModel = "ggml-test.bin"
+
+	channelCache.mu.Lock()
+	channelCache.items = map[string]cachedChannel{}
+	channelCache.mu.Unlock()
+	probes.mu.Lock()
+	probes.file, probes.items = "", map[string]*ProbeState{}
+	probes.mu.Unlock()
+	episodeIndex.Invalidate()
+
+	conf := &Conf{ServerAddress: "podcast.test", Workers: 2}
+	conf.Feeds = []ConfFeed{{Name: "test", ChannelId: testChannelId}}
+	if err := os.MkdirAll(filepath.Join("audio", testChannelId), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return conf
+}
+
+func TestPipeline(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	for _, name := range []string{"vid00000001.opus", "vid00000002.m4a"} {
+		if _, err := os.Stat(filepath.Join("audio", testChannelId, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000002.opus")); err == nil {
+		t.Error("mismatched extension not corrected")
+	}
+	if names, _ := filepath.Glob(filepath.Join("audio", testChannelId, "*.tmp-*")); len(names) > 0 {
+		t.Errorf("temporary files %v left behind", names)
+	}
+	for _, ep := range listEpisodes(context.Background(), testChannelId) {
+		if ep.Status != store.StatusReady {
+			t.Errorf("%s: status %q, want %q", ep.VideoId, ep.Status, store.StatusReady)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed", nil))
+	feed := w.Body.String()
+	for _, want := range []string{
+		`href="http://podcast.test/audio/UCtest/vid00000001.opus"`,
+		`type="audio/opus"`,
+		`href="http://podcast.test/audio/UCtest/vid00000002.m4a"`,
+		`type="audio/mp4"`,
+		"Daily news",
+		"Weekly review",
+		`<image xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd" href="http://podcast.test/audio/UCtest/vid00000001.jpg">`,
+		`<image xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd" href="http://podcast.test/artwork/UCtest.png">`,
+		`<logo>http://podcast.test/artwork/lfpod.png</logo>`,
+		`<chapters xmlns="https://podcastindex.org/namespace/1.0" url="http://podcast.test/audio/UCtest/vid00000001.chapters.json" type="application/json+chapters">`,
+	} {
+		if !strings.Contains(feed, want) {
+			t.Errorf("feed lacks %s", want)
+		}
+	}
+	w = httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/artwork/UCtest.png", nil), map[string]string{"name": testChannelId})
+	placeholderHandler(conf, w, r)
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "\x89PNG") {
+		t.Errorf("placeholder artwork: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+
+	chapters, err := os.ReadFile(chaptersFileName(testChannelId, "vid00000001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"startTime": 90`, `"title": "Weather"`, `"endTime": 900.5`} {
+		if !strings.Contains(string(chapters), want) {
+			t.Errorf("chapters lack %s", want)
+		}
+	}
+}
+
+func TestPipelineTrimSilence(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].TrimSilence = -50
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	chapters, err := os.ReadFile(chaptersFileName(testChannelId, "vid00000001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`"startTime": 80`, `"startTime": 715`, `"endTime": 880`} {
+		if !strings.Contains(string(chapters), want) {
+			t.Errorf("chapters lack %s", want)
+		}
+	}
+}
+
+func TestPipelineTranscript(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].Transcribe = true
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed", nil))
+	for _, want := range []string{
+		`<transcript xmlns="https://podcastindex.org/namespace/1.0" url="http://podcast.test/audio/UCtest/vid00000001.vtt" type="text/vtt">`,
+		`<transcript xmlns="https://podcastindex.org/namespace/1.0" url="http://podcast.test/audio/UCtest/vid00000002.srt" type="application/srt">`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("feed lacks %s", want)
+		}
+	}
+}
+
+func TestFeedQuery(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	for _, tc := range []struct {
+		query  string
+		status int
+		titles []string
+	}{
+		{"", http.StatusOK, []string{"Daily news", "Weekly review"}},
+		{"?keyword=WEEKLY", http.StatusOK, []string{"Weekly review"}},
+		{"?since=2023-05-02", http.StatusOK, []string{"Daily news"}},
+		{"?max=1", http.StatusOK, []string{"Daily news"}},
+		{"?channel=test&keyword=news&keyword=review", http.StatusOK, []string{"Daily news", "Weekly review"}},
+		{"?channel=other", http.StatusBadRequest, nil},
+		{"?since=yesterday", http.StatusBadRequest, nil},
+	} {
+		w := httptest.NewRecorder()
+		feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed"+tc.query, nil))
+		if w.Code != tc.status {
+			t.Errorf("%q: status %d, want %d", tc.query, w.Code, tc.status)
+			continue
+		}
+		if got := strings.Count(w.Body.String(), "<entry>"); tc.titles != nil && got != len(tc.titles) {
+			t.Errorf("%q: %d entries, want %v", tc.query, got, tc.titles)
+		}
+		for _, title := range tc.titles {
+			if !strings.Contains(w.Body.String(), "<title>"+title+"</title>") {
+				t.Errorf("%q: feed lacks %s", tc.query, title)
+			}
+		}
+	}
+
+	// Filtered views come from the archive, also episodes out of the
+	// channel feed window.
+	ytfeed, err := readChannel(context.Background(), &conf.Feeds[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ytfeed.Entries = ytfeed.Entries[:1]
+	cacheChannel(testChannelId, ytfeed)
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed?keyword=weekly&title=Reviews", nil))
+	for _, want := range []string{"<title>Weekly review</title>", "<title>Reviews</title>"} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("filtered feed lacks %s", want)
+		}
+	}
+}
+
+func TestChannelInfo(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	info := readChannelInfo(testChannelId)
+	if info.ChannelTitle != "Test channel" || info.Stats.Episodes != 2 {
+		t.Fatalf("channel info %+v, want 2 episodes of Test channel", info)
+	}
+	if ep := info.Episodes[0]; ep.Title != "Daily news" || ep.File != "vid00000001.opus" {
+		t.Errorf("newest episode %+v, want Daily news", ep)
+	}
+
+	// Titles are kept when the channel feed is unavailable.
+	if err := writeChannelInfo(context.Background(), &conf.Feeds[0], nil); err != nil {
+		t.Fatal(err)
+	}
+	if info := readChannelInfo(testChannelId); info.Episodes[1].Title != "Weekly review" {
+		t.Errorf("episode %+v lost its title", info.Episodes[1])
+	}
+}
+
+func TestSidecar(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	sidecar, ok := readSidecar(testChannelId, "vid00000001")
+	if !ok {
+		t.Fatal("no sidecar written")
+	}
+	if sidecar.Title != "Daily news" || sidecar.Duration != 900.5 ||
+		sidecar.URL != "https://www.youtube.com/watch?v=vid00000001" {
+		t.Errorf("sidecar %+v", sidecar)
+	}
+	// Metadata read with yt-dlp complements the channel feed.
+	if sidecar.Uploader != "Test channel" || strings.Join(sidecar.Tags, ",") != "news,daily" {
+		t.Errorf("sidecar uploader %q, tags %q", sidecar.Uploader, sidecar.Tags)
+	}
+}
+
+func TestVideoFeed(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].Media = "video"
+	conf.Feeds[0].VideoHeightMax = 240
+	if msg := validateFeed(conf.Feeds[0]); msg != "" {
+		t.Fatal(msg)
+	}
+	if args := strings.Join(downloadFormatArgs(&conf.Feeds[0]), " "); args != "-f bv*[height<=240]+wa/b[height<=240]/wv*+wa/w" {
+		t.Errorf("download arguments %q", args)
+	}
+	if args := strings.Join(videoArgs(&conf.Feeds[0]), " "); !strings.Contains(args, "-c:v libx264") || !strings.Contains(args, "min(240,ih)") {
+		t.Errorf("video arguments %q", args)
+	}
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	// The M4A stand-in is taken for MP4, the Opus one has its extension
+	// corrected.
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000002.mp4")); err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed", nil))
+	for _, want := range []string{
+		`href="http://podcast.test/audio/UCtest/vid00000002.mp4"`,
+		`type="video/mp4"`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Errorf("feed lacks %s", want)
+		}
+	}
+
+	for _, f := range []ConfFeed{
+		{Name: "test", ChannelId: testChannelId, Media: "film"},
+		{Name: "test", ChannelId: testChannelId, Media: "video", Speed: 1.5},
+		{Name: "test", ChannelId: testChannelId, Format: "mp4"},
+	} {
+		if validateFeed(f) == "" {
+			t.Errorf("feed %+v accepted", f)
+		}
+	}
+}
+
+func TestCaptions(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].Captions = true
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000001.captions.en.vtt")); err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed", nil))
+	want := `<transcript xmlns="https://podcastindex.org/namespace/1.0" url="http://podcast.test/audio/UCtest/vid00000001.captions.en.vtt" type="text/vtt" language="en" rel="captions">`
+	if !strings.Contains(w.Body.String(), want) {
+		t.Errorf("feed lacks %s:\n%s", want, w.Body.String())
+	}
+
+	if _, err := deleteEpisode(context.Background(), conf.Feeds[0], "vid00000001", false); err != nil {
+		t.Fatal(err)
+	}
+	if names := captionLanguages(testChannelId, "vid00000001"); len(names) != 0 {
+		t.Errorf("captions %v left after deleting the episode", names)
+	}
+}
+
+func TestDownloadGuards(t *testing.T) {
+	conf := setupPipeline(t)
+	saved := minFreeSpace
+	t.Cleanup(func() {
+		minFreeSpace = saved
+		blocked.Remove()
+	})
+	minFreeSpace = 1 << 62
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 0 || result.Failed != 2 {
+		t.Errorf("update result %+v, want both downloads postponed", result)
+	}
+	for _, videoId := range []string{"vid00000001", "vid00000002"} {
+		if !retries.Has(videoId) || retries.Waiting(videoId) {
+			t.Errorf("%s not queued for the next update", videoId)
+		}
+	}
+
+	minFreeSpace = saved
+	conf.Feeds[0].MaxFileSize = 1
+	t.Setenv("LFPOD_TEST_LARGE", "vid00000001")
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 1 || result.Failed != 1 {
+		t.Errorf("update result %+v, want 1 new and 1 failed", result)
+	}
+	if !blocked.Has("vid00000001") || retries.Has("vid00000001") {
+		t.Error("video over max_file_size not blocked")
+	}
+	if !conf.Feeds[0].HasAudioFile("vid00000002") {
+		t.Error("video under max_file_size not downloaded")
+	}
+}
+
+func TestPipelineKeywords(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].Keywords = []string{"weekly"}
+	result := doUpdate(context.Background(), conf, UpdateRequest{})
+	if result.New != 1 || result.Filtered != 1 || result.Failed != 0 {
+		t.Errorf("update result %+v, want 1 new and 1 filtered", result)
+	}
+
+	names := []string{}
+	for _, f := range audioFormatNames {
+		found, _ := filepath.Glob(filepath.Join("audio", testChannelId, "vid*."+f))
+		names = append(names, found...)
+	}
+	if len(names) != 1 || filepath.Base(names[0]) != "vid00000002.m4a" {
+		t.Errorf("downloaded %v, want only vid00000002.m4a", names)
+	}
+}
+
+func TestPipelineDownloadArchive(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	data, err := os.ReadFile(downloadArchiveFileName(testChannelId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "youtube vid00000001\nyoutube vid00000002\n" && string(data) != "youtube vid00000002\nyoutube vid00000001\n" {
+		t.Errorf("download archive %q", data)
+	}
+
+	name := filepath.Join("audio", testChannelId, "vid00000001.opus")
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 0 {
+		t.Errorf("update result %+v, want nothing new", result)
+	}
+	if _, err := os.Stat(name); err == nil {
+		t.Error("archived video downloaded again")
+	}
+}
+
+func TestMetadataRefresh(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	doUpdate(context.Background(), conf, UpdateRequest{Refresh: true})
+
+	info := readChannelInfo(testChannelId)
+	if len(info.Episodes) != 2 {
+		t.Fatalf("channel.json lists %d episodes, want 2", len(info.Episodes))
+	}
+	for _, ep := range info.Episodes {
+		if ep.Title != "Edited "+ep.VideoId || ep.Refreshed == "" {
+			t.Errorf("%s: title %q, refreshed %q", ep.VideoId, ep.Title, ep.Refreshed)
+		}
+		if len(ep.History) == 0 || ep.History[0].Field != "title" || ep.History[0].New != ep.Title {
+			t.Errorf("%s: history %+v", ep.VideoId, ep.History)
+		}
+	}
+}
+
+func TestRemovedUpstream(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	t.Setenv("LFPOD_TEST_REMOVED", "vid00000001")
+	if _, err := refreshMetadata(context.Background(), &conf.Feeds[0], 0); err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed?keyword=news", nil))
+	if !strings.Contains(w.Body.String(), "Removed from YouTube on ") {
+		t.Errorf("kept episode lacks removal note:\n%s", w.Body.String())
+	}
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000001.opus")); err != nil {
+		t.Errorf("kept episode deleted: %v", err)
+	}
+
+	conf.Feeds[0].OnRemoved = "delete"
+	if _, err := refreshMetadata(context.Background(), &conf.Feeds[0], 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000001.opus")); err == nil {
+		t.Error("removed episode not deleted")
+	}
+	for _, ep := range readChannelInfo(testChannelId).Episodes {
+		if ep.VideoId == "vid00000001" {
+			t.Error("removed episode still in channel.json")
+		}
+	}
+	if !downloadArchive.Has(testChannelId, "vid00000001") {
+		t.Error("removed episode dropped from the download archive")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	conf := setupPipeline(t)
+	counts := map[string]int{}
+	unsubscribe := events.Subscribe(func(e Event) {
+		if e.VideoId != "" && e.Feed != "test" {
+			t.Errorf("%s event of feed %q", e.Type, e.Feed)
+		}
+		counts[e.Type]++
+	})
+	defer unsubscribe()
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	feedGetHandler(conf, httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/feed", nil))
+
+	for typ, want := range map[string]int{
+		EventEpisodeDiscovered: 2,
+		EventDownloadStarted:   2,
+		EventDownloadFinished:  2,
+		EventDownloadFailed:    0,
+		EventFeedGenerated:     1,
+	} {
+		if counts[typ] != want {
+			t.Errorf("%d %s events, want %d", counts[typ], typ, want)
+		}
+	}
+}
+
+func TestPipelineVerify(t *testing.T) {
+	conf := setupPipeline(t)
+	t.Setenv("LFPOD_TEST_TRUNCATED", "vid00000001")
+	t.Cleanup(func() { retries.Done("vid00000001") })
+	result := doUpdate(context.Background(), conf, UpdateRequest{})
+	if result.New != 1 || result.Failed != 1 {
+		t.Errorf("update result %+v, want 1 new and 1 failed", result)
+	}
+
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000001.opus")); err == nil {
+		t.Error("truncated file published")
+	}
+	if names, _ := filepath.Glob(filepath.Join(quarantineDir, "vid00000001.tmp-*.opus")); len(names) != 2 {
+		t.Errorf("quarantined %v, want both recodes", names)
+	}
+	if !retries.Waiting("vid00000001") || downloadArchive.Has(testChannelId, "vid00000001") {
+		t.Error("failed recode not queued for retry")
+	}
+}
+
+func TestIntegrityScan(t *testing.T) {
+	conf := setupPipeline(t)
+	saved := integrityScanInterval
+	t.Cleanup(func() { integrityScanInterval = saved })
+	integrityScanInterval = time.Hour
+	integrityChecks.checked = map[string]time.Time{}
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	t.Setenv("LFPOD_TEST_TRUNCATED", "vid00000001")
+	if bad := scanIntegrity(context.Background(), conf); bad != 1 {
+		t.Fatalf("%d corrupted files, want 1", bad)
+	}
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000001.opus")); err == nil {
+		t.Error("corrupted file still published")
+	}
+	if _, err := os.Stat(filepath.Join(quarantineDir, "vid00000001.opus")); err != nil {
+		t.Errorf("corrupted file not quarantined: %v", err)
+	}
+	if !retries.Has("vid00000001") || retries.Waiting("vid00000001") {
+		t.Error("corrupted file not queued for download")
+	}
+	if bad := scanIntegrity(context.Background(), conf); bad != 0 {
+		t.Errorf("checked files scanned again, %d corrupted", bad)
+	}
+
+	t.Setenv("LFPOD_TEST_TRUNCATED", "")
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 1 {
+		t.Errorf("update result %+v, want the corrupted file downloaded again", result)
+	}
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000001.opus")); err != nil {
+		t.Errorf("corrupted file not replaced: %v", err)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	old := filepath.Join("audio", testChannelId, "vid00000001.opus")
+	week := time.Now().Add(-7 * 24 * time.Hour)
+	if err := os.Chtimes(old, week, week); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pruned.videos = map[string]bool{} })
+
+	// A second feed of the channel only expires the episodes it lists,
+	// and not those still listed by the first.
+	conf.Feeds = append(conf.Feeds, ConfFeed{Name: "reviews", ChannelId: testChannelId, Keywords: []string{"review"}, KeepEpisodes: 1, MaxAge: 1})
+	if expired := expiredFiles(context.Background(), conf, mustScanArchive(t, conf)); len(expired) != 0 {
+		t.Errorf("files of another feed expired: %+v", expired)
+	}
+	conf.Feeds[1].Keywords = []string{"news", "review"}
+	if expired := expiredFiles(context.Background(), conf, mustScanArchive(t, conf)); len(expired) != 0 {
+		t.Errorf("files listed by a feed without limits expired: %+v", expired)
+	}
+
+	conf.Feeds[0].KeepEpisodes = 1
+	if expired := expiredFiles(context.Background(), conf, mustScanArchive(t, conf)); len(expired) != 1 || expired[0].Path != old {
+		t.Errorf("expired %+v, want %s once", expired, old)
+	}
+	collectGarbage(context.Background(), conf)
+	if _, err := os.Stat(old); err == nil {
+		t.Error("episode beyond keep_episodes not deleted")
+	}
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000002.m4a")); err != nil {
+		t.Error(err)
+	}
+	if !pruned.Has("vid00000001") {
+		t.Error("deleted episode not marked pruned")
+	}
+}
+
+func mustScanArchive(t *testing.T, conf *Conf) []ArchiveFile {
+	t.Helper()
+	files, err := scanArchive(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestPipelineRetry(t *testing.T) {
+	conf := setupPipeline(t)
+	media := testMedia["vid00000001"]
+	delete(testMedia, "vid00000001")
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	testMedia["vid00000001"] = media
+	t.Cleanup(func() { retries.Done("vid00000001") })
+
+	if !retries.Waiting("vid00000001") {
+		t.Fatal("failed download not queued for retry")
+	}
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	name := filepath.Join("audio", testChannelId, "vid00000001.opus")
+	if _, err := os.Stat(name); err == nil {
+		t.Fatal("retried before backoff expired")
+	}
+
+	retries.mu.Lock()
+	retries.items["vid00000001"].NextAttempt = time.Now()
+	retries.mu.Unlock()
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	if _, err := os.Stat(name); err != nil {
+		t.Fatal(err)
+	}
+	if len(retries.Due()) != 0 {
+		t.Error("retry queue not emptied")
+	}
+}
+
+func TestPipelinePremiere(t *testing.T) {
+	conf := setupPipeline(t)
+	t.Setenv("LFPOD_TEST_UPCOMING", "vid00000001")
+	result := doUpdate(context.Background(), conf, UpdateRequest{})
+	t.Cleanup(func() { retries.Done("vid00000001") })
+
+	if result.NotReady != 1 || result.New != 1 {
+		t.Errorf("update result %+v, want 1 new and 1 not ready", result)
+	}
+	retries.mu.Lock()
+	item := *retries.items["vid00000001"]
+	retries.mu.Unlock()
+	want := time.Now().Add(time.Hour + 10*time.Minute + premiereMargin)
+	if item.Attempts != 0 || item.NextAttempt.Sub(want).Abs() > time.Minute {
+		t.Errorf("premiere scheduled %+v, want retry at %s", item, want)
+	}
+}
+
+func TestPipelineYouTubeAPI(t *testing.T) {
+	conf := setupPipeline(t)
+	savedKey, savedURL, savedMax := youtubeAPIKey, youtubeAPIBaseURL, youtubeAPIMaxItems
+	t.Cleanup(func() {
+		youtubeAPIKey, youtubeAPIBaseURL, youtubeAPIMaxItems = savedKey, savedURL, savedMax
+		apiCache.items = map[string]apiCacheItem{}
+	})
+	youtubeAPIKey, youtubeAPIMaxItems = "test-key", 1
+	youtubeAPIBaseURL = strings.TrimSuffix(feedBaseURL, "/feeds/videos.xml?channel_id=") + "/youtube/v3"
+	result := doUpdate(context.Background(), conf, UpdateRequest{})
+
+	if result.New != 1 || result.Failed != 0 {
+		t.Errorf("update result %+v, want 1 new", result)
+	}
+	if !fileExists(artworkFileName(testChannelId, "vid00000001")) {
+		t.Error("thumbnail not downloaded")
+	}
+	chapters, err := os.ReadFile(chaptersFileName(testChannelId, "vid00000001"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The last chapter ends at the API duration rather than the probed one.
+	if !strings.Contains(string(chapters), `"endTime": 1200`) {
+		t.Errorf("chapters %s do not end at 1200 s", chapters)
+	}
+}
+
+func TestFeedArchive(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	conf.FeedMaxItems = 1
+
+	get := func(vars map[string]string) string {
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/feed", nil), vars)
+		feedGetHandler(conf, w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%v: status %d", vars, w.Code)
+		}
+		return w.Body.String()
+	}
+	feed := get(nil)
+	for _, want := range []string{
+		`<link href="http://podcast.test/feed/archive/2023" rel="prev-archive"`,
+		"Daily news",
+	} {
+		if !strings.Contains(feed, want) {
+			t.Errorf("feed lacks %s", want)
+		}
+	}
+	if strings.Contains(feed, "Weekly review") {
+		t.Error("feed has more than one item")
+	}
+	archive := get(map[string]string{"year": "2023"})
+	for _, want := range []string{
+		`<archive xmlns="http://purl.org/syndication/history/1.0"></archive>`,
+		`<link href="http://podcast.test/feed" rel="current"`,
+		"Weekly review",
+	} {
+		if !strings.Contains(archive, want) {
+			t.Errorf("archive lacks %s", want)
+		}
+	}
+	channel := get(map[string]string{"name": "test", "year": "2023"})
+	if !strings.Contains(channel, `<link href="http://podcast.test/feed/test" rel="current"`) {
+		t.Errorf("channel archive lacks current link")
+	}
+}
+
+func TestStorageS3(t *testing.T) {
+	conf := setupPipeline(t)
+	var mu sync.Mutex
+	objects := map[string][]byte{}
+	bucket := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			objects[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodHead:
+			if _, ok := objects[r.URL.Path]; !ok {
+				w.WriteHeader(http.StatusNotFound)
+			}
+		case http.MethodDelete:
+			delete(objects, r.URL.Path)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	t.Cleanup(bucket.Close)
+	s, err := newS3Storage("bucket", "pods", s3Options{Endpoint: bucket.URL, Region: "us-east-1",
+		AccessKey: "key", SecretKey: "secret", URLExpiry: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	storage = s
+	t.Cleanup(func() { storage = localStorage{}; pruned.videos = map[string]bool{} })
+
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	name := filepath.Join("audio", testChannelId, "vid00000001.opus")
+	key := "/bucket/pods/" + testChannelId + "/vid00000001.opus"
+	if len(objects[key]) == 0 {
+		t.Fatalf("%s not uploaded, bucket has %d objects", key, len(objects))
+	}
+	if info, err := os.Stat(name); err != nil || info.Size() != int64(len(objects[key])) || !isHollow(name) {
+		t.Errorf("local file not replaced by a hollow file of the same size")
+	}
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed", nil))
+	if feed := w.Body.String(); !strings.Contains(feed, bucket.URL+key+"?X-Amz-Algorithm=") {
+		t.Errorf("feed has no presigned enclosure URL:\n%s", feed)
+	}
+
+	conf.Feeds[0].KeepEpisodes = 1
+	os.Chtimes(name, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	collectGarbage(context.Background(), conf)
+	if _, ok := objects[key]; ok {
+		t.Error("deleted episode kept in the bucket")
+	}
+}
+
+func TestEpisodeStore(t *testing.T) {
+	conf := setupPipeline(t)
+	file := filepath.Join(t.TempDir(), "episodes.db")
+	if err := loadEpisodes(context.Background(), file); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { loadEpisodes(context.Background(), ":memory:") })
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	loaded, err := store.Open(context.Background(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	ep, ok, err := loaded.Get(context.Background(), "vid00000001")
+	if !ok || err != nil {
+		t.Fatal("episode not saved")
+	}
+	if ep.ChannelId != testChannelId || ep.Status != store.StatusReady || ep.Title == "" {
+		t.Errorf("saved episode %+v", ep)
+	}
+	if ep.Size == 0 || ep.Duration != 900.5 {
+		t.Errorf("saved size %d and duration %v, want the audio file ones", ep.Size, ep.Duration)
+	}
+
+	// Episodes that left the channel feed are served from the store.
+	for _, name := range []string{"vid00000001.meta.json", "vid00000002.meta.json", "channel.json"} {
+		os.Remove(filepath.Join("audio", testChannelId, name))
+	}
+	episodeIndex.Invalidate()
+	cacheChannel(testChannelId, &youtube.Feed{})
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed", nil))
+	if body := w.Body.String(); strings.Count(body, "<entry>") != 2 || !strings.Contains(body, "Daily news") {
+		t.Errorf("feed without channel feed entries:\n%s", body)
+	}
+}
+
+func TestCleanupOrphans(t *testing.T) {
+	conf := setupPipeline(t)
+	orphans := []string{
+		filepath.Join(downloadDir, "vid00000009.webm.part"),
+		filepath.Join("audio", testChannelId, "vid00000009.tmp-123.opus"),
+		filepath.Join("audio", testChannelId, "channel.json.tmp"),
+	}
+	os.MkdirAll(downloadDir, 0755)
+	for _, name := range orphans {
+		if err := os.WriteFile(name, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	for _, name := range orphans {
+		if _, err := os.Stat(name); err == nil {
+			t.Errorf("%s not deleted", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000001.opus")); err != nil {
+		t.Error(err)
+	}
+
+	// Files of live streams being recorded are kept.
+	recording := []string{
+		filepath.Join(downloadDir, "live0000001.ts.part"),
+		filepath.Join("audio", testChannelId, "live0000001.tmp-456.ffmetadata"),
+	}
+	for _, name := range recording {
+		if err := os.WriteFile(name, []byte("live"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recordings.Lock()
+	recordings.active["live0000001"] = true
+	recordings.Unlock()
+	t.Cleanup(func() {
+		recordings.Lock()
+		delete(recordings.active, "live0000001")
+		recordings.Unlock()
+	})
+	cleanupOrphans(context.Background(), conf)
+	for _, name := range recording {
+		if _, err := os.Stat(name); err != nil {
+			t.Errorf("file of a running recording deleted: %v", err)
+		}
+	}
+}
+
+func TestPipelineBlocked(t *testing.T) {
+	conf := setupPipeline(t)
+	t.Setenv("LFPOD_TEST_PRIVATE", "vid00000001")
+	t.Cleanup(func() { blocked.Remove() })
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	if !blocked.Has("vid00000001") || retries.Waiting("vid00000001") {
+		t.Fatal("private video not blocked")
+	}
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 0 {
+		t.Errorf("update result %+v, blocked video tried again", result)
+	}
+	blocked.Remove("vid00000001")
+	t.Setenv("LFPOD_TEST_PRIVATE", "")
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	if _, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000001.opus")); err != nil {
+		t.Error("unblocked video not downloaded: ", err)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].Keywords = []string{"weekly"}
+	decisions := selectVideos(conf, UpdateRequest{}, conf.Feeds, fetchFeeds(context.Background(), conf.Feeds, 1))
+	skips := map[string]string{}
+	for _, d := range decisions {
+		skips[d.Job.Entry.VideoId] = d.Skip
+	}
+	if len(skips) != 2 || skips["vid00000001"] != skipKeywords || skips["vid00000002"] != "" {
+		t.Errorf("decisions %v, want vid00000001 filtered by keywords and vid00000002 downloaded", skips)
+	}
+
+	printDryRun(context.Background(), conf, UpdateRequest{})
+	if names, _ := filepath.Glob(filepath.Join("audio", testChannelId, "vid*")); len(names) > 0 {
+		t.Errorf("dry run downloaded %v", names)
+	}
+}
+
+func TestResumeDownload(t *testing.T) {
+	conf := setupPipeline(t)
+	entry := &youtube.Entry{VideoId: "vid00000001", Title: "Daily news"}
+	retries.Started(testChannelId, entry)
+	t.Cleanup(func() { retries.Done("vid00000001") })
+	os.MkdirAll(downloadDir, 0755)
+	partial := filepath.Join(downloadDir, "vid00000001.webm.part")
+	orphan := filepath.Join(downloadDir, "vid00000009.webm.part")
+	for _, name := range []string{partial, orphan} {
+		if err := os.WriteFile(name, []byte("partial"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cleanupOrphans(context.Background(), conf)
+	if _, err := os.Stat(partial); err != nil {
+		t.Error("partial download of an interrupted video deleted")
+	}
+	if _, err := os.Stat(orphan); err == nil {
+		t.Error("orphaned partial download kept")
+	}
+	if due := retries.Due(); len(due) != 1 || due[0].VideoId != "vid00000001" || due[0].Attempts != 0 {
+		t.Errorf("interrupted download not due for resuming: %+v", due)
+	}
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	if retries.Has("vid00000001") {
+		t.Error("resumed download still queued")
+	}
+}
+
+func TestResumeLongDownload(t *testing.T) {
+	conf := setupPipeline(t)
+	defer func(saved time.Duration) { downloadStallTimeout = saved }(downloadStallTimeout)
+	downloadStallTimeout = 200 * time.Millisecond
+	t.Setenv("LFPOD_TEST_SLOW", "vid00000001")
+	downloadLog := filepath.Join(t.TempDir(), "offset")
+	t.Setenv("LFPOD_TEST_DOWNLOAD_LOG", downloadLog)
+	entry := &youtube.Entry{VideoId: "vid00000001", Title: "Daily news"}
+	retries.Started(testChannelId, entry)
+	t.Cleanup(func() { retries.Done("vid00000001") })
+	os.MkdirAll(downloadDir, 0755)
+	media := testMedia["vid00000001"]
+	partial := filepath.Join(downloadDir, "vid00000001.webm.part")
+	if err := os.WriteFile(partial, []byte(media[:len(media)/2]), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The rest takes several stall timeouts, but keeps growing.
+	start := time.Now()
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	if time.Since(start) < 2*downloadStallTimeout {
+		t.Errorf("download done in %s, quicker than the test needs", time.Since(start))
+	}
+	name := conf.Feeds[0].AudioFileName("vid00000001")
+	if data, err := os.ReadFile(name); err != nil || string(data) != media {
+		t.Errorf("resumed download %q, %v", data, err)
+	}
+	if offset, _ := os.ReadFile(downloadLog); string(offset) != strconv.Itoa(len(media)/2) {
+		t.Errorf("download continued at %s, want the end of the partial file", offset)
+	}
+
+	// A download that stops growing is stopped, its partial file kept
+	// for the next attempt.
+	os.Remove(name)
+	t.Setenv("LFPOD_TEST_STALL", "1")
+	if err := os.WriteFile(partial, []byte(media[:len(media)/2]), 0644); err != nil {
+		t.Fatal(err)
+	}
+	start = time.Now()
+	if _, err := downloadAudio(context.Background(), &conf.Feeds[0], "vid00000001", false); err == nil {
+		t.Error("stalled download succeeded")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("stalled download stopped after %s", time.Since(start))
+	}
+	if data, err := os.ReadFile(partial); err != nil || len(data) != len(media)/2+1 {
+		t.Errorf("partial download %q, %v", data, err)
+	}
+}
+
+func TestDownloaderUpdate(t *testing.T) {
+	setupPipeline(t)
+	release := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"tag_name": "2024.04.09"}`)
+	}))
+	defer release.Close()
+	savedURL, savedInterval := downloaderReleaseURL, downloaderUpdateInterval
+	t.Cleanup(func() {
+		downloaderReleaseURL, downloaderUpdateInterval = savedURL, savedInterval
+		downloaderUpdateMode, lastDownloaderUpdate = "update", time.Time{}
+	})
+	downloaderReleaseURL, downloaderUpdateInterval = release.URL, time.Hour
+	got := []Event{}
+	unsubscribe := events.Subscribe(func(e Event) { got = append(got, e) })
+	defer unsubscribe()
+
+	lastDownloaderUpdate = time.Time{}
+	maintainDownloader(context.Background())
+	maintainDownloader(context.Background())
+	if len(got) != 1 || got[0].Type != EventDownloaderUpdated || got[0].Title != "stable@2024.04.09" {
+		t.Errorf("events %+v, want one update to stable@2024.04.09", got)
+	}
+
+	// Installed with pip, yt-dlp -U fails and the version is checked.
+	t.Setenv("LFPOD_TEST_PIP", "1")
+	got, lastDownloaderUpdate = nil, time.Time{}
+	maintainDownloader(context.Background())
+	if len(got) != 1 || got[0].Type != EventDownloaderOutdated || got[0].Title != "2024.04.09" {
+		t.Errorf("events %+v, want outdated with release 2024.04.09", got)
+	}
+}
+
+func TestNotify(t *testing.T) {
+	conf := setupPipeline(t)
+	broken := "UCbroken0000000000000000"
+	conf.Feeds = append(conf.Feeds, ConfFeed{Name: "broken", ChannelId: broken})
+	t.Cleanup(func() { delete(feedFailures, broken) })
+	var mu sync.Mutex
+	received := map[string][]string{}
+	hooks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		received[r.URL.Path] = append(received[r.URL.Path], string(body))
+		mu.Unlock()
+	}))
+	defer hooks.Close()
+	saved := telegramAPI
+	t.Cleanup(func() { telegramAPI = saved })
+	telegramAPI = hooks.URL
+	conf.Notify = []Notifier{
+		{Type: "webhook", URL: hooks.URL + "/hook", Events: []string{NotifyEpisode}},
+		{Type: "telegram", BotToken: "T", ChatId: "42", Events: []string{NotifyFeedFailure}, FeedFailures: 2},
+	}
+	unsubscribe, err := startNotifiers(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribe()
+
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	waitNotifications()
+	mu.Lock()
+	defer mu.Unlock()
+	if feedFailures[broken] != 2 {
+		t.Errorf("%d failures in a row, want 2", feedFailures[broken])
+	}
+	if len(received["/hook"]) != 2 {
+		t.Fatalf("webhook received %q, want 2 episodes", received["/hook"])
+	}
+	msg := Notification{}
+	if err := json.Unmarshal([]byte(received["/hook"][0]), &msg); err != nil || msg.Feed != "test" || msg.AudioURL == "" ||
+		!strings.HasPrefix(msg.URL, "https://www.youtube.com/watch?v=") {
+		t.Errorf("webhook body %q", received["/hook"][0])
+	}
+	want := `{"chat_id":"42","text":"Feed broken failed 2 updates in a row"}`
+	if got := received["/botT/sendMessage"]; len(got) != 1 || got[0] != want {
+		t.Errorf("telegram received %q, want %q once", got, want)
+	}
+}
+
+func TestTelegramBot(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.ConfFeedsFile = "feeds.json"
+	conf.Feeds = append(conf.Feeds, ConfFeed{Name: "inbox", ChannelId: "inbox", Inbox: true})
+	t.Cleanup(func() {
+		retries.Done("vid00000002")
+		episodes.Delete(context.Background(), "vid00000002")
+		select {
+		case <-updateTrigger:
+		default:
+		}
+	})
+	var mu sync.Mutex
+	replies := []string{}
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/botT/getUpdates":
+			io.WriteString(w, `{"ok": true, "result": [
+				{"update_id": 1, "message": {"chat": {"id": 7}, "text": "https://youtu.be/vid00000001"}},
+				{"update_id": 2, "message": {"chat": {"id": 42}, "text": "watch https://youtu.be/vid00000002 later"}},
+				{"update_id": 3, "message": {"chat": {"id": 42}, "text": "https://www.youtube.com/channel/UC0123456789abcdefghijkl"}},
+				{"update_id": 4, "message": {"chat": {"id": 42}, "text": "/list@lfpod_bot"}}]}`)
+		case "/botT/sendMessage":
+			msg := map[string]string{}
+			json.NewDecoder(r.Body).Decode(&msg)
+			replies = append(replies, msg["chat_id"]+": "+msg["text"])
+			io.WriteString(w, `{"ok": true, "result": {}}`)
+		default:
+			io.WriteString(w, `{"ok": false, "description": "Not Found"}`)
+		}
+	}))
+	defer api.Close()
+	saved := telegramAPI
+	t.Cleanup(func() { telegramAPI = saved })
+	telegramAPI = api.URL
+
+	bot := newTelegramBot(conf, &Notifier{Type: "telegram", BotToken: "T", ChatId: "42", Bot: true})
+	if err := bot.Poll(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if bot.offset != 5 {
+		t.Errorf("offset %d, want 5", bot.offset)
+	}
+	if retries.Has("vid00000001") || !retries.Has("vid00000002") {
+		t.Error("videos not queued from the chat of the notifier only")
+	}
+	if _, ok := conf.GetFeed("UC0123456789abcdefghijkl"); !ok {
+		t.Error("channel not added as a feed")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	want := []string{
+		"42: Queued vid00000002 for inbox.",
+		"42: Added feed UC0123456789abcdefghijkl.\nhttp://podcast.test/feed/UC0123456789abcdefghijkl",
+		"42: test\ninbox\nUC0123456789abcdefghijkl",
+	}
+	if strings.Join(replies, "|") != strings.Join(want, "|") {
+		t.Errorf("replies %q, want %q", replies, want)
+	}
+}
+
+func TestSearchChannels(t *testing.T) {
+	setupPipeline(t)
+	savedKey, savedURL := youtubeAPIKey, youtubeAPIBaseURL
+	t.Cleanup(func() { youtubeAPIKey, youtubeAPIBaseURL = savedKey, savedURL })
+	youtubeAPIKey = "test-key"
+	youtubeAPIBaseURL = strings.TrimSuffix(feedBaseURL, "/feeds/videos.xml?channel_id=") + "/youtube/v3"
+
+	for _, query := range []string{"test news", "@testchannel", "https://www.youtube.com/@testchannel/videos"} {
+		candidates, err := searchChannels(context.Background(), query, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(candidates) != 1 || candidates[0].ChannelId != testChannelId || candidates[0].Title != "Test channel" ||
+			len(candidates[0].RecentUploads) == 0 {
+			t.Errorf("%s: candidates %+v", query, candidates)
+		}
+	}
+	if _, err := searchChannels(context.Background(), "@nochannel", 5); err == nil {
+		t.Error("unknown handle resolved")
+	}
+}
+
+func TestBrowse(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	w := httptest.NewRecorder()
+	browseHandler(conf, w, httptest.NewRequest(http.MethodGet, "/episodes", nil))
+	page := w.Body.String()
+	for _, want := range []string{"Daily news", `src="audio/UCtest/vid00000001.opus"`, `src="audio/UCtest/vid00000002.m4a"`, `href="feed/UCtest"`} {
+		if !strings.Contains(page, want) {
+			t.Errorf("episode page lacks %s", want)
+		}
+	}
+}
+
+func TestDeleteEpisode(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	if _, err := deleteEpisode(context.Background(), conf.Feeds[0], "vid00000001", false); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(filepath.Join("audio", testChannelId, "vid00000001.opus")) || fileExists(sidecarFileName(testChannelId, "vid00000001")) {
+		t.Error("episode files not deleted")
+	}
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodDelete, "/api/episodes/UCtest/vid00000002?refetch=1", nil)
+	apiEpisodeDeleteHandler(conf, w, mux.SetURLVars(r, map[string]string{"channelId": testChannelId, "videoId": "vid00000002"}))
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("delete status %d: %s", w.Code, w.Body)
+	}
+	if _, err := deleteEpisode(context.Background(), conf.Feeds[0], "vid00000002", false); !errors.Is(err, errEpisodeNotFound) {
+		t.Errorf("deleting a deleted episode: %v", err)
+	}
+
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	if fileExists(filepath.Join("audio", testChannelId, "vid00000001.opus")) {
+		t.Error("deleted episode downloaded again")
+	}
+	if !fileExists(filepath.Join("audio", testChannelId, "vid00000002.m4a")) {
+		t.Error("episode deleted with refetch not downloaded again")
+	}
+}
+
+func TestDuplicateFeeds(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds = append(conf.Feeds, ConfFeed{Name: "news", ChannelId: testChannelId, Keywords: []string{"news"}})
+	started := 0
+	unsubscribe := events.Subscribe(func(e Event) {
+		if e.Type == EventDownloadStarted {
+			started++
+		}
+	})
+	defer unsubscribe()
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	if started != 2 {
+		t.Errorf("%d downloads, want each video once", started)
+	}
+
+	// Archive feeds list the episodes each feed selects too.
+	for _, max := range []int{0, 10} {
+		conf.FeedMaxItems = max
+		for name, want := range map[string]int{"": 2, "news": 1} {
+			w := httptest.NewRecorder()
+			r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/feed", nil), map[string]string{"name": name})
+			feedGetHandler(conf, w, r)
+			if n := strings.Count(w.Body.String(), "<entry>"); n != want {
+				t.Errorf("feed %q with max items %d has %d entries, want %d", name, max, n, want)
+			}
+		}
+	}
+}
+
+func TestWebSub(t *testing.T) {
+	conf := setupPipeline(t)
+	r := mux.NewRouter()
+	r.HandleFunc("/websub/{channelId}", confHandlerWrapper(conf, websubHandler)).Methods("GET", "POST")
+	callback := httptest.NewServer(r)
+	defer callback.Close()
+	conf.ServerAddress = callback.URL
+	var secret string
+	hub := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("hub.mode") != "subscribe" || r.FormValue("hub.topic") != websubTopicURL+testChannelId {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		secret = r.FormValue("hub.secret")
+		w.WriteHeader(http.StatusAccepted)
+		// Verify the subscription, synchronously for the test.
+		res, err := http.Get(r.FormValue("hub.callback") + "?" + url.Values{
+			"hub.mode": {"subscribe"}, "hub.topic": {r.FormValue("hub.topic")},
+			"hub.challenge": {"c123"}, "hub.lease_seconds": {"3600"},
+		}.Encode())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != "c123" {
+			t.Errorf("challenge answered with %q", body)
+		}
+		if ct := res.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") || res.Header.Get("X-Content-Type-Options") != "nosniff" {
+			t.Errorf("challenge answered as %q", ct)
+		}
+	}))
+	defer hub.Close()
+	savedHub := websubHub
+	t.Cleanup(func() {
+		websubHub = savedHub
+		websub = WebSub{subs: map[string]*WebSubSubscription{}, dropped: map[string]time.Time{}}
+	})
+	websubHub = hub.URL
+	websub.secret = []byte("test secret")
+
+	now := time.Now()
+	websub.Maintain(conf, now)
+	subs := websub.List()
+	if len(subs) != 1 || subs[0].State != websubVerified || subs[0].Expires == nil || subs[0].Expires.Sub(now) > time.Hour+time.Minute {
+		t.Fatalf("subscriptions %+v", subs)
+	}
+	if secret != hex.EncodeToString(websub.secret) {
+		t.Errorf("hub secret %q", secret)
+	}
+
+	notify := func(signature string) {
+		body := `<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns="http://www.w3.org/2005/Atom"><entry>` +
+			`<yt:videoId>new00000001</yt:videoId><yt:channelId>` + testChannelId + `</yt:channelId><title>Breaking</title></entry></feed>`
+		if signature == "" {
+			mac := hmac.New(sha1.New, websub.secret)
+			mac.Write([]byte(body))
+			signature = "sha1=" + hex.EncodeToString(mac.Sum(nil))
+		}
+		req, _ := http.NewRequest(http.MethodPost, conf.URL("websub", testChannelId), strings.NewReader(body))
+		req.Header.Set("X-Hub-Signature", signature)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusOK {
+			t.Errorf("notification status %s", res.Status)
+		}
+	}
+	notify("sha1=0000")
+	select {
+	case req := <-updateTrigger:
+		t.Errorf("forged notification triggered %+v", req)
+	default:
+	}
+	notify("")
+	select {
+	case req := <-updateTrigger:
+		if req.ChannelId != testChannelId {
+			t.Errorf("update of %q triggered", req.ChannelId)
+		}
+	default:
+		t.Error("notification triggered no update")
+	}
+
+	// Subscriptions never verified fall back to polling and are retried.
+	websub.subs[testChannelId].State = websubRequested
+	websub.Maintain(conf, now.Add(websubVerifyTimeout+time.Minute))
+	if sub := websub.List()[0]; sub.State != websubFailed {
+		t.Errorf("unverified subscription %s", sub.State)
+	}
+
+	// Only unsubscriptions requested by lfpod are verified.
+	verify := func(channelId string) int {
+		res, err := http.Get(conf.URL("websub", channelId) + "?" + url.Values{
+			"hub.mode": {"unsubscribe"}, "hub.topic": {websubTopicURL + channelId},
+			"hub.challenge": {"<script>alert(1)</script>"},
+		}.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		return res.StatusCode
+	}
+	if status := verify("UCunknown"); status != http.StatusNotFound {
+		t.Errorf("unsolicited unsubscription answered with %d", status)
+	}
+	conf.Feeds = nil
+	websub.Maintain(conf, now)
+	if status := verify(testChannelId); status != http.StatusOK {
+		t.Errorf("unsubscription answered with %d", status)
+	}
+	if status := verify(testChannelId); status != http.StatusNotFound {
+		t.Errorf("repeated unsubscription answered with %d", status)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Profiles = []Profile{{Name: "anna", Token: "t1", Feeds: []ConfFeed{
+		{Name: "anna-news", ChannelId: testChannelId, Keywords: []string{"news"}},
+	}}}
+	started := 0
+	unsubscribe := events.Subscribe(func(e Event) {
+		if e.Type == EventDownloadStarted {
+			started++
+		}
+	})
+	defer unsubscribe()
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	if started != 2 {
+		t.Errorf("%d downloads, want each video once", started)
+	}
+
+	get := func(target string, vars map[string]string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		feedGetHandler(conf, w, mux.SetURLVars(httptest.NewRequest(http.MethodGet, target, nil), vars))
+		return w
+	}
+	if w := get("/u/anna/feed?token=wrong", map[string]string{"user": "anna"}); w.Code != http.StatusNotFound {
+		t.Errorf("wrong token: status %d", w.Code)
+	}
+	if w := get("/u/bob/feed", map[string]string{"user": "bob"}); w.Code != http.StatusNotFound {
+		t.Errorf("unknown profile: status %d", w.Code)
+	}
+	w := get("/u/anna/feed?token=t1", map[string]string{"user": "anna"})
+	body := w.Body.String()
+	if n := strings.Count(body, "<entry>"); n != 1 {
+		t.Errorf("profile feed has %d entries, want 1", n)
+	}
+	if !strings.Contains(body, "/u/anna/feed?token=t1") || !strings.Contains(body, "/u/anna/audio/UCtest/vid00000001.opus?token=t1") {
+		t.Errorf("profile feed links lack the token:\n%s", body)
+	}
+	if w := get("/u/anna/feed/test?token=t1", map[string]string{"user": "anna", "name": "test"}); w.Code != http.StatusNotFound {
+		t.Errorf("feed of another profile: status %d", w.Code)
+	}
+	// Views without a token leave profile feeds out.
+	if w := get("/feed/anna-news", map[string]string{"name": "anna-news"}); w.Code != http.StatusNotFound {
+		t.Errorf("profile feed without token: status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	audioHandler(conf, w, httptest.NewRequest(http.MethodGet, "/audio/UCtest/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("audio directory listed: status %d", w.Code)
+	}
+	conf.Feeds = nil
+	if n := strings.Count(get("/feed", nil).Body.String(), "<entry>"); n != 0 {
+		t.Errorf("top-level feed has %d profile entries", n)
+	}
+	audio := func(target string, handler func(*Conf, http.ResponseWriter, *http.Request)) int {
+		w := httptest.NewRecorder()
+		handler(conf, w, mux.SetURLVars(httptest.NewRequest(http.MethodGet, target, nil), map[string]string{"user": "anna"}))
+		return w.Code
+	}
+	for target, want := range map[string]int{
+		"/audio/UCtest/vid00000001.opus":                 http.StatusNotFound,
+		"/u/anna/audio/UCtest/vid00000001.opus":          http.StatusNotFound,
+		"/u/anna/audio/UCtest/vid00000001.opus?token=t1": http.StatusOK,
+	} {
+		handler := audioHandler
+		if strings.HasPrefix(target, "/u/") {
+			handler = profileAudioHandler
+		}
+		if code := audio(target, handler); code != want {
+			t.Errorf("%s: status %d, want %d", target, code, want)
+		}
+	}
+	w = httptest.NewRecorder()
+	browseHandler(conf, w, httptest.NewRequest(http.MethodGet, "/episodes", nil))
+	if strings.Contains(w.Body.String(), "vid00000001") {
+		t.Error("episodes page lists profile episodes")
+	}
+}
+
+func TestEpisodeIndex(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	entries := func() int {
+		w := httptest.NewRecorder()
+		feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed", nil))
+		return strings.Count(w.Body.String(), "<entry>")
+	}
+	if n := entries(); n != 2 {
+		t.Fatalf("%d entries, want 2", n)
+	}
+	feed := conf.Feeds[0]
+	name, _, ok := feed.FindAudioFile("vid00000001")
+	if !ok {
+		t.Fatal("no audio file")
+	}
+	// Changes by other processes are seen after the archive watcher
+	// bumps the feed version.
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if n := entries(); n != 2 {
+		t.Errorf("%d entries served from the index, want 2", n)
+	}
+	feedVersion.Bump()
+	if n := entries(); n != 1 {
+		t.Errorf("%d entries after the version changed, want 1", n)
+	}
+}
+
+func TestSplitParts(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].SplitMinutes = 10
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	sidecar, ok := readSidecar(testChannelId, "vid00000001")
+	if !ok || len(sidecar.Parts) != 2 || sidecar.Parts[0].File != "vid00000001.part1.opus" {
+		t.Fatalf("sidecar parts %+v", sidecar.Parts)
+	}
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed", nil))
+	body := w.Body.String()
+	if n := strings.Count(body, "<entry>"); n != 4 {
+		t.Errorf("%d entries, want 2 parts of each episode", n)
+	}
+	for _, want := range []string{"Daily news (Part 1/2)", "/audio/UCtest/vid00000001.part2.opus", "<duration xmlns=\"http://www.itunes.com/dtds/podcast-1.0.dtd\">901</duration>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("feed lacks %q", want)
+		}
+	}
+
+	if _, err := deleteEpisode(context.Background(), conf.Feeds[0], "vid00000001", false); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(partFileName(testChannelId, "vid00000001", 1, "opus")) {
+		t.Error("parts not deleted with the episode")
+	}
+}
+
+func TestMusicBitrate(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].MusicBitrate = 64
+	t.Setenv("LFPOD_TEST_SPEECH", "vid00000001")
+	logFile, _ := filepath.Abs("converter.log")
+	t.Setenv("LFPOD_TEST_CONVERTER_LOG", logFile)
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 2 {
+		t.Fatalf("update %+v", result)
+	}
+	data, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rates := map[string]string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		args := strings.Fields(line)
+		for i, arg := range args {
+			if arg == "-b:a" && i+1 < len(args) {
+				for _, videoId := range []string{"vid00000001", "vid00000002"} {
+					if strings.Contains(line, videoId) {
+						rates[videoId] = args[i+1]
+					}
+				}
+			}
+		}
+	}
+	if rates["vid00000001"] != "16k" || rates["vid00000002"] != "64k" {
+		t.Errorf("bitrates %v, want 16k for speech and 64k for music", rates)
+	}
+}
+
+func TestPipelineQuietHours(t *testing.T) {
+	conf := setupPipeline(t)
+	t.Cleanup(func() {
+		quiet.Set("")
+		quiet.Defer(time.Time{})
+		retries.Done("vid00000001")
+		retries.Done("vid00000002")
+	})
+	// Priority videos are not deferred.
+	conf.Feeds[0].PriorityKeywords = []string{"daily"}
+	now := time.Now()
+	quiet.Set(now.Add(-time.Hour).Format("15:04") + "-" + now.Add(time.Hour).Format("15:04"))
+	result := doUpdate(context.Background(), conf, UpdateRequest{})
+	if result.New != 1 || result.Deferred != 1 {
+		t.Fatalf("update in quiet hours %+v", result)
+	}
+	if ids := episodeIndex.VideoIds(testChannelId); len(ids) != 1 || ids[0] != "vid00000001" {
+		t.Errorf("downloaded %v in quiet hours, want the priority video", ids)
+	}
+	resume := quiet.Resume()
+	if !resume.After(now) || !retries.Waiting("vid00000002") {
+		t.Errorf("resume at %s, videos waiting %v", resume, retries.Waiting("vid00000002"))
+	}
+	if s := loopStatus.Get(conf); s.QuietUntil == nil {
+		t.Error("status lacks the end of quiet hours")
+	}
+
+	// The window opens.
+	quiet.Set("")
+	retries.Defer(testChannelId, &youtube.Entry{VideoId: "vid00000002"}, time.Now(), "quiet hours")
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 1 {
+		t.Errorf("update after quiet hours %+v", result)
+	}
+	if retries.Has("vid00000002") {
+		t.Error("deferred video still queued")
+	}
+}
+
+func TestCatchUpPriority(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.CatchUpRate = 1
+	conf.Feeds[0].PriorityKeywords = []string{"daily"}
+	done := make(chan UpdateResult, 1)
+	go func() { done <- doUpdate(context.Background(), conf, UpdateRequest{}) }()
+	select {
+	case result := <-done:
+		if result.New != 2 {
+			t.Errorf("update %+v", result)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("priority video throttled")
+	}
+}
+
+func TestFeedStats(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds = append(conf.Feeds, ConfFeed{Name: "gone", ChannelId: "UCgone"})
+	next := time.Now().Add(time.Hour).Truncate(time.Second)
+	loopStatus.Planned(next.Add(-2 * time.Hour))
+	t.Cleanup(func() {
+		loopStatus.Planned(time.Time{})
+		loopStatus.mu.Lock()
+		delete(loopStatus.feeds, "UCgone")
+		loopStatus.mu.Unlock()
+	})
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	get := func(id string) (*httptest.ResponseRecorder, FeedStats) {
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/api/feeds/"+id+"/stats", nil), map[string]string{"id": id})
+		apiFeedStatsHandler(conf, w, r)
+		stats := FeedStats{}
+		json.Unmarshal(w.Body.Bytes(), &stats)
+		return w, stats
+	}
+	w, stats := get(testChannelId)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if stats.Episodes != 2 || stats.Size == 0 || stats.LastSuccess == nil || stats.LastEpisode == nil || stats.ConsecutiveErrors != 0 {
+		t.Errorf("stats %+v", stats)
+	}
+	if stats.NextPoll != nil {
+		t.Errorf("next poll %v during a pass, want none", stats.NextPoll)
+	}
+	loopStatus.Planned(next)
+	if _, stats := get(testChannelId); stats.NextPoll == nil || !stats.NextPoll.Equal(next) {
+		t.Errorf("next poll %v, want %s", stats.NextPoll, next)
+	}
+	if _, stats := get("UCgone"); stats.ConsecutiveErrors != 2 || stats.LastError == "" || stats.Episodes != 0 || stats.LastSuccess != nil {
+		t.Errorf("failing feed stats %+v", stats)
+	}
+	if w, _ := get("UCmissing"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown feed: status %d", w.Code)
+	}
+}
+
+func TestRedownload(t *testing.T) {
+	conf := setupPipeline(t)
+	t.Cleanup(func() {
+		retries.Done("vid00000001")
+		retries.Done("vid00000002")
+		pruned.Remove("vid00000002")
+		select {
+		case <-updateTrigger:
+		default:
+		}
+	})
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	post := func(channelId, videoId string) (*httptest.ResponseRecorder, map[string]any) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/api/episodes/"+channelId+"/"+videoId+"/redownload", nil)
+		apiEpisodeRedownloadHandler(conf, w, mux.SetURLVars(r, map[string]string{"channelId": channelId, "videoId": videoId}))
+		reply := map[string]any{}
+		json.Unmarshal(w.Body.Bytes(), &reply)
+		select {
+		case <-updateTrigger:
+		default:
+		}
+		return w, reply
+	}
+	name := getAudioFileName(testChannelId, "vid00000001", "opus")
+	w, reply := post(testChannelId, "vid00000001")
+	if w.Code != http.StatusAccepted || reply["deleted"] != true {
+		t.Fatalf("redownload: status %d, %v", w.Code, reply)
+	}
+	if fileExists(name) || !retries.Has("vid00000001") || downloadArchive.Has(testChannelId, "vid00000001") {
+		t.Errorf("after redownload: file kept %v, queued %v", fileExists(name), retries.Has("vid00000001"))
+	}
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 1 || !fileExists(name) || retries.Has("vid00000001") {
+		t.Errorf("update after redownload %+v", result)
+	}
+
+	// A video deleted to free storage is not in the archive anymore.
+	if _, err := deleteEpisode(context.Background(), conf.Feeds[0], "vid00000002", false); err != nil {
+		t.Fatal(err)
+	}
+	pruned.Add("vid00000002")
+	if w, reply := post(testChannelId, "vid00000002"); w.Code != http.StatusAccepted || reply["deleted"] != false {
+		t.Errorf("redownload of deleted episode: status %d, %v", w.Code, reply)
+	}
+	if pruned.Has("vid00000002") || downloadArchive.Has(testChannelId, "vid00000002") {
+		t.Error("pruned state not cleared")
+	}
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 1 {
+		t.Errorf("update after redownload of deleted episode %+v", result)
+	}
+
+	if w, _ := post(testChannelId, "bad"); w.Code != http.StatusBadRequest {
+		t.Errorf("invalid video id: status %d", w.Code)
+	}
+	if w, _ := post("UCmissing", "vid00000001"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown feed: status %d", w.Code)
+	}
+}
+
+func TestTranscode(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	logFile, _ := filepath.Abs("converter.log")
+	t.Setenv("LFPOD_TEST_CONVERTER_LOG", logFile)
+	defer func(size int64) { transcodeCacheSize = size }(transcodeCacheSize)
+	transcodeCacheSize = 1 << 20
+
+	want, err := os.ReadFile(getAudioFileName(testChannelId, "vid00000001", "opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get := func(p string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		audioHandler(conf, w, httptest.NewRequest(http.MethodGet, p, nil))
+		return w
+	}
+	for i := 0; i < 2; i++ {
+		w := get("/audio/UCtest/vid00000001.mp3")
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "audio/mpeg" || w.Body.String() != string(want) {
+			t.Fatalf("transcode %d: status %d, type %q, %d bytes", i, w.Code, w.Header().Get("Content-Type"), w.Body.Len())
+		}
+	}
+	data, _ := os.ReadFile(logFile)
+	if n := strings.Count(string(data), "pipe:1"); n != 1 {
+		t.Errorf("%d transcodes, want 1 and a cache hit", n)
+	}
+	if names, _ := filepath.Glob(filepath.Join(transcodeDir, "vid00000001.*.mp3")); len(names) != 1 {
+		t.Errorf("cached transcodes %v", names)
+	}
+	if w := get("/audio/UCtest/missing0001.mp3"); w.Code != http.StatusNotFound {
+		t.Errorf("missing episode: status %d", w.Code)
+	}
+
+	transcodeCacheSize = 1
+	get("/audio/UCtest/vid00000002.mp3")
+	if names, _ := filepath.Glob(filepath.Join(transcodeDir, "*.mp3")); len(names) != 0 {
+		t.Errorf("cache over its size kept %v", names)
+	}
+}
+
+func TestAlternateEnclosures(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].AlternateBitrates = []int{64}
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	alt := alternateFileName(testChannelId, "vid00000001", 64, "opus")
+	if !fileExists(alt) {
+		t.Fatal("alternate enclosure not recoded")
+	}
+	if ids := episodeIndex.VideoIds(testChannelId); len(ids) != 2 {
+		t.Errorf("video ids %v, alternates listed as episodes", ids)
+	}
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, httptest.NewRequest(http.MethodGet, "/feed", nil))
+	body := w.Body.String()
+	if n := strings.Count(body, "<entry>"); n != 2 {
+		t.Errorf("%d entries, want 2", n)
+	}
+	for _, want := range []string{
+		`bitrate="16000" title="16 kbit/s" default="true"`,
+		`bitrate="64000" title="64 kbit/s">`,
+		`uri="http://podcast.test/audio/UCtest/vid00000001.alt64k.opus"`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("feed lacks %q", want)
+		}
+	}
+
+	if _, err := deleteEpisode(context.Background(), conf.Feeds[0], "vid00000001", false); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(alt) {
+		t.Error("alternate enclosure not deleted with the episode")
+	}
+	if validateFeed(ConfFeed{Name: "test", ChannelId: testChannelId, AlternateBitrates: []int{1000}}) == "" {
+		t.Error("alternate bitrate of 1000 kbit/s accepted")
+	}
+}
+
+func TestInbox(t *testing.T) {
+	ids := inboxVideoIds("https://www.youtube.com/watch?v=vid00000001&t=10s\n" +
+		"see https://youtu.be/vid00000002?si=x and https://m.youtube.com/shorts/abcdefghijk\n" +
+		"vid00000001\nnot a video\n")
+	if want := []string{"vid00000001", "vid00000002", "abcdefghijk"}; strings.Join(ids, " ") != strings.Join(want, " ") {
+		t.Errorf("video ids %v, want %v", ids, want)
+	}
+
+	conf := setupPipeline(t)
+	conf.Feeds = append(conf.Feeds, ConfFeed{Name: "inbox", ChannelId: "inbox", Inbox: true})
+	if err := os.MkdirAll(filepath.Join("audio", "inbox"), 0750); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { inbox.path, inbox.read = "", time.Time{}; retries.Done("vid00000002") })
+	inbox.path = "urls.txt"
+	if err := os.WriteFile("urls.txt", []byte("https://youtu.be/vid00000002\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if n := inbox.Scan(context.Background(), conf); n != 1 {
+		t.Fatalf("%d videos queued, want 1", n)
+	}
+	if n := inbox.Scan(context.Background(), conf); n != 0 {
+		t.Errorf("unchanged inbox queued %d videos", n)
+	}
+	if result := doUpdate(context.Background(), conf, UpdateRequest{ChannelId: "inbox"}); result.New != 1 || result.FeedErrors != 0 {
+		t.Fatalf("update %+v, want the inbox video", result)
+	}
+	if !conf.Feeds[1].HasAudioFile("vid00000002") {
+		t.Fatal("inbox video not downloaded into the inbox feed")
+	}
+
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/feed/inbox", nil), map[string]string{"name": "inbox"}))
+	if !strings.Contains(w.Body.String(), "Edited vid00000002") {
+		t.Errorf("inbox feed lacks the video:\n%s", w.Body)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes("urls.txt", later, later)
+	if n := inbox.Scan(context.Background(), conf); n != 0 {
+		t.Errorf("downloaded video queued again")
+	}
+}
+
+func TestAddVideo(t *testing.T) {
+	conf := setupPipeline(t)
+	// Only the first video is in the filtered channel feed.
+	conf.Feeds[0].Keywords = []string{"daily"}
+	t.Cleanup(func() {
+		retries.Done("vid00000002")
+		episodes.Delete(context.Background(), "vid00000002")
+		select {
+		case <-updateTrigger:
+		default:
+		}
+	})
+	add := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		apiVideoAddHandler(conf, w, httptest.NewRequest(http.MethodPost, "/api/videos?"+query, nil))
+		return w
+	}
+	if w := add("url=not+a+video&feed=test"); w.Code != http.StatusBadRequest {
+		t.Errorf("no video: status %d, want 400", w.Code)
+	}
+	if w := add("url=vid00000002&feed=missing"); w.Code != http.StatusNotFound {
+		t.Errorf("unknown feed: status %d, want 404", w.Code)
+	}
+	if w := add("url=https%3A%2F%2Fyoutu.be%2Fvid00000002&feed=test"); w.Code != http.StatusAccepted {
+		t.Fatalf("status %d, want 202: %s", w.Code, w.Body)
+	}
+	if w := add("url=vid00000002&feed=test"); w.Code != http.StatusConflict {
+		t.Errorf("queued video added again: status %d, want 409", w.Code)
+	}
+	if result := doUpdate(context.Background(), conf, UpdateRequest{ChannelId: testChannelId}); result.New != 2 {
+		t.Fatalf("update %+v, want the feed video and the added one", result)
+	}
+
+	w := httptest.NewRecorder()
+	feedGetHandler(conf, w, mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/feed/test", nil), map[string]string{"name": "test"}))
+	if !strings.Contains(w.Body.String(), "vid00000002.m4a") {
+		t.Errorf("feed lacks the added video:\n%s", w.Body)
+	}
+	if w := add("url=vid00000002&feed=test"); w.Code != http.StatusConflict {
+		t.Errorf("downloaded video added again: status %d, want 409", w.Code)
+	}
+}
+
+func TestRecordLive(t *testing.T) {
+	conf := setupPipeline(t)
+	t.Setenv("LFPOD_TEST_LIVE", "vid00000001")
+	t.Cleanup(func() { retries.Done("vid00000001") })
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 1 || result.NotReady != 1 {
+		t.Fatalf("update %+v, want the live stream not ready", result)
+	}
+	recordings.Wait()
+	if conf.Feeds[0].HasAudioFile("vid00000001") {
+		t.Fatal("live stream recorded without record_live")
+	}
+
+	// Enabled, the stream is recorded at its next check.
+	conf.Feeds[0].RecordLive = true
+	retries.Done("vid00000001")
+	if result := doUpdate(context.Background(), conf, UpdateRequest{ChannelId: testChannelId}); result.NotReady != 1 {
+		t.Fatalf("update %+v, want the live stream recording", result)
+	}
+	recordings.Wait()
+	if !conf.Feeds[0].HasAudioFile("vid00000001") {
+		t.Fatal("live stream not published")
+	}
+	if retries.Has("vid00000001") || isRecording("vid00000001") {
+		t.Error("recorded live stream still queued")
+	}
+}
+
+func TestKeepOriginal(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].KeepOriginal = true
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	name, ok := findOriginal(testChannelId, "vid00000001")
+	if !ok {
+		t.Fatal("original download not kept")
+	}
+	if names, _ := filepath.Glob(filepath.Join(downloadDir, "vid00000001*")); len(names) != 0 {
+		t.Errorf("download left behind: %v", names)
+	}
+	if ids := episodeIndex.VideoIds(testChannelId); len(ids) != 2 {
+		t.Errorf("video ids %v, originals listed as episodes", ids)
+	}
+	get := func(name, videoId string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/original/"+name+"/"+videoId, nil)
+		originalHandler(conf, w, mux.SetURLVars(r, map[string]string{"name": name, "videoId": videoId}))
+		return w
+	}
+	w := get("test", "vid00000001")
+	if w.Code != http.StatusOK || w.Body.Len() == 0 || !strings.Contains(w.Header().Get("Content-Disposition"), "vid00000001") {
+		t.Errorf("original: status %d, disposition %q", w.Code, w.Header().Get("Content-Disposition"))
+	}
+	for _, videoId := range []string{"vid0000000*", "missing"} {
+		if w := get("test", videoId); w.Code != http.StatusNotFound {
+			t.Errorf("original of %s: status %d", videoId, w.Code)
+		}
+	}
+	conf.Profiles = []Profile{{Name: "anna", Token: "t1", Feeds: []ConfFeed{{Name: "anna-news", ChannelId: testChannelId}}}}
+	if w := get("anna-news", "vid00000001"); w.Code != http.StatusNotFound {
+		t.Errorf("original of a profile feed: status %d", w.Code)
+	}
+
+	if _, err := deleteEpisode(context.Background(), conf.Feeds[0], "vid00000001", false); err != nil {
+		t.Fatal(err)
+	}
+	if fileExists(name) {
+		t.Error("original not deleted with the episode")
+	}
+}
+
+func TestEpisodeNumbers(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].Seasons = "dates"
+	conf.Feeds[0].SeasonStarts = []string{"2023-05-02"}
+	conf.Feeds[0].Serial = true
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	feed := func() string {
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/feed/"+conf.Feeds[0].Name, nil), map[string]string{"name": conf.Feeds[0].Name})
+		feedGetHandler(conf, w, r)
+		return w.Body.String()
+	}
+	body := feed()
+	for _, want := range []string{
+		`<type xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">serial</type>`,
+		`<episode xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">2</episode>`,
+		`<episode xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">1</episode>`,
+		`<episodeType xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">full</episodeType>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("feed lacks %s", want)
+		}
+	}
+	if n := strings.Count(body, "<season "); n != 1 {
+		t.Errorf("%d entries with a season, want the one published from the season start", n)
+	}
+
+	// The remaining episode keeps its number.
+	if _, err := deleteEpisode(context.Background(), conf.Feeds[0], "vid00000002", false); err != nil {
+		t.Fatal(err)
+	}
+	body = feed()
+	if !strings.Contains(body, `<episode xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">2</episode>`) {
+		t.Error("episode renumbered after deletion")
+	}
+
+	for _, f := range []ConfFeed{
+		{Seasons: "month"},
+		{Seasons: "dates"},
+		{Seasons: "year", SeasonStarts: []string{"2023-01-01"}},
+		{Seasons: "dates", SeasonStarts: []string{"2023-09-01", "2023-01-01"}},
+	} {
+		f.Name, f.ChannelId = "test", testChannelId
+		if validateFeed(f) == "" {
+			t.Errorf("seasons %q %q accepted", f.Seasons, f.SeasonStarts)
+		}
+	}
+}
+
+func TestDirectoryInfo(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].Category = "News/Daily News"
+	conf.Feeds[0].Explicit = true
+	conf.Feeds[0].OwnerName, conf.Feeds[0].OwnerEmail = "Jane Doe", "jane@example.com"
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	w := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/feed/test", nil), map[string]string{"name": "test"})
+	feedGetHandler(conf, w, r)
+	body := w.Body.String()
+	for _, want := range []string{
+		`<category xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd" text="News">`,
+		`<category xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd" text="Daily News">`,
+		`<explicit xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">true</explicit>`,
+		`<email xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">jane@example.com</email>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("feed lacks %s", want)
+		}
+	}
+	if n := strings.Count(body, "<explicit "); n != 3 {
+		t.Errorf("%d explicit flags, want the feed and its 2 entries", n)
+	}
+
+	for _, f := range []ConfFeed{
+		{Category: "Cooking"},
+		{Category: "News/Cooking"},
+		{OwnerEmail: "jane"},
+	} {
+		f.Name, f.ChannelId = "test", testChannelId
+		if validateFeed(f) == "" {
+			t.Errorf("%+v accepted", f)
+		}
+	}
+	if msg := validateFeed(ConfFeed{Name: "test", ChannelId: testChannelId, Category: "Technology"}); msg != "" {
+		t.Error(msg)
+	}
+}
+
+func TestBackup(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	if err := os.WriteFile("feeds.json", []byte(`{"ytfeeds": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(saved map[string]string) { stateFiles = saved }(stateFiles)
+	stateFiles = map[string]string{"episodes.db": "episodes.db", "retries.json": "missing.json"}
+
+	list := func(data []byte) []string {
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := []string{}
+		tr := tar.NewReader(gz)
+		for {
+			hdr, err := tr.Next()
+			if err != nil {
+				break
+			}
+			names = append(names, hdr.Name)
+		}
+		return names
+	}
+	buf := &bytes.Buffer{}
+	if _, err := writeBackup(context.Background(), buf, "feeds.json", false); err != nil {
+		t.Fatal(err)
+	}
+	names := strings.Join(list(buf.Bytes()), " ")
+	if !strings.HasPrefix(names, "ytfeeds.json episodes.db ") || !strings.Contains(names, "audio/UCtest/vid00000001.meta.json") ||
+		strings.Contains(names, ".opus") {
+		t.Errorf("backup without audio has %s", names)
+	}
+	withAudio := &bytes.Buffer{}
+	if _, err := writeBackup(context.Background(), withAudio, "feeds.json", true); err != nil {
+		t.Fatal(err)
+	}
+	if names := strings.Join(list(withAudio.Bytes()), " "); !strings.Contains(names, "audio/UCtest/vid00000001.opus") {
+		t.Errorf("backup with audio has %s", names)
+	}
+
+	// Command arguments are relative to the directory lfpod was started
+	// in, not the data directory.
+	defer func(saved string) { workDir = saved }(workDir)
+	workDir = t.TempDir()
+	if err := runBackup(context.Background(), "feeds.json", []string{"-o", "out.tar.gz"}); err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(filepath.Join(workDir, "out.tar.gz")) || fileExists("out.tar.gz") {
+		t.Error("backup not written to the working directory")
+	}
+
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restoreBackup(bytes.NewReader(withAudio.Bytes()), "restored.json", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"restored.json", "episodes.db", filepath.Join("audio", testChannelId, "vid00000001.opus")} {
+		if !fileExists(name) {
+			t.Errorf("%s not restored", name)
+		}
+	}
+	restored, err := store.Open(context.Background(), "episodes.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ep, _, _ := restored.Get(context.Background(), "vid00000001"); ep.Status != store.StatusReady {
+		t.Errorf("restored episode %+v", ep)
+	}
+	restored.Close()
+	if _, err := restoreBackup(bytes.NewReader(withAudio.Bytes()), "restored.json", false); err == nil {
+		t.Error("existing configuration replaced without force")
+	}
+	if err := runRestore("restored.json", []string{"-force", "out.tar.gz"}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestProfileSecrets(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.ConfFeedsFile = "feeds.json"
+	secret := "0123456789abcdef"
+	conf.Profiles = []Profile{{Name: "anna", Secrets: []string{secret}, Feeds: []ConfFeed{
+		{Name: "anna-news", ChannelId: testChannelId},
+	}}}
+	if err := checkProfiles(conf.Profiles); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkProfiles([]Profile{{Name: "bob", Secrets: []string{"short"}}}); err == nil {
+		t.Error("short secret accepted")
+	}
+	doUpdate(context.Background(), conf, UpdateRequest{})
+
+	feed := func(secret string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		feedGetHandler(conf, w, mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/t/"+secret+"/feed", nil), map[string]string{"secret": secret}))
+		return w
+	}
+	audio := func(secret, channelId string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/t/"+secret+"/audio/"+channelId+"/vid00000001.opus", nil)
+		profileAudioHandler(conf, w, mux.SetURLVars(r, map[string]string{"secret": secret}))
+		return w
+	}
+	w := feed(secret)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/t/"+secret+"/audio/UCtest/vid00000001.opus") {
+		t.Errorf("capability feed %d lacks capability audio links:\n%s", w.Code, w.Body)
+	}
+	if w := audio(secret, testChannelId); w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("capability audio: status %d", w.Code)
+	}
+	if w := audio(secret, "UCother"); w.Code != http.StatusNotFound {
+		t.Errorf("audio of a channel outside the profile: status %d", w.Code)
+	}
+	if w := feed("wrong-secret-0000"); w.Code != http.StatusNotFound {
+		t.Errorf("wrong secret: status %d", w.Code)
+	}
+
+	rotated, err := conf.RotateSecret("anna", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := feed(secret); w.Code != http.StatusNotFound {
+		t.Errorf("rotated secret: status %d", w.Code)
+	}
+	if w := feed(rotated); w.Code != http.StatusOK {
+		t.Errorf("new secret: status %d", w.Code)
+	}
+	if saved := readConfFeeds("feeds.json"); len(saved.Profiles) != 1 || saved.Profiles[0].Secrets[0] != rotated || len(saved.Profiles[0].Feeds) != 1 {
+		t.Errorf("saved profiles %+v", saved.Profiles)
+	}
+	if got := redactSecrets("/t/" + rotated + "/audio/UCtest/a.opus"); strings.Contains(got, rotated) {
+		t.Errorf("secret logged: %s", got)
+	}
+}
+
+func TestFeedSlug(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].Slug = "test-news"
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	plays = PlayCounter{counts: map[string]*PlayStats{}}
+	t.Cleanup(func() { plays = PlayCounter{counts: map[string]*PlayStats{}} })
+
+	get := func(target, name string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, target, nil)
+		if name != "" {
+			feedGetHandler(conf, w, mux.SetURLVars(r, map[string]string{"name": name}))
+		} else {
+			audioHandler(conf, w, r)
+		}
+		return w
+	}
+	w := get("/feed/test-news", "test-news")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "/audio/test-news/vid00000001.opus") {
+		t.Errorf("slug feed %d lacks slug audio links:\n%s", w.Code, w.Body)
+	}
+	if w := get("/feed/UCtest?q=news", "UCtest"); w.Code != http.StatusMovedPermanently || !strings.HasSuffix(w.Header().Get("Location"), "/feed/test-news?q=news") {
+		t.Errorf("channel id feed: status %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+	if w := get("/audio/test-news/vid00000001.opus", ""); w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("slug audio: status %d", w.Code)
+	}
+	if w := get("/audio/UCtest/vid00000001.opus", ""); w.Code != http.StatusMovedPermanently || !strings.HasSuffix(w.Header().Get("Location"), "/audio/test-news/vid00000001.opus") {
+		t.Errorf("channel id audio: status %d, location %q", w.Code, w.Header().Get("Location"))
+	}
+	if list := plays.List(); len(list) != 1 || list[0].ChannelId != testChannelId {
+		t.Errorf("plays %+v, want the slug download counted for the channel", list)
+	}
+	for _, slug := range []string{"Test", "a b", "-news", "archive"} {
+		if validateFeed(ConfFeed{Name: "test", ChannelId: testChannelId, Slug: slug}) == "" {
+			t.Errorf("slug %q accepted", slug)
+		}
+	}
+}
+
+func TestCtl(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.ConfFeedsFile = "feeds.json"
+	defer func(token string) { apiToken = token }(apiToken)
+	apiToken = "secret"
+	api := mux.NewRouter().PathPrefix("/api").Subrouter()
+	api.Use(authMiddleware)
+	addAPIRoutes(api, conf)
+	server := httptest.NewServer(api)
+	defer server.Close()
+	ctl := func(args ...string) error {
+		return runCtl(append([]string{"-server", server.URL, "-token", "secret"}, args...))
+	}
+
+	if err := ctl("add", "-name", "Music", "-k", "live, session", "UCabcdefghijkl0123456789"); err != nil {
+		t.Fatal(err)
+	}
+	feed, ok := conf.GetFeed("UCabcdefghijkl0123456789")
+	if !ok || feed.Name != "Music" || len(feed.Keywords) != 2 {
+		t.Errorf("added feed %+v", feed)
+	}
+	if err := ctl("list"); err != nil {
+		t.Error(err)
+	}
+	select {
+	case <-updateTrigger:
+	default:
+	}
+	if err := ctl("update", "Music"); err != nil {
+		t.Fatal(err)
+	}
+	if req := <-updateTrigger; req.ChannelId != "UCabcdefghijkl0123456789" {
+		t.Errorf("update of %q", req.ChannelId)
+	}
+	if err := ctl("status"); err != nil {
+		t.Error(err)
+	}
+	if err := ctl("remove", "Music"); err != nil {
+		t.Error(err)
+	}
+	if _, ok := conf.GetFeed("UCabcdefghijkl0123456789"); ok {
+		t.Error("feed not removed")
+	}
+	if err := ctl("remove", "Music"); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("removing a missing feed: %v", err)
+	}
+	if err := runCtl([]string{"-server", server.URL, "-token", "wrong", "list"}); err == nil {
+		t.Error("wrong token accepted")
+	}
+}
+
+func TestDisabledFeed(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Feeds[0].Disabled = true
+	if result := doUpdate(context.Background(), conf, UpdateRequest{ChannelId: "UCtest"}); result.New != 0 || result.FeedErrors != 0 {
+		t.Fatalf("disabled feed polled: %+v", result)
+	}
+	conf.Feeds[0].Disabled = false
+	if result := doUpdate(context.Background(), conf, UpdateRequest{}); result.New != 2 {
+		t.Fatalf("enabled feed: %+v, want 2 new", result)
+	}
+	// Episodes of a disabled feed are still served.
+	conf.Feeds[0].Disabled = true
+	w := httptest.NewRecorder()
+	r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/feed/test", nil), map[string]string{"name": "test"})
+	feedGetHandler(conf, w, r)
+	if !strings.Contains(w.Body.String(), "Daily news") {
+		t.Error("episodes of the disabled feed not served")
+	}
+	if status := loopStatus.Get(conf); len(status.Feeds) != 1 || !status.Feeds[0].Disabled {
+		t.Errorf("status feeds %+v, want the feed disabled", status.Feeds)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	conf := setupPipeline(t)
+	t.Cleanup(func() { throttle = Throttle{} })
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "3600")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	savedURL := feedBaseURL
+	t.Cleanup(func() { feedBaseURL = savedURL })
+	feedBaseURL = server.URL + "/?channel_id="
+
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	until, _, ok := throttle.Active(time.Now())
+	if !ok || time.Until(until) < 59*time.Minute {
+		t.Fatalf("throttled until %s, want an hour as asked by Retry-After", until)
+	}
+	if s := loopStatus.Get(conf); s.ThrottledUntil == nil || s.ThrottleReason == "" {
+		t.Errorf("status %+v, want throttled", s)
+	}
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	if requests != 1 {
+		t.Errorf("%d feed requests, want none while throttled", requests-1)
+	}
+
+	// Backoff doubles with each rate limit in a row and starts over
+	// after a pass without one.
+	throttle = Throttle{}
+	now := time.Now()
+	for _, want := range []time.Duration{5 * time.Minute, 10 * time.Minute, 20 * time.Minute} {
+		if got := throttle.Hit("test", 0, now).Sub(now); got != want {
+			t.Errorf("backoff %s, want %s", got, want)
+		}
+		now = now.Add(time.Hour)
+	}
+	throttle.Reset()
+	if got := throttle.Hit("test", 0, now).Sub(now); got != 5*time.Minute {
+		t.Errorf("backoff after reset %s, want 5m", got)
+	}
+}
+
+func TestProbeState(t *testing.T) {
+	conf := setupPipeline(t)
+	if err := probes.Load("probes.json"); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LFPOD_TEST_UPCOMING", "vid00000001")
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	t.Cleanup(func() { retries.Done("vid00000001") })
+
+	state, ok := probes.Fresh("vid00000001", time.Now())
+	if !ok || state.Status != "is_upcoming" {
+		t.Fatalf("premiere probe %+v, want is_upcoming", state)
+	}
+	if _, ok := probes.Fresh("vid00000002", time.Now()); ok {
+		t.Error("probe of a downloaded video kept")
+	}
+	// The premiere is not probed again before it starts.
+	t.Setenv("LFPOD_TEST_UPCOMING", "")
+	if _, ready, _ := isVideoReady(context.Background(), &conf.Feeds[0], "vid00000001"); ready {
+		t.Error("premiere probed again before its release")
+	}
+	if _, ok := probes.Fresh("vid00000001", time.Now().Add(2*time.Hour)); ok {
+		t.Error("probe fresh after the premiere ended")
+	}
+
+	saved := probes.items["vid00000001"].Checked
+	probes.items = map[string]*ProbeState{}
+	if err := probes.Load("probes.json"); err != nil {
+		t.Fatal(err)
+	}
+	if item := probes.items["vid00000001"]; item == nil || !item.Checked.Equal(saved) {
+		t.Errorf("loaded probe %+v, want checked at %s", item, saved)
+	}
+	// A live stream is probed again after probeLiveInterval.
+	item := probes.items["vid00000001"]
+	item.Status, item.Meta.LiveStatus = "is_live", "is_live"
+	if _, ready, available := isVideoReady(context.Background(), &conf.Feeds[0], "vid00000001"); ready || !available.Equal(item.Checked.Add(probeLiveInterval)) {
+		t.Errorf("live stream expected at %s, want its next probe", available)
+	}
+	item.Checked = time.Now().Add(-probeLiveInterval)
+	if _, ready, _ := isVideoReady(context.Background(), &conf.Feeds[0], "vid00000001"); !ready {
+		t.Error("live stream over not probed again")
+	}
+	// A premiere weeks out is probed again daily in case it is moved.
+	item.Status, item.Meta.LiveStatus = "is_upcoming", "is_upcoming"
+	item.Meta.ReleaseTimestamp = time.Now().Add(21 * 24 * time.Hour).Unix()
+	if _, ready, available := isVideoReady(context.Background(), &conf.Feeds[0], "vid00000001"); ready || !available.Equal(item.Checked.Add(probeUpcomingMaxInterval)) {
+		t.Errorf("far premiere expected at %s, want a probe a day later", available)
+	}
+	item.Checked = time.Now().Add(-probeUpcomingMaxInterval)
+	if _, ready, _ := isVideoReady(context.Background(), &conf.Feeds[0], "vid00000001"); !ready {
+		t.Error("far premiere not probed again")
+	}
+}
+
+func TestShowInfo(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	get := func(name string) string {
+		w := httptest.NewRecorder()
+		r := mux.SetURLVars(httptest.NewRequest(http.MethodGet, "/feed/"+name, nil), map[string]string{"name": name})
+		feedGetHandler(conf, w, r)
+		return w.Body.String()
+	}
+
+	for _, name := range []string{"test", ""} {
+		body := get(name)
+		for _, want := range []string{
+			"<title>Test channel</title>",
+			"<name>Test Author</name>",
+			`<author xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">Test Author</author>`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("feed %q lacks %s", name, want)
+			}
+		}
+	}
+	if info := readChannelInfo(testChannelId); info.Author != "Test Author" {
+		t.Errorf("channel.json author %q", info.Author)
+	}
+
+	// Configured title and author go first, also over the owner.
+	conf.Feeds[0].PodcastTitle, conf.Feeds[0].PodcastAuthor = "My show", "Me"
+	conf.Feeds[0].OwnerName = "Jane Doe"
+	body := get("test")
+	for _, want := range []string{
+		"<title>My show</title>",
+		"<name>Me</name>",
+		`<author xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">Me</author>`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("feed lacks %s", want)
+		}
+	}
+
+	// Without channel metadata the feed name is the title.
+	channelCache.mu.Lock()
+	channelCache.items = map[string]cachedChannel{}
+	channelCache.mu.Unlock()
+	os.Remove(channelInfoFileName(testChannelId))
+	feed := ConfFeed{Name: "test", ChannelId: testChannelId}
+	if title, author := feed.ShowInfo(); title != "test" || author != "" {
+		t.Errorf("show info %q, %q, want the feed name", title, author)
+	}
+}
+
+func TestRecodeWorkers(t *testing.T) {
+	conf := setupPipeline(t)
+	conf.Workers, conf.RecodeWorkers = 2, 1
+	stop := make(chan struct{})
+	sampled := make(chan int)
+	go func() {
+		most := 0
+		for {
+			select {
+			case <-stop:
+				sampled <- most
+				return
+			case <-time.After(time.Millisecond):
+			}
+			n := 0
+			for _, ep := range listEpisodes(context.Background(), testChannelId) {
+				if ep.Status == store.StatusRecoding {
+					n++
+				}
+			}
+			if n > most {
+				most = n
+			}
+		}
+	}()
+	result := doUpdate(context.Background(), conf, UpdateRequest{})
+	close(stop)
+	if most := <-sampled; most > 1 {
+		t.Errorf("%d videos recoded at once, want at most 1", most)
+	}
+	if result.New != 2 || result.Failed != 0 {
+		t.Errorf("update result %+v, want 2 new", result)
+	}
+}
+
+func TestPlayStats(t *testing.T) {
+	conf := setupPipeline(t)
+	doUpdate(context.Background(), conf, UpdateRequest{})
+	conf.Feeds = append(conf.Feeds, ConfFeed{Name: "quiet", ChannelId: "UCquiet"})
+	plays = PlayCounter{counts: map[string]*PlayStats{}}
+	t.Cleanup(func() { plays = PlayCounter{counts: map[string]*PlayStats{}} })
+	if err := plays.Load("plays.json"); err != nil {
+		t.Fatal(err)
+	}
+	get := func(method, name, rng string) {
+		r := httptest.NewRequest(method, "/"+testChannelId+"/"+name, nil)
+		if rng != "" {
+			r.Header.Set("Range", rng)
+		}
+		w := httptest.NewRecorder()
+		audioFiles.ServeHTTP(w, r)
+		if w.Code >= 300 {
+			t.Fatalf("%s %s %s: %d", method, name, rng, w.Code)
+		}
+	}
+	stat, err := os.Stat(filepath.Join("audio", testChannelId, "vid00000001.opus"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	get(http.MethodGet, "vid00000001.opus", "")
+	get(http.MethodHead, "vid00000001.opus", "")
+	// A player fetching in ranges completes the download once.
+	half := stat.Size() / 2
+	get(http.MethodGet, "vid00000001.opus", fmt.Sprintf("bytes=0-%d", half-1))
+	get(http.MethodGet, "vid00000001.opus", fmt.Sprintf("bytes=%d-%d", half, stat.Size()-1))
+	get(http.MethodGet, "vid00000002.m4a", "bytes=0-0")
+	if n := plays.Get("vid00000001"); n != 2 {
+		t.Errorf("%d downloads, want 2", n)
+	}
+	if n := plays.Get("vid00000002"); n != 0 {
+		t.Errorf("%d downloads of a partial fetch, want 0", n)
+	}
+
+	w := httptest.NewRecorder()
+	apiStatsHandler(conf, w, httptest.NewRequest(http.MethodGet, "/api/stats", nil))
+	report := PlayReport{}
+	if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Feeds) != 2 || report.Feeds[0].Downloads != 2 || report.Feeds[0].Episodes != 1 || report.Feeds[0].Last == nil {
+		t.Errorf("feed stats %+v", report.Feeds)
+	}
+	if report.Feeds[1].ChannelId != "UCquiet" || report.Feeds[1].Downloads != 0 {
+		t.Errorf("feed without downloads %+v", report.Feeds[1])
+	}
+	if len(report.Episodes) != 1 || report.Episodes[0].VideoId != "vid00000001" {
+		t.Errorf("episode stats %+v", report.Episodes)
+	}
+
+	plays.Save()
+	plays = PlayCounter{counts: map[string]*PlayStats{}}
+	if err := plays.Load("plays.json"); err != nil || plays.Get("vid00000001") != 2 {
+		t.Errorf("loaded %d downloads, %v", plays.Get("vid00000001"), err)
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+// Published audio files are decoded again this often to find files
+// truncated or damaged after verification, e.g. by a crash of an older
+// version or the disk, 0 to never.
+var integrityScanInterval time.Duration
+
+// An update pass checks at most this many files, so a scan of a large
+// archive spreads over several passes instead of holding up downloads.
+var integrityScanBatch = 20
+
+// integrityChecks keeps the time each audio file was last checked,
+// by path. A restart checks all files again.
+var integrityChecks = struct {
+	sync.Mutex
+	checked map[string]time.Time
+}{checked: map[string]time.Time{}}
+
+// scanIntegrity checks the audio files not checked within the scan
+// interval, up to a batch. Bad files are quarantined and their videos
+// downloaded again. It returns the number of bad files.
+func scanIntegrity(ctx context.Context, conf *Conf) int {
+	if integrityScanInterval <= 0 {
+		return 0
+	}
+	integrityChecks.Lock()
+	defer integrityChecks.Unlock()
+	now := time.Now()
+	checked, bad := 0, 0
+	for _, feed := range conf.AllFeeds() {
+		feed := feed
+		for _, videoId := range episodeIndex.VideoIds(feed.ChannelId) {
+			if checked >= integrityScanBatch {
+				return bad
+			}
+			name, _, ok := feed.FindAudioFile(videoId)
+			if !ok || now.Sub(integrityChecks.checked[name]) < integrityScanInterval || isHollow(name) {
+				continue
+			}
+			checked++
+			integrityChecks.checked[name] = now
+			metrics.Add("lfpod_integrity_checks_total", "", 1)
+			var expected time.Duration
+			sidecar, hasSidecar := readSidecar(feed.ChannelId, videoId)
+			if hasSidecar {
+				expected = time.Duration(sidecar.Duration * float64(time.Second))
+			}
+			err := verifyAudio(videoId, name, expected)
+			if err == nil {
+				continue
+			}
+			log.Printf("%s %s corrupted, downloading again: %v", feed.Name, videoId, err)
+			metrics.Add("lfpod_corrupted_files_total", "", 1)
+			bad++
+			delete(integrityChecks.checked, name)
+			entry := &youtube.Entry{VideoId: videoId, Title: videoId}
+			if hasSidecar {
+				entry.Title, entry.Published = sidecar.Title, sidecar.Published
+				entry.Media = &youtube.Media{Description: sidecar.Description}
+			}
+			// The file is linked to the quarantine, deleting the
+			// episode keeps its blob and sidecars consistent.
+			if err := os.MkdirAll(quarantineDir, 0750); err == nil {
+				dst := filepath.Join(quarantineDir, filepath.Base(name))
+				os.Remove(dst)
+				if err := os.Link(name, dst); err != nil {
+					log.Print(err)
+				}
+			}
+			if _, err := deleteEpisode(ctx, feed, videoId, true); err != nil {
+				log.Print(feed.Name, " ", videoId, " ", err)
+				continue
+			}
+			retries.Queue(feed.ChannelId, entry, "corrupted: "+err.Error())
+		}
+	}
+	return bad
+}
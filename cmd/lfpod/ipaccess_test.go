@@ -0,0 +1,53 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPAllow(t *testing.T) {
+	defer func(saved []*net.IPNet) { trustedProxies = saved }(trustedProxies)
+	allowed, err := parseNetworks("192.168.1.0/24, 10.8.0.0/24")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if trustedProxies, err = parseNetworks("127.0.0.1,::1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parseNetworks("192.168.1.0/33"); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+	h := ipAllowMiddleware(allowed, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, tt := range []struct {
+		remote, forwarded string
+		status            int
+	}{
+		{"192.168.1.20:5000", "", http.StatusOK},
+		{"10.8.0.3:5000", "", http.StatusOK},
+		{"203.0.113.9:5000", "", http.StatusForbidden},
+		// Only trusted proxies name the client.
+		{"203.0.113.9:5000", "192.168.1.20", http.StatusForbidden},
+		{"127.0.0.1:5000", "192.168.1.20", http.StatusOK},
+		{"127.0.0.1:5000", "203.0.113.9", http.StatusForbidden},
+		{"[::1]:5000", "192.168.1.20, 127.0.0.1", http.StatusOK},
+		// The client cannot forge addresses before the proxy's.
+		{"127.0.0.1:5000", "192.168.1.20, 203.0.113.9", http.StatusForbidden},
+		{"127.0.0.1:5000", "", http.StatusForbidden},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/feed", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Errorf("%s forwarded for %q: %d, want %d", tt.remote, tt.forwarded, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lfpod/server"
+	"github.com/lfpod/store"
+)
+
+const seasonDateLayout = "2006-01-02"
+
+// itunesCategories are the Apple Podcasts categories with their
+// subcategories, directories reject feeds with others.
+var itunesCategories = map[string][]string{
+	"Arts":                    {"Books", "Design", "Fashion & Beauty", "Food", "Performing Arts", "Visual Arts"},
+	"Business":                {"Careers", "Entrepreneurship", "Investing", "Management", "Marketing", "Non-Profit"},
+	"Comedy":                  {"Comedy Interviews", "Improv", "Stand-Up"},
+	"Education":               {"Courses", "How To", "Language Learning", "Self-Improvement"},
+	"Fiction":                 {"Comedy Fiction", "Drama", "Science Fiction"},
+	"Government":              nil,
+	"Health & Fitness":        {"Alternative Health", "Fitness", "Medicine", "Mental Health", "Nutrition", "Sexuality"},
+	"History":                 nil,
+	"Kids & Family":           {"Education for Kids", "Parenting", "Pets & Animals", "Stories for Kids"},
+	"Leisure":                 {"Animation & Manga", "Automotive", "Aviation", "Crafts", "Games", "Hobbies", "Home & Garden", "Video Games"},
+	"Music":                   {"Music Commentary", "Music History", "Music Interviews"},
+	"News":                    {"Business News", "Daily News", "Entertainment News", "News Commentary", "Politics", "Sports News", "Tech News"},
+	"Religion & Spirituality": {"Buddhism", "Christianity", "Hinduism", "Islam", "Judaism", "Religion", "Spirituality"},
+	"Science":                 {"Astronomy", "Chemistry", "Earth Sciences", "Life Sciences", "Mathematics", "Natural Sciences", "Nature", "Physics", "Social Sciences"},
+	"Society & Culture":       {"Documentary", "Personal Journals", "Philosophy", "Places & Travel", "Relationships"},
+	"Sports":                  {"Baseball", "Basketball", "Cricket", "Fantasy Sports", "Football", "Golf", "Hockey", "Rugby", "Running", "Soccer", "Swimming", "Tennis", "Volleyball", "Wilderness", "Wrestling"},
+	"TV & Film":               {"After Shows", "Film History", "Film Interviews", "Film Reviews", "TV Reviews"},
+	"Technology":              nil,
+	"True Crime":              nil,
+}
+
+// episodeNumbers numbers the episodes of the channels of a list in
+// publication order, from 1 per channel, for itunes:episode. Episodes
+// deleted from the archive keep their place, the episode store
+// remembers them, so numbers do not change when old episodes go.
+func episodeNumbers(ctx context.Context, list []FeedEpisode) map[string]int {
+	type numbered struct {
+		videoId   string
+		published time.Time
+	}
+	channels := map[string]map[string]time.Time{}
+	for _, ep := range list {
+		if channels[ep.ChannelId] == nil {
+			channels[ep.ChannelId] = map[string]time.Time{}
+		}
+		channels[ep.ChannelId][ep.VideoId] = ep.Published
+	}
+	numbers := map[string]int{}
+	for channelId, published := range channels {
+		for _, ep := range listEpisodes(ctx, channelId) {
+			if ep.Status != store.StatusReady && ep.Status != store.StatusDeleted {
+				continue
+			}
+			if _, ok := published[ep.VideoId]; ok {
+				continue
+			}
+			if t, err := time.Parse(time.RFC3339, ep.Published); err == nil {
+				published[ep.VideoId] = t
+			}
+		}
+		sorted := []numbered{}
+		for videoId, t := range published {
+			sorted = append(sorted, numbered{videoId, t})
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			if !sorted[i].published.Equal(sorted[j].published) {
+				return sorted[i].published.Before(sorted[j].published)
+			}
+			return sorted[i].videoId < sorted[j].videoId
+		})
+		for i, ep := range sorted {
+			numbers[ep.videoId] = i + 1
+		}
+	}
+	return numbers
+}
+
+// Season returns the itunes:season of an episode of the feed, 0 if the
+// feed has no seasons or the episode was published before the first.
+func (f *ConfFeed) Season(published time.Time) int {
+	if f.Seasons == "year" {
+		return published.Year()
+	}
+	season := 0
+	for i, s := range f.SeasonStarts {
+		start, err := time.Parse(seasonDateLayout, s)
+		if err != nil || published.Before(start) {
+			break
+		}
+		season = i + 1
+	}
+	return season
+}
+
+// checkSeasons reports invalid season options of a feed.
+func checkSeasons(feed ConfFeed) error {
+	switch feed.Seasons {
+	case "":
+		if len(feed.SeasonStarts) > 0 {
+			return fmt.Errorf(`season_starts requires seasons "dates"`)
+		}
+	case "year":
+		if len(feed.SeasonStarts) > 0 {
+			return fmt.Errorf(`season_starts conflicts with seasons "year"`)
+		}
+	case "dates":
+		if len(feed.SeasonStarts) == 0 {
+			return fmt.Errorf(`seasons "dates" requires season_starts`)
+		}
+		var prev time.Time
+		for _, s := range feed.SeasonStarts {
+			start, err := time.Parse(seasonDateLayout, s)
+			if err != nil {
+				return fmt.Errorf("invalid season start %q, YYYY-MM-DD expected", s)
+			}
+			if !start.After(prev) {
+				return fmt.Errorf("season starts must be in ascending order")
+			}
+			prev = start
+		}
+	default:
+		return fmt.Errorf(`seasons must be "year" or "dates"`)
+	}
+	return nil
+}
+
+// checkDirectoryInfo reports an unknown category or invalid owner email
+// of a feed.
+func checkDirectoryInfo(feed ConfFeed) error {
+	if feed.Category != "" {
+		category, sub, hasSub := strings.Cut(feed.Category, "/")
+		subs, ok := itunesCategories[category]
+		if !ok {
+			return fmt.Errorf("unknown category %q", category)
+		}
+		if hasSub && !containsString(subs, sub) {
+			return fmt.Errorf("unknown subcategory %q of %s", sub, category)
+		}
+	}
+	if feed.OwnerEmail != "" {
+		if _, err := mail.ParseAddress(feed.OwnerEmail); err != nil {
+			return fmt.Errorf("invalid owner_email: %v", err)
+		}
+	}
+	return nil
+}
+
+// setChannel adds the podcast directory information of a feed to the
+// feed of its channel.
+func setChannel(f *server.Feed, feed *ConfFeed) {
+	if feed.Serial {
+		f.Type = "serial"
+	}
+	if feed.Category != "" {
+		f.ItunesCategory = server.NewCategory(feed.Category)
+	}
+	f.Explicit = "false"
+	if feed.Explicit {
+		f.Explicit = "true"
+	}
+	if feed.OwnerName != "" || feed.OwnerEmail != "" {
+		f.ItunesAuthor = feed.OwnerName
+		f.Owner = &server.Owner{Name: feed.OwnerName, Email: feed.OwnerEmail}
+	}
+}
@@ -0,0 +1,2041 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lfpod/pipeline"
+	"github.com/lfpod/server"
+	"github.com/lfpod/store"
+	"github.com/lfpod/youtube"
+)
+
+// Channel feeds are fetched from here, integration tests point it to a
+// mock server.
+var feedBaseURL = youtube.FeedURL
+
+// Failed channel feed requests are retried this many times, after
+// network errors and server errors, waiting fetchRetryBackoff and twice
+// as long before each next attempt.
+var fetchRetries = 2
+
+var fetchRetryBackoff = 2 * time.Second
+
+// Headers of channel feed requests, set by -user-agent and
+// -fetch-header.
+var fetchHeader = http.Header{}
+
+// parseHeader parses a "Name: value" header.
+func parseHeader(s string) (string, string, error) {
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("invalid header %q, want Name: value", s)
+	}
+	return http.CanonicalHeaderKey(name), strings.TrimSpace(value), nil
+}
+
+type feedDocument struct {
+	validators youtube.Validators
+	data       []byte
+}
+
+// The last channel feed documents read, with their ETag and Last-Modified
+// headers. Channels are polled with conditional requests and an
+// unchanged feed is answered from here.
+var feedDocuments = struct {
+	mu    sync.Mutex
+	items map[string]feedDocument
+}{items: map[string]feedDocument{}}
+
+func readFeed(ctx context.Context, client *http.Client, channelId string) ([]byte, error) {
+	loopHealth.Beat()
+	c := youtube.Client{HTTPClient: client, BaseURL: feedBaseURL, Retries: fetchRetries, Backoff: fetchRetryBackoff, Logf: log.Printf,
+		Header: fetchHeader}
+	feedDocuments.mu.Lock()
+	prev := feedDocuments.items[channelId]
+	feedDocuments.mu.Unlock()
+	data, validators, err := c.ReadFeedIfChanged(ctx, channelId, prev.validators)
+	if errors.Is(err, youtube.ErrNotModified) {
+		metrics.Add("lfpod_feed_requests_total", labels("result", "not_modified"), 1)
+		return prev.data, nil
+	} else if err != nil {
+		return nil, err
+	}
+	metrics.Add("lfpod_feed_requests_total", labels("result", "changed"), 1)
+	if validators != (youtube.Validators{}) {
+		feedDocuments.mu.Lock()
+		feedDocuments.items[channelId] = feedDocument{validators, data}
+		feedDocuments.mu.Unlock()
+	}
+	return data, nil
+}
+
+// readChannel returns the recent videos of a channel from the YouTube
+// Data API if an API key is set, otherwise from the channel RSS feed.
+func readChannel(ctx context.Context, feed *ConfFeed) (*youtube.Feed, error) {
+	if youtubeAPIKey != "" {
+		return readChannelAPI(ctx, feedClient(feed), feed.ChannelId)
+	}
+	data, err := readFeed(ctx, feedClient(feed), feed.ChannelId)
+	if err != nil {
+		return nil, err
+	}
+	return youtube.ParseFeed(data)
+}
+
+// fetchFeeds reads the channel feeds of feeds with at most concurrency
+// requests in flight. Feeds that failed to be read are nil.
+func fetchFeeds(ctx context.Context, feeds []ConfFeed, concurrency int) []*youtube.Feed {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	data := make([]*youtube.Feed, len(feeds))
+	slots := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range feeds {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(i int) {
+			defer func() { <-slots; wg.Done() }()
+			if feeds[i].Inbox {
+				return
+			}
+			var err error
+			if data[i], err = readChannel(ctx, &feeds[i]); err != nil {
+				log.Print(feeds[i].Name, " ", err)
+				throttled(err)
+			} else {
+				cacheChannel(feeds[i].ChannelId, data[i])
+			}
+			loopStatus.Fetched(&feeds[i], err)
+		}(i)
+	}
+	wg.Wait()
+	return data
+}
+
+// filterFeed returns the entries of a channel feed the feed selects.
+func filterFeed(ytfeed youtube.Feed, feed *ConfFeed) youtube.Feed {
+	if feed.FilterKeywords() == nil && feed.Match == "" {
+		return ytfeed
+	}
+	filtered := youtube.Feed{Title: ytfeed.Title, Author: ytfeed.Author}
+	for _, entry := range ytfeed.Entries {
+		if feed.Selects(entry) {
+			filtered.Entries = append(filtered.Entries, entry)
+		}
+	}
+	return filtered
+}
+
+// downloadAudio downloads the audio of a video, or records a live
+// stream until it ends. It returns the downloaded file.
+func downloadAudio(ctx context.Context, feed *ConfFeed, videoId string, live bool) (string, error) {
+	// Downloads take as long as they need at the rate limit, they are
+	// stopped when they stop making progress.
+	d := &pipeline.Downloader{
+		Path:         downloader,
+		Dir:          downloadDir,
+		Rates:        downloadRates,
+		StallTimeout: downloadStallTimeout,
+		Run: func(cmd *exec.Cmd, videoId string, started func()) ([]byte, error) {
+			return runCommandStarted(cmd, videoId, "download", started)
+		},
+		Logf: log.Printf,
+	}
+	args := []string{"--download-archive", downloadArchiveFileName(feed.ChannelId)}
+	if len(feed.SponsorBlock) > 0 {
+		args = append(args, "--sponsorblock-remove", strings.Join(feed.SponsorBlock, ","))
+	}
+	if feed.MaxFileSize > 0 {
+		args = append(args, "--max-filesize", strconv.FormatInt(feed.MaxFileSize, 10)+"M")
+	}
+	args = append(append(args, downloaderExtraArgs...), feed.DownloaderArgs...)
+	if live {
+		ctx, cancel := context.WithTimeout(ctx, liveRecordMax)
+		defer cancel()
+		return d.Record(ctx, videoId, feedDownloaderArgs(feed, append(liveArgs(feed), args...)...)...)
+	}
+	return d.Download(ctx, videoId, feedDownloaderArgs(feed, append(downloadFormatArgs(feed), args...)...)...)
+}
+
+// AudioFormat is an output container and codec of recoded audio.
+type AudioFormat struct {
+	Ext      string
+	MimeType string
+	Codec    []string
+	Rate     string
+	// Container and codec names as reported by ffprobe.
+	ProbeFormat string
+	ProbeCodec  string
+	// Video formats are published by video feeds only.
+	Video bool
+}
+
+// Opus in Ogg is the default, CAF and AAC play natively on older Apple
+// devices. Video feeds publish MP4 with H.264 and AAC.
+var audioFormats = map[string]AudioFormat{
+	"opus": {"opus", "audio/opus", []string{"-c:a", "libopus"}, "16k", "ogg", "opus", false},
+	"caf":  {"caf", "audio/x-caf", []string{"-c:a", "libopus", "-f", "caf"}, "16k", "caf", "opus", false},
+	"m4a":  {"m4a", "audio/mp4", []string{"-c:a", "aac"}, "32k", "mov,mp4,m4a,3gp,3g2,mj2", "aac", false},
+	"mp4":  {"mp4", "video/mp4", []string{"-c:a", "aac"}, "48k", "mov,mp4,m4a,3gp,3g2,mj2", "aac", true},
+}
+
+// Values of the Opus encoder options of feeds.
+var (
+	opusVBRModes       = []string{"on", "off", "constrained"}
+	opusApplications   = []string{"voip", "audio", "lowdelay"}
+	opusFrameDurations = []float64{2.5, 5, 10, 20, 40, 60, 80, 100, 120}
+)
+
+// opusArgs returns the libopus arguments of the encoder options of a
+// feed, none for other codecs.
+func opusArgs(feed *ConfFeed) []string {
+	if feed.AudioFormat().ProbeCodec != "opus" {
+		return nil
+	}
+	args := []string{}
+	if feed.OpusVBR != "" {
+		args = append(args, "-vbr", feed.OpusVBR)
+	}
+	if feed.OpusApplication != "" {
+		args = append(args, "-application", feed.OpusApplication)
+	}
+	if feed.OpusFrameDuration != 0 {
+		args = append(args, "-frame_duration", strconv.FormatFloat(feed.OpusFrameDuration, 'g', -1, 64))
+	}
+	return args
+}
+
+// SampleRates returns the output sample rates the codec supports.
+func (f AudioFormat) SampleRates() []int {
+	if f.ProbeCodec == "opus" {
+		return []int{8000, 12000, 16000, 24000, 48000}
+	}
+	return []int{8000, 11025, 12000, 16000, 22050, 24000, 32000, 44100, 48000}
+}
+
+// Order in which audio files of a video are looked up.
+var audioFormatNames = []string{"opus", "caf", "m4a", "mp4"}
+
+func getAudioFileName(channelId, videoId, format string) string {
+	return filepath.Join("audio", channelId, videoId+"."+format)
+}
+
+// probeAudio returns the audio format of file according to ffprobe,
+// want if it matches. M4A and MP4 look the same to it.
+func probeAudio(videoId, file string, want AudioFormat) (AudioFormat, error) {
+	cmd := exec.Command(probe, "-v", "error", "-select_streams", "a:0",
+		"-show_entries", "format=format_name:stream=codec_name",
+		"-of", "default=noprint_wrappers=1", file)
+	out, err := runCommand(cmd, videoId, "verify")
+	if err != nil {
+		return AudioFormat{}, fmt.Errorf("%s: %v: %s", file, err, out)
+	}
+	var format, codec string
+	for _, line := range strings.Split(string(out), "\n") {
+		if v, ok := strings.CutPrefix(line, "format_name="); ok {
+			format = strings.TrimSpace(v)
+		} else if v, ok := strings.CutPrefix(line, "codec_name="); ok {
+			codec = strings.TrimSpace(v)
+		}
+	}
+	if want.ProbeFormat == format && want.ProbeCodec == codec {
+		return want, nil
+	}
+	for _, name := range audioFormatNames {
+		f := audioFormats[name]
+		if f.ProbeFormat == format && f.ProbeCodec == codec && !f.Video {
+			return f, nil
+		}
+	}
+	return AudioFormat{}, fmt.Errorf("%s: unknown format %q codec %q", file, format, codec)
+}
+
+// isVideoReady reads the metadata of a video and reports whether it
+// can be downloaded. Upcoming videos and premieres are expected to be
+// available at the returned time, zero if it is unknown. A recent probe
+// is reused while the video cannot have changed, a video not ready is
+// then expected at its next probe, which comes before a premiere far
+// out in case it is moved.
+func isVideoReady(ctx context.Context, feed *ConfFeed, videoId string) (*episodeMetadata, bool, time.Time) {
+	now := time.Now()
+	if state, ok := probes.Fresh(videoId, now); ok {
+		if ready, _ := state.Meta.ready(); ready {
+			return &state.Meta, true, time.Time{}
+		}
+		return &state.Meta, false, state.recheck()
+	}
+	m, err := fetchMetadata(ctx, feed, videoId)
+	if err != nil {
+		log.Print(videoId, " metadata: ", err)
+		throttled(err)
+		return nil, false, time.Time{}
+	}
+	state := probes.Record(feed.ChannelId, videoId, m, now)
+	if ready, _ := m.ready(); ready {
+		return &m, true, time.Time{}
+	}
+	return &m, false, state.recheck()
+}
+
+// Time after the end of a premiere or the start of an upcoming stream
+// until a video is checked again.
+const premiereMargin = 15 * time.Minute
+
+// recodeAudio recodes fileIn to fileOut at the audio bitrate rate, that
+// of the feed format if empty, with a pipeline.Recoder. The file
+// extension is corrected if the produced file turns out to be of
+// another format. Output that fails verification is quarantined and
+// pipeline.ErrBadOutput returned.
+func recodeAudio(ctx context.Context, feed *ConfFeed, entry *youtube.Entry, info *episodeMetadata, fileIn, fileOut, rate string) error {
+	videoId := entry.VideoId
+	format := feed.AudioFormat()
+	if rate == "" {
+		rate = contentRate(feed, videoId, fileIn)
+	}
+	var err error
+	inputs := []string{"-i", fileIn}
+	args := []string{}
+	var trimStart, trimEnd time.Duration
+	if feed.TrimSilence != 0 {
+		if trimStart, trimEnd, err = silenceBounds(videoId, fileIn, feed.TrimSilence); err != nil {
+			log.Printf("%s silence detection failed: %v", videoId, err)
+		}
+	}
+	chapters := episodeChapters(feed, entry, info, fileIn, trimStart, trimEnd)
+	if len(chapters) > 0 {
+		meta, err := os.CreateTemp(filepath.Dir(fileOut), videoId+".tmp-*.ffmetadata")
+		if err != nil {
+			return err
+		}
+		metaFile := meta.Name()
+		defer os.Remove(metaFile)
+		_, err = meta.WriteString(ffmetadata(entry.Title, chapters))
+		if cerr := meta.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		args = append(args, "-map_chapters", strconv.Itoa(len(inputs)/2))
+		inputs = append(inputs, "-i", metaFile)
+	}
+	if artwork := artworkFileName(feed.ChannelId, videoId); fileExists(artwork) {
+		in, out, err := artworkArgs(format, artwork, len(inputs)/2)
+		if err != nil {
+			log.Print(err)
+		}
+		inputs, args = append(inputs, in...), append(args, out...)
+	}
+	if len(inputs) > 2 {
+		if format.Video {
+			args = append([]string{"-map", "0:v:0", "-map", "0:a"}, args...)
+		} else {
+			args = append([]string{"-map", "0:a"}, args...)
+		}
+	}
+	args = append(inputs, args...)
+	chain := []string{}
+	if trimStart != 0 || trimEnd != 0 {
+		chain = append(chain, trimFilter(trimStart, trimEnd))
+	}
+	if feed.AudioFilters != "" {
+		chain = append(chain, feed.AudioFilters)
+	}
+	if feed.Speed > 0 && feed.Speed != 1 {
+		chain = append(chain, atempoFilter(feed.Speed))
+	}
+	if target := feed.LoudnessTarget(); target != 0 {
+		chain = append(chain, loudnormFilter(videoId, fileIn, strings.Join(chain, ","), target))
+	}
+	if len(chain) > 0 {
+		args = append(args, "-af", strings.Join(chain, ","))
+	}
+	args = append(append(args, format.Codec...), "-b:a", rate)
+	args = append(args, videoArgs(feed)...)
+	if feed.Mono {
+		args = append(args, "-ac", "1")
+	}
+	if feed.SampleRate > 0 {
+		args = append(args, "-ar", strconv.Itoa(feed.SampleRate))
+	}
+	args = append(args, opusArgs(feed)...)
+	args = append(args, audioTags(feed, entry, info)...)
+	args = append(args, threadArgs()...)
+	args = append(args, feed.ConverterArgs...)
+	var expected time.Duration
+	if feed.AudioFilters == "" {
+		expected = expectedDuration(feed, fileIn, trimStart, trimEnd)
+	}
+	r := &pipeline.Recoder{
+		Path: converter,
+		Run: func(cmd *exec.Cmd, videoId string) ([]byte, error) {
+			return runCommand(cmd, videoId, "recode")
+		},
+		Verify: func(file string) (string, error) {
+			actual, err := probeAudio(videoId, file, format)
+			if err == nil {
+				err = verifyAudio(videoId, file, expected)
+			}
+			return actual.Ext, err
+		},
+		Quarantine: quarantine,
+		Logf:       log.Printf,
+	}
+	if fileOut, err = r.Recode(ctx, videoId, fileOut, args...); err != nil {
+		return err
+	}
+	if blobDir != "" {
+		if freed, err := storeBlob(fileOut); err != nil {
+			log.Print(err)
+		} else if freed > 0 {
+			log.Printf("%s identical to a stored file, %.1f MB saved", videoId, float64(freed)/1e6)
+		}
+	}
+	if len(chapters) > 0 {
+		if err := writeChapters(chaptersFileName(feed.ChannelId, videoId), chapters); err != nil {
+			log.Print(err)
+		}
+	}
+	return nil
+}
+
+// audioTags returns ffmpeg arguments setting ID3 or Vorbis comment
+// tags, so file-based players show more than video ids.
+func audioTags(feed *ConfFeed, entry *youtube.Entry, info *episodeMetadata) []string {
+	tags := []string{
+		"title=" + entry.Title,
+		"artist=" + feed.Title(),
+		"album=" + feed.Title(),
+	}
+	if t, err := time.Parse(time.RFC3339, entry.Published); err == nil {
+		tags = append(tags, "date="+t.Format("2006-01-02"))
+	}
+	if entry.Media != nil && entry.Media.Description != "" {
+		tags = append(tags, "comment="+entry.Media.Description)
+	}
+	if info != nil && len(info.Tags) > 0 {
+		tags = append(tags, "genre="+strings.Join(info.Tags, ", "))
+	}
+	args := []string{}
+	for _, tag := range tags {
+		args = append(args, "-metadata", tag)
+	}
+	return args
+}
+
+// episodeChapters returns the chapters of the video, those of its
+// metadata or else listed in the description, timed for the recoded
+// file. SponsorBlock cuts make them inaccurate, so feeds removing
+// segments have none.
+func episodeChapters(feed *ConfFeed, entry *youtube.Entry, info *episodeMetadata, fileIn string, trimStart, trimEnd time.Duration) []Chapter {
+	if len(feed.SponsorBlock) > 0 {
+		return nil
+	}
+	var chapters []Chapter
+	if info != nil {
+		chapters = info.chapters()
+	}
+	if chapters == nil {
+		if entry.Media == nil || parseChapters(entry.Media.Description, time.Duration(math.MaxInt64)) == nil {
+			return nil
+		}
+		duration := entry.Duration
+		if duration == 0 {
+			var err error
+			if duration, err = probeDuration(fileIn); err != nil {
+				log.Print(err)
+				return nil
+			}
+		}
+		chapters = parseChapters(entry.Media.Description, duration)
+	}
+	if trimStart != 0 || trimEnd != 0 {
+		chapters = trimChapters(chapters, trimStart, trimEnd)
+	}
+	if feed.Speed > 0 && feed.Speed != 1 {
+		chapters = scaleChapters(chapters, feed.Speed)
+	}
+	return chapters
+}
+
+// atempoFilter returns a filter changing playback speed. A single atempo
+// filter only takes factors from 0.5 to 2 in older ffmpeg versions, so
+// larger changes are chained.
+func atempoFilter(speed float64) string {
+	tempos := []string{}
+	for ; speed > 2; speed /= 2 {
+		tempos = append(tempos, "atempo=2")
+	}
+	for ; speed < 0.5; speed /= 0.5 {
+		tempos = append(tempos, "atempo=0.5")
+	}
+	return strings.Join(append(tempos, "atempo="+strconv.FormatFloat(speed, 'g', 4, 64)), ",")
+}
+
+// UpdateRequest selects what an update pass does.
+type UpdateRequest struct {
+	// Update only the feed of this channel if not empty.
+	ChannelId string
+	// A periodic pass, feeds with a schedule are left to it.
+	Periodic bool
+	// Feeds with a schedule due, updated alone unless Periodic.
+	Feeds []string
+	// Download videos regardless of the feed ignore_older_than window.
+	Backfill bool
+	// Read the metadata of all downloaded episodes from YouTube again.
+	Refresh bool
+	// Called as each video is processed, if not nil.
+	Progress func(p JobProgress)
+}
+
+// Reasons videos of channel feeds are not downloaded.
+const (
+	skipKeywords   = "not matching keywords"
+	skipDownloaded = "already downloaded"
+	skipTooOld     = "older than ignore_older_than"
+	skipPruned     = "deleted to free storage"
+	skipBlocked    = "blocked"
+	skipArchived   = "in download archive"
+	skipWaiting    = "waiting for retry"
+	skipRecording  = "live stream being recorded"
+	skipRemoved    = "feed removed"
+	skipDuplicate  = "selected by another feed"
+)
+
+// Decision is what an update does with a video.
+type Decision struct {
+	Job Job
+	// Reason the video is skipped, empty if it is downloaded.
+	Skip string
+	// Queued retry of the video, nil for new videos.
+	Retry *RetryItem
+}
+
+// selectVideos decides which videos of the fetched channel feeds and
+// of the due retries an update downloads. It has no side effects, so
+// dry runs share it.
+func selectVideos(conf *Conf, req UpdateRequest, feeds []ConfFeed, ytfeeds []*youtube.Feed) []Decision {
+	decisions := []Decision{}
+	queued := map[string]bool{}
+	for i, ytfeed := range ytfeeds {
+		feed := feeds[i]
+		if ytfeed == nil {
+			continue
+		}
+		for _, entry := range ytfeed.Entries {
+			d := Decision{Job: Job{feed, entry, feed.IsPriority(entry)}}
+			switch {
+			case !feed.Selects(entry):
+				d.Skip = skipKeywords
+			case queued[entry.VideoId]:
+				// Feeds of the same channel share its audio files.
+				d.Skip = skipDuplicate
+			case feed.HasAudioFile(entry.VideoId):
+				d.Skip = skipDownloaded
+			case !req.Backfill && feed.IsTooOld(entry):
+				d.Skip = skipTooOld
+			case pruned.Has(entry.VideoId):
+				d.Skip = skipPruned
+			case blocked.Has(entry.VideoId):
+				d.Skip = skipBlocked
+			case downloadArchive.Has(feed.ChannelId, entry.VideoId):
+				d.Skip = skipArchived
+			case retries.Waiting(entry.VideoId):
+				d.Skip = skipWaiting
+			case isRecording(entry.VideoId):
+				d.Skip = skipRecording
+			default:
+				queued[entry.VideoId] = true
+			}
+			decisions = append(decisions, d)
+		}
+	}
+	for _, item := range retries.Due() {
+		item := item
+		feed, ok := conf.GetFeed(item.ChannelId)
+		entry := item.Entry()
+		d := Decision{Job: Job{feed, entry, feed.IsPriority(entry)}, Retry: &item}
+		switch {
+		case !ok:
+			d.Skip = skipRemoved
+		case blocked.Has(item.VideoId):
+			d.Skip = skipBlocked
+		case queued[item.VideoId] || feed.Disabled || isRecording(item.VideoId) || (req.ChannelId != "" && feed.ChannelId != req.ChannelId):
+			continue
+		case feed.HasAudioFile(item.VideoId) || downloadArchive.Has(feed.ChannelId, item.VideoId):
+			d.Skip = skipDownloaded
+		}
+		decisions = append(decisions, d)
+	}
+	return decisions
+}
+
+// UpdateResult counts what an update pass did.
+type UpdateResult struct {
+	New      int
+	Filtered int
+	NotReady int
+	Failed   int
+	Bytes    int64
+	// Channel feeds that could not be fetched.
+	FeedErrors int
+	// Videos deferred to the end of quiet hours.
+	Deferred int
+}
+
+// JobProgress reports a processed video of an update pass.
+type JobProgress struct {
+	Job   Job
+	Done  int
+	Total int
+	Size  int64
+	Err   error
+}
+
+func doUpdate(ctx context.Context, conf *Conf, req UpdateRequest) UpdateResult {
+	result := UpdateResult{}
+	updateRunning.Store(true)
+	defer updateRunning.Store(false)
+	updateHeartbeat.Beat()
+	defer updateHeartbeat.Beat()
+	loopStatus.Planned(time.Time{})
+	if pause.Active() {
+		log.Print("updates paused, skipped")
+		return result
+	}
+	if until, _, ok := throttle.Active(time.Now()); ok {
+		log.Printf("rate limited by YouTube until %s, skipped", until.Format(time.RFC3339))
+		return result
+	}
+	// The backoff starts over after a pass without rate limits.
+	hits := throttle.Hits()
+	defer func() {
+		if throttle.Hits() == hits {
+			throttle.Reset()
+		}
+	}()
+	defer lastUpdate.Beat()
+	cleanupOrphans(ctx, conf)
+	maintainDownloader(ctx)
+	scanIntegrity(ctx, conf)
+	jobs := []Job{}
+	feeds := []ConfFeed{}
+	for _, feed := range conf.AllFeeds() {
+		if req.includes(feed) {
+			feeds = append(feeds, feed)
+		}
+	}
+	ytfeeds := fetchFeeds(ctx, feeds, conf.FetchConcurrency)
+	for i, ytfeed := range ytfeeds {
+		if ytfeed != nil {
+			metrics.Set("lfpod_feed_last_success_timestamp_seconds", labels("feed", feeds[i].Name), float64(time.Now().Unix()))
+			delete(feedFailures, feeds[i].ChannelId)
+		} else {
+			result.FeedErrors++
+			feedFailures[feeds[i].ChannelId]++
+			events.Publish(Event{Type: EventFeedFailed, Feed: feeds[i].Name, ChannelId: feeds[i].ChannelId, Count: feedFailures[feeds[i].ChannelId]})
+		}
+	}
+	for _, d := range selectVideos(conf, req, feeds, ytfeeds) {
+		job := d.Job
+		switch {
+		case d.Retry != nil && d.Skip != "":
+			retries.Done(d.Retry.VideoId)
+		case d.Retry != nil:
+			log.Printf("retrying %s %s, attempt %d", job.Feed.Name, job.Entry.VideoId, d.Retry.Attempts+1)
+			jobs = append(jobs, job)
+		case d.Skip == skipKeywords || d.Skip == skipTooOld:
+			result.Filtered++
+		case d.Skip == skipDownloaded:
+			logStore(episodes.SetStatus(ctx, job.Feed.ChannelId, job.Entry, store.StatusReady))
+			if err := downloadArchive.Add(job.Feed.ChannelId, job.Entry.VideoId); err != nil {
+				log.Print(err)
+			}
+		case d.Skip == "":
+			log.Print("found new video ", job.Feed.Name, " ", job.Entry.VideoId)
+			events.Publish(jobEvent(EventEpisodeDiscovered, job))
+			jobs = append(jobs, job)
+		}
+	}
+	quiet.Resumed(time.Now())
+	if until, ok := quiet.Until(time.Now()); ok && len(jobs) > 0 {
+		kept := deferJobs(jobs, until)
+		result.Deferred, jobs = len(jobs)-len(kept), kept
+	}
+	// Priority videos go first, otherwise feeds keep configuration order.
+	sort.SliceStable(jobs, func(i, j int) bool {
+		return jobs[i].Priority && !jobs[j].Priority
+	})
+	loopStatus.Start(jobs)
+	defer loopStatus.Finish()
+	// Downloads and recodes run in separate worker pools, the network
+	// and the CPU stage, with up to a download per worker waiting for a
+	// recode.
+	queue := make(chan Job)
+	recodeQueue := make(chan *downloadedJob, conf.Workers)
+	var wg, recodeWg sync.WaitGroup
+	var mu sync.Mutex
+	done := 0
+	finished := func(job Job, size int64, err error) {
+		updateHeartbeat.Beat()
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case errors.Is(err, errNotReady):
+			result.NotReady++
+		case err != nil:
+			result.Failed++
+		default:
+			result.New++
+			result.Bytes += size
+		}
+		done++
+		if req.Progress != nil {
+			req.Progress(JobProgress{job, done, len(jobs), size, err})
+		}
+	}
+	for i := 0; i < conf.Workers || i == 0; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				var d *downloadedJob
+				_, err := runJob(ctx, job, func() (size int64, err error) {
+					d, err = downloadJob(ctx, job)
+					return 0, err
+				})
+				if err != nil {
+					finished(job, 0, err)
+					continue
+				}
+				updateHeartbeat.Beat()
+				recodeQueue <- d
+			}
+		}()
+	}
+	recodeWorkers := conf.RecodeWorkers
+	if recodeWorkers <= 0 {
+		recodeWorkers = conf.Workers
+	}
+	for i := 0; i < recodeWorkers || i == 0; i++ {
+		recodeWg.Add(1)
+		go func() {
+			defer recodeWg.Done()
+			for d := range recodeQueue {
+				d := d
+				size, err := runJob(ctx, d.Job, func() (int64, error) { return recodeJob(ctx, d) })
+				finished(d.Job, size, err)
+			}
+		}()
+	}
+	// Priority videos are not throttled, they are queued first.
+	throttled := 0
+	for _, job := range jobs {
+		if !job.Priority {
+			throttled++
+		}
+	}
+	interval := catchUpInterval(throttled, conf.CatchUpRate)
+	if interval > 0 {
+		log.Printf("catching up on %d videos at %d per hour", throttled, conf.CatchUpRate)
+	}
+	queued := 0
+	for _, job := range jobs {
+		if pause.Active() {
+			log.Print(job, " updates paused, skipped")
+			continue
+		}
+		if _, _, ok := throttle.Active(time.Now()); ok {
+			log.Print(job, " rate limited, skipped")
+			continue
+		}
+		if interval > 0 && !job.Priority {
+			if queued > 0 {
+				waitCatchUp(interval)
+			}
+			catchUpProgress(queued, throttled, interval)
+			queued++
+		}
+		queue <- job
+	}
+	if interval > 0 {
+		catchUpProgress(throttled, throttled, interval)
+	}
+	close(queue)
+	wg.Wait()
+	close(recodeQueue)
+	recodeWg.Wait()
+	collectGarbage(ctx, conf)
+	syncStorage(conf)
+	pruneEpisodes(ctx, feeds, ytfeeds)
+	plays.Save()
+	for i := range feeds {
+		if err := writeChannelInfo(ctx, &feeds[i], ytfeeds[i]); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				log.Print(err)
+			}
+			continue
+		}
+		if req.Refresh || metadataRefreshInterval > 0 {
+			maxAge := metadataRefreshInterval
+			if req.Refresh {
+				maxAge = 0
+			}
+			if n, err := refreshMetadata(ctx, &feeds[i], maxAge); err != nil {
+				log.Print(err)
+			} else if n > 0 {
+				feedVersion.Bump()
+			}
+		}
+	}
+	return result
+}
+
+// runJob runs a stage of a job, a panic fails the job rather than the
+// whole process.
+func runJob(ctx context.Context, job Job, stage func() (int64, error)) (size int64, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("%s panic: %v\n%s", job, r, debug.Stack())
+			err = fmt.Errorf("panic: %v", r)
+			logStore(episodes.SetStatus(ctx, job.Feed.ChannelId, job.Entry, store.StatusFailed))
+			retries.Failed(job.Feed.ChannelId, job.Entry, err)
+		}
+	}()
+	return stage()
+}
+
+// Job is a new video to be downloaded and recoded.
+type Job struct {
+	Feed     ConfFeed
+	Entry    *youtube.Entry
+	Priority bool
+}
+
+func (j Job) String() string {
+	return j.Feed.Name + " " + j.Entry.VideoId
+}
+
+var errNotReady = errors.New("not ready")
+
+// downloadedJob is a job whose audio was downloaded, to be recoded.
+type downloadedJob struct {
+	Job
+	// Entry completed with the metadata.
+	entry *youtube.Entry
+	info  *episodeMetadata
+	file  string
+}
+
+// downloadJob checks a video is ready and downloads its audio.
+func downloadJob(ctx context.Context, job Job) (*downloadedJob, error) {
+	feed, entry, desc := job.Feed, job.Entry, job.String()
+	if err := checkFreeSpace(); err != nil {
+		// Not the video's fault, it is downloaded once there is space.
+		log.Print(desc, " skipped: ", err)
+		metrics.Add("lfpod_low_disk_space_skips_total", "", 1)
+		retries.Queue(feed.ChannelId, entry, err.Error())
+		return nil, err
+	}
+	info, ready, available := isVideoReady(ctx, &feed, entry.VideoId)
+	if !ready && feed.RecordLive && info != nil && info.LiveStatus == "is_live" {
+		log.Print(desc, " is live, recording")
+		recordLive(ctx, job, info)
+		return nil, errNotReady
+	}
+	if !ready {
+		log.Print(desc, " not ready, skipped")
+		logStore(episodes.SetStatus(ctx, feed.ChannelId, entry, store.StatusNotReady))
+		if until, _, ok := throttle.Active(time.Now()); ok {
+			// Checked again once the rate limit is over, the video may
+			// well be ready.
+			retries.Schedule(feed.ChannelId, entry, until)
+		} else if available.After(time.Now()) {
+			retries.Schedule(feed.ChannelId, entry, available)
+		} else {
+			retries.Failed(feed.ChannelId, entry, errNotReady)
+		}
+		return nil, errNotReady
+	}
+	return fetchJob(ctx, job, info, false)
+}
+
+// fetchJob downloads the audio of a ready video, or records a live
+// stream until it ends.
+func fetchJob(ctx context.Context, job Job, info *episodeMetadata, live bool) (*downloadedJob, error) {
+	feed, desc := job.Feed, job.String()
+	entry := info.complete(job.Entry)
+	if live {
+		log.Print("recording ", desc)
+	} else {
+		log.Print("downloading ", desc)
+	}
+	retries.Started(feed.ChannelId, entry)
+	events.Publish(jobEvent(EventDownloadStarted, job))
+	logStore(episodes.SetStatus(ctx, feed.ChannelId, entry, store.StatusDownloading))
+	fileDown, err := downloadAudio(ctx, &feed, entry.VideoId, live)
+	if err != nil {
+		log.Print(desc, " download error, skipped")
+		e := jobEvent(EventDownloadFailed, job)
+		e.Error = err.Error()
+		events.Publish(e)
+		logStore(episodes.SetStatus(ctx, feed.ChannelId, entry, store.StatusFailed))
+		if errors.Is(err, pipeline.ErrPermanent) {
+			retries.Done(entry.VideoId)
+			blocked.Add(feed.ChannelId, entry, err.Error())
+		} else if throttled(err) {
+			// Not the video's fault, it does not count as an attempt.
+			until, _, _ := throttle.Active(time.Now())
+			retries.Schedule(feed.ChannelId, entry, until)
+		} else {
+			retries.Failed(feed.ChannelId, entry, err)
+		}
+		return nil, err
+	}
+	log.Print(desc, " downloaded")
+	return &downloadedJob{job, entry, info, fileDown}, nil
+}
+
+// recodeJob recodes the downloaded audio of a job and publishes the
+// episode, it returns the size of the audio file.
+func recodeJob(ctx context.Context, d *downloadedJob) (int64, error) {
+	job, entry, info, fileDown := d.Job, d.entry, d.info, d.file
+	feed, desc := job.Feed, job.String()
+	fileDst := feed.AudioFileName(entry.VideoId)
+	log.Print("recoding ", desc)
+	logStore(episodes.SetStatus(ctx, feed.ChannelId, entry, store.StatusRecoding))
+	if _, err := downloadArtwork(&feed, entry); err != nil {
+		log.Print(desc, " artwork: ", err)
+	}
+	start := time.Now()
+	err := recodeAudio(ctx, &feed, entry, info, fileDown, fileDst, "")
+	if errors.Is(err, pipeline.ErrBadOutput) {
+		log.Print(desc, " ", err, ", recoding again")
+		err = recodeAudio(ctx, &feed, entry, info, fileDown, fileDst, "")
+	}
+	if err == nil && len(feed.AlternateBitrates) > 0 {
+		recodeAlternates(ctx, &feed, entry, info, fileDown)
+	}
+	metrics.Observe("lfpod_recode_duration_seconds", labels("feed", feed.Name), time.Since(start).Seconds())
+	if err == nil && feed.KeepOriginal {
+		if err := keepOriginal(&feed, entry.VideoId, fileDown); err != nil {
+			log.Print(desc, " original: ", err)
+		}
+	}
+	os.Remove(fileDown)
+	if err != nil {
+		log.Print(desc, " recode error, skipped: ", err)
+		e := jobEvent(EventDownloadFailed, job)
+		e.Error = err.Error()
+		events.Publish(e)
+		logStore(episodes.SetStatus(ctx, feed.ChannelId, entry, store.StatusFailed))
+		// yt-dlp archived the download, the retry has to download again.
+		if err := downloadArchive.Remove(feed.ChannelId, entry.VideoId); err != nil {
+			log.Print(err)
+		}
+		retries.Failed(feed.ChannelId, entry, err)
+		return 0, err
+	}
+	if err := downloadArchive.Add(feed.ChannelId, entry.VideoId); err != nil {
+		log.Print(err)
+	}
+	log.Print(desc, " recoded")
+	logStore(episodes.SetStatus(ctx, feed.ChannelId, entry, store.StatusReady))
+	retries.Done(entry.VideoId)
+	probes.Forget(entry.VideoId)
+	feedVersion.Bump()
+	var size int64
+	if name, _, ok := feed.FindAudioFile(entry.VideoId); ok {
+		if info, err := os.Stat(name); err == nil {
+			size = info.Size()
+		}
+		duration, err := probeDuration(name)
+		if err != nil {
+			log.Print(desc, " ", err)
+		}
+		logStore(episodes.SetFile(ctx, entry.VideoId, size, duration))
+		sidecar := newSidecar(entry, info, duration)
+		if feed.SplitMinutes > 0 && duration > time.Duration(feed.SplitMinutes)*time.Minute {
+			if sidecar.Parts, err = splitAudio(&feed, entry.VideoId, name); err != nil {
+				log.Print(desc, " split: ", err)
+			} else if len(sidecar.Parts) > 0 {
+				log.Printf("%s split into %d parts", desc, len(sidecar.Parts))
+			}
+		}
+		if err := writeSidecar(feed.ChannelId, sidecar); err != nil {
+			log.Print(desc, " sidecar: ", err)
+		}
+		if len(sidecar.Parts) > 0 {
+			feedVersion.Bump()
+		}
+		if feed.Transcribe {
+			log.Print("transcribing ", desc)
+			if err := transcribeAudio(&feed, entry.VideoId, name); err != nil {
+				log.Print(desc, " transcript: ", err)
+			} else {
+				log.Print(desc, " transcribed")
+				feedVersion.Bump()
+			}
+		}
+		if feed.Captions {
+			if languages, err := downloadCaptions(ctx, &feed, entry.VideoId); err != nil {
+				log.Print(desc, " captions: ", err)
+			} else if len(languages) > 0 {
+				log.Printf("%s captions in %s", desc, strings.Join(languages, ", "))
+				feedVersion.Bump()
+			}
+		}
+		// Failed uploads are retried after the update.
+		if err := storage.Store(name); err != nil {
+			log.Print(desc, " upload: ", err)
+		}
+	}
+	e := jobEvent(EventDownloadFinished, job)
+	e.Size = size
+	events.Publish(e)
+	return size, nil
+}
+
+var updateRunning atomic.Bool
+
+// Failed updates in a row of channel feeds, by channel id.
+var feedFailures = map[string]int{}
+
+var updateTrigger = make(chan UpdateRequest, 1)
+
+// triggerUpdate wakes the update loop, it returns false if an update
+// has already been requested.
+func triggerUpdate(req UpdateRequest) bool {
+	select {
+	case updateTrigger <- req:
+		return true
+	default:
+		return false
+	}
+}
+
+func updateFeeds(ctx context.Context, conf *Conf) {
+	req := UpdateRequest{Periodic: true}
+	feedSchedules.Due(conf.AllFeeds(), time.Now())
+	for {
+		loopHealth.Beat()
+		doUpdate(ctx, conf, req)
+		loopHealth.Wait()
+		next := time.Now().Add(updateInterval + randomJitter(updateJitter))
+		loopStatus.Planned(next)
+		req = waitUpdate(conf, next)
+	}
+}
+
+// audioFiles serves the files of the audio directory, without
+// directory listings.
+var audioFiles = countPlays(redirectStored(http.FileServer(server.FilesOnly{FileSystem: http.Dir("audio")})))
+
+var downloader = "yt-dlp"
+var converter = "ffmpeg"
+var probe = "ffprobe"
+
+func checkExecs(execs ...*string) {
+	for _, name := range execs {
+		if _, err := exec.LookPath(*name); err != nil {
+			if errors.Is(err, exec.ErrNotFound) {
+				log.Fatalf("%s executable not found", *name)
+			} else if errors.Is(err, exec.ErrDot) {
+				log.Printf("%s executable found in current directory", *name)
+				*name = "./" + *name
+			} else {
+				log.Fatal(err)
+			}
+		}
+	}
+}
+
+type ConfFeed struct {
+	Name      string   `json:"name"`
+	ChannelId string   `json:"channel_id"`
+	Keywords  []string `json:"keywords,omitempty"`
+	// Name of the feed in feed and audio URLs instead of the channel
+	// id, e.g. /feed/veritasium.
+	Slug string `json:"slug,omitempty"`
+	// Stop polling the channel and retrying its videos, its episodes
+	// are still served.
+	Disabled bool `json:"disabled,omitempty"`
+	// The feed publishes the videos of the -inbox file instead of a
+	// channel, channel_id only names its audio directory.
+	Inbox bool `json:"inbox,omitempty"`
+	// Skip videos published more than this many days ago unless
+	// backfilling, 0 for no limit.
+	IgnoreOlderThan int `json:"ignore_older_than,omitempty"`
+	// Retention limits: number of newest episodes kept, age in days
+	// and storage in MB, the global limits if 0.
+	KeepEpisodes int   `json:"keep_episodes,omitempty"`
+	MaxAge       int   `json:"max_age,omitempty"`
+	MaxStorage   int64 `json:"max_storage,omitempty"`
+	// Videos whose download is larger than this many MB are skipped
+	// and blocked, 0 for no limit.
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+	// Videos matching these keywords are downloaded before all others.
+	PriorityKeywords []string `json:"priority_keywords,omitempty"`
+	// Boolean expression of words selecting videos together with
+	// keywords, e.g. interview AND NOT shorts.
+	Match string `json:"match,omitempty"`
+	// Keywords and the match expression are searched in the video
+	// description too, not only the title.
+	MatchDescription bool `json:"match_description,omitempty"`
+	// Output format, one of audioFormats, opus if empty.
+	Format string `json:"format,omitempty"`
+	// Media published, audio, the default, or video, small MP4 videos
+	// at most VideoHeightMax pixels high, 360 if 0.
+	Media          string `json:"media,omitempty"`
+	VideoHeightMax int    `json:"video_height,omitempty"`
+	// Downmix to mono and resample to this many Hz when recoding, the
+	// channels and rate of the source are kept if false and 0.
+	Mono       bool `json:"mono,omitempty"`
+	SampleRate int  `json:"sample_rate,omitempty"`
+	// Also publish episodes at these bitrates in kbit/s, e.g. [64], as
+	// podcast:alternateEnclosure, the format bitrate stays the default.
+	AlternateBitrates []int `json:"alternate_bitrates,omitempty"`
+	// Recode episodes found to be music rather than speech at this
+	// bitrate in kbit/s, e.g. 64, the format bitrate is for speech.
+	MusicBitrate int `json:"music_bitrate,omitempty"`
+	// Record live streams of the channel as they run, for channels that
+	// do not keep them as videos.
+	RecordLive bool `json:"record_live,omitempty"`
+	// Keep the download as it was before recoding, served from
+	// /original/<feed>/<video id>.
+	KeepOriginal bool `json:"keep_original,omitempty"`
+	// Publish episodes longer than this many minutes as parts of this
+	// length, 0 to publish them whole.
+	SplitMinutes int `json:"split_minutes,omitempty"`
+	// iTunes seasons: "year" numbers them by year of publication,
+	// "dates" from the season_starts, YYYY-MM-DD, of seasons 1, 2, ...
+	Seasons      string   `json:"seasons,omitempty"`
+	SeasonStarts []string `json:"season_starts,omitempty"`
+	// Present the feed as a serial show, listed oldest first in podcast
+	// apps, rather than episodic.
+	Serial bool `json:"serial,omitempty"`
+	// Apple Podcasts category, e.g. "News" or "News/Daily News" with a
+	// subcategory, explicit content flag and owner of the feed, for
+	// podcast directories.
+	Category   string `json:"category,omitempty"`
+	Explicit   bool   `json:"explicit,omitempty"`
+	OwnerName  string `json:"owner_name,omitempty"`
+	OwnerEmail string `json:"owner_email,omitempty"`
+	// Podcast title and author, the channel title and author from
+	// YouTube if empty.
+	PodcastTitle  string `json:"title,omitempty"`
+	PodcastAuthor string `json:"author,omitempty"`
+	// What the metadata refresh does with episodes whose video was
+	// deleted or made private on YouTube: keep, the default, serves them
+	// with a note in the description, delete deletes them.
+	OnRemoved string `json:"on_removed,omitempty"`
+	// Opus encoder tuning: variable bitrate on, off or constrained,
+	// application voip, audio or lowdelay, and frame duration in
+	// milliseconds, the libopus defaults if empty.
+	OpusVBR           string  `json:"opus_vbr,omitempty"`
+	OpusApplication   string  `json:"opus_application,omitempty"`
+	OpusFrameDuration float64 `json:"opus_frame_duration,omitempty"`
+	// yt-dlp cookies file or browser, the global ones if both empty.
+	Cookies            string `json:"cookies,omitempty"`
+	CookiesFromBrowser string `json:"cookies_from_browser,omitempty"`
+	// HTTP or SOCKS proxy for this channel, the global one if empty.
+	Proxy string `json:"proxy,omitempty"`
+	// yt-dlp geo restriction bypass: the two-letter country code of
+	// the faked X-Forwarded-For header, and a proxy only verifying the
+	// location of region-locked videos.
+	GeoCountry           string `json:"geo_country,omitempty"`
+	GeoVerificationProxy string `json:"geo_verification_proxy,omitempty"`
+	// SponsorBlock categories cut from the audio.
+	SponsorBlock []string `json:"sponsorblock,omitempty"`
+	// Extra yt-dlp arguments for downloads, after the global ones.
+	DownloaderArgs []string `json:"downloader_args,omitempty"`
+	// Video ids kept by the pinned garbage collection strategy.
+	Pinned []string `json:"pinned,omitempty"`
+	// ffmpeg filter graph applied when recoding, e.g.
+	// "highpass=f=100,dynaudnorm", and extra ffmpeg output arguments.
+	AudioFilters  string   `json:"audio_filters,omitempty"`
+	ConverterArgs []string `json:"converter_args,omitempty"`
+	// Playback speed factor, e.g. 1.5, episodes are pre-accelerated.
+	Speed float64 `json:"speed,omitempty"`
+	// Target loudness in LUFS, e.g. -16, the global one if 0.
+	Loudness float64 `json:"loudness,omitempty"`
+	// Transcribe episodes with whisper.cpp, in this language code if
+	// not empty, otherwise the language is detected.
+	Transcribe         bool   `json:"transcribe,omitempty"`
+	TranscriptLanguage string `json:"transcript_language,omitempty"`
+	// Publish the captions of videos, the channel's own or else the
+	// automatic ones, in these yt-dlp language codes, en if empty.
+	Captions     bool     `json:"captions,omitempty"`
+	CaptionLangs []string `json:"caption_languages,omitempty"`
+	// Trim leading and trailing silence below this many dB, e.g. -50,
+	// 0 to keep it.
+	TrimSilence float64 `json:"trim_silence,omitempty"`
+	// Share of the combined feed with -feed-order interleave, 1 if 0.
+	Weight int `json:"weight,omitempty"`
+	// Cron expression of the polls of the channel, e.g. "0 6-22 * * *",
+	// instead of the update interval, and the random delay of each poll
+	// in minutes, -jitter if 0.
+	Schedule string `json:"schedule,omitempty"`
+	Jitter   int    `json:"jitter,omitempty"`
+}
+
+// SponsorBlock segment categories that yt-dlp can remove.
+var sponsorBlockCategories = []string{"sponsor", "intro", "outro", "selfpromo",
+	"preview", "filler", "interaction", "music_offtopic", "all"}
+
+func (f *ConfFeed) AudioFormat() AudioFormat {
+	if f.IsVideo() {
+		return audioFormats["mp4"]
+	}
+	if format, ok := audioFormats[f.Format]; ok {
+		return format
+	}
+	return audioFormats["opus"]
+}
+
+func (f *ConfFeed) AudioFileName(videoId string) string {
+	return getAudioFileName(f.ChannelId, videoId, f.AudioFormat().Ext)
+}
+
+// FindAudioFile returns the existing audio file of a video and its
+// format. Files of the feed format are preferred, but a file may have
+// another format if its extension was corrected or the feed format
+// changed.
+func (f *ConfFeed) FindAudioFile(videoId string) (string, AudioFormat, bool) {
+	want := f.AudioFormat()
+	if name := f.AudioFileName(videoId); fileExists(name) {
+		return name, want, true
+	}
+	for _, ext := range audioFormatNames {
+		name := getAudioFileName(f.ChannelId, videoId, ext)
+		if ext != want.Ext && fileExists(name) {
+			return name, audioFormats[ext], true
+		}
+	}
+	return "", AudioFormat{}, false
+}
+
+func (f *ConfFeed) HasAudioFile(videoId string) bool {
+	_, _, ok := f.FindAudioFile(videoId)
+	return ok
+}
+
+func fileExists(name string) bool {
+	_, err := os.Stat(name)
+	return err == nil
+}
+
+// Title returns the feed name, or the channel id if it has none.
+func (f *ConfFeed) Title() string {
+	if f.Name != "" {
+		return f.Name
+	}
+	return f.ChannelId
+}
+
+// LoudnessTarget returns the target loudness in LUFS, 0 for none.
+func (f *ConfFeed) LoudnessTarget() float64 {
+	if f.Loudness != 0 {
+		return f.Loudness
+	}
+	return loudnessTarget
+}
+
+// FilterKeywords returns keywords selecting videos of the feed, nil
+// for all videos.
+func (f *ConfFeed) FilterKeywords() []string {
+	if f.Keywords == nil {
+		return nil
+	}
+	return append(append([]string{}, f.Keywords...), f.PriorityKeywords...)
+}
+
+func (f *ConfFeed) IsTooOld(entry *youtube.Entry) bool {
+	if f.IgnoreOlderThan <= 0 {
+		return false
+	}
+	published, err := time.Parse(time.RFC3339, entry.Published)
+	if err != nil {
+		return false
+	}
+	return time.Since(published) > time.Duration(f.IgnoreOlderThan)*24*time.Hour
+}
+
+type ConfFeeds struct {
+	// Directory audio, state and temporary files are kept in, relative
+	// to the configuration file.
+	DataDir string     `json:"data_dir,omitempty"`
+	Feeds   []ConfFeed `json:"ytfeeds"`
+	Notify  []Notifier `json:"notify,omitempty"`
+	// Feed sets of people sharing the server, each with its own feed
+	// URL.
+	Profiles []Profile `json:"profiles,omitempty"`
+	// Addresses the server listens on, used unless -listen is given.
+	Listen []string `json:"listen,omitempty"`
+	// External commands, unless set by flags or the environment.
+	Tools *ConfTools `json:"tools,omitempty"`
+	// Cross-origin access of web pages, none if nil.
+	CORS *ConfCORS `json:"cors,omitempty"`
+}
+
+type Conf struct {
+	ConfFeeds
+	ConfFeedsFile string
+	ServerAddress string
+	BasePath      string
+	FeedStats     bool
+	Workers       int
+	// Number of videos recoded in parallel, Workers if 0.
+	RecodeWorkers int
+	// Number of channel feeds fetched concurrently.
+	FetchConcurrency int
+	// Maximum number of items in a feed, older ones go to yearly
+	// archive feeds. All downloaded episodes are in the feed if 0.
+	FeedMaxItems int
+	// Number of items per page of paged feeds, 0 for no paging.
+	FeedPageSize int
+	// Order of the combined feed, date or interleave.
+	FeedOrder string
+	// Update passes with more new videos than this are spread at this
+	// many videos per hour, 0 for no limit.
+	CatchUpRate int
+	// Storage limit in bytes, 0 for no limit, and the strategy
+	// selecting files deleted to keep it.
+	MaxStorage int64
+	GCStrategy string
+	// Default retention limits of feeds: number of newest episodes and
+	// age in days, 0 for no limit.
+	KeepEpisodes int
+	MaxAge       int
+	mu           sync.RWMutex
+}
+
+// URL returns the public URL of a server path. The server address may
+// include a scheme, http is assumed otherwise.
+func (c *Conf) URL(elem ...string) string {
+	base := c.ServerAddress
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	u, err := url.JoinPath(base, append([]string{c.BasePath}, elem...)...)
+	if err != nil {
+		log.Print(err)
+	}
+	return u
+}
+
+// GetFeeds returns the feeds of the public views, without those of the
+// profiles, which require the profile token or secret.
+func (c *Conf) GetFeeds() []ConfFeed {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]ConfFeed(nil), c.Feeds...)
+}
+
+// AllFeeds returns the feeds, those of the profiles included, for the
+// update loop and management.
+func (c *Conf) AllFeeds() []ConfFeed {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	feeds := append([]ConfFeed(nil), c.Feeds...)
+	for _, p := range c.Profiles {
+		feeds = append(feeds, p.Feeds...)
+	}
+	return feeds
+}
+
+// FindFeed returns a feed by channel id or name.
+func (c *Conf) FindFeed(name string) (ConfFeed, bool) {
+	return findFeed(c.AllFeeds(), name)
+}
+
+func findFeed(feeds []ConfFeed, name string) (ConfFeed, bool) {
+	for _, feed := range feeds {
+		if feed.ChannelId == name || feed.Name == name || feed.Slug != "" && feed.Slug == name {
+			return feed, true
+		}
+	}
+	return ConfFeed{}, false
+}
+
+func (c *Conf) GetFeed(channelId string) (ConfFeed, bool) {
+	for _, feed := range c.AllFeeds() {
+		if feed.ChannelId == channelId {
+			return feed, true
+		}
+	}
+	return ConfFeed{}, false
+}
+
+// PutFeed adds a feed or replaces the ones with the same channel id and
+// saves the configuration file. Feeds of a profile are replaced in the
+// profile, new feeds are added to the top-level feeds.
+func (c *Conf) PutFeed(feed ConfFeed) error {
+	if err := os.MkdirAll(filepath.Join("audio", feed.ChannelId), 0750); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	confFeeds := c.cloneFeeds()
+	feeds := confFeeds.feedList(feed.ChannelId)
+	if feeds == nil {
+		confFeeds.Feeds = append(confFeeds.Feeds, feed)
+		return c.save(confFeeds)
+	}
+	for i := range *feeds {
+		if (*feeds)[i].ChannelId == feed.ChannelId {
+			(*feeds)[i] = feed
+		}
+	}
+	return c.save(confFeeds)
+}
+
+// AddFeeds adds feeds not configured yet, at the top level or in a
+// profile, and saves the configuration file once. It returns the number
+// of feeds added.
+func (c *Conf) AddFeeds(feeds []ConfFeed) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	confFeeds := c.cloneFeeds()
+	added := 0
+	for _, feed := range feeds {
+		if confFeeds.feedList(feed.ChannelId) != nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Join("audio", feed.ChannelId), 0750); err != nil {
+			return 0, err
+		}
+		confFeeds.Feeds = append(confFeeds.Feeds, feed)
+		added++
+	}
+	if added == 0 {
+		return 0, nil
+	}
+	return added, c.save(confFeeds)
+}
+
+// DeleteFeed removes a feed from the configuration, from the profile it
+// belongs to for profile feeds. Downloaded audio is kept.
+func (c *Conf) DeleteFeed(channelId string) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	confFeeds := c.cloneFeeds()
+	feeds := confFeeds.feedList(channelId)
+	if feeds == nil {
+		return false, nil
+	}
+	kept := []ConfFeed{}
+	for _, feed := range *feeds {
+		if feed.ChannelId != channelId {
+			kept = append(kept, feed)
+		}
+	}
+	*feeds = kept
+	return true, c.save(confFeeds)
+}
+
+// cloneFeeds returns a copy of the configuration whose feed lists can be
+// changed without affecting c. The caller holds c.mu.
+func (c *Conf) cloneFeeds() ConfFeeds {
+	confFeeds := c.ConfFeeds
+	confFeeds.Feeds = append([]ConfFeed(nil), c.Feeds...)
+	confFeeds.Profiles = append([]Profile(nil), c.Profiles...)
+	for i := range confFeeds.Profiles {
+		confFeeds.Profiles[i].Feeds = append([]ConfFeed(nil), c.Profiles[i].Feeds...)
+	}
+	return confFeeds
+}
+
+// feedList returns the feed list holding a channel, the top-level feeds
+// before those of the profiles in order, as GetFeed looks them up. It
+// returns nil if no feed has the channel.
+func (c *ConfFeeds) feedList(channelId string) *[]ConfFeed {
+	lists := []*[]ConfFeed{&c.Feeds}
+	for i := range c.Profiles {
+		lists = append(lists, &c.Profiles[i].Feeds)
+	}
+	for _, feeds := range lists {
+		for _, feed := range *feeds {
+			if feed.ChannelId == channelId {
+				return feeds
+			}
+		}
+	}
+	return nil
+}
+
+func (c *Conf) save(confFeeds ConfFeeds) error {
+	if err := writeConfFeeds(c.ConfFeedsFile, confFeeds); err != nil {
+		return err
+	}
+	c.ConfFeeds = confFeeds
+	feedVersion.Bump()
+	return nil
+}
+
+func readConfFeeds(fileName string) ConfFeeds {
+	freader, err := os.Open(fileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer freader.Close()
+	conf := ConfFeeds{}
+	if err := json.NewDecoder(freader).Decode(&conf); err != nil {
+		log.Fatal("error while parsing ", fileName, ": ", err)
+	}
+	return conf
+}
+
+func writeConfFeeds(fileName string, conf ConfFeeds) error {
+	data, err := json.MarshalIndent(conf, "", "    ")
+	if err != nil {
+		return err
+	}
+	fileTmp := fileName + ".tmp"
+	if err := os.WriteFile(fileTmp, append(data, '\n'), 0640); err != nil {
+		return err
+	}
+	return os.Rename(fileTmp, fileName)
+}
+
+// flagSet reports whether a flag was given on the command line.
+func flagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func main() {
+	confFeedsFile := flag.String("f", "ytfeeds.json", "YouTube feeds configuration file.")
+	dataDir := flag.String("d", "", "Data directory audio, state and temporary files are kept in, the data_dir of the configuration or the working directory if empty. Other relative file names are relative to it.")
+	serverAddress := flag.String("s", "127.0.0.1:8080", "Server address.")
+	listenAddress := flag.String("listen", ":8080", "Listen addresses, comma-separated host:port, [IPv6]:port, unix:/path/to/socket or tailnet:hostname, the configuration file listen if not set.")
+	flag.StringVar(&tailnetStateDir, "tailnet-state", tailnetStateDir, "Directory of the node keys of tailnet:hostname listeners, in builds with -tags tsnet.")
+	socketMode := flag.Uint("socket-mode", 0660, "File mode of the unix socket.")
+	basePath := flag.String("base-path", "", "Path prefix the server is mounted under, e.g. /lfpod behind a reverse proxy.")
+	outAddress := flag.String("source-address", "", "Local IP address or interface name for outbound connections.")
+	flag.DurationVar(&fetchTimeout, "fetch-timeout", fetchTimeout, "Timeout of channel feed requests, raise it on slow connections.")
+	flag.DurationVar(&downloadStallTimeout, "download-stall-timeout", downloadStallTimeout, "Stop downloads whose files have not grown for this long, the next attempt continues them.")
+	flag.IntVar(&fetchRetries, "fetch-retries", fetchRetries, "Retries of channel feed requests failed with network or server errors, with exponential backoff.")
+	userAgent := flag.String("user-agent", "", "User-Agent of channel feed requests, Go's default if empty.")
+	flag.Func("fetch-header", "Header of channel feed requests, \"Name: value\", may be repeated.", func(s string) error {
+		name, value, err := parseHeader(s)
+		if err == nil {
+			fetchHeader.Add(name, value)
+		}
+		return err
+	})
+	flag.StringVar(&proxyURL, "proxy", "", "HTTP or SOCKS proxy for feed fetching and yt-dlp, e.g. socks5://127.0.0.1:1080.")
+	flag.BoolVar(&serveOnly, "serve-only", false, "Only serve feeds and audio, leaving updates to a separate lfpod update process.")
+	dnsServer := flag.String("dns", "", "DNS server for outbound connections, https://host/dns-query (DoH) or tls://host (DoT).")
+	forceIPv4 := flag.Bool("force-ipv4", false, "Make all outbound connections via IPv4.")
+	forceIPv6 := flag.Bool("force-ipv6", false, "Make all outbound connections via IPv6.")
+	flag.StringVar(&apiToken, "api-token", os.Getenv("LFPOD_API_TOKEN"), "Token for the management API and admin UI, defaults to $LFPOD_API_TOKEN.")
+	flag.StringVar(&adminToken, "admin-token", os.Getenv("LFPOD_ADMIN_TOKEN"), "Token required instead of -api-token for changes through the management API and admin UI, defaults to $LFPOD_ADMIN_TOKEN.")
+	accessLogFile := flag.String("access-log", "", "Write HTTP access log in combined format to this file instead of the standard log.")
+	workers := flag.Int("workers", 1, "Number of videos downloaded and recoded in parallel.")
+	recodeWorkers := flag.Int("recode-workers", 0, "Number of videos recoded in parallel, -workers if 0.")
+	flag.DurationVar(&integrityScanInterval, "integrity-scan", 0, "Decode published audio files again this often, e.g. 168h, downloading corrupted ones again, 0 to never.")
+	flag.DurationVar(&metadataRefreshInterval, "metadata-refresh", 0, "Read titles, descriptions and thumbnails of downloaded episodes from YouTube again this often, e.g. 24h, 0 to refresh only on request.")
+	eventLog := flag.String("event-log", "", "Append pipeline and server events to this file as JSON lines.")
+	limitRate := flag.String("limit-rate", "", "Maximum download rate of each video in bytes per second, e.g. 500K or 2M.")
+	maxDownloadRate := flag.String("max-download-rate", "", "Maximum download rate of all downloads together, split evenly between the workers, e.g. 4M.")
+	catchUpRate := flag.Int("catch-up-rate", 0, "Spread update passes with more new videos than this at this many videos per hour, 0 for no limit.")
+	fetchConcurrency := flag.Int("fetch-concurrency", 8, "Number of channel feeds fetched in parallel.")
+	maxProcs := flag.Int("max-procs", 4, "Maximum number of concurrently running yt-dlp/ffmpeg/ffprobe processes, 0 for no limit.")
+	flag.IntVar(&procNice, "nice", 0, "Nice value of yt-dlp/ffmpeg/ffprobe processes, 1 to 19 for a lower CPU priority, 0 to keep lfpod's.")
+	ioPriority := flag.String("ionice", "", "I/O priority of yt-dlp/ffmpeg/ffprobe processes: idle, best-effort or best-effort:<level 0-7>, Linux only.")
+	flag.IntVar(&converterThreads, "ffmpeg-threads", 0, "Threads of each ffmpeg recode, 0 to let ffmpeg decide.")
+	rateLimit := flag.Float64("rate-limit", 0, "Maximum HTTP requests per second per client IP, 0 for no limit.")
+	rateBurst := flag.Int("rate-burst", 0, "Burst of HTTP requests allowed per client IP above the rate limit.")
+	maxConns := flag.Int("max-conns", 0, "Maximum number of concurrently served HTTP requests, 0 for no limit.")
+	allowFrom := flag.String("allow-from", "", "Comma separated CIDRs or addresses of the only clients served, e.g. 192.168.1.0/24,10.8.0.0/24, all if empty.")
+	trustedProxyList := flag.String("trusted-proxies", "", "Comma separated CIDRs or addresses of reverse proxies whose X-Forwarded-For header names the client.")
+	feedMaxItems := flag.Int("feed-max-items", 0, "Maximum number of episodes in a feed, older ones go to yearly archive feeds. 0 puts all downloaded episodes in the feed.")
+	feedPageSize := flag.Int("feed-page-size", 0, "Publish all downloaded episodes in pages of this many items linked with rel=next, 0 for no paging.")
+	feedOrder := flag.String("feed-order", "date", "Order of the combined feed: date, or interleave to alternate channels by their weights.")
+	feedStats := flag.Bool("feed-stats", false, "Add episode count, archive size and last update time to the feed description.")
+	flag.StringVar(&youtubeAPIKey, "youtube-api-key", os.Getenv("LFPOD_YOUTUBE_API_KEY"), "YouTube Data API key, channels are read from the API instead of RSS feeds if set. Defaults to $LFPOD_YOUTUBE_API_KEY.")
+	flag.IntVar(&youtubeAPIMaxItems, "youtube-api-max-items", youtubeAPIMaxItems, "Number of most recent uploads read per channel from the YouTube Data API.")
+	flag.StringVar(&blobDir, "blob-dir", "", "Store audio by content hash in this directory, hard linked from the audio directory, so identical files share space. Must be on the same file system.")
+	storageLocation := flag.String("storage", "", "Upload audio files to s3://bucket/prefix after recoding, only hollow files of the same size are kept in the audio directory.")
+	s3Opts := s3Options{AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"), SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY")}
+	flag.StringVar(&s3Opts.Endpoint, "s3-endpoint", "https://s3.amazonaws.com", "S3 compatible endpoint URL, credentials are read from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY.")
+	flag.StringVar(&s3Opts.Region, "s3-region", "us-east-1", "S3 region requests are signed for.")
+	flag.StringVar(&s3Opts.PublicURL, "s3-public-url", "", "Base URL of a public bucket enclosures link to, presigned URLs if empty.")
+	flag.DurationVar(&s3Opts.URLExpiry, "s3-url-expiry", 24*time.Hour, "Validity of presigned enclosure URLs, at most 168h.")
+	shareSecret := flag.String("share-secret", os.Getenv("LFPOD_SHARE_SECRET"), "Key signing episode share links, random if empty so links expire on restart.")
+	flag.StringVar(&cookiesFile, "cookies", "", "Netscape cookies file passed to yt-dlp, for members-only and age-restricted videos.")
+	flag.StringVar(&cookiesFromBrowser, "cookies-from-browser", "", "Browser to load yt-dlp cookies from, e.g. firefox.")
+	flag.DurationVar(&downloaderUpdateInterval, "downloader-update", 0, "Update yt-dlp this often, e.g. 24h, 0 to never.")
+	flag.StringVar(&downloaderUpdateMode, "downloader-update-mode", downloaderUpdateMode, "How to update yt-dlp: update runs yt-dlp -U, check only logs when a newer release is out.")
+	downloaderArgs := flag.String("downloader-args", "", "Extra space separated yt-dlp arguments for downloads.")
+	maxStorage := flag.Int64("max-storage", 0, "Maximum archive size in MB, 0 for no limit.")
+	transcodeCache := flag.Int64("transcode-cache", 512, "Size of the cache of episodes transcoded to MP3 for players requesting <video id>.mp3, in MB, 0 for no cache.")
+	freeSpaceMB := flag.Int64("min-free-space", minFreeSpace>>20, "Free disk space in MB below which downloads wait, 0 to never check.")
+	keepEpisodes := flag.Int("keep-episodes", 0, "Keep at most this many newest episodes per feed, 0 for no limit.")
+	maxAge := flag.Int("max-age", 0, "Delete episodes downloaded more than this many days ago, 0 for no limit.")
+	gcStrategy := flag.String("gc-strategy", "oldest", "Files deleted first when over -max-storage: oldest, least-played, proportional or pinned.")
+	prunedFile := flag.String("pruned-file", "pruned.json", "File keeping videos deleted to free storage, so they are not downloaded again.")
+	playsFile := flag.String("plays-file", "plays.json", "File keeping completed downloads of episodes.")
+	flag.StringVar(&transcriber, "transcriber", transcriber, "whisper.cpp command line tool used for transcripts, defaults to $LFPOD_TRANSCRIBER or the configuration file.")
+	flag.StringVar(&downloader, "downloader", downloader, "yt-dlp command, a name in PATH or a path, defaults to $LFPOD_DOWNLOADER or the configuration file.")
+	flag.StringVar(&converter, "converter", converter, "ffmpeg command, defaults to $LFPOD_CONVERTER or the configuration file.")
+	flag.StringVar(&probe, "probe", probe, "ffprobe command, defaults to $LFPOD_PROBE or the configuration file.")
+	flag.StringVar(&whisperModel, "whisper-model", "", "whisper.cpp model file, e.g. ggml-base.bin, required by feeds with transcribe.")
+	transcribeConcurrency := flag.Int("transcribe-concurrency", 1, "Maximum number of concurrent transcriptions.")
+	flag.Float64Var(&loudnessTarget, "loudness", 0, "Normalize loudness to this many LUFS, e.g. -16, 0 to disable.")
+	blockedFile := flag.String("blocked-file", "blocked.json", "File keeping videos not downloaded anymore after permanent failures.")
+	retryFile := flag.String("retry-file", "retries.json", "File keeping failed downloads to be retried.")
+	episodeDb := flag.String("episode-db", "episodes.db", "SQLite database keeping metadata and status of discovered episodes.")
+	probeFile := flag.String("probe-file", "probes.json", "File keeping the last readiness check of videos not downloaded yet.")
+	enableWebSub := flag.Bool("websub", false, "Subscribe to WebSub push notifications of uploads, to update feeds within seconds. The server address must be reachable by the hub.")
+	flag.StringVar(&websubHub, "websub-hub", websubHub, "WebSub hub subscriptions are requested from.")
+	flag.DurationVar(&updateJitter, "jitter", 0, "Delay each update pass and scheduled poll by a random duration up to this, e.g. 5m, so instances do not poll YouTube at the same time.")
+	watchdogStall := flag.Duration("watchdog-stall", 30*time.Minute, "Under a systemd watchdog, stop pinging it when an update pass made no progress for this long, so systemd restarts lfpod.")
+	flag.StringVar(&inbox.path, "inbox", "", "File or directory of YouTube video URLs to download into the feed marked inbox, checked every 30 seconds.")
+	watchInterval := flag.Duration("watch-archive", 0, "Check the audio directories for changes by other processes this often, e.g. 1m, 0 for none. Serve-only instances check every minute by default.")
+	debugRoutes := flag.Bool("pprof", false, "Serve runtime profiles at /debug/pprof, with the management API authentication.")
+	printVersion := flag.Bool("version", false, "Print the version and exit.")
+	once := flag.Bool("once", false, "Run a single update pass and exit, like the update command.")
+	quietHours := flag.String("quiet-hours", "", "Comma separated local time ranges, e.g. 07:00-23:00, in which updates only poll feeds and defer downloads and recodes to the end of the range.")
+	pauseUntil := flag.String("pause", "", "Start with updates paused until RFC 3339 time or for duration, e.g. 72h.")
+	flag.Usage = usage
+	flag.Parse()
+	if *printVersion {
+		fmt.Println(buildVersion())
+		return
+	}
+	// ctl talks to a remote server, it needs no local data.
+	if flag.Arg(0) == "ctl" {
+		if err := runCtl(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	applyTools(*confFeedsFile, readConfTools(*confFeedsFile), flagSet)
+	if err := enterDataDir(confFeedsFile, *dataDir); err != nil {
+		log.Fatal(err)
+	}
+	transcodeCacheSize = *transcodeCache << 20
+	stateFiles = map[string]string{backupEpisodeDbName: *episodeDb, "retries.json": *retryFile, "probes.json": *probeFile,
+		"pruned.json": *prunedFile, "plays.json": *playsFile, "blocked.json": *blockedFile}
+
+	if *feedOrder != "date" && *feedOrder != "interleave" {
+		log.Fatalf("unknown -feed-order %q", *feedOrder)
+	}
+	// Blobs would never be pruned without link counts.
+	if blobDir != "" && !linkCountsKnown {
+		log.Fatal("-blob-dir is not supported on ", runtime.GOOS)
+	}
+	if updateJitter < 0 || updateJitter >= updateInterval {
+		log.Fatalf("-jitter must be less than the update interval of %s", updateInterval)
+	}
+	if _, ok := gcStrategies[*gcStrategy]; !ok {
+		log.Fatalf("unknown -gc-strategy %q", *gcStrategy)
+	}
+	if err := pruned.Load(*prunedFile); err != nil {
+		log.Fatal(err)
+	}
+	if err := plays.Load(*playsFile); err != nil {
+		log.Fatal(err)
+	}
+	go plays.saveEvery(time.Minute)
+	if err := blocked.Load(*blockedFile); err != nil {
+		log.Fatal(err)
+	}
+
+	// The commands and the update loop run until lfpod exits.
+	ctx := context.Background()
+	if flag.NArg() > 0 && flag.Arg(0) != "update" && flag.Arg(0) != "add-video" && flag.Arg(0) != "serve" {
+		var err error
+		switch flag.Arg(0) {
+		case "add":
+			// The first feed creates the configuration file.
+			conf := Conf{ConfFeedsFile: *confFeedsFile}
+			if fileExists(*confFeedsFile) {
+				conf.ConfFeeds = readConfFeeds(*confFeedsFile)
+			}
+			err = runAdd(ctx, &conf, flag.Args()[1:])
+		case "remove":
+			conf := Conf{ConfFeeds: readConfFeeds(*confFeedsFile), ConfFeedsFile: *confFeedsFile}
+			err = runRemove(&conf, flag.Args()[1:])
+		case "list":
+			conf := Conf{ConfFeeds: readConfFeeds(*confFeedsFile)}
+			err = runList(&conf, flag.Args()[1:])
+		case "search":
+			checkExecs(&downloader)
+			err = runSearch(ctx, flag.Args()[1:])
+		case "gc":
+			if err := setStorage(*storageLocation, s3Opts); err != nil {
+				log.Fatal(err)
+			}
+			conf := Conf{ConfFeeds: readConfFeeds(*confFeedsFile), MaxStorage: *maxStorage << 20, GCStrategy: *gcStrategy,
+				KeepEpisodes: *keepEpisodes, MaxAge: *maxAge}
+			err = runGC(ctx, &conf, flag.Args()[1:])
+		case "backup":
+			err = runBackup(ctx, *confFeedsFile, flag.Args()[1:])
+		case "restore":
+			err = runRestore(*confFeedsFile, flag.Args()[1:])
+		case "delete":
+			if err := setStorage(*storageLocation, s3Opts); err != nil {
+				log.Fatal(err)
+			}
+			if err := loadEpisodes(ctx, *episodeDb); err != nil {
+				log.Fatal(err)
+			}
+			conf := Conf{ConfFeeds: readConfFeeds(*confFeedsFile)}
+			err = runDelete(ctx, &conf, flag.Args()[1:])
+		case "digest":
+			checkExecs(&converter, &probe)
+			conf := Conf{ConfFeeds: readConfFeeds(*confFeedsFile)}
+			err = runDigest(ctx, &conf, flag.Args()[1:])
+		case "bench":
+			err = runBench(flag.Args()[1:])
+		case "dedupe":
+			conf := Conf{ConfFeeds: readConfFeeds(*confFeedsFile)}
+			err = runDedupe(&conf, flag.Args()[1:])
+		case "blocked":
+			err = runBlocked(flag.Args()[1:])
+		case "import":
+			conf := Conf{ConfFeeds: readConfFeeds(*confFeedsFile), ConfFeedsFile: *confFeedsFile}
+			err = runImport(&conf, flag.Args()[1:])
+		default:
+			err = fmt.Errorf("unknown command %q, see lfpod -h", flag.Arg(0))
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	conf := Conf{
+		ConfFeeds:        readConfFeeds(*confFeedsFile),
+		ConfFeedsFile:    *confFeedsFile,
+		ServerAddress:    *serverAddress,
+		BasePath:         strings.TrimSuffix(*basePath, "/"),
+		FeedStats:        *feedStats,
+		Workers:          *workers,
+		RecodeWorkers:    *recodeWorkers,
+		FetchConcurrency: *fetchConcurrency,
+		FeedMaxItems:     *feedMaxItems,
+		FeedPageSize:     *feedPageSize,
+		CatchUpRate:      *catchUpRate,
+		FeedOrder:        *feedOrder,
+		MaxStorage:       *maxStorage << 20,
+		GCStrategy:       *gcStrategy,
+		KeepEpisodes:     *keepEpisodes,
+		MaxAge:           *maxAge,
+	}
+	if conf.BasePath != "" && !strings.HasPrefix(conf.BasePath, "/") {
+		conf.BasePath = "/" + conf.BasePath
+	}
+
+	if err := checkProfiles(conf.Profiles); err != nil {
+		log.Fatal(err)
+	}
+	listenAddresses := splitListen(*listenAddress)
+	if len(conf.Listen) > 0 && !flagSet("listen") {
+		listenAddresses = conf.Listen
+	}
+	for _, address := range listenAddresses {
+		if err := checkListenAddress(address); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := checkAdminToken(conf.Profiles); err != nil {
+		log.Fatal(err)
+	}
+	checkExecs(&downloader, &converter, &probe)
+	for _, feed := range conf.AllFeeds() {
+		if feed.Transcribe {
+			checkExecs(&transcriber)
+			if whisperModel == "" {
+				log.Fatal("-whisper-model is required by feeds with transcribe")
+			}
+			break
+		}
+	}
+	setTranscribeConcurrency(*transcribeConcurrency)
+	setMaxProcs(*maxProcs)
+	if procNice < 0 || procNice > 19 {
+		log.Fatal("-nice must be 0 to 19")
+	}
+	class, level, err := parseIOPriority(*ioPriority)
+	if err != nil {
+		log.Fatal(err)
+	}
+	procIOClass, procIOLevel = class, level
+	if (procNice != 0 || procIOClass != 0) && runtime.GOOS != "linux" {
+		log.Fatal("-nice and -ionice are supported on Linux only")
+	}
+	if *freeSpaceMB < 0 {
+		log.Fatal("-min-free-space must not be negative")
+	}
+	minFreeSpace = *freeSpaceMB << 20
+	events.Subscribe(countEvents)
+	events.Subscribe(loopStatus.Track)
+	if *eventLog != "" {
+		if err := openEventLog(*eventLog); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, err := startNotifiers(&conf); err != nil {
+		log.Fatal(err)
+	}
+	initShareKey(*shareSecret)
+
+	if addr, err := resolveSourceAddress(*outAddress); err != nil {
+		log.Fatal(err)
+	} else if addr != "" {
+		sourceAddress = addr
+		log.Print("outbound connections bound to ", sourceAddress)
+	}
+	if downloaderUpdateMode != "update" && downloaderUpdateMode != "check" {
+		log.Fatalf("-downloader-update-mode: unknown mode %q", downloaderUpdateMode)
+	}
+	if fetchTimeout <= 0 || fetchRetries < 0 {
+		log.Fatal("-fetch-timeout must be positive and -fetch-retries not negative")
+	}
+	if *userAgent != "" {
+		fetchHeader.Set("User-Agent", *userAgent)
+	}
+	if *forceIPv4 && *forceIPv6 {
+		log.Fatal("-force-ipv4 and -force-ipv6 are mutually exclusive")
+	} else if *forceIPv4 {
+		ipVersion = "4"
+	} else if *forceIPv6 {
+		ipVersion = "6"
+	}
+	if *dnsServer != "" {
+		lookup, err := newLookup(*dnsServer)
+		if err != nil {
+			log.Fatal(err)
+		}
+		outboundLookup = lookup
+		log.Print("using DNS server ", *dnsServer)
+	}
+	if proxyURL != "" {
+		u, err := parseProxy(proxyURL)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Print("using proxy ", u.Redacted())
+	}
+	if conf.CORS != nil {
+		if err := conf.CORS.validate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if _, ok := conf.InboxFeed(); inbox.path != "" && !ok {
+		log.Fatal("-inbox needs a feed with \"inbox\": true")
+	}
+	slugs := map[string]bool{}
+	for _, feed := range conf.AllFeeds() {
+		if feed.Slug != "" && slugs[feed.Slug] {
+			log.Fatal(feed.Name, ": slug ", feed.Slug, " used by another feed")
+		}
+		slugs[feed.Slug] = true
+		if _, err := parseMatch(feed.Match); err != nil {
+			log.Fatal(feed.Name, ": invalid match: ", err)
+		}
+		for _, proxy := range []string{feed.Proxy, feed.GeoVerificationProxy} {
+			if proxy == "" {
+				continue
+			}
+			if _, err := parseProxy(proxy); err != nil {
+				log.Fatal(feed.Name, ": ", err)
+			}
+		}
+	}
+	fetchClient = newFetchClient(proxyURL)
+	if err := setStorage(*storageLocation, s3Opts); err != nil {
+		log.Fatal(err)
+	}
+	if *storageLocation != "" {
+		log.Print("storing audio in ", *storageLocation)
+	}
+	downloaderExtraArgs = strings.Fields(*downloaderArgs)
+	perDownload, err := pipeline.ParseRate(*limitRate)
+	if err != nil {
+		log.Fatal("-limit-rate: ", err)
+	}
+	total, err := pipeline.ParseRate(*maxDownloadRate)
+	if err != nil {
+		log.Fatal("-max-download-rate: ", err)
+	}
+	if rate := downloadRates.Set(perDownload, total, *workers); rate > 0 {
+		log.Printf("downloads limited to %d KiB/s each", rate>>10)
+	}
+
+	if err := quiet.Set(*quietHours); err != nil {
+		log.Fatal(err)
+	}
+	if *pauseUntil != "" {
+		until, err := parsePauseUntil(*pauseUntil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		pause.Set(until)
+	}
+
+	if err := loadEpisodes(ctx, *episodeDb); err != nil {
+		log.Fatal(err)
+	}
+	if err := retries.Load(*retryFile); err != nil {
+		log.Fatal(err)
+	}
+	if err := probes.Load(*probeFile); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, feed := range conf.AllFeeds() {
+		if err := os.MkdirAll(filepath.Join("audio", feed.ChannelId), 0750); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if flag.Arg(0) == "update" || flag.Arg(0) == "add-video" || *once {
+		args := []string{}
+		if flag.NArg() > 0 {
+			args = flag.Args()[1:]
+		}
+		var err error
+		if flag.Arg(0) == "add-video" {
+			err = runAddVideo(ctx, &conf, args)
+		} else {
+			err = runUpdate(ctx, &conf, args)
+		}
+		waitNotifications()
+		if err != nil {
+			var exit *updateExitError
+			if errors.As(err, &exit) {
+				log.Print(err)
+				os.Exit(exit.Code)
+			}
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if serveOnly && *watchInterval == 0 {
+		*watchInterval = time.Minute
+	}
+	if *watchInterval > 0 {
+		go watchArchive(&conf, *watchInterval)
+	}
+	if serveOnly {
+		log.Print("serving only, updates run in a separate process")
+	} else {
+		go updateFeeds(ctx, &conf)
+		if *enableWebSub {
+			startWebSub(&conf)
+		}
+		if inbox.path != "" {
+			go watchInbox(ctx, &conf)
+		}
+		for i := range conf.Notify {
+			if conf.Notify[i].Bot {
+				go newTelegramBot(&conf, &conf.Notify[i]).run(ctx)
+			}
+		}
+	}
+
+	root := mux.NewRouter()
+	r := root
+	if conf.BasePath != "" {
+		r = root.PathPrefix(conf.BasePath).Subrouter()
+	}
+	r.Use(metricsMiddleware)
+	r.HandleFunc("/healthz", healthzHandler).Methods("GET")
+	r.HandleFunc("/readyz", readyzHandlerWrapper(&conf)).Methods("GET")
+	r.HandleFunc("/metrics", metricsHandlerWrapper(&conf)).Methods("GET")
+	r.HandleFunc("/api/openapi.json", openAPIHandler).Methods("GET")
+	api := r.PathPrefix("/api").Subrouter()
+	api.Use(authMiddleware, jsonBodyMiddleware)
+	addAPIRoutes(api, &conf)
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(authMiddleware)
+	admin.HandleFunc("", confHandlerWrapper(&conf, adminGetHandler)).Methods("GET")
+	admin.HandleFunc("/feeds", confHandlerWrapper(&conf, adminAddFeedHandler)).Methods("POST")
+	admin.HandleFunc("/feeds/{channelId}/delete", confHandlerWrapper(&conf, adminDeleteFeedHandler)).Methods("POST")
+	admin.HandleFunc("/update", adminUpdateHandler).Methods("POST")
+	if *debugRoutes {
+		addDebugRoutes(r, conf.BasePath)
+	}
+	r.HandleFunc("/episodes", confHandlerWrapper(&conf, browseHandler)).Methods("GET")
+	if *enableWebSub {
+		r.HandleFunc("/websub/{channelId}", confHandlerWrapper(&conf, websubHandler)).Methods("GET", "POST")
+	}
+	r.HandleFunc("/share/{token}", shareGetHandler).Methods("GET")
+	r.HandleFunc("/share/{token}/audio", confHandlerWrapper(&conf, shareAudioHandler)).Methods("GET")
+	feedHandler := conditionalFeedHandler(&conf, server.Gzip(confHandlerWrapper(&conf, feedGetHandler)))
+	r.HandleFunc("/feed", feedHandler).Methods("GET")
+	r.HandleFunc("/feed/archive/{year:[0-9]+}", feedHandler).Methods("GET")
+	r.HandleFunc("/feed/{name}", feedHandler).Methods("GET")
+	r.HandleFunc("/feed/{name}/archive/{year:[0-9]+}", feedHandler).Methods("GET")
+	r.HandleFunc("/u/{user}/feed", feedHandler).Methods("GET")
+	r.HandleFunc("/u/{user}/feed/archive/{year:[0-9]+}", feedHandler).Methods("GET")
+	r.HandleFunc("/u/{user}/feed/{name}", feedHandler).Methods("GET")
+	r.HandleFunc("/u/{user}/feed/{name}/archive/{year:[0-9]+}", feedHandler).Methods("GET")
+	r.HandleFunc("/artwork/{name}.png", confHandlerWrapper(&conf, placeholderHandler)).Methods("GET")
+	r.HandleFunc("/t/{secret}/feed", feedHandler).Methods("GET")
+	r.HandleFunc("/t/{secret}/feed/archive/{year:[0-9]+}", feedHandler).Methods("GET")
+	r.HandleFunc("/t/{secret}/feed/{name}", feedHandler).Methods("GET")
+	r.HandleFunc("/t/{secret}/feed/{name}/archive/{year:[0-9]+}", feedHandler).Methods("GET")
+	r.PathPrefix("/u/{user}/audio/").Handler(confHandlerWrapper(&conf, profileAudioHandler))
+	r.PathPrefix("/t/{secret}/audio/").Handler(confHandlerWrapper(&conf, profileAudioHandler))
+	r.PathPrefix("/audio/").HandlerFunc(confHandlerWrapper(&conf, audioHandler))
+	r.HandleFunc("/original/{name}/{videoId}", confHandlerWrapper(&conf, originalHandler)).Methods("GET")
+	accessLog := &AccessLog{}
+	if *accessLogFile != "" {
+		f, err := os.OpenFile(*accessLogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0640)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer f.Close()
+		accessLog.out = f
+	}
+	limiter := newRateLimiter(*rateLimit, *rateBurst, *maxConns)
+	allowed, err := parseNetworks(*allowFrom)
+	if err != nil {
+		log.Fatal("-allow-from: ", err)
+	}
+	if trustedProxies, err = parseNetworks(*trustedProxyList); err != nil {
+		log.Fatal("-trusted-proxies: ", err)
+	}
+	log.Print(buildVersion())
+	startWatchdog(*watchdogStall)
+	handler := ipAllowMiddleware(allowed, limiter.Middleware(corsMiddleware(conf.CORS, root)))
+	serve(accessLog.Middleware(handler), listenAddresses, os.FileMode(*socketMode))
+}
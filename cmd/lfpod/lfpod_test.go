@@ -0,0 +1,64 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHeader(t *testing.T) {
+	name, value, err := parseHeader("accept-language:  en-US,en;q=0.9")
+	if err != nil || name != "Accept-Language" || value != "en-US,en;q=0.9" {
+		t.Errorf("header %q: %q, %v", name, value, err)
+	}
+	for _, s := range []string{"User-Agent", ": value", "X Test: value"} {
+		if _, _, err := parseHeader(s); err == nil {
+			t.Errorf("header %q accepted", s)
+		}
+	}
+}
+
+func TestOpusArgs(t *testing.T) {
+	feed := ConfFeed{OpusVBR: "constrained", OpusApplication: "voip", OpusFrameDuration: 60}
+	want := "-vbr constrained -application voip -frame_duration 60"
+	if got := strings.Join(opusArgs(&feed), " "); got != want {
+		t.Errorf("opus args %q, want %q", got, want)
+	}
+	feed.Format = "m4a"
+	if args := opusArgs(&feed); len(args) != 0 {
+		t.Errorf("opus args %q for AAC", args)
+	}
+	if msg := validateFeed(ConfFeed{Name: "test", ChannelId: testChannelId, OpusFrameDuration: 30}); msg == "" {
+		t.Error("invalid frame duration accepted")
+	}
+}
+
+func TestReadFeedRetry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests++; requests < 3 {
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "<feed></feed>")
+	}))
+	defer server.Close()
+	savedURL, savedBackoff := feedBaseURL, fetchRetryBackoff
+	t.Cleanup(func() { feedBaseURL, fetchRetryBackoff = savedURL, savedBackoff })
+	feedBaseURL, fetchRetryBackoff = server.URL+"/?channel_id=", time.Millisecond
+
+	if _, err := readFeed(context.Background(), fetchClient, testChannelId); err != nil || requests != 3 {
+		t.Errorf("read after %d requests: %v, want success on the third", requests, err)
+	}
+	requests = -10
+	if _, err := readFeed(context.Background(), fetchClient, testChannelId); err == nil || requests != -7 {
+		t.Errorf("read after %d failed requests: %v, want failure after 3", requests+10, err)
+	}
+}
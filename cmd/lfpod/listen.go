@@ -0,0 +1,179 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// First file descriptor passed by systemd socket activation.
+const listenFdsStart = 3
+
+// activationListeners returns the sockets passed by systemd, if the
+// process was socket-activated.
+func activationListeners() ([]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	listeners := []net.Listener{}
+	for fd := listenFdsStart; fd < listenFdsStart+n; fd++ {
+		f := os.NewFile(uintptr(fd), "LISTEN_FD_"+strconv.Itoa(fd))
+		l, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// Directory of the node state of tailnet listeners.
+var tailnetStateDir = "tailnet"
+
+var errNoTailnet = errors.New("tailnet listeners need lfpod built with -tags tsnet")
+
+// listen opens a TCP listener or, for addresses like unix:/run/lfpod.sock,
+// a unix domain socket with the given file mode. Addresses like
+// tailnet:lfpod listen on a tailnet node of that name, in builds with
+// the tsnet tag.
+func listen(address string, socketMode os.FileMode) (net.Listener, error) {
+	if hostname, ok := strings.CutPrefix(address, "tailnet:"); ok {
+		return listenTailnet(hostname)
+	}
+	path, ok := strings.CutPrefix(address, "unix:")
+	if !ok {
+		return net.Listen("tcp", address)
+	}
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		// Stale socket left by a previous run.
+		os.Remove(path)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// splitListen splits a comma-separated list of listen addresses.
+func splitListen(s string) []string {
+	addresses := []string{}
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses
+}
+
+// checkListenAddress reports a listen address that is neither host:port,
+// a unix socket nor a tailnet node this build can listen on.
+func checkListenAddress(address string) error {
+	if strings.HasPrefix(address, "unix:") {
+		return nil
+	}
+	if hostname, ok := strings.CutPrefix(address, "tailnet:"); ok {
+		if !tailnetSupported {
+			return fmt.Errorf("listen address %s: %v", address, errNoTailnet)
+		}
+		if !isHostnameLabel(hostname) {
+			return fmt.Errorf("listen address %s: invalid tailnet hostname %q", address, hostname)
+		}
+		return nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		if strings.Count(address, ":") > 1 && !strings.HasPrefix(address, "[") {
+			return fmt.Errorf("listen address %s: IPv6 addresses go in brackets, e.g. [::1]:8080", address)
+		}
+		return fmt.Errorf("listen address %s: %v", address, err)
+	}
+	if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("listen address %s: invalid port %q", address, port)
+	}
+	if ip, _, _ := strings.Cut(host, "%"); strings.Contains(ip, ":") && net.ParseIP(ip) == nil {
+		return fmt.Errorf("listen address %s: invalid IPv6 address", address)
+	}
+	return nil
+}
+
+// isHostnameLabel reports whether s is a single DNS label, as tailnet
+// machine names are.
+func isHostnameLabel(s string) bool {
+	if s == "" || len(s) > 63 || s[0] == '-' || s[len(s)-1] == '-' {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// listenAll opens listeners on all addresses, none if one fails.
+func listenAll(addresses []string, socketMode os.FileMode) ([]net.Listener, error) {
+	listeners := []net.Listener{}
+	for _, address := range addresses {
+		l, err := listen(address, socketMode)
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// serve serves handler on the sockets passed by systemd or, if not
+// socket-activated, on all addresses.
+func serve(handler http.Handler, addresses []string, socketMode os.FileMode) {
+	listeners, err := activationListeners()
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, l := range listeners {
+		log.Print("listening on socket-activated ", l.Addr())
+	}
+	if len(listeners) == 0 {
+		if listeners, err = listenAll(addresses, socketMode); err != nil {
+			log.Fatal(err)
+		}
+		for _, l := range listeners {
+			log.Print("listening on ", l.Addr())
+		}
+	}
+	errs := make(chan error)
+	for _, l := range listeners {
+		go func(l net.Listener) {
+			errs <- http.Serve(l, handler)
+		}(l)
+	}
+	// The configuration was read and the sockets are open, connections
+	// are accepted from now on.
+	sdNotify("READY=1")
+	log.Fatal(<-errs)
+}
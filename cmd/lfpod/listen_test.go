@@ -0,0 +1,50 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import "testing"
+
+func TestListenAddresses(t *testing.T) {
+	if got := splitListen("127.0.0.1:8080, [::1]:8080,"); len(got) != 2 || got[1] != "[::1]:8080" {
+		t.Errorf("split %q", got)
+	}
+	for address, ok := range map[string]bool{
+		":8080":                true,
+		"127.0.0.1:8080":       true,
+		"[::1]:8080":           true,
+		"[fe80::1%eth0]:8080":  true,
+		"unix:/run/lfpod.sock": true,
+		"::1:8080":             false,
+		"[::g]:8080":           false,
+		"127.0.0.1:http":       false,
+		"127.0.0.1":            false,
+		"tailnet:lfpod":        tailnetSupported,
+		"tailnet:":             false,
+		"tailnet:lfpod.ts.net": false,
+		"tailnet:-lfpod":       false,
+	} {
+		if err := checkListenAddress(address); (err == nil) != ok {
+			t.Errorf("%s: %v", address, err)
+		}
+	}
+	if !tailnetSupported {
+		if _, err := listen("tailnet:lfpod", 0660); err != errNoTailnet {
+			t.Errorf("tailnet listener without tsnet: %v", err)
+		}
+	}
+
+	listeners, err := listenAll([]string{"127.0.0.1:0", "127.0.0.1:0"}, 0660)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, l := range listeners {
+		l.Close()
+	}
+	if len(listeners) != 2 {
+		t.Errorf("%d listeners, want 2", len(listeners))
+	}
+	if _, err := listenAll([]string{"127.0.0.1:0", "192.0.2.1:0"}, 0660); err == nil {
+		t.Error("listening on an address not of this host")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// Live streams are recorded for at most this long.
+var liveRecordMax = 12 * time.Hour
+
+// recordings are the live streams being recorded. They run outside the
+// update passes, which would otherwise wait for them for hours.
+var recordings = struct {
+	sync.Mutex
+	sync.WaitGroup
+	active map[string]bool
+}{active: map[string]bool{}}
+
+// isRecording reports whether a live stream is being recorded.
+func isRecording(videoId string) bool {
+	recordings.Lock()
+	defer recordings.Unlock()
+	return recordings.active[videoId]
+}
+
+// liveArgs returns the yt-dlp arguments selecting what to record of a
+// live stream from its start: the audio, live streams have no audio
+// only formats, or the video of video feeds. Recordings are MPEG-TS,
+// playable even if cut off.
+func liveArgs(feed *ConfFeed) []string {
+	args := []string{"--live-from-start", "--hls-use-mpegts"}
+	if !feed.IsVideo() {
+		return append(args, "-f", "ba/w", "-x")
+	}
+	return append(args, downloadFormatArgs(feed)...)
+}
+
+// recordLive starts recording an ongoing live stream, unless it is
+// being recorded already. The recording is published like a download
+// once the stream ends.
+func recordLive(ctx context.Context, job Job, info *episodeMetadata) {
+	recordings.Lock()
+	defer recordings.Unlock()
+	if recordings.active[job.Entry.VideoId] {
+		return
+	}
+	recordings.active[job.Entry.VideoId] = true
+	recordings.Add(1)
+	go func() {
+		defer recordings.Done()
+		defer func() {
+			recordings.Lock()
+			delete(recordings.active, job.Entry.VideoId)
+			recordings.Unlock()
+		}()
+		var d *downloadedJob
+		_, err := runJob(ctx, job, func() (size int64, err error) {
+			d, err = fetchJob(ctx, job, info, true)
+			return 0, err
+		})
+		if err != nil {
+			return
+		}
+		if _, err := runJob(ctx, job, func() (int64, error) { return recodeJob(ctx, d) }); err == nil {
+			log.Print(job, " live stream published")
+		}
+	}()
+}
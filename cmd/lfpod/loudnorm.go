@@ -0,0 +1,52 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os/exec"
+	"strings"
+)
+
+// Target integrated loudness in LUFS for feeds not setting their own,
+// 0 to disable normalization.
+var loudnessTarget float64
+
+// Loudness range and true peak targets of the EBU R128 loudnorm filter.
+const loudnessRange, loudnessTruePeak = 11.0, -1.5
+
+// loudnormFilter returns a loudnorm filter normalizing fileIn to target
+// LUFS after filters. The input is measured first, so the second pass
+// can normalize linearly, the single pass dynamic mode is used if the
+// measurement fails.
+func loudnormFilter(videoId, fileIn, filters string, target float64) string {
+	params := fmt.Sprintf("loudnorm=I=%g:TP=%g:LRA=%g", target, loudnessTruePeak, loudnessRange)
+	af := params + ":print_format=json"
+	if filters != "" {
+		af = filters + "," + af
+	}
+	args := append([]string{"-hide_banner", "-nostats", "-i", fileIn, "-af", af}, threadArgs()...)
+	cmd := exec.Command(converter, append(args, "-f", "null", "-")...)
+	out, err := runCommand(cmd, videoId, "loudness")
+	if err != nil {
+		log.Printf("%s loudness measurement failed: %v", videoId, err)
+		return params
+	}
+	var m struct {
+		InputI       string `json:"input_i"`
+		InputTP      string `json:"input_tp"`
+		InputLRA     string `json:"input_lra"`
+		InputThresh  string `json:"input_thresh"`
+		TargetOffset string `json:"target_offset"`
+	}
+	s := string(out)
+	if i := strings.LastIndex(s, "{"); i < 0 || json.Unmarshal([]byte(s[i:]), &m) != nil || m.InputI == "" {
+		log.Printf("%s loudness measurement not found", videoId)
+		return params
+	}
+	return fmt.Sprintf("%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+		params, m.InputI, m.InputTP, m.InputLRA, m.InputThresh, m.TargetOffset)
+}
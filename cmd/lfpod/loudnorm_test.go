@@ -0,0 +1,62 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoudnormFilter(t *testing.T) {
+	self, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := t.TempDir()
+	if err := os.Symlink(self, filepath.Join(bin, "ffmpeg")); err != nil {
+		t.Skip("symlinks not supported: ", err)
+	}
+	saved := converter
+	converter = filepath.Join(bin, "ffmpeg")
+	t.Cleanup(func() { converter = saved })
+	argsLog := filepath.Join(t.TempDir(), "args")
+	t.Setenv("LFPOD_TEST_CONVERTER_LOG", argsLog)
+
+	const params = "loudnorm=I=-16:TP=-1.5:LRA=11"
+	const measurement = `[Parsed_loudnorm_0 @ 0x1]
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-16.58",
+	"target_offset" : "0.58"
+}
+`
+	for _, tc := range []struct {
+		name, output, filters string
+		measured              string
+		want                  string
+	}{
+		{"measured", "size=N/A time=00:00:10.00\n" + measurement, "", "-af " + params + ":print_format=json",
+			params + ":measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:measured_thresh=-39.20:offset=0.58:linear=true"},
+		{"after filters", measurement, "atempo=1.5", "-af atempo=1.5," + params + ":print_format=json",
+			params + ":measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:measured_thresh=-39.20:offset=0.58:linear=true"},
+		{"no measurement", "size=N/A time=00:00:10.00\n", "", "", params},
+		{"no integrated loudness", `{"input_tp" : "-4.47"}`, "", "", params},
+		{"invalid JSON", "{\"input_i\" : ", "", "", params},
+		{"failed", "fail", "", "", params},
+	} {
+		t.Setenv("LFPOD_TEST_LOUDNESS", tc.output)
+		os.Remove(argsLog)
+		if got := loudnormFilter("vid00000001", "in.webm", tc.filters, -16); got != tc.want {
+			t.Errorf("%s: filter %s, want %s", tc.name, got, tc.want)
+		}
+		if args, _ := os.ReadFile(argsLog); !strings.Contains(string(args), tc.measured) {
+			t.Errorf("%s: measured with %s", tc.name, args)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/lfpod/youtube"
+)
+
+// matchExpr is a boolean expression of terms selecting videos, like
+// `interview AND NOT (shorts OR "live stream")`. Terms match text
+// containing them, ignoring case. Words not separated by operators are
+// one term, quotes make a term of operators and parentheses too. AND
+// binds tighter than OR, operators are upper case only.
+type matchExpr struct {
+	// AND, OR, NOT, or empty for a term.
+	op   string
+	term string
+	args []*matchExpr
+}
+
+func (e *matchExpr) match(text string) bool {
+	switch e.op {
+	case "AND":
+		for _, a := range e.args {
+			if !a.match(text) {
+				return false
+			}
+		}
+		return true
+	case "OR":
+		for _, a := range e.args {
+			if a.match(text) {
+				return true
+			}
+		}
+		return false
+	case "NOT":
+		return !e.args[0].match(text)
+	}
+	return strings.Contains(text, e.term)
+}
+
+// matchToken is an operator, a parenthesis or, if quoted or a word, a
+// term.
+type matchToken struct {
+	s    string
+	term bool
+}
+
+func tokenizeMatch(s string) ([]matchToken, error) {
+	tokens := []matchToken{}
+	rs := []rune(s)
+	for i := 0; i < len(rs); {
+		switch r := rs[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			tokens = append(tokens, matchToken{s: string(r)})
+			i++
+		case r == '"':
+			end := i + 1
+			for end < len(rs) && rs[end] != '"' {
+				end++
+			}
+			if end == len(rs) {
+				return nil, fmt.Errorf("unterminated quote")
+			}
+			tokens = append(tokens, matchToken{s: string(rs[i+1 : end]), term: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(rs) && !unicode.IsSpace(rs[end]) && !strings.ContainsRune(`()"`, rs[end]) {
+				end++
+			}
+			word := string(rs[i:end])
+			tokens = append(tokens, matchToken{s: word, term: word != "AND" && word != "OR" && word != "NOT"})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+// matchParser parses tokens by recursive descent:
+//
+//	or   = and {"OR" and}
+//	and  = not {"AND" not}
+//	not  = "NOT" not | "(" or ")" | term {term}
+type matchParser struct {
+	tokens []matchToken
+	pos    int
+}
+
+func (p *matchParser) peek(s string) bool {
+	return p.pos < len(p.tokens) && !p.tokens[p.pos].term && p.tokens[p.pos].s == s
+}
+
+func (p *matchParser) list(op string, next func() (*matchExpr, error)) (*matchExpr, error) {
+	e, err := next()
+	if err != nil {
+		return nil, err
+	}
+	args := []*matchExpr{e}
+	for p.peek(op) {
+		p.pos++
+		e, err := next()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+	}
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	return &matchExpr{op: op, args: args}, nil
+}
+
+func (p *matchParser) or() (*matchExpr, error) {
+	return p.list("OR", p.and)
+}
+
+func (p *matchParser) and() (*matchExpr, error) {
+	return p.list("AND", p.not)
+}
+
+func (p *matchParser) not() (*matchExpr, error) {
+	switch {
+	case p.peek("NOT"):
+		p.pos++
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return &matchExpr{op: "NOT", args: []*matchExpr{e}}, nil
+	case p.peek("("):
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		p.pos++
+		return e, nil
+	}
+	words := []string{}
+	for p.pos < len(p.tokens) && p.tokens[p.pos].term {
+		words = append(words, p.tokens[p.pos].s)
+		p.pos++
+	}
+	if len(words) == 0 {
+		if p.pos == len(p.tokens) {
+			return nil, fmt.Errorf("unexpected end")
+		}
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos].s)
+	}
+	return &matchExpr{term: strings.ToLower(strings.Join(words, " "))}, nil
+}
+
+// parseMatch parses a match expression, nil if s is empty.
+func parseMatch(s string) (*matchExpr, error) {
+	tokens, err := tokenizeMatch(s)
+	if err != nil || len(tokens) == 0 {
+		return nil, err
+	}
+	p := &matchParser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos].s)
+	}
+	return e, nil
+}
+
+// matchText returns the text of an entry keywords and the match
+// expression of the feed are searched in, lower case.
+func (f *ConfFeed) matchText(entry *youtube.Entry) string {
+	text := entry.Title
+	if f.MatchDescription && entry.Media != nil {
+		text += "\n" + entry.Media.Description
+	}
+	return strings.ToLower(text)
+}
+
+// Selects reports whether the feed downloads a video: its title, or
+// description with match_description, contains one of the keywords, if
+// any, and matches the match expression, if any.
+func (f *ConfFeed) Selects(entry *youtube.Entry) bool {
+	text := f.matchText(entry)
+	if keywords := f.FilterKeywords(); keywords != nil && !youtube.MatchKeywords(text, keywords) {
+		return false
+	}
+	e, err := parseMatch(f.Match)
+	if err != nil {
+		// Validated when the feed is configured.
+		return false
+	}
+	return e == nil || e.match(text)
+}
+
+// IsPriority reports whether a video matches the priority keywords of
+// the feed.
+func (f *ConfFeed) IsPriority(entry *youtube.Entry) bool {
+	return youtube.MatchKeywords(f.matchText(entry), f.PriorityKeywords)
+}
@@ -0,0 +1,50 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lfpod/youtube"
+)
+
+func TestMatchExpression(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "channel.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ytfeed, err := youtube.ParseFeed(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		feed   ConfFeed
+		titles string
+	}{
+		{ConfFeed{Match: "news AND NOT review"}, "Daily news"},
+		{ConfFeed{Match: "NEWS OR Weekly"}, "Daily news, Weekly review"},
+		{ConfFeed{Match: "daily news AND NOT (review OR weather)"}, "Daily news"},
+		{ConfFeed{Match: "episode"}, ""},
+		{ConfFeed{Match: "episode", MatchDescription: true}, "Daily news, Weekly review"},
+		{ConfFeed{Match: `"second episode" OR "AND"`, MatchDescription: true}, "Weekly review"},
+		{ConfFeed{Keywords: []string{"daily", "weekly"}, Match: "NOT weather", MatchDescription: true}, "Weekly review"},
+		{ConfFeed{Keywords: []string{"second"}, MatchDescription: true}, "Weekly review"},
+	} {
+		titles := []string{}
+		for _, entry := range filterFeed(*ytfeed, &tc.feed).Entries {
+			titles = append(titles, entry.Title)
+		}
+		if got := strings.Join(titles, ", "); got != tc.titles {
+			t.Errorf("%+v selects %q, want %q", tc.feed, got, tc.titles)
+		}
+	}
+	for _, match := range []string{"news AND", "(news", `"news`, "OR news", "news)"} {
+		if msg := validateFeed(ConfFeed{Name: "test", ChannelId: testChannelId, Match: match}); !strings.HasPrefix(msg, "invalid match") {
+			t.Errorf("match %q: %q", match, msg)
+		}
+	}
+}
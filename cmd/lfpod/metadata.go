@@ -0,0 +1,279 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"time"
+
+	"github.com/lfpod/pipeline"
+	"github.com/lfpod/youtube"
+)
+
+// Metadata of downloaded episodes is read again from YouTube this
+// often, 0 to refresh only on request.
+var metadataRefreshInterval time.Duration
+
+// MetadataChange is an edit of episode metadata made upstream after
+// the episode was downloaded.
+type MetadataChange struct {
+	Time  time.Time `json:"time"`
+	Field string    `json:"field"`
+	Old   string    `json:"old"`
+	New   string    `json:"new"`
+}
+
+// episodeMetadata is the part of the yt-dlp --dump-json output of a
+// video lfpod uses. It is read once for each new video and shared by the
+// readiness check, the audio tags and the sidecar feeds are built from.
+type episodeMetadata struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Thumbnail   string `json:"thumbnail"`
+	// Duration in seconds.
+	Duration float64 `json:"duration"`
+	// Upload date, YYYYMMDD.
+	UploadDate string `json:"upload_date"`
+	// Upload and scheduled release times, Unix seconds.
+	Timestamp        int64    `json:"timestamp"`
+	ReleaseTimestamp int64    `json:"release_timestamp"`
+	LiveStatus       string   `json:"live_status"`
+	Uploader         string   `json:"uploader"`
+	Tags             []string `json:"tags"`
+	Chapters         []struct {
+		StartTime float64 `json:"start_time"`
+		EndTime   float64 `json:"end_time"`
+		Title     string  `json:"title"`
+	} `json:"chapters"`
+}
+
+// ready reports whether the video can be downloaded. Upcoming videos and
+// premieres are expected to be available at the returned time, zero if
+// it is unknown.
+func (m *episodeMetadata) ready() (bool, time.Time) {
+	switch m.LiveStatus {
+	case "not_live", "was_live":
+		return true, time.Time{}
+	case "is_upcoming":
+		if m.ReleaseTimestamp == 0 {
+			break
+		}
+		// A premiere plays the video before it can be downloaded.
+		available := time.Unix(m.ReleaseTimestamp, 0).Add(premiereMargin)
+		return false, available.Add(time.Duration(m.Duration * float64(time.Second)))
+	}
+	return false, time.Time{}
+}
+
+// published returns the upload time in RFC 3339, empty if unknown.
+func (m *episodeMetadata) published() string {
+	if m.Timestamp != 0 {
+		return time.Unix(m.Timestamp, 0).UTC().Format(time.RFC3339)
+	}
+	if t, err := time.Parse("20060102", m.UploadDate); err == nil {
+		return t.Format(time.RFC3339)
+	}
+	return ""
+}
+
+// complete returns entry with the fields its channel feed lacks filled
+// in from the metadata.
+func (m *episodeMetadata) complete(entry *youtube.Entry) *youtube.Entry {
+	e := *entry
+	if e.Title == "" {
+		e.Title = m.Title
+	}
+	if e.Published == "" {
+		e.Published = m.published()
+	}
+	if e.Duration == 0 {
+		e.Duration = time.Duration(m.Duration * float64(time.Second))
+	}
+	if e.Media == nil {
+		e.Media = &youtube.Media{}
+	} else {
+		media := *e.Media
+		e.Media = &media
+	}
+	if e.Media.Description == "" {
+		e.Media.Description = m.Description
+	}
+	if e.Media.Thumbnail.URL == "" {
+		e.Media.Thumbnail.URL = m.Thumbnail
+	}
+	return &e
+}
+
+// chapters returns the chapters of the video known to YouTube, nil if
+// there are fewer than two.
+func (m *episodeMetadata) chapters() []Chapter {
+	if len(m.Chapters) < 2 {
+		return nil
+	}
+	chapters := []Chapter{}
+	for _, c := range m.Chapters {
+		chapters = append(chapters, Chapter{
+			Start: time.Duration(c.StartTime * float64(time.Second)),
+			End:   time.Duration(c.EndTime * float64(time.Second)),
+			Title: c.Title,
+		})
+	}
+	return chapters
+}
+
+// setMetadata updates the metadata of an episode with the non-empty
+// fields of m and records changes of known values, it returns true if
+// any changed.
+func (ep *ChannelEpisode) setMetadata(m episodeMetadata, now time.Time) bool {
+	changed := false
+	for _, f := range []struct {
+		name     string
+		old      *string
+		new      string
+		recorded bool
+	}{
+		{"title", &ep.Title, m.Title, true},
+		{"description", &ep.Description, m.Description, true},
+		// Thumbnail URLs change with every size and format, the artwork
+		// is replaced but not recorded.
+		{"thumbnail", &ep.Thumbnail, m.Thumbnail, false},
+	} {
+		if f.new == "" || f.new == *f.old {
+			continue
+		}
+		if *f.old != "" && *f.old != ep.VideoId && f.recorded {
+			ep.History = append(ep.History, MetadataChange{now, f.name, *f.old, f.new})
+			changed = true
+		}
+		*f.old = f.new
+	}
+	return changed
+}
+
+// fetchMetadata reads the current metadata of a video with yt-dlp.
+func fetchMetadata(ctx context.Context, feed *ConfFeed, videoId string) (episodeMetadata, error) {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, downloader, feedDownloaderArgs(feed, "--no-warnings",
+		"--skip-download", "--dump-json", "--", videoId)...)
+	out, err := runCommand(cmd, videoId, "metadata")
+	m := episodeMetadata{}
+	if err != nil {
+		if err := pipeline.RateLimitError(out); err != nil {
+			return m, err
+		}
+		if reason := pipeline.PermanentFailure(out); reason != "" {
+			return m, fmt.Errorf("%w: %s", pipeline.ErrPermanent, reason)
+		}
+		return m, fmt.Errorf("%v: %s", err, out)
+	}
+	return m, json.Unmarshal(out, &m)
+}
+
+// refreshMetadata reads the metadata of the downloaded episodes of a
+// feed not refreshed within maxAge from YouTube, updating channel.json
+// and the artwork of episodes whose thumbnail changed. It returns the
+// number of episodes changed.
+func refreshMetadata(ctx context.Context, feed *ConfFeed, maxAge time.Duration) (int, error) {
+	info := readChannelInfo(feed.ChannelId)
+	if info.ChannelId == "" {
+		return 0, nil
+	}
+	now := time.Now().UTC()
+	changed := 0
+	deleted := map[string]bool{}
+	for i := range info.Episodes {
+		ep := &info.Episodes[i]
+		if t, err := time.Parse(time.RFC3339, ep.Refreshed); err == nil && now.Sub(t) < maxAge {
+			continue
+		}
+		m, err := fetchMetadata(ctx, feed, ep.VideoId)
+		if errors.Is(err, pipeline.ErrPermanent) {
+			if removedUpstream(ctx, feed, ep, err, now) {
+				deleted[ep.VideoId] = true
+				changed++
+			} else if ep.Removed == "" {
+				ep.Removed = now.Format(time.RFC3339)
+				if err := setSidecarRemoved(feed.ChannelId, ep.VideoId, ep.Removed); err != nil {
+					log.Print(feed.Name, " ", ep.VideoId, " sidecar: ", err)
+				}
+				changed++
+			}
+			ep.Refreshed = now.Format(time.RFC3339)
+			continue
+		} else if err != nil {
+			log.Print(feed.Name, " ", ep.VideoId, " metadata: ", err)
+			continue
+		}
+		if ep.Removed != "" {
+			log.Printf("%s %s is back on YouTube", feed.Name, ep.VideoId)
+			ep.Removed = ""
+			changed++
+		}
+		thumbnail := ep.Thumbnail
+		if ep.setMetadata(m, now) {
+			log.Printf("%s %s metadata changed upstream", feed.Name, ep.VideoId)
+			changed++
+		}
+		if err := updateSidecar(feed.ChannelId, ep.VideoId, m); err != nil {
+			log.Print(feed.Name, " ", ep.VideoId, " sidecar: ", err)
+		}
+		if ep.Thumbnail != thumbnail && fileExists(artworkFileName(feed.ChannelId, ep.VideoId)) {
+			entry := &youtube.Entry{VideoId: ep.VideoId, Media: &youtube.Media{}}
+			entry.Media.Thumbnail.URL = ep.Thumbnail
+			if _, err := downloadArtwork(feed, entry); err != nil {
+				log.Print(feed.Name, " ", ep.VideoId, " artwork: ", err)
+			} else {
+				changed++
+			}
+		}
+		ep.Refreshed = now.Format(time.RFC3339)
+	}
+	kept := info.Episodes[:0]
+	for _, ep := range info.Episodes {
+		if !deleted[ep.VideoId] {
+			kept = append(kept, ep)
+		}
+	}
+	info.Episodes = kept
+	return changed, saveChannelInfo(&info)
+}
+
+// removedUpstream handles a downloaded episode whose video was deleted
+// or made private on YouTube, as on_removed of its feed says. It
+// reports whether the episode was deleted, kept episodes are noted as
+// removed by the caller.
+func removedUpstream(ctx context.Context, feed *ConfFeed, ep *ChannelEpisode, cause error, now time.Time) bool {
+	if feed.OnRemoved != "delete" {
+		if ep.Removed == "" {
+			log.Printf("%s %s removed from YouTube, kept: %v", feed.Name, ep.VideoId, cause)
+		}
+		return false
+	}
+	log.Printf("%s %s removed from YouTube, deleted: %v", feed.Name, ep.VideoId, cause)
+	if _, err := deleteEpisode(ctx, *feed, ep.VideoId, false); err != nil && !errors.Is(err, errEpisodeNotFound) {
+		log.Print(feed.Name, " ", ep.VideoId, " ", err)
+		return false
+	}
+	return true
+}
+
+// removedNote prefixes the description of an episode removed from
+// YouTube at time removed, if any, with a note.
+func removedNote(removed, description string) string {
+	t, err := time.Parse(time.RFC3339, removed)
+	if err != nil {
+		return description
+	}
+	note := "Removed from YouTube on " + t.Format("2006-01-02") + "."
+	if description == "" {
+		return note
+	}
+	return note + "\n\n" + description
+}
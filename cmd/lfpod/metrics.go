@@ -0,0 +1,201 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type metricDesc struct {
+	name string
+	typ  string
+	help string
+}
+
+// Metrics is a minimal registry rendered in the Prometheus text
+// exposition format. Series are keyed by metric name and label set.
+type Metrics struct {
+	mu     sync.Mutex
+	descs  []metricDesc
+	series map[string]map[string]float64
+}
+
+func newMetrics(descs ...metricDesc) *Metrics {
+	return &Metrics{descs: descs, series: map[string]map[string]float64{}}
+}
+
+func (m *Metrics) update(name, labels string, f func(float64) float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[name]
+	if !ok {
+		s = map[string]float64{}
+		m.series[name] = s
+	}
+	s[labels] = f(s[labels])
+}
+
+func (m *Metrics) Add(name, labels string, v float64) {
+	m.update(name, labels, func(old float64) float64 { return old + v })
+}
+
+func (m *Metrics) Set(name, labels string, v float64) {
+	m.update(name, labels, func(float64) float64 { return v })
+}
+
+// Observe records a sample of a summary without quantiles.
+func (m *Metrics) Observe(name, labels string, v float64) {
+	m.Add(name+"_sum", labels, v)
+	m.Add(name+"_count", labels, 1)
+}
+
+func (m *Metrics) Render(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range m.descs {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.typ)
+		names := []string{d.name}
+		if d.typ == "summary" {
+			names = []string{d.name + "_sum", d.name + "_count"}
+		}
+		for _, name := range names {
+			s := m.series[name]
+			keys := make([]string, 0, len(s))
+			for k := range s {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				v := strconv.FormatFloat(s[k], 'g', -1, 64)
+				if k == "" {
+					fmt.Fprintf(w, "%s %s\n", name, v)
+				} else {
+					fmt.Fprintf(w, "%s{%s} %s\n", name, k, v)
+				}
+			}
+		}
+	}
+}
+
+// labels formats label name/value pairs, e.g. labels("feed", "x").
+func labels(kv ...string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(kv); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(kv[i+1])
+		fmt.Fprintf(&b, "%s=\"%s\"", kv[i], v)
+	}
+	return b.String()
+}
+
+var metrics = newMetrics(
+	metricDesc{"lfpod_videos_discovered_total", "counter", "New videos found in YouTube feeds."},
+	metricDesc{"lfpod_downloads_succeeded_total", "counter", "Successful audio downloads."},
+	metricDesc{"lfpod_downloads_failed_total", "counter", "Failed audio downloads."},
+	metricDesc{"lfpod_recode_duration_seconds", "summary", "Time spent recoding audio."},
+	metricDesc{"lfpod_stored_bytes", "gauge", "Bytes of audio stored per channel."},
+	metricDesc{"lfpod_feed_requests_total", "counter", "Channel feed requests, changed or not_modified."},
+	metricDesc{"lfpod_feed_last_success_timestamp_seconds", "gauge", "Last successful update of a feed."},
+	metricDesc{"lfpod_catchup_remaining_videos", "gauge", "Videos left in a throttled catch-up."},
+	metricDesc{"lfpod_process_cpu_seconds_total", "counter", "CPU time of child processes per pipeline stage."},
+	metricDesc{"lfpod_process_wall_seconds", "summary", "Wall time of child processes per pipeline stage."},
+	metricDesc{"lfpod_process_max_rss_bytes", "gauge", "Peak memory of the last child process per pipeline stage."},
+	metricDesc{"lfpod_http_requests_total", "counter", "HTTP requests served."},
+	metricDesc{"lfpod_http_request_duration_seconds", "summary", "HTTP request latency."},
+	metricDesc{"lfpod_rate_limited_total", "counter", "Rate limits by YouTube starting a backoff."},
+	metricDesc{"lfpod_throttled_until_timestamp_seconds", "gauge", "End of the last rate limit backoff."},
+	metricDesc{"lfpod_probe_cache_hits_total", "counter", "Readiness checks answered by a recent probe instead of yt-dlp."},
+	metricDesc{"lfpod_integrity_checks_total", "counter", "Published audio files decoded by the integrity scan."},
+	metricDesc{"lfpod_corrupted_files_total", "counter", "Published audio files found corrupted and downloaded again."},
+	metricDesc{"lfpod_free_disk_bytes", "gauge", "Free space of the download and audio directories."},
+	metricDesc{"lfpod_low_disk_space_skips_total", "counter", "Downloads postponed for low disk space."},
+)
+
+func storedBytes(channelId string) int64 {
+	var total int64
+	filepath.WalkDir(filepath.Join("audio", channelId), func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+func metricsHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	for _, feed := range conf.AllFeeds() {
+		metrics.Set("lfpod_stored_bytes", labels("channel", feed.ChannelId), float64(storedBytes(feed.ChannelId)))
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.Render(w)
+}
+
+func metricsHandlerWrapper(conf *Conf) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		metricsHandler(conf, w, r)
+	}
+}
+
+// statusWriter records the status code and size of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Unwrap lets http.ResponseController reach Flush of the underlying
+// writer.
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		route := "unmatched"
+		if cr := mux.CurrentRoute(r); cr != nil {
+			if tpl, err := cr.GetPathTemplate(); err == nil {
+				route = tpl
+			}
+		}
+		if sw.status == 0 {
+			sw.status = http.StatusOK
+		}
+		metrics.Add("lfpod_http_requests_total", labels("method", r.Method, "route", route, "code", strconv.Itoa(sw.status)), 1)
+		metrics.Observe("lfpod_http_request_duration_seconds", labels("method", r.Method, "route", route), time.Since(start).Seconds())
+	})
+}
@@ -0,0 +1,107 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestLabels(t *testing.T) {
+	for _, tc := range []struct {
+		kv   []string
+		want string
+	}{
+		{nil, ""},
+		{[]string{"feed", "news"}, `feed="news"`},
+		{[]string{"method", "GET", "code", "200"}, `method="GET",code="200"`},
+		{[]string{"feed", `say "hi"\` + "\nagain"}, `feed="say \"hi\"\\\nagain"`},
+		// A name without a value is left out.
+		{[]string{"feed", "news", "stage"}, `feed="news"`},
+	} {
+		if got := labels(tc.kv...); got != tc.want {
+			t.Errorf("labels%q: %s, want %s", tc.kv, got, tc.want)
+		}
+	}
+}
+
+func TestMetricsRender(t *testing.T) {
+	m := newMetrics(
+		metricDesc{"test_total", "counter", "A counter."},
+		metricDesc{"test_bytes", "gauge", "A gauge."},
+		metricDesc{"test_seconds", "summary", "A summary."},
+	)
+	for _, tc := range []struct {
+		update func()
+		want   string
+	}{
+		{func() {}, "# HELP test_total A counter.\n# TYPE test_total counter\n" +
+			"# HELP test_bytes A gauge.\n# TYPE test_bytes gauge\n" +
+			"# HELP test_seconds A summary.\n# TYPE test_seconds summary\n"},
+		{func() {
+			m.Add("test_total", labels("feed", "b"), 1)
+			m.Add("test_total", labels("feed", "a"), 2)
+			m.Add("test_total", labels("feed", "b"), 1.5)
+			m.Set("test_bytes", "", 1e6)
+			m.Set("test_bytes", "", 2048)
+			m.Observe("test_seconds", labels("stage", "recode"), 1.25)
+			m.Observe("test_seconds", labels("stage", "recode"), 0.75)
+		}, "# HELP test_total A counter.\n# TYPE test_total counter\n" +
+			"test_total{feed=\"a\"} 2\ntest_total{feed=\"b\"} 2.5\n" +
+			"# HELP test_bytes A gauge.\n# TYPE test_bytes gauge\ntest_bytes 2048\n" +
+			"# HELP test_seconds A summary.\n# TYPE test_seconds summary\n" +
+			"test_seconds_sum{stage=\"recode\"} 2\ntest_seconds_count{stage=\"recode\"} 2\n"},
+	} {
+		tc.update()
+		// Series without a description are not rendered.
+		m.Add("test_unknown", "", 1)
+		if got := renderMetrics(m); got != tc.want {
+			t.Errorf("rendered\n%s\nwant\n%s", got, tc.want)
+		}
+	}
+}
+
+func renderMetrics(m *Metrics) string {
+	var b strings.Builder
+	m.Render(&b)
+	return b.String()
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	r := mux.NewRouter()
+	r.Use(metricsMiddleware)
+	r.HandleFunc("/feed/{name}", func(w http.ResponseWriter, r *http.Request) {}).Methods("GET")
+	r.HandleFunc("/missing", func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}).Methods("GET")
+	for _, tc := range []struct {
+		path, series string
+	}{
+		{"/feed/news", `lfpod_http_requests_total{method="GET",route="/feed/{name}",code="200"}`},
+		{"/feed/talks", `lfpod_http_requests_total{method="GET",route="/feed/{name}",code="200"}`},
+		{"/missing", `lfpod_http_requests_total{method="GET",route="/missing",code="404"}`},
+	} {
+		before := metricValue(tc.series)
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tc.path, nil))
+		if after := metricValue(tc.series); after != before+1 {
+			t.Errorf("%s: %s went from %g to %g", tc.path, tc.series, before, after)
+		}
+	}
+	if metricValue(`lfpod_http_request_duration_seconds_count{method="GET",route="/feed/{name}"}`) < 2 {
+		t.Error("request durations not observed")
+	}
+}
+
+// metricValue returns the value of a series of the global metrics, 0 if
+// it has none.
+func metricValue(series string) float64 {
+	name, labels, _ := strings.Cut(strings.TrimSuffix(series, "}"), "{")
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+	return metrics.series[name][labels]
+}
@@ -0,0 +1,320 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net/http"
+	"net/smtp"
+	neturl "net/url"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Notification kinds notifiers subscribe to.
+const (
+	NotifyEpisode     = "episode"
+	NotifyFeedFailure = "feed_failure"
+)
+
+// A feed failure is notified after this many failed updates in a row
+// by default.
+const defaultNotifyFeedFailures = 3
+
+var telegramAPI = "https://api.telegram.org"
+
+// Notifier sends a message when an episode is published or a feed
+// keeps failing.
+type Notifier struct {
+	// Type is webhook, ntfy, telegram or email.
+	Type string `json:"type"`
+	// Kinds of notifications sent, episode and feed_failure, all if
+	// empty.
+	Events []string `json:"events,omitempty"`
+	// URL of the webhook or the ntfy topic.
+	URL string `json:"url,omitempty"`
+	// Telegram bot token and chat.
+	BotToken string `json:"bot_token,omitempty"`
+	ChatId   string `json:"chat_id,omitempty"`
+	// The telegram bot also takes video and channel links and commands
+	// from the chat.
+	Bot bool `json:"bot,omitempty"`
+	// SMTP server host:port, credentials and addresses of email.
+	SMTPServer string   `json:"smtp_server,omitempty"`
+	Username   string   `json:"username,omitempty"`
+	Password   string   `json:"password,omitempty"`
+	From       string   `json:"from,omitempty"`
+	To         []string `json:"to,omitempty"`
+	// Templates of the message of a Notification, the webhook body is
+	// the notification as JSON if not set.
+	EpisodeTemplate     string `json:"episode_template,omitempty"`
+	FeedFailureTemplate string `json:"feed_failure_template,omitempty"`
+	// Feed failures are notified after this many failed updates in a
+	// row, defaultNotifyFeedFailures if 0.
+	FeedFailures int `json:"feed_failures,omitempty"`
+
+	templates map[string]*template.Template
+}
+
+// Notification is the data of notification templates and webhook
+// bodies.
+type Notification struct {
+	Event     string    `json:"event"`
+	Time      time.Time `json:"time"`
+	Feed      string    `json:"feed"`
+	ChannelId string    `json:"channel_id"`
+	VideoId   string    `json:"video_id,omitempty"`
+	Title     string    `json:"title,omitempty"`
+	// Video page, enclosure and feed URLs.
+	URL      string `json:"url,omitempty"`
+	AudioURL string `json:"audio_url,omitempty"`
+	FeedURL  string `json:"feed_url"`
+	Failures int    `json:"failures,omitempty"`
+}
+
+var defaultNotifyTemplates = map[string]string{
+	NotifyEpisode:     "New episode of {{.Feed}}: {{.Title}}\n{{.URL}}",
+	NotifyFeedFailure: "Feed {{.Feed}} failed {{.Failures}} updates in a row",
+}
+
+func (n *Notifier) init() error {
+	switch n.Type {
+	case "webhook", "ntfy":
+		if n.URL == "" {
+			return fmt.Errorf("%s notifier: url is required", n.Type)
+		}
+	case "telegram":
+		if n.BotToken == "" || n.ChatId == "" {
+			return fmt.Errorf("telegram notifier: bot_token and chat_id are required")
+		}
+	case "email":
+		if n.SMTPServer == "" || n.From == "" || len(n.To) == 0 {
+			return fmt.Errorf("email notifier: smtp_server, from and to are required")
+		}
+	default:
+		return fmt.Errorf("unknown notifier type %q", n.Type)
+	}
+	if n.Bot && n.Type != "telegram" {
+		return fmt.Errorf("%s notifier: bot needs a telegram notifier", n.Type)
+	}
+	for _, kind := range n.Events {
+		if _, ok := defaultNotifyTemplates[kind]; !ok {
+			return fmt.Errorf("%s notifier: unknown event %q", n.Type, kind)
+		}
+	}
+	n.templates = map[string]*template.Template{}
+	for kind, text := range map[string]string{NotifyEpisode: n.EpisodeTemplate, NotifyFeedFailure: n.FeedFailureTemplate} {
+		if text == "" {
+			if n.Type == "webhook" {
+				continue
+			}
+			text = defaultNotifyTemplates[kind]
+		}
+		t, err := template.New(kind).Parse(text)
+		if err != nil {
+			return fmt.Errorf("%s notifier: %v", n.Type, err)
+		}
+		n.templates[kind] = t
+	}
+	return nil
+}
+
+func (n *Notifier) wants(kind string, failures int) bool {
+	if kind == NotifyFeedFailure {
+		threshold := n.FeedFailures
+		if threshold == 0 {
+			threshold = defaultNotifyFeedFailures
+		}
+		// Once per failure streak.
+		if failures != threshold {
+			return false
+		}
+	}
+	if len(n.Events) == 0 {
+		return true
+	}
+	for _, k := range n.Events {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *Notifier) send(kind string, msg Notification) error {
+	text := ""
+	if t := n.templates[kind]; t != nil {
+		buf := &bytes.Buffer{}
+		if err := t.Execute(buf, msg); err != nil {
+			return err
+		}
+		text = buf.String()
+	}
+	subject := msg.Feed + ": " + msg.Title
+	if kind == NotifyFeedFailure {
+		subject = msg.Feed + " failing"
+	}
+	switch n.Type {
+	case "webhook":
+		if text == "" {
+			data, err := json.Marshal(msg)
+			if err != nil {
+				return err
+			}
+			return notifyPost(n.URL, "application/json", data, nil)
+		}
+		return notifyPost(n.URL, "text/plain; charset=utf-8", []byte(text), nil)
+	case "ntfy":
+		header := http.Header{"Title": {subject}}
+		if msg.URL != "" {
+			header.Set("Click", msg.URL)
+		}
+		if kind == NotifyFeedFailure {
+			header.Set("Tags", "warning")
+		}
+		return notifyPost(n.URL, "text/plain; charset=utf-8", []byte(text), header)
+	case "telegram":
+		data, err := json.Marshal(map[string]string{"chat_id": n.ChatId, "text": text})
+		if err != nil {
+			return err
+		}
+		return notifyPost(telegramAPI+"/bot"+n.BotToken+"/sendMessage", "application/json", data, nil)
+	case "email":
+		return n.sendMail(subject, text)
+	}
+	return nil
+}
+
+func (n *Notifier) sendMail(subject, text string) error {
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, _ := strings.Cut(n.SMTPServer, ":")
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+	msg := &bytes.Buffer{}
+	fmt.Fprintf(msg, "From: %s\r\nTo: %s\r\n", n.From, strings.Join(n.To, ", "))
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.BEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(text, "\n", "\r\n"))
+	return smtp.SendMail(n.SMTPServer, auth, n.From, n.To, msg.Bytes())
+}
+
+func notifyPost(url, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Content-Type", contentType)
+	// Errors name the host only, Telegram URLs contain the bot token.
+	res, err := fetchClient.Do(req)
+	if err != nil {
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("%s: %v", req.URL.Host, err)
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("%s: %s", req.URL.Host, res.Status)
+	}
+	return nil
+}
+
+// Notifications queued for sending, more are dropped if the notifiers
+// do not keep up.
+const notifyQueueSize = 64
+
+type notifyItem struct {
+	notifier *Notifier
+	kind     string
+	msg      Notification
+}
+
+var notifyQueue = make(chan notifyItem, notifyQueueSize)
+
+var notifyPending sync.WaitGroup
+
+var notifySender sync.Once
+
+// startNotifiers subscribes the notifiers of the configuration to the
+// event bus until the returned function is called. Notifications are
+// sent in the background, in order.
+func startNotifiers(conf *Conf) (func(), error) {
+	notifiers := []*Notifier{}
+	for i := range conf.Notify {
+		n := &conf.Notify[i]
+		if err := n.init(); err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, n)
+	}
+	if len(notifiers) == 0 {
+		return func() {}, nil
+	}
+	notifySender.Do(func() {
+		go func() {
+			for item := range notifyQueue {
+				if err := item.notifier.send(item.kind, item.msg); err != nil {
+					log.Printf("%s notification: %v", item.notifier.Type, err)
+				}
+				notifyPending.Done()
+			}
+		}()
+	})
+	return events.Subscribe(func(e Event) {
+		kind := ""
+		switch e.Type {
+		case EventDownloadFinished:
+			kind = NotifyEpisode
+		case EventFeedFailed:
+			kind = NotifyFeedFailure
+		default:
+			return
+		}
+		msg := newNotification(conf, e)
+		for _, n := range notifiers {
+			if !n.wants(kind, e.Count) {
+				continue
+			}
+			notifyPending.Add(1)
+			select {
+			case notifyQueue <- notifyItem{n, kind, msg}:
+			default:
+				notifyPending.Done()
+				log.Printf("%s notification of %s dropped", n.Type, e.Type)
+			}
+		}
+	}), nil
+}
+
+// waitNotifications waits for queued notifications to be sent.
+func waitNotifications() {
+	notifyPending.Wait()
+}
+
+func newNotification(conf *Conf, e Event) Notification {
+	msg := Notification{Event: e.Type, Time: e.Time, Feed: e.Feed, ChannelId: e.ChannelId,
+		VideoId: e.VideoId, Title: e.Title, FeedURL: conf.URL("feed", e.Feed), Failures: e.Count}
+	if e.VideoId != "" {
+		msg.URL = "https://www.youtube.com/watch?v=" + e.VideoId
+		if feed, ok := conf.GetFeed(e.ChannelId); ok {
+			if name, _, ok := feed.FindAudioFile(e.VideoId); ok {
+				msg.AudioURL = audioURL(conf, name)
+			}
+		}
+	}
+	return msg
+}
@@ -0,0 +1,435 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lfpod/store"
+)
+
+type object = map[string]interface{}
+
+func schemaRef(name string) object {
+	return object{"$ref": "#/components/schemas/" + name}
+}
+
+func arrayOf(name string) object {
+	return object{"type": "array", "items": schemaRef(name)}
+}
+
+var apiSchemas = object{
+	"Feed": object{
+		"type":     "object",
+		"required": []string{"name", "channel_id"},
+		"properties": object{
+			"name":       object{"type": "string"},
+			"channel_id": object{"type": "string"},
+			"keywords":   object{"type": "array", "items": object{"type": "string"}},
+			"slug": object{"type": "string", "pattern": "^[a-z0-9]+(-[a-z0-9]+)*$",
+				"description": "Name of the feed in feed and audio URLs instead of the channel id."},
+			"inbox": object{"type": "boolean",
+				"description": "Publish the videos of the -inbox file instead of a channel."},
+			"disabled": object{"type": "boolean",
+				"description": "Stop polling the channel, its episodes are still served."},
+			"ignore_older_than": object{"type": "integer",
+				"description": "Skip videos older than this many days unless backfilling."},
+			"keep_episodes": object{"type": "integer", "description": "Keep at most this many newest episodes."},
+			"max_age":       object{"type": "integer", "description": "Delete episodes downloaded more than this many days ago."},
+			"max_storage":   object{"type": "integer", "description": "Keep the newest episodes up to this many MB."},
+			"max_file_size": object{"type": "integer", "description": "Skip videos whose download is larger than this many MB."},
+			"priority_keywords": object{"type": "array", "items": object{"type": "string"},
+				"description": "Videos matching these keywords are downloaded first."},
+			"match": object{"type": "string",
+				"description": "Boolean expression of words selecting videos with keywords, e.g. interview AND NOT shorts."},
+			"match_description": object{"type": "boolean",
+				"description": "Search keywords and match in the description too."},
+			"format":       object{"type": "string", "enum": []string{"opus", "caf", "m4a"}},
+			"media":        object{"type": "string", "enum": mediaTypes, "description": "Publish audio, or small MP4 videos."},
+			"video_height": object{"type": "integer", "description": "Maximum height of videos in pixels, 360 if 0."},
+			"record_live": object{"type": "boolean",
+				"description": "Record live streams of the channel while they run."},
+			"keep_original": object{"type": "boolean",
+				"description": "Keep the download before recoding, served from /original/{feed}/{video id}."},
+			"alternate_bitrates": object{"type": "array", "items": object{"type": "integer", "minimum": minAlternateBitrate, "maximum": maxAlternateBitrate},
+				"description": "Also publish episodes at these bitrates in kbit/s as alternate enclosures."},
+			"music_bitrate": object{"type": "integer", "minimum": minAlternateBitrate, "maximum": maxAlternateBitrate,
+				"description": "Recode episodes detected as music at this bitrate in kbit/s."},
+			"cookies":              object{"type": "string", "description": "yt-dlp cookies file, set in the configuration file only."},
+			"cookies_from_browser": object{"type": "string", "description": "Browser to load yt-dlp cookies from, set in the configuration file only."},
+			"proxy":                object{"type": "string", "description": "HTTP or SOCKS proxy for this channel, set in the configuration file only."},
+			"geo_country":          object{"type": "string", "description": "Country code yt-dlp fakes to bypass geo restrictions."},
+			"geo_verification_proxy": object{"type": "string",
+				"description": "Proxy yt-dlp verifies the location of region-locked videos with, set in the configuration file only."},
+			"sponsorblock": object{"type": "array",
+				"items":       object{"type": "string", "enum": sponsorBlockCategories},
+				"description": "SponsorBlock segment categories cut from the audio."},
+			"downloader_args": object{"type": "array", "items": object{"type": "string"},
+				"description": "Extra yt-dlp arguments for downloads, set in the configuration file only."},
+			"pinned": object{"type": "array", "items": object{"type": "string"},
+				"description": "Video ids never deleted by the pinned garbage collection strategy."},
+			"audio_filters": object{"type": "string",
+				"description": "ffmpeg filter graph applied when recoding, set in the configuration file only."},
+			"converter_args": object{"type": "array", "items": object{"type": "string"},
+				"description": "Extra ffmpeg output arguments, set in the configuration file only."},
+			"speed": object{"type": "number", "minimum": 0.25, "maximum": 4,
+				"description": "Playback speed factor episodes are pre-accelerated to."},
+			"loudness": object{"type": "number",
+				"description": "Target loudness in LUFS, e.g. -16, the global one if 0."},
+			"transcribe": object{"type": "boolean",
+				"description": "Transcribe episodes with whisper.cpp."},
+			"transcript_language": object{"type": "string",
+				"description": "Transcript language code, detected if empty."},
+			"captions": object{"type": "boolean",
+				"description": "Publish the YouTube captions of episodes."},
+			"caption_languages": object{"type": "array", "items": object{"type": "string"},
+				"description": "yt-dlp language codes of captions, en if empty."},
+			"trim_silence": object{"type": "number", "maximum": 0,
+				"description": "Trim leading and trailing silence below this many dB, 0 to keep it."},
+			"weight": object{"type": "integer", "minimum": 0,
+				"description": "Share of the combined feed when channels are interleaved, 1 if 0."},
+			"title":  object{"type": "string", "description": "Podcast title, the channel title if empty."},
+			"author": object{"type": "string", "description": "Podcast author, the channel author if empty."},
+			"on_removed": object{"type": "string", "enum": []string{"keep", "delete"},
+				"description": "What to do with episodes whose video was deleted or made private on YouTube, keep if empty."},
+		},
+	},
+	"FeedList": arrayOf("Feed"),
+	"Episode": object{
+		"type": "object",
+		"properties": object{
+			"channel_id":  object{"type": "string"},
+			"video_id":    object{"type": "string"},
+			"title":       object{"type": "string"},
+			"description": object{"type": "string"},
+			"published":   object{"type": "string", "format": "date-time"},
+			"size":        object{"type": "integer", "description": "Audio file size in bytes."},
+			"duration":    object{"type": "number", "description": "Audio duration in seconds."},
+			"status": object{"type": "string", "enum": []string{
+				store.StatusNotReady, store.StatusDownloading, store.StatusRecoding, store.StatusReady, store.StatusFailed, store.StatusDeleted}},
+			"updated": object{"type": "string", "format": "date-time"},
+			"usage": object{
+				"type":                 "object",
+				"description":          "Child process resource usage per pipeline stage.",
+				"additionalProperties": schemaRef("ProcUsage"),
+			},
+			"seq":   object{"type": "integer", "description": "Sequence number of the last change."},
+			"added": object{"type": "boolean", "description": "Added to the feed on its own, not from the channel feed."},
+		},
+	},
+	"ProcUsage": object{
+		"type": "object",
+		"properties": object{
+			"runs":     object{"type": "integer"},
+			"wall_sec": object{"type": "number"},
+			"user_sec": object{"type": "number"},
+			"sys_sec":  object{"type": "number"},
+			"max_rss":  object{"type": "integer", "description": "Peak resident memory in bytes."},
+		},
+	},
+	"EpisodeList": arrayOf("Episode"),
+	"UpdateStatus": object{
+		"type": "object",
+		"properties": object{
+			"channel":  object{"type": "string"},
+			"backfill": object{"type": "boolean"},
+			"refresh":  object{"type": "boolean"},
+			"queued":   object{"type": "boolean"},
+		},
+	},
+	"AddedVideo": object{
+		"type": "object",
+		"properties": object{
+			"video_id": object{"type": "string"},
+			"feed":     object{"type": "string"},
+			"channel":  object{"type": "string"},
+		},
+	},
+	"Redownload": object{
+		"type": "object",
+		"properties": object{
+			"video_id": object{"type": "string"},
+			"feed":     object{"type": "string"},
+			"channel":  object{"type": "string"},
+			"deleted":  object{"type": "boolean", "description": "An audio file was deleted."},
+		},
+	},
+	"ShareLink": object{
+		"type": "object",
+		"properties": object{
+			"url":     object{"type": "string"},
+			"expires": object{"type": "string", "format": "date-time"},
+		},
+	},
+	"ChannelCandidate": object{
+		"type": "object",
+		"properties": object{
+			"channel_id":     object{"type": "string"},
+			"title":          object{"type": "string"},
+			"url":            object{"type": "string"},
+			"recent_uploads": object{"type": "array", "items": object{"type": "string"}},
+		},
+	},
+	"ChannelCandidateList": arrayOf("ChannelCandidate"),
+	"PauseStatus": object{
+		"type": "object",
+		"properties": object{
+			"paused": object{"type": "boolean"},
+			"until":  object{"type": "string", "format": "date-time"},
+		},
+	},
+	"StatusJob": object{
+		"type": "object",
+		"properties": object{
+			"feed":     object{"type": "string"},
+			"video_id": object{"type": "string"},
+			"title":    object{"type": "string"},
+			"started":  object{"type": "string", "format": "date-time"},
+		},
+	},
+	"FeedStatus": object{
+		"type": "object",
+		"properties": object{
+			"name":         object{"type": "string"},
+			"channel_id":   object{"type": "string"},
+			"disabled":     object{"type": "boolean"},
+			"last_success": object{"type": "string", "format": "date-time", "description": "Last time the channel feed was read."},
+			"last_error":   object{"type": "string"},
+			"error_time":   object{"type": "string", "format": "date-time"},
+			"next_poll":    object{"type": "string", "format": "date-time", "description": "Next poll of a feed with a schedule."},
+			"consecutive_errors": object{"type": "integer",
+				"description": "Failed reads of the channel feed since the last successful one."},
+		},
+	},
+	"FeedStats": object{
+		"type": "object",
+		"properties": object{
+			"name":               object{"type": "string"},
+			"channel_id":         object{"type": "string"},
+			"disabled":           object{"type": "boolean"},
+			"last_success":       object{"type": "string", "format": "date-time", "description": "Last time the channel feed was read."},
+			"last_episode":       object{"type": "string", "format": "date-time", "description": "Time the newest episode was downloaded."},
+			"episodes":           object{"type": "integer", "description": "Number of downloaded episodes."},
+			"size":               object{"type": "integer", "description": "Bytes of the audio directory of the channel."},
+			"consecutive_errors": object{"type": "integer", "description": "Failed reads of the channel feed since the last successful one."},
+			"last_error":         object{"type": "string"},
+			"error_time":         object{"type": "string", "format": "date-time"},
+			"next_poll":          object{"type": "string", "format": "date-time", "description": "Next scheduled or periodic poll."},
+		},
+	},
+	"Status": object{
+		"type": "object",
+		"properties": object{
+			"running":         object{"type": "boolean"},
+			"paused":          object{"type": "boolean"},
+			"started":         object{"type": "string", "format": "date-time", "description": "Start of the running update."},
+			"last_update":     object{"type": "string", "format": "date-time", "description": "End of the last update."},
+			"throttled_until": object{"type": "string", "format": "date-time", "description": "End of the backoff after YouTube rate limited lfpod."},
+			"quiet_until":     object{"type": "string", "format": "date-time", "description": "End of the quiet hours downloads are deferred to."},
+			"throttle_reason": object{"type": "string"},
+			"active":          arrayOf("StatusJob"),
+			"queued":          arrayOf("StatusJob"),
+			"feeds":           arrayOf("FeedStatus"),
+		},
+	},
+	"SyncEpisode": object{
+		"allOf": []object{schemaRef("Episode"), {
+			"type": "object",
+			"properties": object{
+				"url": object{"type": "string", "description": "Audio file URL of ready episodes."},
+			},
+		}},
+	},
+	"SyncResult": object{
+		"type": "object",
+		"properties": object{
+			"cursor": object{"type": "string", "description": "Pass as since to get later changes."},
+			"reset": object{"type": "boolean",
+				"description": "The cursor expired on restart, all known episodes are listed."},
+			"episodes": arrayOf("SyncEpisode"),
+		},
+	},
+	"ArchiveFile": object{
+		"type": "object",
+		"properties": object{
+			"channel_id": object{"type": "string"},
+			"video_id":   object{"type": "string"},
+			"path":       object{"type": "string"},
+			"size":       object{"type": "integer"},
+			"modified":   object{"type": "string", "format": "date-time"},
+			"plays":      object{"type": "integer"},
+			"pinned":     object{"type": "boolean"},
+		},
+	},
+	"GCReport": object{
+		"type": "object",
+		"properties": object{
+			"strategy": object{"type": "string", "enum": gcStrategyNames},
+			"total":    object{"type": "integer", "description": "Archive size in bytes."},
+			"limit":    object{"type": "integer", "description": "Storage limit in bytes, 0 for no limit."},
+			"freed":    object{"type": "integer"},
+			"delete":   arrayOf("ArchiveFile"),
+		},
+	},
+	"GCReportList": arrayOf("GCReport"),
+	"PlayStats": object{
+		"type": "object",
+		"properties": object{
+			"channel_id": object{"type": "string"},
+			"video_id":   object{"type": "string"},
+			"downloads":  object{"type": "integer", "description": "Completed downloads of the audio file."},
+			"last":       object{"type": "string", "format": "date-time"},
+		},
+	},
+	"FeedPlays": object{
+		"type": "object",
+		"properties": object{
+			"name":       object{"type": "string"},
+			"channel_id": object{"type": "string"},
+			"downloads":  object{"type": "integer"},
+			"episodes":   object{"type": "integer", "description": "Episodes downloaded at least once."},
+			"last":       object{"type": "string", "format": "date-time"},
+		},
+	},
+	"PlayReport": object{
+		"type": "object",
+		"properties": object{
+			"feeds":    arrayOf("FeedPlays"),
+			"episodes": arrayOf("PlayStats"),
+		},
+	},
+	"Version": object{
+		"type": "object",
+		"properties": object{
+			"version": object{"type": "string"},
+			"commit":  object{"type": "string", "description": "VCS revision, -dirty if built with local changes."},
+			"date":    object{"type": "string", "description": "Build or commit time."},
+			"go":      object{"type": "string"},
+		},
+	},
+	"BlockedVideo": object{
+		"type": "object",
+		"properties": object{
+			"channel_id": object{"type": "string"},
+			"video_id":   object{"type": "string"},
+			"title":      object{"type": "string"},
+			"reason":     object{"type": "string"},
+			"time":       object{"type": "string", "format": "date-time"},
+		},
+	},
+	"BlockedVideoList": arrayOf("BlockedVideo"),
+	"WebSubSubscription": object{
+		"type": "object",
+		"properties": object{
+			"channel_id": object{"type": "string"},
+			"state":      object{"type": "string", "enum": []string{"requested", "verified", "failed"}},
+			"requested":  object{"type": "string", "format": "date-time"},
+			"expires":    object{"type": "string", "format": "date-time", "description": "Lease end of a verified subscription."},
+			"notified":   object{"type": "string", "format": "date-time", "description": "Time of the last notification received."},
+			"error":      object{"type": "string"},
+		},
+	},
+	"WebSubSubscriptionList": arrayOf("WebSubSubscription"),
+	"ProfileSecret": object{
+		"type": "object",
+		"properties": object{
+			"secret":   object{"type": "string"},
+			"feed_url": object{"type": "string"},
+		},
+	},
+	"Error": object{
+		"type": "object",
+		"properties": object{
+			"error": object{
+				"type":     "object",
+				"required": []string{"code", "message", "retryable"},
+				"properties": object{
+					"code":      object{"type": "string"},
+					"message":   object{"type": "string"},
+					"details":   object{},
+					"retryable": object{"type": "boolean"},
+				},
+			},
+		},
+	},
+}
+
+var pathParamRegexp = regexp.MustCompile(`{([^}]+)}`)
+
+func jsonContent(schema object) object {
+	return object{"application/json": object{"schema": schema}}
+}
+
+// openAPIDocument builds an OpenAPI 3 document from the API route table.
+func openAPIDocument() object {
+	paths := object{}
+	for _, route := range apiRoutes(nil) {
+		params := []object{}
+		for _, m := range pathParamRegexp.FindAllStringSubmatch(route.Path, -1) {
+			params = append(params, object{
+				"name": m[1], "in": "path", "required": true,
+				"schema": object{"type": "string"},
+			})
+		}
+		for _, q := range route.Query {
+			params = append(params, object{
+				"name": q.Name, "in": "query", "description": q.Description,
+				"schema": object{"type": "string"},
+			})
+		}
+		response := object{"description": http.StatusText(route.Status)}
+		if route.Response != "" {
+			response["content"] = jsonContent(schemaRef(route.Response))
+		}
+		op := object{
+			"summary": route.Summary,
+			"responses": object{
+				strconv.Itoa(route.Status): response,
+				"default": object{
+					"description": "Error",
+					"content":     jsonContent(schemaRef("Error")),
+				},
+			},
+		}
+		if len(params) > 0 {
+			op["parameters"] = params
+		}
+		if route.Request != "" {
+			op["requestBody"] = object{
+				"required": true,
+				"content":  jsonContent(schemaRef(route.Request)),
+			}
+		}
+		path := "/api" + route.Path
+		item, ok := paths[path].(object)
+		if !ok {
+			item = object{}
+			paths[path] = item
+		}
+		item[strings.ToLower(route.Method)] = op
+	}
+	return object{
+		"openapi": "3.0.3",
+		"info": object{
+			"title":   "lfpod management API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": object{
+			"schemas": apiSchemas,
+			"securitySchemes": object{
+				"bearer": object{"type": "http", "scheme": "bearer"},
+				"basic":  object{"type": "http", "scheme": "basic"},
+			},
+		},
+		"security": []object{{"bearer": []string{}}, {"basic": []string{}}},
+	}
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, openAPIDocument())
+}
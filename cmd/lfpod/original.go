@@ -0,0 +1,89 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// originalPrefix returns the start of the file name of the download of
+// a video kept before recoding, <video id>.orig.<ext of the download>.
+// Like parts it is not an audio file to the archive scans.
+func originalPrefix(videoId string) string {
+	return videoId + ".orig"
+}
+
+// findOriginal returns the kept download of a video.
+func findOriginal(channelId, videoId string) (string, bool) {
+	names, _ := filepath.Glob(filepath.Join("audio", channelId, originalPrefix(videoId)+"*"))
+	if len(names) == 0 {
+		return "", false
+	}
+	return names[0], true
+}
+
+// keepOriginal moves the download of a video into the channel audio
+// directory, copying it if the download directory is on another file
+// system.
+func keepOriginal(feed *ConfFeed, videoId, fileDown string) error {
+	removeOriginal(feed.ChannelId, videoId)
+	name := filepath.Join("audio", feed.ChannelId, originalPrefix(videoId)+filepath.Ext(fileDown))
+	if err := os.Rename(fileDown, name); err == nil {
+		return nil
+	}
+	defer os.Remove(fileDown)
+	in, err := os.Open(fileDown)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.CreateTemp(filepath.Dir(name), videoId+".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), name)
+	}
+	if err != nil {
+		os.Remove(out.Name())
+	}
+	return err
+}
+
+// removeOriginal deletes the kept download of a video.
+func removeOriginal(channelId, videoId string) {
+	if name, ok := findOriginal(channelId, videoId); ok {
+		os.Remove(name)
+	}
+}
+
+// originalHandler serves the kept download of an episode as an
+// attachment.
+func originalHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	videoId := vars["videoId"]
+	// Profile feeds need their token, their originals are not public.
+	feed, ok := findFeed(conf.GetFeeds(), vars["name"])
+	if !ok || strings.ContainsAny(videoId, `/\.*?[`) {
+		http.NotFound(w, r)
+		return
+	}
+	name, ok := findOriginal(feed.ChannelId, videoId)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Disposition", `attachment; filename="`+videoId+filepath.Ext(name)+`"`)
+	http.ServeFile(w, r, name)
+}
@@ -0,0 +1,67 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Raw downloads are kept in this directory until recoded.
+const downloadDir = "downloads"
+
+// cleanupOrphans deletes intermediate files left by a pipeline that
+// died midway: raw downloads, temporary recodes and state files.
+// Partial downloads of videos queued for retry are kept to be resumed.
+// It runs before each update, when no job of the update passes is in
+// flight; the files of live streams still being recorded are kept.
+func cleanupOrphans(ctx context.Context, conf *Conf) {
+	orphans := []string{}
+	if entries, err := os.ReadDir(downloadDir); err == nil {
+		for _, e := range entries {
+			if videoId, _, _ := strings.Cut(e.Name(), "."); !retries.Has(videoId) && !isRecording(videoId) {
+				orphans = append(orphans, filepath.Join(downloadDir, e.Name()))
+			}
+		}
+	}
+	for _, feed := range conf.AllFeeds() {
+		entries, err := os.ReadDir(filepath.Join("audio", feed.ChannelId))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if videoId, _, _ := strings.Cut(name, "."); isRecording(videoId) {
+				continue
+			}
+			if strings.Contains(name, ".tmp-") || strings.HasSuffix(name, ".tmp") {
+				orphans = append(orphans, filepath.Join("audio", feed.ChannelId, name))
+			}
+		}
+	}
+	// Before the download directory, raw downloads and recodes went to
+	// the working directory, named by video id.
+	if entries, err := os.ReadDir("."); err == nil {
+		known := map[string]bool{}
+		for _, ep := range listEpisodes(ctx, "") {
+			known[ep.VideoId] = true
+		}
+		for _, e := range entries {
+			videoId, _, _ := strings.Cut(e.Name(), ".")
+			if e.Type().IsRegular() && known[videoId] && !isRecording(videoId) {
+				orphans = append(orphans, e.Name())
+			}
+		}
+	}
+	for _, name := range orphans {
+		if err := os.RemoveAll(name); err != nil {
+			log.Print(err)
+			continue
+		}
+		log.Print("deleted orphaned ", name)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lfpod/pipeline"
+)
+
+// Local address outbound connections are bound to, empty for default.
+var sourceAddress string
+
+// IP version outbound connections are restricted to, "4", "6" or empty.
+var ipVersion string
+
+// Resolver for outbound connections, nil for the system resolver.
+var outboundLookup lookupFunc
+
+// HTTP or SOCKS proxy for outbound connections, overridden by feed
+// settings. The proxy environment variables apply if empty.
+var proxyURL string
+
+// Timeout of requests for channel feeds, thumbnails and the like.
+var fetchTimeout = 3 * time.Second
+
+var fetchClient = newFetchClient(proxyURL)
+
+// Clients of feeds with their own proxy, by proxy URL.
+var proxyClients = struct {
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}{clients: map[string]*http.Client{}}
+
+// parseProxy checks a proxy URL, yt-dlp and net/http both understand
+// these schemes.
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("proxy %q: scheme must be http, https, socks5 or socks5h", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy %q: no host", s)
+	}
+	return u, nil
+}
+
+// feedClient returns the HTTP client for requests made on behalf of a
+// feed.
+func feedClient(feed *ConfFeed) *http.Client {
+	if feed == nil || feed.Proxy == "" {
+		return fetchClient
+	}
+	proxyClients.mu.Lock()
+	defer proxyClients.mu.Unlock()
+	client, ok := proxyClients.clients[feed.Proxy]
+	if !ok {
+		client = newFetchClient(feed.Proxy)
+		proxyClients.clients[feed.Proxy] = client
+	}
+	return client
+}
+
+// resolveSourceAddress accepts either an IP address or a network
+// interface name and returns the IP address to bind to.
+func resolveSourceAddress(s string) (string, error) {
+	if s == "" || net.ParseIP(s) != nil {
+		return s, nil
+	}
+	iface, err := net.InterfaceByName(s)
+	if err != nil {
+		return "", err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			return ipnet.IP.String(), nil
+		}
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLinkLocalUnicast() {
+			return ipnet.IP.String(), nil
+		}
+	}
+	return "", fmt.Errorf("interface %s has no usable address", s)
+}
+
+func newFetchClient(proxy string) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if sourceAddress != "" {
+		dialer.LocalAddr = &net.TCPAddr{IP: net.ParseIP(sourceAddress)}
+	}
+	dial := dialer.DialContext
+	if outboundLookup != nil {
+		dial = dialContext(dialer, outboundLookup)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		if ipVersion != "" {
+			network = "tcp" + ipVersion
+		}
+		return dial(ctx, network, address)
+	}
+	if u, err := parseProxy(proxy); err == nil {
+		transport.Proxy = http.ProxyURL(u)
+	}
+	return &http.Client{
+		Transport: transport,
+		Timeout:   fetchTimeout,
+	}
+}
+
+// downloaderArgs prepends options common to every downloader invocation.
+func downloaderArgs(args ...string) []string {
+	common := []string{}
+	if sourceAddress != "" {
+		common = append(common, "--source-address", sourceAddress)
+	}
+	if ipVersion != "" {
+		common = append(common, "--force-ipv"+ipVersion)
+	}
+	if proxyURL != "" {
+		common = append(common, "--proxy", proxyURL)
+	}
+	return append(common, args...)
+}
+
+// Extra yt-dlp arguments for all downloads.
+var downloaderExtraArgs []string
+
+// downloadRates limits the rate of all yt-dlp downloads.
+var downloadRates = &pipeline.Rates{}
+
+// Downloads whose files have not grown for this long are stopped, the
+// next attempt continues the partial download.
+var downloadStallTimeout = 5 * time.Minute
+
+// Global cookies options, overridden by feed settings.
+var cookiesFile, cookiesFromBrowser string
+
+// Geo bypass countries are ISO 3166-1 alpha-2 codes.
+var countryCodeRegexp = regexp.MustCompile(`^[A-Za-z]{2}$`)
+
+// feedDownloaderArgs adds feed-specific options to downloaderArgs.
+func feedDownloaderArgs(feed *ConfFeed, args ...string) []string {
+	extra := []string{}
+	cookies, browser := cookiesFile, cookiesFromBrowser
+	if feed.Cookies != "" || feed.CookiesFromBrowser != "" {
+		cookies, browser = feed.Cookies, feed.CookiesFromBrowser
+	}
+	if cookies != "" {
+		extra = append(extra, "--cookies", cookies)
+	}
+	if browser != "" {
+		extra = append(extra, "--cookies-from-browser", browser)
+	}
+	// The last --proxy wins.
+	if feed.Proxy != "" {
+		extra = append(extra, "--proxy", feed.Proxy)
+	}
+	if feed.GeoCountry != "" {
+		extra = append(extra, "--geo-bypass-country", strings.ToUpper(feed.GeoCountry))
+	}
+	if feed.GeoVerificationProxy != "" {
+		extra = append(extra, "--geo-verification-proxy", feed.GeoVerificationProxy)
+	}
+	return downloaderArgs(append(extra, args...)...)
+}
@@ -0,0 +1,35 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGeoBypass(t *testing.T) {
+	feed := ConfFeed{Name: "test", ChannelId: testChannelId, Proxy: "socks5://10.0.0.2:1080",
+		GeoCountry: "de", GeoVerificationProxy: "http://10.0.0.3:3128"}
+	if msg := validateFeed(feed); msg != "" {
+		t.Fatal(msg)
+	}
+	args := strings.Join(feedDownloaderArgs(&feed, "--", "vid00000001"), " ")
+	for _, want := range []string{
+		"--proxy socks5://10.0.0.2:1080",
+		"--geo-bypass-country DE",
+		"--geo-verification-proxy http://10.0.0.3:3128",
+	} {
+		if !strings.Contains(args, want) {
+			t.Errorf("yt-dlp arguments %q lack %s", args, want)
+		}
+	}
+	for _, f := range []ConfFeed{
+		{Name: "test", ChannelId: testChannelId, GeoCountry: "Germany"},
+		{Name: "test", ChannelId: testChannelId, GeoVerificationProxy: "ftp://10.0.0.3"},
+	} {
+		if validateFeed(f) == "" {
+			t.Errorf("feed %+v accepted", f)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Pause stops downloading and probing while the server keeps serving
+// existing feeds and audio. A zero until means pause until resumed.
+type Pause struct {
+	mu     sync.Mutex
+	paused bool
+	until  time.Time
+}
+
+var pause Pause
+
+func (p *Pause) Set(until time.Time) {
+	p.mu.Lock()
+	p.paused, p.until = true, until
+	p.mu.Unlock()
+	if until.IsZero() {
+		log.Print("updates paused")
+	} else {
+		log.Print("updates paused until ", until.Format(time.RFC3339))
+	}
+}
+
+func (p *Pause) Resume() {
+	p.mu.Lock()
+	p.paused, p.until = false, time.Time{}
+	p.mu.Unlock()
+	log.Print("updates resumed")
+}
+
+// Active reports whether updates are paused, resuming automatically
+// once the pause deadline has passed.
+func (p *Pause) Active() bool {
+	p.mu.Lock()
+	expired := p.paused && !p.until.IsZero() && time.Now().After(p.until)
+	p.mu.Unlock()
+	if expired {
+		p.Resume()
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.paused
+}
+
+func (p *Pause) Until() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.until
+}
+
+// parsePauseUntil accepts either an RFC 3339 timestamp or a duration
+// relative to now, e.g. "72h". An empty string pauses indefinitely.
+func parsePauseUntil(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid pause time %q", s)
+	}
+	return time.Now().Add(d), nil
+}
+
+type PauseStatus struct {
+	Paused bool       `json:"paused"`
+	Until  *time.Time `json:"until,omitempty"`
+}
+
+func pauseHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		until, err := parsePauseUntil(r.FormValue("until"))
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err.Error(), nil)
+			return
+		}
+		pause.Set(until)
+	case http.MethodDelete:
+		pause.Resume()
+	}
+	status := PauseStatus{Paused: pause.Active()}
+	if until := pause.Until(); status.Paused && !until.IsZero() {
+		status.Until = &until
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		log.Print(err)
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/gorilla/mux"
+)
+
+// Generated placeholder artwork is cached here.
+const placeholderDir = "artwork"
+
+const placeholderSize = 600
+
+// 5x7 bitmap glyphs of placeholder initials.
+var placeholderFont = map[rune][7]string{
+	'A': {".###.", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'B': {"####.", "#...#", "#...#", "####.", "#...#", "#...#", "####."},
+	'C': {".###.", "#...#", "#....", "#....", "#....", "#...#", ".###."},
+	'D': {"####.", "#...#", "#...#", "#...#", "#...#", "#...#", "####."},
+	'E': {"#####", "#....", "#....", "####.", "#....", "#....", "#####"},
+	'F': {"#####", "#....", "#....", "####.", "#....", "#....", "#...."},
+	'G': {".###.", "#...#", "#....", "#.###", "#...#", "#...#", ".####"},
+	'H': {"#...#", "#...#", "#...#", "#####", "#...#", "#...#", "#...#"},
+	'I': {".###.", "..#..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'J': {"..###", "...#.", "...#.", "...#.", "...#.", "#..#.", ".##.."},
+	'K': {"#...#", "#..#.", "#.#..", "##...", "#.#..", "#..#.", "#...#"},
+	'L': {"#....", "#....", "#....", "#....", "#....", "#....", "#####"},
+	'M': {"#...#", "##.##", "#.#.#", "#.#.#", "#...#", "#...#", "#...#"},
+	'N': {"#...#", "#...#", "##..#", "#.#.#", "#..##", "#...#", "#...#"},
+	'O': {".###.", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'P': {"####.", "#...#", "#...#", "####.", "#....", "#....", "#...."},
+	'Q': {".###.", "#...#", "#...#", "#...#", "#.#.#", "#..#.", ".##.#"},
+	'R': {"####.", "#...#", "#...#", "####.", "#.#..", "#..#.", "#...#"},
+	'S': {".####", "#....", "#....", ".###.", "....#", "....#", "####."},
+	'T': {"#####", "..#..", "..#..", "..#..", "..#..", "..#..", "..#.."},
+	'U': {"#...#", "#...#", "#...#", "#...#", "#...#", "#...#", ".###."},
+	'V': {"#...#", "#...#", "#...#", "#...#", "#...#", ".#.#.", "..#.."},
+	'W': {"#...#", "#...#", "#...#", "#.#.#", "#.#.#", "#.#.#", ".#.#."},
+	'X': {"#...#", "#...#", ".#.#.", "..#..", ".#.#.", "#...#", "#...#"},
+	'Y': {"#...#", "#...#", ".#.#.", "..#..", "..#..", "..#..", "..#.."},
+	'Z': {"#####", "....#", "...#.", "..#..", ".#...", "#....", "#####"},
+	'0': {".###.", "#...#", "#..##", "#.#.#", "##..#", "#...#", ".###."},
+	'1': {"..#..", ".##..", "..#..", "..#..", "..#..", "..#..", ".###."},
+	'2': {".###.", "#...#", "....#", "...#.", "..#..", ".#...", "#####"},
+	'3': {"#####", "...#.", "..#..", "...#.", "....#", "#...#", ".###."},
+	'4': {"...#.", "..##.", ".#.#.", "#..#.", "#####", "...#.", "...#."},
+	'5': {"#####", "#....", "####.", "....#", "....#", "#...#", ".###."},
+	'6': {"..##.", ".#...", "#....", "####.", "#...#", "#...#", ".###."},
+	'7': {"#####", "....#", "...#.", "..#..", ".#...", ".#...", ".#..."},
+	'8': {".###.", "#...#", "#...#", ".###.", "#...#", "#...#", ".###."},
+	'9': {".###.", "#...#", "#...#", ".####", "....#", "...#.", ".##.."},
+}
+
+// Latin letters standing in for Cyrillic initials.
+var cyrillicInitials = map[rune]rune{
+	'А': 'A', 'Б': 'B', 'В': 'V', 'Г': 'G', 'Д': 'D', 'Е': 'E', 'Ё': 'E',
+	'Ж': 'Z', 'З': 'Z', 'И': 'I', 'Й': 'I', 'К': 'K', 'Л': 'L', 'М': 'M',
+	'Н': 'N', 'О': 'O', 'П': 'P', 'Р': 'R', 'С': 'S', 'Т': 'T', 'У': 'U',
+	'Ф': 'F', 'Х': 'H', 'Ц': 'C', 'Ч': 'C', 'Ш': 'S', 'Щ': 'S', 'Ы': 'Y',
+	'Э': 'E', 'Ю': 'U', 'Я': 'Y',
+}
+
+// initials returns up to two initials of title that have glyphs.
+func initials(title string) []rune {
+	words := strings.FieldsFunc(title, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	letters := []rune{}
+	for _, w := range words {
+		r := unicode.ToUpper([]rune(w)[0])
+		if l, ok := cyrillicInitials[r]; ok {
+			r = l
+		}
+		if _, ok := placeholderFont[r]; ok {
+			letters = append(letters, r)
+		}
+		if len(letters) == 2 {
+			break
+		}
+	}
+	return letters
+}
+
+// Dark background colors, so white initials stay readable.
+var placeholderColors = []color.RGBA{
+	{0xc6, 0x28, 0x28, 0xff}, {0xad, 0x14, 0x57, 0xff}, {0x6a, 0x1b, 0x9a, 0xff},
+	{0x45, 0x27, 0xa0, 0xff}, {0x28, 0x35, 0x93, 0xff}, {0x15, 0x65, 0xc0, 0xff},
+	{0x02, 0x77, 0xbd, 0xff}, {0x00, 0x83, 0x8f, 0xff}, {0x00, 0x69, 0x5c, 0xff},
+	{0x2e, 0x7d, 0x32, 0xff}, {0xef, 0x6c, 0x00, 0xff}, {0x4e, 0x34, 0x2e, 0xff},
+}
+
+func hashString(s string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(s))
+	return h.Sum32()
+}
+
+// placeholderImage draws the initials of title on a color derived from
+// key.
+func placeholderImage(key, title string) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, placeholderSize, placeholderSize))
+	bg := placeholderColors[hashString(key)%uint32(len(placeholderColors))]
+	for i := 0; i < len(img.Pix); i += 4 {
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2], img.Pix[i+3] = bg.R, bg.G, bg.B, bg.A
+	}
+	letters := initials(title)
+	if len(letters) == 0 {
+		return img
+	}
+	// Glyphs are 5 columns wide with a column between them, the text
+	// takes 60% of the width.
+	cols := 6*len(letters) - 1
+	scale := placeholderSize * 3 / 5 / cols
+	if scale > placeholderSize/2/7 {
+		scale = placeholderSize / 2 / 7
+	}
+	x0 := (placeholderSize - cols*scale) / 2
+	y0 := (placeholderSize - 7*scale) / 2
+	for i, letter := range letters {
+		for row, line := range placeholderFont[letter] {
+			for col, c := range line {
+				if c != '#' {
+					continue
+				}
+				for y := 0; y < scale; y++ {
+					for x := 0; x < scale; x++ {
+						img.Set(x0+(6*i+col)*scale+x, y0+row*scale+y, color.White)
+					}
+				}
+			}
+		}
+	}
+	return img
+}
+
+// placeholderFile returns the cached placeholder of key, generating it
+// if needed. The file name includes a hash of the title, so renamed
+// feeds get new initials.
+func placeholderFile(key, title string) (string, error) {
+	name := filepath.Join(placeholderDir, fmt.Sprintf("%s-%08x.png", key, hashString(title)))
+	if fileExists(name) {
+		return name, nil
+	}
+	if err := os.MkdirAll(placeholderDir, 0750); err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp(placeholderDir, ".placeholder-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	err = png.Encode(f, placeholderImage(key, title))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return "", err
+	}
+	return name, os.Rename(f.Name(), name)
+}
+
+// Key and title of the combined feed placeholder.
+const combinedPlaceholder, combinedTitle = "lfpod", "low-fi podcast"
+
+// placeholderURL returns the placeholder artwork URL of a channel, or of
+// the combined feed if channelId is empty.
+func placeholderURL(conf *Conf, channelId string) string {
+	if channelId == "" {
+		channelId = combinedPlaceholder
+	}
+	return conf.URL("artwork", channelId+".png")
+}
+
+func placeholderHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	key, title := mux.Vars(r)["name"], combinedTitle
+	if key != combinedPlaceholder {
+		feed, ok := conf.GetFeed(key)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		title = feed.Title()
+	}
+	name, err := placeholderFile(key, title)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	http.ServeFile(w, r, name)
+}
@@ -0,0 +1,238 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PlayStats are the completed downloads of an audio file.
+type PlayStats struct {
+	ChannelId string    `json:"channel_id"`
+	VideoId   string    `json:"video_id"`
+	Downloads int       `json:"downloads"`
+	Last      time.Time `json:"last"`
+}
+
+// PlayCounter counts completed downloads of audio files, saved to a
+// file if set.
+type PlayCounter struct {
+	mu     sync.Mutex
+	file   string
+	dirty  bool
+	counts map[string]*PlayStats
+}
+
+var plays = PlayCounter{counts: map[string]*PlayStats{}}
+
+func (p *PlayCounter) Load(file string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.file = file
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	list := []*PlayStats{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	for _, s := range list {
+		p.counts[s.VideoId] = s
+	}
+	return nil
+}
+
+// Save writes the counts to the file they were loaded from, if any
+// changed since.
+func (p *PlayCounter) Save() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.file == "" || !p.dirty {
+		return
+	}
+	data, err := json.MarshalIndent(p.list(), "", "    ")
+	if err == nil {
+		fileTmp := p.file + ".tmp"
+		if err = os.WriteFile(fileTmp, append(data, '\n'), 0640); err == nil {
+			err = os.Rename(fileTmp, p.file)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	p.dirty = false
+}
+
+// saveEvery saves the counts periodically, downloads come in between
+// updates.
+func (p *PlayCounter) saveEvery(interval time.Duration) {
+	for range time.Tick(interval) {
+		p.Save()
+	}
+}
+
+func (p *PlayCounter) Add(channelId, videoId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s, ok := p.counts[videoId]
+	if !ok {
+		s = &PlayStats{ChannelId: channelId, VideoId: videoId}
+		p.counts[videoId] = s
+	}
+	s.Downloads++
+	s.Last = time.Now().UTC()
+	p.dirty = true
+}
+
+func (p *PlayCounter) Get(videoId string) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if s, ok := p.counts[videoId]; ok {
+		return s.Downloads
+	}
+	return 0
+}
+
+// list returns the counts, most downloaded first.
+func (p *PlayCounter) list() []PlayStats {
+	list := []PlayStats{}
+	for _, s := range p.counts {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Downloads != list[j].Downloads {
+			return list[i].Downloads > list[j].Downloads
+		}
+		return list[i].VideoId < list[j].VideoId
+	})
+	return list
+}
+
+func (p *PlayCounter) List() []PlayStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.list()
+}
+
+// completionWriter records what a handler wrote.
+type completionWriter struct {
+	http.ResponseWriter
+	status  int
+	written int64
+}
+
+func (w *completionWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *completionWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	return n, err
+}
+
+// completed reports whether a response delivered the end of a file: a
+// whole file, the last range of one fetched in ranges, or a redirect to
+// the storage the file is served from.
+func (w *completionWriter) completed() bool {
+	switch {
+	case w.status == http.StatusOK:
+		length, err := strconv.ParseInt(w.Header().Get("Content-Length"), 10, 64)
+		return err != nil || w.written == length
+	case w.status == http.StatusPartialContent:
+		var first, last, size int64
+		if _, err := fmt.Sscanf(w.Header().Get("Content-Range"), "bytes %d-%d/%d", &first, &last, &size); err != nil {
+			return false
+		}
+		return last == size-1 && w.written == last-first+1
+	}
+	return w.status == http.StatusFound
+}
+
+// countPlays counts completed downloads of audio files, players fetching
+// files in ranges are counted once, at their end.
+func countPlays(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(r.URL.Path)
+		videoId, _, _ := strings.Cut(name, ".")
+		ext := strings.TrimPrefix(path.Ext(name), ".")
+		if r.Method != http.MethodGet || (!containsString(audioFormatNames, ext) && ext != transcodeExt) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &completionWriter{ResponseWriter: w}
+		next.ServeHTTP(cw, r)
+		if cw.completed() {
+			plays.Add(path.Base(path.Dir(r.URL.Path)), videoId)
+		}
+	})
+}
+
+// FeedPlays are the downloads of the episodes of a feed.
+type FeedPlays struct {
+	Name      string `json:"name"`
+	ChannelId string `json:"channel_id"`
+	Downloads int    `json:"downloads"`
+	// Episodes downloaded at least once.
+	Episodes int        `json:"episodes"`
+	Last     *time.Time `json:"last,omitempty"`
+}
+
+// PlayReport is the download analytics of the feeds and episodes.
+type PlayReport struct {
+	Feeds    []FeedPlays `json:"feeds"`
+	Episodes []PlayStats `json:"episodes"`
+}
+
+// playReport sums up the downloads of each configured feed, feeds
+// nobody downloads from included.
+func playReport(conf *Conf) PlayReport {
+	report := PlayReport{Feeds: []FeedPlays{}, Episodes: plays.List()}
+	byChannel := map[string]int{}
+	for _, feed := range conf.AllFeeds() {
+		if _, ok := byChannel[feed.ChannelId]; ok {
+			continue
+		}
+		byChannel[feed.ChannelId] = len(report.Feeds)
+		report.Feeds = append(report.Feeds, FeedPlays{Name: feed.Name, ChannelId: feed.ChannelId})
+	}
+	for _, s := range report.Episodes {
+		i, ok := byChannel[s.ChannelId]
+		if !ok {
+			continue
+		}
+		f := &report.Feeds[i]
+		f.Downloads += s.Downloads
+		f.Episodes++
+		if last := s.Last; f.Last == nil || last.After(*f.Last) {
+			f.Last = &last
+		}
+	}
+	return report
+}
+
+func apiStatsHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, playReport(conf))
+}
@@ -0,0 +1,28 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/gorilla/mux"
+)
+
+// addDebugRoutes serves the runtime profiles of net/http/pprof under
+// /debug/pprof and the memory statistics of expvar at /debug/vars, for
+// the management API token holders only.
+func addDebugRoutes(r *mux.Router, basePath string) {
+	debug := r.PathPrefix("/debug").Subrouter()
+	debug.Use(authMiddleware)
+	debug.HandleFunc("/pprof/cmdline", pprof.Cmdline)
+	debug.HandleFunc("/pprof/profile", pprof.Profile)
+	debug.HandleFunc("/pprof/symbol", pprof.Symbol)
+	debug.HandleFunc("/pprof/trace", pprof.Trace)
+	// pprof.Index serves the other profiles by path without the base
+	// path.
+	debug.PathPrefix("/pprof/").Handler(http.StripPrefix(basePath, http.HandlerFunc(pprof.Index)))
+	debug.Handle("/vars", expvar.Handler()).Methods("GET")
+}
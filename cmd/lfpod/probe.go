@@ -0,0 +1,149 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// Metadata of a video ready for download is reused this long, e.g.
+	// by the retry of a failed download.
+	probeReadyMaxAge = 6 * time.Hour
+	// Live streams and upcoming videos without a release time are
+	// probed again at most this often.
+	probeLiveInterval = time.Hour
+	// Premieres further out are probed again after a quarter of the
+	// time left, at most this long, to notice when they are moved.
+	probeUpcomingMaxInterval = 24 * time.Hour
+	// Probes of videos not seen for this long are forgotten.
+	probeForgetAge = 30 * 24 * time.Hour
+)
+
+// ProbeState is the last readiness probe of a video.
+type ProbeState struct {
+	ChannelId string `json:"channel_id"`
+	VideoId   string `json:"video_id"`
+	// First and last time the video was probed.
+	Seen    time.Time       `json:"seen"`
+	Checked time.Time       `json:"checked"`
+	Status  string          `json:"status"`
+	Meta    episodeMetadata `json:"metadata"`
+}
+
+// recheck returns when probing the video again may tell something new.
+func (s *ProbeState) recheck() time.Time {
+	switch s.Status {
+	case "not_live", "was_live":
+		return s.Checked.Add(probeReadyMaxAge)
+	}
+	if _, available := s.Meta.ready(); !available.IsZero() {
+		ttl := available.Sub(s.Checked) / 4
+		if ttl > probeUpcomingMaxInterval {
+			ttl = probeUpcomingMaxInterval
+		}
+		if ttl >= probeLiveInterval {
+			return s.Checked.Add(ttl)
+		}
+		return available
+	}
+	return s.Checked.Add(probeLiveInterval)
+}
+
+// Probes keeps the readiness probes of videos not downloaded yet, so
+// yt-dlp is not run for a video each update when its state cannot have
+// changed, like a premiere hours away. It is saved to a file if set.
+type Probes struct {
+	mu    sync.Mutex
+	file  string
+	items map[string]*ProbeState
+}
+
+var probes = Probes{items: map[string]*ProbeState{}}
+
+func (p *Probes) Load(file string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.file = file
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	items := []*ProbeState{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		p.items[item.VideoId] = item
+	}
+	return nil
+}
+
+func (p *Probes) save() {
+	if p.file == "" {
+		return
+	}
+	items := []*ProbeState{}
+	for id, item := range p.items {
+		if time.Since(item.Checked) > probeForgetAge {
+			delete(p.items, id)
+			continue
+		}
+		items = append(items, item)
+	}
+	data, err := json.MarshalIndent(items, "", "    ")
+	if err == nil {
+		fileTmp := p.file + ".tmp"
+		if err = os.WriteFile(fileTmp, append(data, '\n'), 0640); err == nil {
+			err = os.Rename(fileTmp, p.file)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// Fresh returns the last probe of a video, if probing it again at now
+// cannot tell anything new.
+func (p *Probes) Fresh(videoId string, now time.Time) (ProbeState, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	item, ok := p.items[videoId]
+	if !ok || !now.Before(item.recheck()) {
+		return ProbeState{}, false
+	}
+	metrics.Add("lfpod_probe_cache_hits_total", "", 1)
+	return *item, true
+}
+
+// Record saves the outcome of probing a video and returns it.
+func (p *Probes) Record(channelId, videoId string, m episodeMetadata, now time.Time) ProbeState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	item, ok := p.items[videoId]
+	if !ok {
+		item = &ProbeState{ChannelId: channelId, VideoId: videoId, Seen: now}
+		p.items[videoId] = item
+	}
+	item.Checked, item.Status, item.Meta = now, m.LiveStatus, m
+	p.save()
+	return *item
+}
+
+// Forget drops the probe of a downloaded video.
+func (p *Probes) Forget(videoId string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.items[videoId]; ok {
+		delete(p.items, videoId)
+		p.save()
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lfpod/store"
+)
+
+// Slots limiting the number of concurrently running child processes,
+// nil for no limit.
+var procSlots chan struct{}
+
+func setMaxProcs(n int) {
+	if n > 0 {
+		procSlots = make(chan struct{}, n)
+	} else {
+		procSlots = nil
+	}
+}
+
+// Priority of child processes, so a batch of recodes does not make the
+// host unusable: nice value and I/O scheduling class and level, see
+// ioprio_set(2), unchanged if 0.
+var (
+	procNice    int
+	procIOClass int
+	procIOLevel int
+)
+
+// Threads of ffmpeg recodes and analyses, ffmpeg picks if 0.
+var converterThreads int
+
+const (
+	ioClassBestEffort = 2
+	ioClassIdle       = 3
+)
+
+// parseIOPriority parses an I/O priority: idle, best-effort or
+// best-effort:<level> with level 0, the highest, to 7.
+func parseIOPriority(s string) (class, level int, err error) {
+	name, levelStr, hasLevel := strings.Cut(s, ":")
+	switch name {
+	case "":
+		return 0, 0, nil
+	case "idle":
+		if hasLevel {
+			return 0, 0, fmt.Errorf("I/O priority %q: idle has no level", s)
+		}
+		return ioClassIdle, 0, nil
+	case "best-effort":
+		level = 4
+		if hasLevel {
+			if level, err = strconv.Atoi(levelStr); err != nil || level < 0 || level > 7 {
+				return 0, 0, fmt.Errorf("I/O priority %q: level 0 to 7 expected", s)
+			}
+		}
+		return ioClassBestEffort, level, nil
+	}
+	return 0, 0, fmt.Errorf("I/O priority %q: idle or best-effort[:level] expected", s)
+}
+
+// threadArgs returns the ffmpeg arguments limiting its threads.
+func threadArgs() []string {
+	if converterThreads <= 0 {
+		return nil
+	}
+	return []string{"-threads", strconv.Itoa(converterThreads)}
+}
+
+// runCommand runs cmd once a process slot is available and returns its
+// combined output, or only its errors if cmd.Stdout is set. Resource
+// usage is accounted to the pipeline stage of the video, if any.
+func runCommand(cmd *exec.Cmd, videoId, stage string) ([]byte, error) {
+	return runCommandStarted(cmd, videoId, stage, nil)
+}
+
+// runCommandStarted is runCommand calling started, if not nil, once cmd
+// started.
+func runCommandStarted(cmd *exec.Cmd, videoId, stage string, started func()) ([]byte, error) {
+	if procSlots != nil {
+		procSlots <- struct{}{}
+		defer func() { <-procSlots }()
+	}
+	loopHealth.ProcStarted()
+	defer loopHealth.ProcDone()
+	start := time.Now()
+	var buf bytes.Buffer
+	// Commands streaming their output get only the errors buffered.
+	if cmd.Stdout == nil {
+		cmd.Stdout = &buf
+	}
+	cmd.Stderr = &buf
+	err := cmd.Start()
+	if err == nil {
+		if started != nil {
+			started()
+		}
+		// Children started from here on, like the ffmpeg of yt-dlp,
+		// inherit the priority.
+		if procNice != 0 || procIOClass != 0 {
+			if err := setProcPriority(cmd.Process.Pid); err != nil {
+				log.Print(cmd.Path, " priority: ", err)
+			}
+		}
+		err = cmd.Wait()
+	}
+	out := buf.Bytes()
+	if cmd.ProcessState != nil {
+		usage := store.Usage{
+			Runs:    1,
+			WallSec: time.Since(start).Seconds(),
+			UserSec: cmd.ProcessState.UserTime().Seconds(),
+			SysSec:  cmd.ProcessState.SystemTime().Seconds(),
+			MaxRSS:  maxRSS(cmd.ProcessState),
+		}
+		l := labels("stage", stage)
+		metrics.Add("lfpod_process_cpu_seconds_total", l, usage.UserSec+usage.SysSec)
+		metrics.Observe("lfpod_process_wall_seconds", l, usage.WallSec)
+		metrics.Set("lfpod_process_max_rss_bytes", l, float64(usage.MaxRSS))
+		if videoId != "" {
+			// Usage is accounted for commands stopped by a cancelled
+			// context too, it is not bound to theirs.
+			logStore(episodes.AddUsage(context.Background(), videoId, stage, usage))
+		}
+	}
+	return out, err
+}
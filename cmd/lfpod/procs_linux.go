@@ -0,0 +1,34 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// maxRSS returns peak resident memory of a finished process in bytes.
+func maxRSS(state *os.ProcessState) int64 {
+	if rusage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		return rusage.Maxrss * 1024
+	}
+	return 0
+}
+
+// setProcPriority lowers the CPU and I/O priority of a process.
+func setProcPriority(pid int) error {
+	if procNice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, pid, procNice); err != nil {
+			return err
+		}
+	}
+	if procIOClass != 0 {
+		const ioprioWhoProcess, ioprioClassShift = 1, 13
+		prio := procIOClass<<ioprioClassShift | procIOLevel
+		if _, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoProcess, uintptr(pid), uintptr(prio)); errno != 0 {
+			return errno
+		}
+	}
+	return nil
+}
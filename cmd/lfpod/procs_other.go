@@ -0,0 +1,19 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+func maxRSS(state *os.ProcessState) int64 {
+	return 0
+}
+
+func setProcPriority(pid int) error {
+	return errors.New("process priority is supported on Linux only")
+}
@@ -0,0 +1,46 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestProcPriority(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Linux only")
+	}
+	t.Cleanup(func() { procNice, procIOClass, procIOLevel, converterThreads = 0, 0, 0, 0 })
+	procNice = 7
+	procIOClass, procIOLevel, _ = parseIOPriority("best-effort:6")
+	// cat starts after the priority is set.
+	out, err := runCommand(exec.Command("sh", "-c", "sleep 0.2; cat /proc/self/stat"), "", "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The nice value is the 19th field, after the command in parentheses.
+	fields := strings.Fields(string(out[bytes.LastIndexByte(out, ')')+1:]))
+	if len(fields) < 17 || fields[16] != "7" {
+		t.Errorf("child process stat %q, want nice 7", out)
+	}
+
+	for s, want := range map[string][2]int{"": {0, 0}, "idle": {ioClassIdle, 0}, "best-effort": {ioClassBestEffort, 4}, "best-effort:0": {ioClassBestEffort, 0}} {
+		if class, level, err := parseIOPriority(s); err != nil || [2]int{class, level} != want {
+			t.Errorf("I/O priority %q parsed as %d:%d, %v", s, class, level, err)
+		}
+	}
+	for _, s := range []string{"realtime", "idle:3", "best-effort:8"} {
+		if _, _, err := parseIOPriority(s); err == nil {
+			t.Errorf("I/O priority %q accepted", s)
+		}
+	}
+	converterThreads = 2
+	if args := threadArgs(); strings.Join(args, " ") != "-threads 2" {
+		t.Errorf("thread arguments %q", args)
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// Profile is a named set of feeds with a feed URL of its own, for people
+// sharing an lfpod server. Profile feeds are updated along with the
+// others, audio is kept per channel, so a channel followed by several
+// profiles is downloaded and stored once.
+type Profile struct {
+	Name  string `json:"name"`
+	Title string `json:"title,omitempty"`
+	// Token required as the token query parameter of profile feed
+	// requests, the profile feeds are public if empty.
+	Token string `json:"token,omitempty"`
+	// Secrets of the capability URLs of the profile, /t/<secret>/feed
+	// and the audio below it, for podcast apps without Basic auth. Links
+	// use the first, the others stay valid until removed.
+	Secrets []string   `json:"secrets,omitempty"`
+	Feeds   []ConfFeed `json:"ytfeeds"`
+}
+
+// Capability URL secrets are at least this long, so they cannot be
+// guessed.
+const minSecretLength = 16
+
+var profileNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// FeedTitle returns the title of the combined feed of the profile.
+func (p *Profile) FeedTitle() string {
+	if p.Title != "" {
+		return p.Title
+	}
+	return combinedTitle + " - " + p.Name
+}
+
+// checkProfiles reports profiles with invalid or duplicate names, and
+// short or duplicate secrets.
+func checkProfiles(profiles []Profile) error {
+	names := map[string]bool{}
+	secrets := map[string]bool{}
+	for _, p := range profiles {
+		if !profileNameRegexp.MatchString(p.Name) {
+			return fmt.Errorf("invalid profile name %q, letters, digits, - and _ only", p.Name)
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate profile %q", p.Name)
+		}
+		names[p.Name] = true
+		for _, secret := range p.Secrets {
+			if len(secret) < minSecretLength || strings.ContainsAny(secret, "/?#%") {
+				return fmt.Errorf("profile %s: secrets must be at least %d characters, without /?#%%", p.Name, minSecretLength)
+			}
+			if secrets[secret] {
+				return fmt.Errorf("profile %s: secret used twice", p.Name)
+			}
+			secrets[secret] = true
+		}
+	}
+	return nil
+}
+
+// GetProfile returns a profile by name.
+func (c *Conf) GetProfile(name string) (Profile, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, p := range c.Profiles {
+		if p.Name == name {
+			p.Feeds = append([]ConfFeed(nil), p.Feeds...)
+			return p, true
+		}
+	}
+	return Profile{}, false
+}
+
+// ProfileBySecret returns the profile of a capability URL secret.
+func (c *Conf) ProfileBySecret(secret string) (Profile, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, p := range c.Profiles {
+		for _, s := range p.Secrets {
+			if subtle.ConstantTimeCompare([]byte(secret), []byte(s)) == 1 {
+				p.Feeds = append([]ConfFeed(nil), p.Feeds...)
+				return p, true
+			}
+		}
+	}
+	return Profile{}, false
+}
+
+// RotateSecret sets a new random secret of a profile and saves the
+// configuration, the previous secrets stay valid if keep is true.
+func (c *Conf) RotateSecret(name string, keep bool) (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(b)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	confFeeds := c.cloneFeeds()
+	for i := range confFeeds.Profiles {
+		p := &confFeeds.Profiles[i]
+		if p.Name != name {
+			continue
+		}
+		secrets := []string{secret}
+		if keep {
+			secrets = append(secrets, p.Secrets...)
+		}
+		p.Secrets = secrets
+		if err := c.save(confFeeds); err != nil {
+			return "", err
+		}
+		return secret, nil
+	}
+	return "", errNoProfile
+}
+
+var errNoProfile = errors.New("profile not found")
+
+// hasChannel reports whether a channel is one of the profile feeds.
+func (p *Profile) hasChannel(channelId string) bool {
+	for _, feed := range p.Feeds {
+		if feed.ChannelId == channelId {
+			return true
+		}
+	}
+	return false
+}
+
+// profileAudioHandler serves the audio of the channels of a profile
+// below its feed URLs: /u/<name>/audio with the profile token and
+// /t/<secret>/audio.
+func profileAudioHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	var profile Profile
+	var ok bool
+	prefix := []string{"u", vars["user"], "audio"}
+	if secret := vars["secret"]; secret != "" {
+		profile, ok = conf.ProfileBySecret(secret)
+		prefix = []string{"t", secret, "audio"}
+	} else {
+		profile, ok = conf.GetProfile(vars["user"])
+		ok = ok && profile.authorized(r)
+	}
+	rest := strings.TrimPrefix(r.URL.Path, conf.BasePath+"/"+strings.Join(prefix, "/"))
+	file, _ := conf.resolveAudioPath(rest)
+	if !ok || !profile.hasChannel(audioChannel(file)) {
+		http.NotFound(w, r)
+		return
+	}
+	serveAudio(conf, w, r, prefix, rest)
+}
+
+// audioChannel returns the channel of an audio directory path,
+// /<channel id>/<file>.
+func audioChannel(file string) string {
+	channelId, _, _ := strings.Cut(strings.TrimPrefix(path.Clean("/"+file), "/"), "/")
+	return channelId
+}
+
+// public reports whether the profile has neither a token nor secrets,
+// so its audio is served under the public /audio/ like that of the
+// top-level feeds.
+func (p *Profile) public() bool {
+	return p.Token == "" && len(p.Secrets) == 0
+}
+
+// publicChannel reports whether the audio of a channel is served under
+// the public /audio/, that of the channels of the top-level feeds and
+// of public profiles. The audio of the other profiles is served below
+// their feed URLs only.
+func (c *Conf) publicChannel(channelId string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, feed := range c.Feeds {
+		if feed.ChannelId == channelId {
+			return true
+		}
+	}
+	for _, p := range c.Profiles {
+		if p.public() && p.hasChannel(channelId) {
+			return true
+		}
+	}
+	return false
+}
+
+var secretPathRegexp = regexp.MustCompile(`/t/[^/?]+`)
+
+// redactSecrets hides capability URL secrets in logged request URIs.
+func redactSecrets(uri string) string {
+	return secretPathRegexp.ReplaceAllString(uri, "/t/-")
+}
+
+func apiRotateSecretHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	secret, err := conf.RotateSecret(name, r.FormValue("keep") == "1" || r.FormValue("keep") == "true")
+	if errors.Is(err, errNoProfile) {
+		apiError(w, http.StatusNotFound, "profile not found", name)
+		return
+	}
+	if err != nil {
+		log.Print(err)
+		apiError(w, http.StatusInternalServerError, "cannot save configuration", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ProfileSecret{Secret: secret, FeedURL: conf.URL("t", secret, "feed")})
+}
+
+// ProfileSecret is a new capability URL secret of a profile.
+type ProfileSecret struct {
+	Secret  string `json:"secret"`
+	FeedURL string `json:"feed_url"`
+}
+
+// authorized reports whether a feed request carries the profile token.
+func (p *Profile) authorized(r *http.Request) bool {
+	if p.Token == "" {
+		return true
+	}
+	token := r.URL.Query().Get("token")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(p.Token)) == 1
+}
+
+// withQuery appends a query to a URL, u if query is empty.
+func withQuery(u, query string) string {
+	switch {
+	case query == "":
+		return u
+	case strings.Contains(u, "?"):
+		return u + "&" + query
+	}
+	return u + "?" + query
+}
@@ -0,0 +1,145 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// quietRange is a daily time range in minutes since midnight, local
+// time, wrapping around midnight if end is before start.
+type quietRange struct {
+	start, end int
+}
+
+func (r quietRange) contains(minute int) bool {
+	if r.start < r.end {
+		return r.start <= minute && minute < r.end
+	}
+	return minute >= r.start || minute < r.end
+}
+
+// parseQuietHours parses comma separated ranges like 07:00-23:00 or
+// 18:00-02:00.
+func parseQuietHours(s string) ([]quietRange, error) {
+	ranges := []quietRange{}
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		from, to, ok := strings.Cut(item, "-")
+		start, err1 := time.Parse("15:04", strings.TrimSpace(from))
+		end, err2 := time.Parse("15:04", strings.TrimSpace(to))
+		if !ok || err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("quiet hours %q: want HH:MM-HH:MM", item)
+		}
+		r := quietRange{start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute()}
+		if r.start == r.end {
+			return nil, fmt.Errorf("quiet hours %q: empty range", item)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// QuietHours are the hours the update loop only polls feeds, downloads
+// and recodes are deferred to the end of the quiet hours.
+type QuietHours struct {
+	mu     sync.Mutex
+	ranges []quietRange
+	// End of the quiet hours videos were deferred to, zero if none.
+	resume time.Time
+}
+
+var quiet = QuietHours{}
+
+// Set sets the quiet hours, none if s is empty.
+func (q *QuietHours) Set(s string) error {
+	ranges, err := parseQuietHours(s)
+	if err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.ranges = ranges
+	return nil
+}
+
+// Until returns the end of the quiet hours now is in, adjacent ranges
+// joined, false if downloads are allowed.
+func (q *QuietHours) Until(now time.Time) (time.Time, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	t, quiet := now, false
+	// Each range is entered once at most.
+	for range q.ranges {
+		minute := t.Hour()*60 + t.Minute()
+		next, ok := time.Time{}, false
+		for _, r := range q.ranges {
+			if !r.contains(minute) {
+				continue
+			}
+			day := t
+			if r.end <= minute {
+				day = t.AddDate(0, 0, 1)
+			}
+			end := time.Date(day.Year(), day.Month(), day.Day(), r.end/60, r.end%60, 0, 0, t.Location())
+			if !ok || end.After(next) {
+				next, ok = end, true
+			}
+		}
+		if !ok || !next.After(t) {
+			break
+		}
+		t, quiet = next, true
+	}
+	return t, quiet
+}
+
+// Defer records that videos wait for the end of the quiet hours.
+func (q *QuietHours) Defer(until time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.resume = until
+}
+
+// Resume returns when deferred videos are due, zero if none are.
+func (q *QuietHours) Resume() time.Time {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.resume
+}
+
+// Resumed clears the deferral once an update pass ran after it.
+func (q *QuietHours) Resumed(now time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if !q.resume.IsZero() && !now.Before(q.resume) {
+		q.resume = time.Time{}
+	}
+}
+
+// deferJobs queues the jobs of an update pass in quiet hours for their
+// end, keeping the failed attempts of retried videos. Priority videos
+// are not deferred, their jobs are returned to run now.
+func deferJobs(jobs []Job, until time.Time) []Job {
+	kept := []Job{}
+	for _, job := range jobs {
+		if job.Priority {
+			kept = append(kept, job)
+			continue
+		}
+		retries.Defer(job.Feed.ChannelId, job.Entry, until, "quiet hours")
+	}
+	if deferred := len(jobs) - len(kept); deferred > 0 {
+		quiet.Defer(until)
+		log.Printf("quiet hours until %s, %d videos deferred", until.Format("15:04"), deferred)
+	}
+	return kept
+}
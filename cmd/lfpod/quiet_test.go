@@ -0,0 +1,40 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestQuietHours(t *testing.T) {
+	q := &QuietHours{}
+	loc := time.FixedZone("test", 3*3600)
+	at := func(day, hour, minute int) time.Time { return time.Date(2024, 3, day, hour, minute, 0, 0, loc) }
+	for _, tc := range []struct {
+		ranges string
+		now    time.Time
+		until  time.Time
+	}{
+		{"07:00-23:00", at(10, 12, 0), at(10, 23, 0)},
+		{"07:00-23:00", at(10, 23, 30), time.Time{}},
+		{"07:00-23:00", at(10, 6, 59), time.Time{}},
+		{"22:00-02:00", at(10, 1, 0), at(10, 2, 0)},
+		{"22:00-02:00, 02:00-06:00", at(10, 23, 0), at(11, 6, 0)},
+		{"12:00-13:00,08:00-09:00", at(10, 8, 30), at(10, 9, 0)},
+	} {
+		if err := q.Set(tc.ranges); err != nil {
+			t.Fatal(err)
+		}
+		until, ok := q.Until(tc.now)
+		if ok != !tc.until.IsZero() || (ok && !until.Equal(tc.until)) {
+			t.Errorf("%s at %s: until %s, %v", tc.ranges, tc.now.Format("15:04"), until, ok)
+		}
+	}
+	for _, s := range []string{"7-23", "10:00-10:00", "10:00", "25:00-26:00"} {
+		if err := q.Set(s); err == nil {
+			t.Errorf("quiet hours %q accepted", s)
+		}
+	}
+}
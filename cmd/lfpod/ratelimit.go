@@ -0,0 +1,130 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// RateLimiter is a per-client-IP token bucket limiter. It also caps the
+// number of requests handled concurrently, until their responses start.
+type RateLimiter struct {
+	rate  float64
+	burst float64
+	slots chan struct{}
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// newRateLimiter returns a limiter allowing rate requests per second per
+// IP with bursts of burst requests, and at most maxConns requests
+// handled concurrently. Zero values disable the respective limit.
+func newRateLimiter(rate float64, burst, maxConns int) *RateLimiter {
+	l := &RateLimiter{rate: rate, burst: float64(burst), buckets: map[string]*bucket{}}
+	if l.burst < 1 {
+		l.burst = math.Max(1, rate)
+	}
+	if maxConns > 0 {
+		l.slots = make(chan struct{}, maxConns)
+	}
+	if rate > 0 {
+		go l.cleanup()
+	}
+	return l
+}
+
+// allow takes a token from the bucket of ip, it returns the time to
+// wait for the next token if the bucket is empty.
+func (l *RateLimiter) allow(ip string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	b, ok := l.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// cleanup forgets clients whose buckets have been refilled.
+func (l *RateLimiter) cleanup() {
+	full := time.Duration(l.burst / l.rate * float64(time.Second))
+	for range time.Tick(time.Minute) {
+		l.mu.Lock()
+		for ip, b := range l.buckets {
+			if time.Since(b.last) > full {
+				delete(l.buckets, ip)
+			}
+		}
+		l.mu.Unlock()
+	}
+}
+
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.rate > 0 {
+			if ok, wait := l.allow(clientIP(r)); !ok {
+				seconds := int(math.Ceil(wait.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				http.Error(w, "too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		if l.slots != nil {
+			select {
+			case l.slots <- struct{}{}:
+				var once sync.Once
+				release := func() { once.Do(func() { <-l.slots }) }
+				defer release()
+				w = &slotWriter{w, release}
+			default:
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "server busy", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// slotWriter releases the concurrency slot of a request once its
+// response starts, so long-lived responses like the /api/events stream
+// and audio downloads do not hold slots for as long as they last.
+type slotWriter struct {
+	http.ResponseWriter
+	release func()
+}
+
+func (w *slotWriter) WriteHeader(status int) {
+	w.release()
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *slotWriter) Write(b []byte) (int, error) {
+	w.release()
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap lets http.ResponseController reach Flush of the underlying
+// writer.
+func (w *slotWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
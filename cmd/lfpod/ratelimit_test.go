@@ -0,0 +1,114 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(2, 3, 0)
+	for _, tc := range []struct {
+		ip      string
+		elapsed time.Duration
+		ok      bool
+		wait    time.Duration
+	}{
+		// A burst of 3, then a token every half second.
+		{"192.0.2.1", 0, true, 0},
+		{"192.0.2.1", 0, true, 0},
+		{"192.0.2.1", 0, true, 0},
+		{"192.0.2.1", 0, false, 500 * time.Millisecond},
+		{"192.0.2.2", 0, true, 0},
+		{"192.0.2.1", 250 * time.Millisecond, false, 250 * time.Millisecond},
+		{"192.0.2.1", 250 * time.Millisecond, true, 0},
+		{"192.0.2.1", 0, false, 500 * time.Millisecond},
+		// The bucket refills up to the burst only.
+		{"192.0.2.1", time.Hour, true, 0},
+		{"192.0.2.1", 0, true, 0},
+		{"192.0.2.1", 0, true, 0},
+		{"192.0.2.1", 0, false, 500 * time.Millisecond},
+	} {
+		if b, ok := l.buckets[tc.ip]; ok {
+			b.last = b.last.Add(-tc.elapsed)
+		}
+		ok, wait := l.allow(tc.ip)
+		// Time passes between the calls, waits are a bit shorter.
+		if ok != tc.ok || wait > tc.wait || wait < tc.wait-50*time.Millisecond {
+			t.Errorf("%s after %s: %v, wait %s, want %v, wait %s", tc.ip, tc.elapsed, ok, wait, tc.ok, tc.wait)
+		}
+	}
+}
+
+func TestRateLimiterMiddleware(t *testing.T) {
+	l := newRateLimiter(1, 1, 0)
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/feed", nil)
+		r.RemoteAddr = "192.0.2.1:1234"
+		h.ServeHTTP(w, r)
+		if w.Code != want {
+			t.Errorf("status %d, want %d", w.Code, want)
+		}
+		if want == http.StatusTooManyRequests && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("Retry-After %q", w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestRateLimiterMaxConns(t *testing.T) {
+	l := newRateLimiter(0, 0, 1)
+	// Requests run until their path is finished.
+	started := make(chan struct{})
+	finish := map[string]chan struct{}{"/feed": make(chan struct{}), "/api/events": make(chan struct{}),
+		"/feed/other": make(chan struct{})}
+	h := l.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/events" {
+			// A stream, it holds no slot once the response started.
+			w.WriteHeader(http.StatusOK)
+		}
+		started <- struct{}{}
+		<-finish[r.URL.Path]
+	}))
+	serve := func(path string) chan int {
+		code := make(chan int)
+		go func() {
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+			code <- w.Code
+		}()
+		return code
+	}
+	for _, tc := range []struct {
+		path string
+		want int
+	}{
+		{"/feed", http.StatusServiceUnavailable},
+		{"/api/events", http.StatusOK},
+	} {
+		first := serve(tc.path)
+		<-started
+		other := serve("/feed/other")
+		if tc.want == http.StatusOK {
+			<-started
+			finish["/feed/other"] <- struct{}{}
+		}
+		if code := <-other; code != tc.want {
+			t.Errorf("request during %s: status %d, want %d", tc.path, code, tc.want)
+		}
+		finish[tc.path] <- struct{}{}
+		<-first
+	}
+	// The slot is free again.
+	other := serve("/feed/other")
+	<-started
+	finish["/feed/other"] <- struct{}{}
+	if code := <-other; code != http.StatusOK {
+		t.Errorf("request after the others: status %d", code)
+	}
+}
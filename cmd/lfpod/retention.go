@@ -0,0 +1,124 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+// Retention limits the episodes kept of a feed, zero values for no
+// limit. Pinned episodes are always kept.
+type Retention struct {
+	// Number of newest episodes kept.
+	KeepEpisodes int
+	// Episodes downloaded longer ago are deleted.
+	MaxAge time.Duration
+	// Newest episodes kept up to this many bytes.
+	MaxBytes int64
+}
+
+func (r Retention) IsZero() bool {
+	return r == Retention{}
+}
+
+// Retention returns the retention limits of a feed, feed settings
+// override the global ones.
+func (c *Conf) Retention(feed *ConfFeed) Retention {
+	r := Retention{KeepEpisodes: c.KeepEpisodes, MaxAge: time.Duration(c.MaxAge) * 24 * time.Hour}
+	if feed.KeepEpisodes > 0 {
+		r.KeepEpisodes = feed.KeepEpisodes
+	}
+	if feed.MaxAge > 0 {
+		r.MaxAge = time.Duration(feed.MaxAge) * 24 * time.Hour
+	}
+	if feed.MaxStorage > 0 {
+		r.MaxBytes = feed.MaxStorage << 20
+	}
+	return r
+}
+
+// hasRetention reports whether any feed has retention limits.
+func (c *Conf) hasRetention() bool {
+	for _, feed := range c.AllFeeds() {
+		if !c.Retention(&feed).IsZero() {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired returns the files of a feed beyond the retention limits.
+func (r Retention) Expired(files []ArchiveFile, now time.Time) []ArchiveFile {
+	sorted := append([]ArchiveFile{}, files...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Modified.After(sorted[j].Modified)
+	})
+	expired := []ArchiveFile{}
+	kept := 0
+	var size int64
+	for _, f := range sorted {
+		if f.Pinned {
+			continue
+		}
+		switch {
+		case r.KeepEpisodes > 0 && kept >= r.KeepEpisodes,
+			r.MaxAge > 0 && now.Sub(f.Modified) > r.MaxAge,
+			r.MaxBytes > 0 && size+f.Size > r.MaxBytes:
+			expired = append(expired, f)
+			continue
+		}
+		kept++
+		size += f.Size
+	}
+	return expired
+}
+
+// expiredFiles returns the files of all feeds beyond their retention
+// limits. Each feed expires only the files of the episodes it lists; a
+// file listed by several feeds is expired once all of them expired it.
+func expiredFiles(ctx context.Context, conf *Conf, files []ArchiveFile) []ArchiveFile {
+	feeds := conf.AllFeeds()
+	// Only channel feeds already read are consulted, retention does
+	// not fetch any.
+	entries := map[string]*youtube.Entry{}
+	for _, feed := range feeds {
+		if c, ok := cachedChannelFeed(feed.ChannelId); ok {
+			for _, entry := range c.feed.Entries {
+				entries[entry.VideoId] = entry
+			}
+		}
+	}
+	meta := newArchiveMetadata(ctx, entries)
+	listed, expiredBy := map[string]int{}, map[string]int{}
+	now := time.Now()
+	for _, feed := range feeds {
+		added, err := episodes.Added(ctx, feed.ChannelId)
+		logStore(err)
+		selected := []ArchiveFile{}
+		for _, f := range files {
+			if f.ChannelId == feed.ChannelId && feed.lists(meta.entry(f.ChannelId, f.VideoId), added) {
+				selected = append(selected, f)
+				listed[f.Path]++
+			}
+		}
+		if r := conf.Retention(&feed); !r.IsZero() {
+			for _, f := range r.Expired(selected, now) {
+				expiredBy[f.Path]++
+			}
+		}
+	}
+	expired := []ArchiveFile{}
+	for _, f := range files {
+		if n := expiredBy[f.Path]; n > 0 && n == listed[f.Path] {
+			expired = append(expired, f)
+			// Listed once from here on.
+			expiredBy[f.Path] = 0
+		}
+	}
+	return expired
+}
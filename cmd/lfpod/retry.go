@@ -0,0 +1,223 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+const (
+	retryBackoffMin = 10 * time.Minute
+	retryBackoffMax = 24 * time.Hour
+	// Videos are given up after this many failed attempts.
+	retryMaxAttempts = 10
+)
+
+// RetryItem is a video whose download failed.
+type RetryItem struct {
+	ChannelId   string    `json:"channel_id"`
+	VideoId     string    `json:"video_id"`
+	Title       string    `json:"title"`
+	Published   string    `json:"published"`
+	Description string    `json:"description,omitempty"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+func (item *RetryItem) Entry() *youtube.Entry {
+	return &youtube.Entry{
+		Title:     item.Title,
+		VideoId:   item.VideoId,
+		Published: item.Published,
+		Media:     &youtube.Media{Description: item.Description},
+	}
+}
+
+// RetryQueue keeps failed videos with exponential backoff between
+// attempts, so they are retried even after they drop out of the channel
+// feed. The queue is saved to file if set.
+type RetryQueue struct {
+	mu    sync.Mutex
+	file  string
+	items map[string]*RetryItem
+}
+
+var retries = RetryQueue{items: map[string]*RetryItem{}}
+
+func (q *RetryQueue) Load(file string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.file = file
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	items := []*RetryItem{}
+	if err := json.Unmarshal(data, &items); err != nil {
+		return err
+	}
+	for _, item := range items {
+		q.items[item.VideoId] = item
+	}
+	return nil
+}
+
+func (q *RetryQueue) save() {
+	if q.file == "" {
+		return
+	}
+	items := []*RetryItem{}
+	for _, item := range q.items {
+		items = append(items, item)
+	}
+	data, err := json.MarshalIndent(items, "", "    ")
+	if err == nil {
+		fileTmp := q.file + ".tmp"
+		if err = os.WriteFile(fileTmp, append(data, '\n'), 0640); err == nil {
+			err = os.Rename(fileTmp, q.file)
+		}
+	}
+	if err != nil {
+		log.Print(err)
+	}
+}
+
+// item returns the queued item of a video, adding it if needed.
+func (q *RetryQueue) item(channelId string, entry *youtube.Entry) *RetryItem {
+	item, ok := q.items[entry.VideoId]
+	if !ok {
+		item = &RetryItem{ChannelId: channelId, VideoId: entry.VideoId,
+			Title: entry.Title, Published: entry.Published}
+		if entry.Media != nil {
+			item.Description = entry.Media.Description
+		}
+		q.items[entry.VideoId] = item
+	}
+	return item
+}
+
+// Failed records a failed attempt and schedules the next one.
+func (q *RetryQueue) Failed(channelId string, entry *youtube.Entry, cause error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item := q.item(channelId, entry)
+	item.Attempts++
+	item.LastError = cause.Error()
+	if item.Attempts >= retryMaxAttempts {
+		log.Printf("%s failed %d times, given up", entry.VideoId, item.Attempts)
+		delete(q.items, entry.VideoId)
+		blocked.Add(channelId, entry, fmt.Sprintf("given up after %d attempts: %s", item.Attempts, item.LastError))
+	} else {
+		backoff := retryBackoffMin << (item.Attempts - 1)
+		if backoff > retryBackoffMax || backoff <= 0 {
+			backoff = retryBackoffMax
+		}
+		item.NextAttempt = time.Now().Add(backoff)
+		log.Printf("%s retry %d in %s", entry.VideoId, item.Attempts, backoff)
+	}
+	q.save()
+}
+
+// Schedule records a video expected to become available at a time,
+// like an upcoming premiere, without counting a failed attempt.
+func (q *RetryQueue) Schedule(channelId string, entry *youtube.Entry, at time.Time) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item := q.item(channelId, entry)
+	item.NextAttempt = at
+	item.LastError = "upcoming"
+	log.Printf("%s upcoming, retry at %s", entry.VideoId, at.Format(time.RFC3339))
+	q.save()
+}
+
+// Defer records a video to download at a time, like the end of quiet
+// hours, without counting a failed attempt. The error of a failed video
+// is kept.
+func (q *RetryQueue) Defer(channelId string, entry *youtube.Entry, at time.Time, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item := q.item(channelId, entry)
+	item.NextAttempt = at
+	if item.LastError == "" || item.LastError == "interrupted" {
+		item.LastError = reason
+	}
+	q.save()
+}
+
+// Started records a video being downloaded, due right away, so a
+// download interrupted by a restart is resumed by the next update. The
+// attempt is counted only if it fails.
+func (q *RetryQueue) Started(channelId string, entry *youtube.Entry) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item := q.item(channelId, entry)
+	item.NextAttempt = time.Now()
+	if item.LastError == "" {
+		item.LastError = "interrupted"
+	}
+	q.save()
+}
+
+// Queue records a video to download again right away, like one whose
+// audio file turned out to be corrupted, without counting a failed
+// attempt.
+func (q *RetryQueue) Queue(channelId string, entry *youtube.Entry, reason string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item := q.item(channelId, entry)
+	item.NextAttempt = time.Now()
+	item.LastError = reason
+	q.save()
+}
+
+// Has reports whether a video is queued.
+func (q *RetryQueue) Has(videoId string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	_, ok := q.items[videoId]
+	return ok
+}
+
+// Done removes a video from the queue.
+func (q *RetryQueue) Done(videoId string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.items[videoId]; ok {
+		delete(q.items, videoId)
+		q.save()
+	}
+}
+
+// Waiting reports whether a video is in backoff.
+func (q *RetryQueue) Waiting(videoId string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	item, ok := q.items[videoId]
+	return ok && time.Now().Before(item.NextAttempt)
+}
+
+// Due returns the videos whose backoff has expired.
+func (q *RetryQueue) Due() []RetryItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	due := []RetryItem{}
+	now := time.Now()
+	for _, item := range q.items {
+		if !now.Before(item.NextAttempt) {
+			due = append(due, *item)
+		}
+	}
+	return due
+}
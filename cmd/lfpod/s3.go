@@ -0,0 +1,308 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type s3Options struct {
+	// Endpoint URL, buckets are addressed by path.
+	Endpoint  string
+	Region    string
+	AccessKey string
+	SecretKey string
+	// Base URL of a public bucket, presigned URLs are used if empty.
+	PublicURL string
+	// Validity of presigned URLs, at most 7 days.
+	URLExpiry time.Duration
+}
+
+// s3Storage uploads audio files to an S3 compatible object storage,
+// requests are signed with AWS Signature Version 4.
+type s3Storage struct {
+	s3Options
+	endpoint *url.URL
+	bucket   string
+	prefix   string
+	client   *http.Client
+
+	mu       sync.Mutex
+	uploaded map[string]bool
+}
+
+func newS3Storage(bucket, prefix string, opts s3Options) (*s3Storage, error) {
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "http" && endpoint.Scheme != "https") {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+	}
+	if opts.AccessKey == "" || opts.SecretKey == "" {
+		return nil, errors.New("S3 credentials are not set")
+	}
+	if opts.URLExpiry <= 0 || opts.URLExpiry > 7*24*time.Hour {
+		return nil, fmt.Errorf("S3 URL expiry %v out of range", opts.URLExpiry)
+	}
+	opts.PublicURL = strings.TrimSuffix(opts.PublicURL, "/")
+	// Uploads take longer than feed fetches.
+	client := newFetchClient("")
+	client.Timeout = 0
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+	return &s3Storage{
+		s3Options: opts,
+		endpoint:  endpoint,
+		bucket:    bucket,
+		prefix:    prefix,
+		client:    client,
+		uploaded:  map[string]bool{},
+	}, nil
+}
+
+func (s *s3Storage) isUploaded(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.uploaded[key]
+}
+
+func (s *s3Storage) setUploaded(key string, uploaded bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if uploaded {
+		s.uploaded[key] = true
+	} else {
+		delete(s.uploaded, key)
+	}
+}
+
+func (s *s3Storage) Store(name string) error {
+	key := storageObjectKey(s.prefix, name)
+	if !s.isUploaded(key) {
+		exists, err := s.head(key)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if isHollow(name) {
+				return fmt.Errorf("%s is missing in the bucket", key)
+			}
+			if err := s.put(key, name); err != nil {
+				return err
+			}
+		}
+		s.setUploaded(key, true)
+	}
+	if isHollow(name) {
+		return nil
+	}
+	return hollowFile(name)
+}
+
+func (s *s3Storage) Remove(name string) error {
+	key := storageObjectKey(s.prefix, name)
+	resp, err := s.do(http.MethodDelete, key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("delete %s: %s", key, resp.Status)
+	}
+	s.setUploaded(key, false)
+	return nil
+}
+
+func (s *s3Storage) URL(name string) (string, bool) {
+	_, ext, _ := strings.Cut(filepath.Base(name), ".")
+	if !containsString(audioFormatNames, ext) {
+		return "", false
+	}
+	key := storageObjectKey(s.prefix, name)
+	if !s.isUploaded(key) {
+		if !isHollow(name) {
+			return "", false
+		}
+		s.setUploaded(key, true)
+	}
+	if s.PublicURL != "" {
+		return s.PublicURL + "/" + s3Escape(key, true), true
+	}
+	return s.presign(http.MethodGet, s.endpoint.Host, s.objectPath(key), time.Now(), s.URLExpiry), true
+}
+
+func (s *s3Storage) objectPath(key string) string {
+	return s.endpoint.Path + "/" + s3Escape(s.bucket, false) + "/" + s3Escape(key, true)
+}
+
+func (s *s3Storage) head(key string) (bool, error) {
+	resp, err := s.do(http.MethodHead, key, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, fmt.Errorf("head %s: %s", key, resp.Status)
+}
+
+func (s *s3Storage) put(key, name string) error {
+	hash, err := hashFile(name)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(http.MethodPut, key, f, info.Size(), hash)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("put %s: %s %s", key, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+func (s *s3Storage) do(method, key string, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	u := *s.endpoint
+	u.RawPath = s.objectPath(key)
+	u.Path, _ = url.PathUnescape(u.RawPath)
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+		_, ext, _ := strings.Cut(filepath.Base(key), ".")
+		if f, ok := audioFormats[ext]; ok {
+			req.Header.Set("Content-Type", f.MimeType)
+		}
+	}
+	s.sign(req, payloadHash, time.Now())
+	return s.client.Do(req)
+}
+
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape encodes s as required by Signature Version 4, keeping
+// slashes in object keys.
+func s3Escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := []string{}
+	for _, k := range keys {
+		for _, v := range q[k] {
+			pairs = append(pairs, s3Escape(k, false)+"="+s3Escape(v, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func (s *s3Storage) scope(t time.Time) string {
+	return t.Format("20060102") + "/" + s.Region + "/s3/aws4_request"
+}
+
+// signature signs a canonical request made at t.
+func (s *s3Storage) signature(t time.Time, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + t.Format("20060102T150405Z") + "\n" +
+		s.scope(t) + "\n" + sha256Hex(canonicalRequest)
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+// sign adds the Authorization header to a request.
+func (s *s3Storage) sign(req *http.Request, payloadHash string, t time.Time) {
+	t = t.UTC()
+	amzDate := t.Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	const signedHeaders = "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		s3CanonicalQuery(req.URL.Query()),
+		"host:" + req.URL.Host + "\n" +
+			"x-amz-content-sha256:" + payloadHash + "\n" +
+			"x-amz-date:" + amzDate + "\n",
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+s.scope(t)+
+		", SignedHeaders="+signedHeaders+", Signature="+s.signature(t, canonicalRequest))
+}
+
+// presign returns a URL of an object valid for expires without
+// credentials.
+func (s *s3Storage) presign(method, host, path string, t time.Time, expires time.Duration) string {
+	t = t.UTC()
+	q := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {s.AccessKey + "/" + s.scope(t)},
+		"X-Amz-Date":          {t.Format("20060102T150405Z")},
+		"X-Amz-Expires":       {strconv.Itoa(int(expires.Seconds()))},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	query := s3CanonicalQuery(q)
+	canonicalRequest := strings.Join([]string{
+		method, path, query, "host:" + host + "\n", "host", "UNSIGNED-PAYLOAD",
+	}, "\n")
+	return s.endpoint.Scheme + "://" + host + path + "?" + query +
+		"&X-Amz-Signature=" + s.signature(t, canonicalRequest)
+}
@@ -0,0 +1,279 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Random extra delay of each poll, up to this long, so instances and
+// feeds do not all hit YouTube at the same minute.
+var updateJitter time.Duration
+
+// randomJitter returns a random duration in [0, max).
+func randomJitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(max)))
+}
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+}
+
+// cronSchedule is a standard five field cron expression: minute, hour,
+// day of month, month and day of week, in local time. Fields are sets of
+// bits of the matching values.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// A day matches either day field if both are restricted, as in cron.
+	domAny, dowAny bool
+}
+
+// parseCron parses a cron expression like "*/15 6-22 * * 1-5" or a macro
+// like @daily.
+func parseCron(spec string) (*cronSchedule, error) {
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("schedule %q: 5 fields expected, minute hour day month weekday", spec)
+	}
+	c := &cronSchedule{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	for i, f := range []struct {
+		bits     *uint64
+		min, max int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}} {
+		bits, err := parseCronField(fields[i], f.min, f.max)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %q: %v", spec, err)
+		}
+		*f.bits = bits
+	}
+	// Sunday is 0 or 7.
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseCronField parses a comma-separated list of values, ranges and
+// steps like 1,5,10-20/2 or */15.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepStr); err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(loStr); err != nil {
+				return 0, fmt.Errorf("invalid value %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(hiStr); err != nil {
+					return 0, fmt.Errorf("invalid value %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<t.Weekday()) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+// Next returns the first time matching the schedule after t, zero if
+// none within five years, e.g. for February 30.
+func (c *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		y, m, d := t.Date()
+		switch {
+		case c.month&(1<<m) == 0:
+			t = time.Date(y, m+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.matchDay(t):
+			t = time.Date(y, m, d+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(y, m, d, t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// nextPoll returns when a feed with a schedule is polled next after now,
+// with its jitter.
+func (f *ConfFeed) nextPoll(now time.Time) time.Time {
+	c, err := parseCron(f.Schedule)
+	if err != nil {
+		log.Printf("%s: %v", f.Name, err)
+		return now.Add(updateInterval)
+	}
+	next := c.Next(now)
+	if next.IsZero() {
+		log.Printf("%s: schedule %q never matches", f.Name, f.Schedule)
+		return now.Add(updateInterval)
+	}
+	jitter := updateJitter
+	if f.Jitter > 0 {
+		jitter = time.Duration(f.Jitter) * time.Minute
+	}
+	return next.Add(randomJitter(jitter))
+}
+
+type plannedPoll struct {
+	schedule string
+	at       time.Time
+}
+
+// Schedules plans the polls of feeds with a schedule.
+type Schedules struct {
+	mu   sync.Mutex
+	next map[string]plannedPoll
+}
+
+var feedSchedules = Schedules{next: map[string]plannedPoll{}}
+
+// Due returns the channel ids of the feeds with a schedule due at now and
+// plans their next polls. Feeds new to the planner or with a changed
+// schedule are planned without being due.
+func (s *Schedules) Due(feeds []ConfFeed, now time.Time) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	due := []string{}
+	scheduled := map[string]bool{}
+	for _, feed := range feeds {
+		if feed.Schedule == "" || feed.Disabled {
+			continue
+		}
+		scheduled[feed.ChannelId] = true
+		p, ok := s.next[feed.ChannelId]
+		if ok && p.schedule == feed.Schedule {
+			if p.at.After(now) {
+				continue
+			}
+			due = append(due, feed.ChannelId)
+		}
+		s.next[feed.ChannelId] = plannedPoll{feed.Schedule, feed.nextPoll(now)}
+	}
+	for id := range s.next {
+		if !scheduled[id] {
+			delete(s.next, id)
+		}
+	}
+	return due
+}
+
+// Next returns the earliest planned poll, zero if none.
+func (s *Schedules) Next() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var next time.Time
+	for _, p := range s.next {
+		if next.IsZero() || p.at.Before(next) {
+			next = p.at
+		}
+	}
+	return next
+}
+
+// Of returns the planned poll of a feed.
+func (s *Schedules) Of(channelId string) (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p, ok := s.next[channelId]
+	return p.at, ok
+}
+
+// waitUpdate waits for the next update pass: the periodic one at next, a
+// feed with a schedule becoming due, the end of quiet hours videos were
+// deferred to or an update triggered.
+func waitUpdate(conf *Conf, next time.Time) UpdateRequest {
+	for {
+		wake := next
+		if s := feedSchedules.Next(); !s.IsZero() && s.Before(wake) {
+			wake = s
+		}
+		resume := quiet.Resume()
+		if !resume.IsZero() && resume.Before(wake) {
+			wake = resume
+		}
+		timer := time.NewTimer(time.Until(wake))
+		select {
+		case req := <-updateTrigger:
+			timer.Stop()
+			log.Print("update triggered")
+			return req
+		case <-timer.C:
+		}
+		now := time.Now()
+		due := feedSchedules.Due(conf.AllFeeds(), now)
+		if !now.Before(next) {
+			return UpdateRequest{Periodic: true, Feeds: due}
+		}
+		if len(due) > 0 {
+			return UpdateRequest{Feeds: due}
+		}
+		if !resume.IsZero() && !now.Before(resume) {
+			log.Print("quiet hours over, resuming deferred downloads")
+			return UpdateRequest{Periodic: true}
+		}
+	}
+}
+
+// includes reports whether an update covers a feed.
+func (req UpdateRequest) includes(feed ConfFeed) bool {
+	switch {
+	case feed.Disabled || feed.Inbox:
+		return false
+	case req.ChannelId != "":
+		return feed.ChannelId == req.ChannelId
+	case containsString(req.Feeds, feed.ChannelId):
+		return true
+	case req.Periodic:
+		return feed.Schedule == ""
+	}
+	return len(req.Feeds) == 0
+}
@@ -0,0 +1,80 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule(t *testing.T) {
+	at := func(s string) time.Time {
+		tm, err := time.ParseInLocation("2006-01-02 15:04", s, time.Local)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm
+	}
+	for _, tt := range []struct {
+		spec, from, want string
+	}{
+		{"*/15 * * * *", "2023-05-01 10:07", "2023-05-01 10:15"},
+		{"0 6-22 * * *", "2023-05-01 22:30", "2023-05-02 06:00"},
+		{"30 7 * * 1-5", "2023-05-05 08:00", "2023-05-08 07:30"},
+		{"0 0 1 * *", "2023-05-01 00:00", "2023-06-01 00:00"},
+		{"@weekly", "2023-05-01 10:00", "2023-05-07 00:00"},
+		{"0 12 * * 7", "2023-05-01 10:00", "2023-05-07 12:00"},
+		{"0 9 13 * 5", "2023-05-01 10:00", "2023-05-05 09:00"},
+	} {
+		c, err := parseCron(tt.spec)
+		if err != nil {
+			t.Errorf("%s: %v", tt.spec, err)
+			continue
+		}
+		if got := c.Next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("%s after %s: %s, want %s", tt.spec, tt.from, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+	for _, spec := range []string{"* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "5-1 * * * *", "@often"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Errorf("%q parsed", spec)
+		}
+	}
+	if c, _ := parseCron("0 0 30 2 *"); !c.Next(time.Now()).IsZero() {
+		t.Error("February 30 matched")
+	}
+
+	feeds := []ConfFeed{
+		{Name: "hourly", ChannelId: "UChourly", Schedule: "0 * * * *"},
+		{Name: "polled", ChannelId: "UCpolled"},
+	}
+	s := Schedules{next: map[string]plannedPoll{}}
+	now := at("2023-05-01 10:30")
+	if due := s.Due(feeds, now); len(due) != 0 {
+		t.Errorf("due when first planned: %q", due)
+	}
+	if next := s.Next(); !next.Equal(at("2023-05-01 11:00")) {
+		t.Errorf("next poll %s", next)
+	}
+	if due := s.Due(feeds, at("2023-05-01 11:00")); len(due) != 1 || due[0] != "UChourly" {
+		t.Errorf("due %q", due)
+	}
+	if due := s.Due(feeds[1:], at("2023-05-01 12:00")); len(due) != 0 || !s.Next().IsZero() {
+		t.Errorf("removed feed still planned: %q", due)
+	}
+
+	for req, want := range map[*UpdateRequest][]bool{
+		{}:               {true, true},
+		{Periodic: true}: {false, true},
+		{Periodic: true, Feeds: []string{"UChourly"}}: {true, true},
+		{Feeds: []string{"UChourly"}}:                 {true, false},
+		{ChannelId: "UCpolled"}:                       {false, true},
+	} {
+		for i, feed := range feeds {
+			if req.includes(feed) != want[i] {
+				t.Errorf("%+v includes %s: %v", *req, feed.Name, !want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// sdNotify sends a state like READY=1 to systemd, if lfpod runs as a
+// Type=notify service. It reports whether the state was sent.
+func sdNotify(state string) bool {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false
+	}
+	// Abstract sockets start with @, a NUL byte on the wire.
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:]
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		log.Print("sd_notify: ", err)
+		return false
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		log.Print("sd_notify: ", err)
+		return false
+	}
+	return true
+}
+
+// watchdogInterval returns the watchdog timeout systemd expects pings
+// within, 0 if the watchdog is off.
+func watchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// LoopHealth tells the watchdog whether the update loop makes progress.
+// The loop is healthy while waiting for the next pass, running a child
+// process, or within the stall limit of its last step.
+type LoopHealth struct {
+	mu      sync.Mutex
+	last    time.Time
+	waiting bool
+	procs   int
+}
+
+var loopHealth = LoopHealth{}
+
+// Beat records a step of an update pass.
+func (h *LoopHealth) Beat() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last, h.waiting = time.Now(), false
+}
+
+// Wait records that the loop waits for the next pass.
+func (h *LoopHealth) Wait() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.last, h.waiting = time.Now(), true
+}
+
+// ProcStarted and ProcDone count running child processes, which may
+// take long without being stuck.
+func (h *LoopHealth) ProcStarted() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.procs++
+}
+
+func (h *LoopHealth) ProcDone() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.procs--
+	h.last = time.Now()
+}
+
+// Stalled returns for how long the loop has made no progress, if longer
+// than limit.
+func (h *LoopHealth) Stalled(now time.Time, limit time.Duration) (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.last.IsZero() || h.waiting || h.procs > 0 {
+		return 0, false
+	}
+	d := now.Sub(h.last)
+	return d, d > limit
+}
+
+// startWatchdog pings the systemd watchdog at half its interval while the
+// update loop is healthy, so systemd restarts lfpod when it is stuck.
+func startWatchdog(stallLimit time.Duration) {
+	interval := watchdogInterval()
+	if interval == 0 {
+		return
+	}
+	events.Subscribe(func(Event) { loopHealth.Beat() })
+	log.Printf("pinging the systemd watchdog every %s", interval/2)
+	go func() {
+		reported := false
+		for range time.Tick(interval / 2) {
+			if d, stalled := loopHealth.Stalled(time.Now(), stallLimit); stalled {
+				if !reported {
+					log.Printf("update loop stalled for %s, not pinging the watchdog", d.Round(time.Second))
+					reported = true
+				}
+				continue
+			}
+			reported = false
+			sdNotify("WATCHDOG=1")
+		}
+	}()
+}
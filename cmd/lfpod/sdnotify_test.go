@@ -0,0 +1,65 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSdNotify(t *testing.T) {
+	dir, err := os.MkdirTemp("", "sd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", socket)
+	if !sdNotify("READY=1") {
+		t.Fatal("not notified")
+	}
+	buf := make([]byte, 64)
+	n, err := conn.Read(buf)
+	if err != nil || string(buf[:n]) != "READY=1" {
+		t.Errorf("received %q, %v", buf[:n], err)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "60000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if d := watchdogInterval(); d != time.Minute {
+		t.Errorf("watchdog interval %s", d)
+	}
+	t.Setenv("WATCHDOG_PID", "1")
+	if d := watchdogInterval(); d != 0 {
+		t.Errorf("watchdog of another process: %s", d)
+	}
+
+	h := LoopHealth{}
+	now := time.Now()
+	if _, stalled := h.Stalled(now, time.Minute); stalled {
+		t.Error("loop stalled before it started")
+	}
+	h.Beat()
+	if _, stalled := h.Stalled(now.Add(2*time.Minute), time.Minute); !stalled {
+		t.Error("stuck pass not detected")
+	}
+	h.ProcStarted()
+	if _, stalled := h.Stalled(now.Add(2*time.Minute), time.Minute); stalled {
+		t.Error("running process taken for a stall")
+	}
+	h.ProcDone()
+	h.Wait()
+	if _, stalled := h.Stalled(now.Add(time.Hour), time.Minute); stalled {
+		t.Error("waiting loop taken for a stall")
+	}
+}
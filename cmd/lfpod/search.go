@@ -0,0 +1,263 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+const recentUploadsCount = 3
+
+type ChannelCandidate struct {
+	ChannelId     string   `json:"channel_id"`
+	Title         string   `json:"title"`
+	URL           string   `json:"url"`
+	RecentUploads []string `json:"recent_uploads,omitempty"`
+}
+
+// channelHandleRegexp matches channel handles and URLs of channels
+// without a channel id: youtube.com/@handle, /c/name and /user/name.
+var channelHandleRegexp = regexp.MustCompile(`^@[\w.-]+$|youtube\.com/(@[\w.-]+|c/[^/?#]+|user/[^/?#]+)`)
+
+// searchChannels looks up YouTube channels matching query, with the
+// Data API if there is a key or the downloader otherwise, and lists
+// recent uploads of each from its RSS feed. A channel id, handle or
+// channel URL query resolves to the channel.
+func searchChannels(ctx context.Context, query string, limit int) ([]ChannelCandidate, error) {
+	var candidates []ChannelCandidate
+	var err error
+	if youtube.ChannelIdRegexp.MatchString(query) || channelHandleRegexp.MatchString(query) {
+		var c ChannelCandidate
+		if c, err = resolveChannel(ctx, query); err == nil {
+			candidates = []ChannelCandidate{c}
+		}
+	} else if youtubeAPIKey != "" {
+		candidates, err = searchChannelsAPI(ctx, query, limit)
+	} else {
+		candidates, err = searchChannelsDownloader(ctx, query, limit)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range candidates {
+		c := &candidates[i]
+		data, err := readFeed(ctx, fetchClient, c.ChannelId)
+		if err != nil {
+			continue
+		}
+		if ytfeed, err := youtube.ParseFeed(data); err == nil {
+			for i, e := range ytfeed.Entries {
+				if i == recentUploadsCount {
+					break
+				}
+				c.RecentUploads = append(c.RecentUploads, e.Title)
+			}
+		}
+	}
+	return candidates, nil
+}
+
+// resolveChannel returns the channel of a channel id, handle or URL.
+func resolveChannel(ctx context.Context, s string) (ChannelCandidate, error) {
+	if id := youtube.ChannelIdRegexp.FindString(s); id != "" {
+		c := ChannelCandidate{ChannelId: id, URL: "https://www.youtube.com/channel/" + id}
+		if data, err := readFeed(ctx, fetchClient, id); err == nil {
+			if ytfeed, err := youtube.ParseFeed(data); err == nil {
+				c.Title = ytfeed.Title
+			}
+		}
+		return c, nil
+	}
+	m := channelHandleRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return ChannelCandidate{}, fmt.Errorf("%q is not a channel id, handle or URL", s)
+	}
+	path := m[1]
+	if path == "" {
+		path = m[0]
+	}
+	if youtubeAPIKey != "" {
+		return resolveChannelAPI(ctx, path)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, downloader, downloaderArgs("--no-warnings", "--flat-playlist",
+		"--playlist-items", "0", "--dump-single-json", "--", "https://www.youtube.com/"+path)...)
+	out, err := runCommand(cmd, "", "search")
+	if err != nil {
+		log.Printf("%s", out)
+		return ChannelCandidate{}, err
+	}
+	channel := struct {
+		ChannelId string `json:"channel_id"`
+		Channel   string `json:"channel"`
+	}{}
+	if err := json.Unmarshal(out, &channel); err != nil {
+		return ChannelCandidate{}, err
+	}
+	if !youtube.ChannelIdRegexp.MatchString(channel.ChannelId) {
+		return ChannelCandidate{}, fmt.Errorf("channel %s not found", path)
+	}
+	return ChannelCandidate{ChannelId: channel.ChannelId, Title: channel.Channel,
+		URL: "https://www.youtube.com/channel/" + channel.ChannelId}, nil
+}
+
+// resolveChannelAPI looks up the channel of a handle or legacy user
+// name with the Data API, custom /c/ URLs are searched for.
+func resolveChannelAPI(ctx context.Context, path string) (ChannelCandidate, error) {
+	params := url.Values{"part": {"snippet"}}
+	if name, ok := strings.CutPrefix(path, "user/"); ok {
+		params.Set("forUsername", name)
+	} else if name, ok := strings.CutPrefix(path, "c/"); ok {
+		candidates, err := searchChannelsAPI(ctx, name, 1)
+		if err != nil {
+			return ChannelCandidate{}, err
+		}
+		if len(candidates) == 0 {
+			return ChannelCandidate{}, fmt.Errorf("channel %s not found", path)
+		}
+		return candidates[0], nil
+	} else {
+		params.Set("forHandle", path)
+	}
+	channels := struct {
+		Items []struct {
+			Id      string `json:"id"`
+			Snippet struct {
+				Title string `json:"title"`
+			} `json:"snippet"`
+		} `json:"items"`
+	}{}
+	if err := apiGet(ctx, fetchClient, "/channels", params, &channels); err != nil {
+		return ChannelCandidate{}, err
+	}
+	if len(channels.Items) == 0 {
+		return ChannelCandidate{}, fmt.Errorf("channel %s not found", path)
+	}
+	ch := channels.Items[0]
+	return ChannelCandidate{ChannelId: ch.Id, Title: ch.Snippet.Title, URL: "https://www.youtube.com/channel/" + ch.Id}, nil
+}
+
+// searchChannelsAPI searches channels with the Data API, at the quota
+// cost of 100 units.
+func searchChannelsAPI(ctx context.Context, query string, limit int) ([]ChannelCandidate, error) {
+	results := struct {
+		Items []struct {
+			Snippet struct {
+				ChannelId    string `json:"channelId"`
+				ChannelTitle string `json:"channelTitle"`
+			} `json:"snippet"`
+		} `json:"items"`
+	}{}
+	params := url.Values{"part": {"snippet"}, "type": {"channel"}, "q": {query}, "maxResults": {strconv.Itoa(limit)}}
+	if err := apiGet(ctx, fetchClient, "/search", params, &results); err != nil {
+		return nil, err
+	}
+	candidates := []ChannelCandidate{}
+	for _, it := range results.Items {
+		id := it.Snippet.ChannelId
+		candidates = append(candidates, ChannelCandidate{ChannelId: id, Title: it.Snippet.ChannelTitle,
+			URL: "https://www.youtube.com/channel/" + id})
+	}
+	return candidates, nil
+}
+
+// searchChannelsDownloader searches channels with the downloader.
+func searchChannelsDownloader(ctx context.Context, query string, limit int) ([]ChannelCandidate, error) {
+	ctx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	defer cancel()
+	// sp=EgIQAg%3D%3D restricts search results to channels.
+	u := "https://www.youtube.com/results?sp=EgIQAg%3D%3D&search_query=" + url.QueryEscape(query)
+	cmd := exec.CommandContext(ctx, downloader, downloaderArgs("--no-warnings", "--flat-playlist",
+		"--dump-json", "--playlist-end", strconv.Itoa(limit), "--", u)...)
+	out, err := runCommand(cmd, "", "search")
+	if err != nil {
+		log.Printf("%s", out)
+		return nil, err
+	}
+	candidates := []ChannelCandidate{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		entry := struct {
+			Id        string `json:"id"`
+			ChannelId string `json:"channel_id"`
+			Title     string `json:"title"`
+			Channel   string `json:"channel"`
+			URL       string `json:"url"`
+		}{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		c := ChannelCandidate{ChannelId: entry.ChannelId, Title: entry.Title, URL: entry.URL}
+		if strings.HasPrefix(entry.Id, "UC") {
+			c.ChannelId = entry.Id
+		}
+		if c.Title == "" {
+			c.Title = entry.Channel
+		}
+		if c.ChannelId == "" {
+			continue
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, scanner.Err()
+}
+
+func apiDiscoverHandler(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.FormValue("q"))
+	if query == "" {
+		apiError(w, http.StatusBadRequest, "query is required", nil)
+		return
+	}
+	limit := 5
+	if s := r.FormValue("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > 50 {
+			apiError(w, http.StatusBadRequest, "limit must be 1 to 50", s)
+			return
+		}
+		limit = n
+	}
+	candidates, err := searchChannels(r.Context(), query, limit)
+	if err != nil {
+		apiError(w, http.StatusBadGateway, "channel search failed", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, candidates)
+}
+
+// runSearch implements the search command.
+func runSearch(ctx context.Context, args []string) error {
+	query := strings.Join(args, " ")
+	if query == "" {
+		return errors.New("usage: lfpod search <query, channel handle or URL>")
+	}
+	candidates, err := searchChannels(ctx, query, 5)
+	if err != nil {
+		return err
+	}
+	for _, c := range candidates {
+		fmt.Printf("%s  %s\n", c.ChannelId, c.Title)
+		for _, title := range c.RecentUploads {
+			fmt.Printf("    %s\n", title)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// The downloader is updated or checked for updates this often, 0 to
+// never.
+var downloaderUpdateInterval time.Duration
+
+// downloaderUpdateMode is "update" to run yt-dlp -U, or "check" to only
+// compare the installed version with the latest release, for yt-dlp
+// installed by a package manager.
+var downloaderUpdateMode = "update"
+
+var downloaderReleaseURL = "https://api.github.com/repos/yt-dlp/yt-dlp/releases/latest"
+
+var lastDownloaderUpdate time.Time
+
+const downloaderUpdateTimeout = 5 * time.Minute
+
+// yt-dlp -U exits with this status after it replaced itself.
+const downloaderUpdatedStatus = 100
+
+var downloaderUpdatedRegexp = regexp.MustCompile(`Updated yt-dlp to (\S+)`)
+
+// maintainDownloader updates the downloader, or checks whether it is
+// outdated, if the update interval has passed. It runs in the update
+// loop between downloads, so yt-dlp is never replaced while running.
+func maintainDownloader(ctx context.Context) {
+	if downloaderUpdateInterval <= 0 || time.Since(lastDownloaderUpdate) < downloaderUpdateInterval {
+		return
+	}
+	lastDownloaderUpdate = time.Now()
+	if downloaderUpdateMode == "update" {
+		err := updateDownloader(ctx)
+		if err == nil {
+			return
+		}
+		log.Printf("%s update failed, checking the version: %v", downloader, err)
+	}
+	if err := checkDownloader(ctx); err != nil {
+		log.Printf("%s version check failed: %v", downloader, err)
+	}
+}
+
+// updateDownloader runs yt-dlp -U.
+func updateDownloader(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, downloaderUpdateTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, downloader, "-U").CombinedOutput()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == downloaderUpdatedStatus {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, lastLine(out))
+	}
+	if m := downloaderUpdatedRegexp.FindSubmatch(out); m != nil {
+		log.Printf("%s updated to %s", downloader, m[1])
+		events.Publish(Event{Type: EventDownloaderUpdated, Title: string(m[1])})
+		return nil
+	}
+	log.Printf("%s: %s", downloader, lastLine(out))
+	return nil
+}
+
+// checkDownloader compares the downloader version with the latest
+// release and reports it if outdated.
+func checkDownloader(ctx context.Context) error {
+	out, err := exec.Command(downloader, "--version").Output()
+	if err != nil {
+		return err
+	}
+	current := strings.TrimSpace(string(out))
+	latest, err := latestDownloaderRelease()
+	if err != nil {
+		return err
+	}
+	// Versions are dates, nightly builds add the time.
+	if current >= latest {
+		log.Printf("%s %s is up to date", downloader, current)
+		return nil
+	}
+	log.Printf("%s %s is outdated, the latest release is %s", downloader, current, latest)
+	events.Publish(Event{Type: EventDownloaderOutdated, Title: latest, Error: "installed version " + current})
+	return nil
+}
+
+func latestDownloaderRelease() (string, error) {
+	res, err := fetchClient.Get(downloaderReleaseURL)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", downloaderReleaseURL, res.Status)
+	}
+	release := struct {
+		TagName string `json:"tag_name"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&release); err != nil {
+		return "", err
+	}
+	if release.TagName == "" {
+		return "", fmt.Errorf("%s: no release tag", downloaderReleaseURL)
+	}
+	return release.TagName, nil
+}
+
+// lastLine returns the last non-empty line of command output.
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
@@ -0,0 +1,98 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+// Serve HTTP only, updates run in a separate lfpod update process.
+var serveOnly bool
+
+type cachedChannel struct {
+	feed    *youtube.Feed
+	fetched time.Time
+}
+
+// Channel feeds read by the last update. Feed requests are answered
+// from here, so serving does not wait for YouTube.
+var channelCache = struct {
+	mu    sync.Mutex
+	items map[string]cachedChannel
+}{items: map[string]cachedChannel{}}
+
+func cacheChannel(channelId string, ytfeed *youtube.Feed) {
+	channelCache.mu.Lock()
+	defer channelCache.mu.Unlock()
+	channelCache.items[channelId] = cachedChannel{ytfeed, time.Now()}
+}
+
+func cachedChannelFeed(channelId string) (cachedChannel, bool) {
+	channelCache.mu.Lock()
+	defer channelCache.mu.Unlock()
+	c, ok := channelCache.items[channelId]
+	return c, ok
+}
+
+// servedFeeds returns the channel feeds of feeds for feed generation.
+// Only channels not read within an update interval are fetched, if that
+// fails the stale channel feed is used.
+func servedFeeds(ctx context.Context, feeds []ConfFeed, concurrency int) []*youtube.Feed {
+	ytfeeds := make([]*youtube.Feed, len(feeds))
+	stale := []int{}
+	for i, feed := range feeds {
+		if c, ok := cachedChannelFeed(feed.ChannelId); ok && time.Since(c.fetched) < updateInterval {
+			ytfeeds[i] = c.feed
+		} else {
+			stale = append(stale, i)
+		}
+	}
+	if len(stale) == 0 {
+		return ytfeeds
+	}
+	missing := make([]ConfFeed, len(stale))
+	for j, i := range stale {
+		missing[j] = feeds[i]
+	}
+	for j, ytfeed := range fetchFeeds(ctx, missing, concurrency) {
+		i := stale[j]
+		if ytfeed != nil {
+			ytfeeds[i] = ytfeed
+		} else if c, ok := cachedChannelFeed(feeds[i].ChannelId); ok {
+			ytfeeds[i] = c.feed
+		}
+	}
+	return ytfeeds
+}
+
+// watchArchive bumps the feed version when another process adds or
+// deletes audio files, so conditional requests see new episodes.
+func watchArchive(conf *Conf, interval time.Duration) {
+	modTimes := map[string]time.Time{}
+	for {
+		changed := false
+		for _, feed := range conf.AllFeeds() {
+			info, err := os.Stat(filepath.Join("audio", feed.ChannelId))
+			if err != nil {
+				continue
+			}
+			if prev, ok := modTimes[feed.ChannelId]; ok && !prev.Equal(info.ModTime()) {
+				changed = true
+			}
+			modTimes[feed.ChannelId] = info.ModTime()
+		}
+		if changed {
+			log.Print("archive changed")
+			feedVersion.Bump()
+		}
+		time.Sleep(interval)
+	}
+}
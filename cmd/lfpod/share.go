@@ -0,0 +1,173 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const defaultShareTTL = 7 * 24 * time.Hour
+
+// Share links live at most this long, a leaked link stops working in
+// bounded time.
+const maxShareTTL = 30 * 24 * time.Hour
+
+// Key signing share tokens. A random key is used unless configured, so
+// share links then expire on restart.
+var shareKey []byte
+
+func initShareKey(secret string) {
+	if secret != "" {
+		shareKey = []byte(secret)
+		return
+	}
+	shareKey = make([]byte, 32)
+	if _, err := rand.Read(shareKey); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func shareSign(payload string) string {
+	mac := hmac.New(sha256.New, shareKey)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// newShareToken returns a token granting access to a single episode
+// until expires.
+func newShareToken(channelId, videoId string, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString(
+		[]byte(channelId + "/" + videoId + "/" + strconv.FormatInt(expires.Unix(), 10)))
+	return payload + "." + shareSign(payload)
+}
+
+func parseShareToken(token string) (channelId, videoId string, err error) {
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(shareSign(payload))) {
+		return "", "", errors.New("invalid share link")
+	}
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return "", "", errors.New("invalid share link")
+	}
+	parts := strings.Split(string(data), "/")
+	if len(parts) != 3 {
+		return "", "", errors.New("invalid share link")
+	}
+	expires, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return "", "", errors.New("share link expired")
+	}
+	return parts[0], parts[1], nil
+}
+
+type ShareLink struct {
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+func apiShareHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	channelId, videoId := vars["channelId"], vars["videoId"]
+	feed, ok := conf.GetFeed(channelId)
+	if !ok {
+		apiError(w, http.StatusNotFound, "feed not found", channelId)
+		return
+	}
+	if _, _, ok := feed.FindAudioFile(videoId); !ok {
+		apiError(w, http.StatusNotFound, "episode not found", videoId)
+		return
+	}
+	ttl := defaultShareTTL
+	if s := r.FormValue("ttl"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil || d <= 0 {
+			apiError(w, http.StatusBadRequest, "invalid ttl", s)
+			return
+		}
+		if d > maxShareTTL {
+			apiError(w, http.StatusBadRequest, "ttl longer than "+maxShareTTL.String(), s)
+			return
+		}
+		ttl = d
+	}
+	expires := time.Now().Add(ttl).Truncate(time.Second)
+	token := newShareToken(channelId, videoId, expires)
+	writeJSON(w, http.StatusCreated, ShareLink{conf.URL("share", token), expires})
+}
+
+var shareTemplate = template.Must(template.New("share").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>body { font-family: sans-serif; max-width: 40em; margin: auto; padding: 1em; } audio { width: 100%; }</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<audio controls preload="none" src="{{.Token}}/audio"></audio>
+<p><a href="{{.Token}}/audio?download=1">Download</a></p>
+</body>
+</html>
+`))
+
+func shareGetHandler(w http.ResponseWriter, r *http.Request) {
+	token := mux.Vars(r)["token"]
+	channelId, videoId, err := parseShareToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	title := videoId
+	for _, ep := range listEpisodes(r.Context(), channelId) {
+		if ep.VideoId == videoId && ep.Title != "" {
+			title = ep.Title
+		}
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	data := struct{ Title, Token string }{title, token}
+	if err := shareTemplate.Execute(w, data); err != nil {
+		log.Print(err)
+	}
+}
+
+func shareAudioHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	channelId, videoId, err := parseShareToken(mux.Vars(r)["token"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	feed, ok := conf.GetFeed(channelId)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	name, _, ok := feed.FindAudioFile(videoId)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if u, ok := storage.URL(name); ok {
+		http.Redirect(w, r, u, http.StatusFound)
+		return
+	}
+	if r.URL.Query().Has("download") {
+		w.Header().Set("Content-Disposition", `attachment; filename="`+filepath.Base(name)+`"`)
+	}
+	http.ServeFile(w, r, name)
+}
@@ -0,0 +1,36 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestShareTTL(t *testing.T) {
+	conf := setupPipeline(t)
+	initShareKey("test")
+	if err := os.WriteFile(conf.Feeds[0].AudioFileName("vid00000001"), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for ttl, want := range map[string]int{
+		"":     http.StatusCreated,
+		"48h":  http.StatusCreated,
+		"720h": http.StatusCreated,
+		"721h": http.StatusBadRequest,
+		"-1h":  http.StatusBadRequest,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/api/share/"+testChannelId+"/vid00000001?ttl="+ttl, nil)
+		r = mux.SetURLVars(r, map[string]string{"channelId": testChannelId, "videoId": "vid00000001"})
+		w := httptest.NewRecorder()
+		apiShareHandler(conf, w, r)
+		if w.Code != want {
+			t.Errorf("ttl %q: status %d, want %d", ttl, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+// Sidecar describes an audio file in a JSON file next to it, so the
+// file keeps its metadata when moved or after the video leaves the
+// channel feed.
+type Sidecar struct {
+	VideoId     string `json:"video_id"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	// Publication time, RFC 3339.
+	Published string `json:"published,omitempty"`
+	// Duration in seconds.
+	Duration  float64  `json:"duration,omitempty"`
+	URL       string   `json:"url"`
+	Uploader  string   `json:"uploader,omitempty"`
+	Thumbnail string   `json:"thumbnail,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	// Parts of an episode split by length.
+	Parts []SidecarPart `json:"parts,omitempty"`
+	// Time the video was found deleted or private on YouTube, RFC 3339.
+	Removed string `json:"removed,omitempty"`
+}
+
+func sidecarFileName(channelId, videoId string) string {
+	return filepath.Join("audio", channelId, videoId+".meta.json")
+}
+
+func readSidecar(channelId, videoId string) (Sidecar, bool) {
+	sidecar := Sidecar{}
+	data, err := os.ReadFile(sidecarFileName(channelId, videoId))
+	if err != nil || json.Unmarshal(data, &sidecar) != nil {
+		return sidecar, false
+	}
+	return sidecar, true
+}
+
+func writeSidecar(channelId string, sidecar *Sidecar) error {
+	data, err := json.MarshalIndent(sidecar, "", "    ")
+	if err != nil {
+		return err
+	}
+	file := sidecarFileName(channelId, sidecar.VideoId)
+	fileTmp := file + ".tmp"
+	if err := os.WriteFile(fileTmp, append(data, '\n'), 0640); err != nil {
+		return err
+	}
+	return os.Rename(fileTmp, file)
+}
+
+// newSidecar returns the sidecar of a recoded video, with the metadata
+// read by yt-dlp if info is not nil.
+func newSidecar(entry *youtube.Entry, info *episodeMetadata, duration time.Duration) *Sidecar {
+	sidecar := &Sidecar{
+		VideoId:   entry.VideoId,
+		Title:     entry.Title,
+		Published: entry.Published,
+		Duration:  duration.Seconds(),
+		URL:       "https://www.youtube.com/watch?v=" + entry.VideoId,
+	}
+	if entry.Media != nil {
+		sidecar.Description = entry.Media.Description
+		sidecar.Thumbnail = entry.Media.Thumbnail.URL
+	}
+	if info != nil {
+		sidecar.Uploader, sidecar.Tags = info.Uploader, info.Tags
+	}
+	return sidecar
+}
+
+// updateSidecar sets changed metadata in an existing sidecar.
+func updateSidecar(channelId, videoId string, m episodeMetadata) error {
+	sidecar, ok := readSidecar(channelId, videoId)
+	if !ok {
+		return nil
+	}
+	if m.Title != "" {
+		sidecar.Title = m.Title
+	}
+	if m.Description != "" {
+		sidecar.Description = m.Description
+	}
+	// Metadata was read, the video is back.
+	sidecar.Removed = ""
+	return writeSidecar(channelId, &sidecar)
+}
+
+// setSidecarRemoved records in an existing sidecar that the video was
+// removed from YouTube at time removed.
+func setSidecarRemoved(channelId, videoId, removed string) error {
+	sidecar, ok := readSidecar(channelId, videoId)
+	if !ok {
+		return nil
+	}
+	sidecar.Removed = removed
+	return writeSidecar(channelId, &sidecar)
+}
@@ -0,0 +1,88 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Shorter silence at the start or end of a video is kept.
+const trimSilenceMinDuration = 2 * time.Second
+
+var silenceRegexp = regexp.MustCompile(`silence_(start|end): *(-?[0-9.]+)`)
+
+// silenceBounds returns the part of fileIn between leading and trailing
+// silence below threshold dB, end is 0 if there is no trailing silence.
+// Trimming with atrim after detection avoids the areverse filter, which
+// buffers the whole stream to remove trailing silence in one pass.
+func silenceBounds(videoId, fileIn string, threshold float64) (start, end time.Duration, err error) {
+	duration, err := probeDuration(fileIn)
+	if err != nil {
+		return 0, 0, err
+	}
+	af := fmt.Sprintf("silencedetect=noise=%gdB:d=%g", threshold, trimSilenceMinDuration.Seconds())
+	args := append([]string{"-hide_banner", "-nostats", "-i", fileIn, "-af", af}, threadArgs()...)
+	cmd := exec.Command(converter, append(args, "-f", "null", "-")...)
+	out, err := runCommand(cmd, videoId, "silence")
+	if err != nil {
+		return 0, 0, fmt.Errorf("%v: %s", err, out)
+	}
+	// Silence still going on at the end of the input may have no
+	// silence_end, or one at the input duration.
+	var lastStart, lastEnd time.Duration = -1, -1
+	for _, m := range silenceRegexp.FindAllStringSubmatch(string(out), -1) {
+		sec, _ := strconv.ParseFloat(m[2], 64)
+		t := time.Duration(sec * float64(time.Second))
+		if m[1] == "start" {
+			lastStart, lastEnd = t, -1
+			continue
+		}
+		lastEnd = t
+		if lastStart < 100*time.Millisecond && start == 0 {
+			start = t
+		}
+	}
+	if lastStart > 0 && (lastEnd < 0 || lastEnd >= duration-100*time.Millisecond) {
+		end = lastStart
+	}
+	if start >= duration-100*time.Millisecond || (end != 0 && end <= start) {
+		// Silent throughout, nothing worth keeping is detected.
+		return 0, 0, nil
+	}
+	return start, end, nil
+}
+
+// trimFilter returns a filter keeping audio from start to end, or to the
+// end of the input if end is 0.
+func trimFilter(start, end time.Duration) string {
+	f := fmt.Sprintf("atrim=start=%g", start.Seconds())
+	if end != 0 {
+		f += fmt.Sprintf(":end=%g", end.Seconds())
+	}
+	return f + ",asetpts=PTS-STARTPTS"
+}
+
+// trimChapters shifts chapters to audio trimmed from start to end,
+// dropping chapters left empty.
+func trimChapters(chapters []Chapter, start, end time.Duration) []Chapter {
+	trimmed := []Chapter{}
+	for _, c := range chapters {
+		if end != 0 && c.End > end {
+			c.End = end
+		}
+		if c.Start < start {
+			c.Start = start
+		}
+		if c.End <= c.Start {
+			continue
+		}
+		c.Start, c.End = c.Start-start, c.End-start
+		trimmed = append(trimmed, c)
+	}
+	return trimmed
+}
@@ -0,0 +1,86 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Feed slugs are lowercase words joined by dashes, e.g. "veritasium".
+var slugRegexp = regexp.MustCompile(`^[a-z0-9]+(-[a-z0-9]+)*$`)
+
+// PathName returns the name of the feed in feed and audio URLs, its
+// slug or else its channel id.
+func (f *ConfFeed) PathName() string {
+	if f.Slug != "" {
+		return f.Slug
+	}
+	return f.ChannelId
+}
+
+// FeedBySlug returns the feed with a slug.
+func (c *Conf) FeedBySlug(slug string) (ConfFeed, bool) {
+	for _, feed := range c.AllFeeds() {
+		if slug != "" && feed.Slug == slug {
+			return feed, true
+		}
+	}
+	return ConfFeed{}, false
+}
+
+// audioPathName returns the name of the audio directory of a channel
+// in URLs.
+func (c *Conf) audioPathName(channelId string) string {
+	if feed, ok := c.GetFeed(channelId); ok {
+		return feed.PathName()
+	}
+	return channelId
+}
+
+// resolveAudioPath maps an audio URL path, /<slug or channel id>/<file>,
+// to the path of the file in the audio directory. canonical is the URL
+// path of the file, the slug path if the feed has one.
+func (c *Conf) resolveAudioPath(p string) (file, canonical string) {
+	dir, rest, _ := strings.Cut(strings.TrimPrefix(p, "/"), "/")
+	if feed, ok := c.FeedBySlug(dir); ok {
+		return "/" + feed.ChannelId + "/" + rest, p
+	}
+	if feed, ok := c.GetFeed(dir); ok && feed.Slug != "" {
+		return p, "/" + feed.Slug + "/" + rest
+	}
+	return p, p
+}
+
+// serveAudio serves a file of the audio directory below prefix, the
+// URL path elements before the channel, or its transcode. Channel id
+// URLs of feeds with a slug are redirected to the slug URL.
+func serveAudio(conf *Conf, w http.ResponseWriter, r *http.Request, prefix []string, p string) {
+	file, canonical := conf.resolveAudioPath(p)
+	if canonical != p {
+		u := conf.URL(append(prefix, strings.Split(strings.TrimPrefix(canonical, "/"), "/")...)...)
+		http.Redirect(w, r, withQuery(u, r.URL.RawQuery), http.StatusMovedPermanently)
+		return
+	}
+	r2 := r.Clone(r.Context())
+	r2.URL.Path, r2.URL.RawPath = file, ""
+	if isTranscoded(file) {
+		transcodeHandler(conf).ServeHTTP(w, r2)
+		return
+	}
+	audioFiles.ServeHTTP(w, r2)
+}
+
+// audioHandler serves the audio of the public channels, see
+// publicChannel.
+func audioHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, conf.BasePath+"/audio")
+	file, _ := conf.resolveAudioPath(rest)
+	if !conf.publicChannel(audioChannel(file)) {
+		http.NotFound(w, r)
+		return
+	}
+	serveAudio(conf, w, r, []string{"audio"}, rest)
+}
@@ -0,0 +1,103 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SidecarPart is a part of an episode split by length.
+type SidecarPart struct {
+	// File name in the channel audio directory.
+	File string `json:"file"`
+	// Duration in seconds.
+	Duration float64 `json:"duration"`
+}
+
+// partFileName returns part n, counted from 1, of the audio of a video.
+// Parts are not audio files to the archive scans, their extension is
+// partN.<format>.
+func partFileName(channelId, videoId string, n int, ext string) string {
+	return filepath.Join("audio", channelId, fmt.Sprintf("%s.part%d.%s", videoId, n, ext))
+}
+
+// splitAudio segments the audio file of a video into parts of the split
+// length of the feed, without recoding, with the video of video feeds.
+// It returns the parts, the audio file is kept for everything else.
+func splitAudio(feed *ConfFeed, videoId, file string) ([]SidecarPart, error) {
+	ext := strings.TrimPrefix(filepath.Ext(file), ".")
+	removeParts(feed.ChannelId, videoId)
+	seconds := strconv.Itoa(feed.SplitMinutes * 60)
+	pattern := strings.Replace(partFileName(feed.ChannelId, videoId, 0, ext), ".part0.", ".part%d.", 1)
+	streams := "0:a"
+	if feed.IsVideo() {
+		streams = "0"
+	}
+	cmd := exec.Command(converter, "-v", "error", "-i", file, "-map", streams, "-c", "copy",
+		"-f", "segment", "-segment_time", seconds, "-segment_start_number", "1", "-reset_timestamps", "1",
+		"-y", pattern)
+	cmd.Dir, _ = os.Getwd()
+	if out, err := runCommand(cmd, videoId, "split"); err != nil {
+		removeParts(feed.ChannelId, videoId)
+		return nil, fmt.Errorf("%s: %v: %s", converter, err, out)
+	}
+	parts := []SidecarPart{}
+	for n := 1; ; n++ {
+		name := partFileName(feed.ChannelId, videoId, n, ext)
+		if !fileExists(name) {
+			break
+		}
+		duration, err := probeDuration(name)
+		if err != nil {
+			removeParts(feed.ChannelId, videoId)
+			return nil, err
+		}
+		parts = append(parts, SidecarPart{File: filepath.Base(name), Duration: duration.Seconds()})
+	}
+	if len(parts) < 2 {
+		removeParts(feed.ChannelId, videoId)
+		return nil, nil
+	}
+	return parts, nil
+}
+
+// removeParts deletes the parts of a video.
+func removeParts(channelId, videoId string) {
+	names, _ := filepath.Glob(filepath.Join("audio", channelId, videoId+".part*"))
+	for _, name := range names {
+		os.Remove(name)
+	}
+}
+
+// expandParts replaces episodes split into parts by an episode per part,
+// titled "Title (Part 1/3)". Parts are a second apart, so players
+// sorting by date keep their order.
+func expandParts(list []FeedEpisode) []FeedEpisode {
+	expanded := []FeedEpisode{}
+	for _, ep := range list {
+		for i := len(ep.Parts) - 1; i >= 0; i-- {
+			p := ep.Parts[i]
+			part := ep
+			part.Parts = nil
+			part.Title = fmt.Sprintf("%s (Part %d/%d)", ep.Title, i+1, len(ep.Parts))
+			part.File = filepath.Join("audio", ep.ChannelId, p.File)
+			part.Duration = time.Duration(p.Duration * float64(time.Second))
+			part.Published = ep.Published.Add(time.Duration(i) * time.Second)
+			// Chapters and transcripts are timed for the whole episode.
+			part.Chapters, part.Transcripts, part.Alternates = "", nil, nil
+			part.Size = episodeIndex.files(ep.ChannelId)[p.File].size
+			expanded = append(expanded, part)
+		}
+		if len(ep.Parts) == 0 {
+			expanded = append(expanded, ep)
+		}
+	}
+	return expanded
+}
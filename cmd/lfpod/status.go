@@ -0,0 +1,232 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatusJob is a video queued or being processed by the update loop.
+type StatusJob struct {
+	Feed    string     `json:"feed"`
+	VideoId string     `json:"video_id"`
+	Title   string     `json:"title"`
+	Started *time.Time `json:"started,omitempty"`
+}
+
+// FeedStatus is the outcome of the last update of a feed.
+type FeedStatus struct {
+	Name        string     `json:"name"`
+	ChannelId   string     `json:"channel_id"`
+	Disabled    bool       `json:"disabled,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	ErrorTime   *time.Time `json:"error_time,omitempty"`
+	// Failed reads of the channel feed since the last successful one.
+	ConsecutiveErrors int `json:"consecutive_errors,omitempty"`
+	// Next poll of a feed with a schedule.
+	NextPoll *time.Time `json:"next_poll,omitempty"`
+}
+
+// FeedStats is the health of a feed, for monitoring.
+type FeedStats struct {
+	Name        string     `json:"name"`
+	ChannelId   string     `json:"channel_id"`
+	Disabled    bool       `json:"disabled,omitempty"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	// Modification time of the newest audio file.
+	LastEpisode       *time.Time `json:"last_episode,omitempty"`
+	Episodes          int        `json:"episodes"`
+	Size              int64      `json:"size"`
+	ConsecutiveErrors int        `json:"consecutive_errors"`
+	LastError         string     `json:"last_error,omitempty"`
+	ErrorTime         *time.Time `json:"error_time,omitempty"`
+	// Next poll, scheduled or periodic, none for disabled feeds and
+	// without an update loop.
+	NextPoll *time.Time `json:"next_poll,omitempty"`
+}
+
+// LoopStatus is what the update loop is doing right now.
+type LoopStatus struct {
+	Running bool `json:"running"`
+	Paused  bool `json:"paused"`
+	// End and cause of a backoff after YouTube rate limited lfpod.
+	ThrottledUntil *time.Time   `json:"throttled_until,omitempty"`
+	ThrottleReason string       `json:"throttle_reason,omitempty"`
+	Started        *time.Time   `json:"started,omitempty"`
+	LastUpdate     *time.Time   `json:"last_update,omitempty"`
+	Active         []StatusJob  `json:"active"`
+	Queued         []StatusJob  `json:"queued"`
+	Feeds          []FeedStatus `json:"feeds"`
+	// End of the quiet hours downloads are deferred to.
+	QuietUntil *time.Time `json:"quiet_until,omitempty"`
+}
+
+// StatusTracker follows the update loop through its events.
+type StatusTracker struct {
+	mu      sync.Mutex
+	started time.Time
+	active  []StatusJob
+	queued  []StatusJob
+	feeds   map[string]FeedStatus
+	// Next periodic update pass.
+	next time.Time
+}
+
+var loopStatus = StatusTracker{feeds: map[string]FeedStatus{}}
+
+func statusJob(job Job) StatusJob {
+	return StatusJob{Feed: job.Feed.Name, VideoId: job.Entry.VideoId, Title: job.Entry.Title}
+}
+
+// Start records the start of an update pass and the videos it queued.
+func (s *StatusTracker) Start(jobs []Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = time.Now()
+	s.active = []StatusJob{}
+	s.queued = []StatusJob{}
+	for _, job := range jobs {
+		s.queued = append(s.queued, statusJob(job))
+	}
+}
+
+// Finish records the end of an update pass, videos skipped because of
+// a pause are no longer queued.
+func (s *StatusTracker) Finish() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = time.Time{}
+	s.active, s.queued = nil, nil
+}
+
+// Fetched records the outcome of reading a channel feed.
+func (s *StatusTracker) Fetched(feed *ConfFeed, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	fs := s.feeds[feed.ChannelId]
+	if err != nil {
+		fs.LastError, fs.ErrorTime = err.Error(), &now
+		fs.ConsecutiveErrors++
+	} else {
+		fs.LastSuccess, fs.ConsecutiveErrors = &now, 0
+	}
+	s.feeds[feed.ChannelId] = fs
+}
+
+// Planned records the time of the next periodic update pass.
+func (s *StatusTracker) Planned(next time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.next = next
+}
+
+// FeedStats returns the health of a feed.
+func (s *StatusTracker) FeedStats(feed ConfFeed) FeedStats {
+	s.mu.Lock()
+	fs, next := s.feeds[feed.ChannelId], s.next
+	s.mu.Unlock()
+	stats := FeedStats{
+		Name:              feed.Name,
+		ChannelId:         feed.ChannelId,
+		Disabled:          feed.Disabled,
+		LastSuccess:       fs.LastSuccess,
+		ConsecutiveErrors: fs.ConsecutiveErrors,
+		LastError:         fs.LastError,
+		ErrorTime:         fs.ErrorTime,
+	}
+	var last time.Time
+	for name, f := range episodeIndex.files(feed.ChannelId) {
+		stats.Size += f.size
+		_, ext, _ := strings.Cut(name, ".")
+		if containsString(audioFormatNames, ext) && f.modTime.After(last) {
+			last = f.modTime
+		}
+	}
+	stats.Episodes = len(episodeIndex.VideoIds(feed.ChannelId))
+	if !last.IsZero() {
+		stats.LastEpisode = &last
+	}
+	switch {
+	case feed.Disabled || feed.Inbox:
+	case feed.Schedule != "":
+		if t, ok := feedSchedules.Of(feed.ChannelId); ok {
+			stats.NextPoll = &t
+		}
+	case !next.IsZero():
+		stats.NextPoll = &next
+	}
+	return stats
+}
+
+func removeStatusJob(list []StatusJob, videoId string) []StatusJob {
+	for i, j := range list {
+		if j.VideoId == videoId {
+			return append(list[:i:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// Track is subscribed to the event bus.
+func (s *StatusTracker) Track(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch e.Type {
+	case EventDownloadStarted:
+		s.queued = removeStatusJob(s.queued, e.VideoId)
+		t := e.Time
+		s.active = append(s.active, StatusJob{Feed: e.Feed, VideoId: e.VideoId, Title: e.Title, Started: &t})
+	case EventDownloadFinished:
+		s.active = removeStatusJob(s.active, e.VideoId)
+	case EventDownloadFailed:
+		s.active = removeStatusJob(s.active, e.VideoId)
+		t := e.Time
+		fs := s.feeds[e.ChannelId]
+		fs.LastError, fs.ErrorTime = e.VideoId+": "+e.Error, &t
+		s.feeds[e.ChannelId] = fs
+	}
+}
+
+func (s *StatusTracker) Get(conf *Conf) LoopStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := LoopStatus{
+		Running: updateRunning.Load(),
+		Paused:  pause.Active(),
+		Active:  append([]StatusJob{}, s.active...),
+		Queued:  append([]StatusJob{}, s.queued...),
+		Feeds:   []FeedStatus{},
+	}
+	if !s.started.IsZero() {
+		t := s.started
+		status.Started = &t
+	}
+	if t := lastUpdate.Last(); !t.IsZero() {
+		status.LastUpdate = &t
+	}
+	if until, reason, ok := throttle.Active(time.Now()); ok {
+		status.ThrottledUntil, status.ThrottleReason = &until, reason
+	}
+	if until, ok := quiet.Until(time.Now()); ok {
+		status.QuietUntil = &until
+	}
+	for _, feed := range conf.AllFeeds() {
+		fs := s.feeds[feed.ChannelId]
+		fs.Name, fs.ChannelId, fs.Disabled = feed.Name, feed.ChannelId, feed.Disabled
+		if t, ok := feedSchedules.Of(feed.ChannelId); ok {
+			fs.NextPoll = &t
+		}
+		status.Feeds = append(status.Feeds, fs)
+	}
+	return status
+}
+
+func apiStatusHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, loopStatus.Get(conf))
+}
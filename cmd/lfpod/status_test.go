@@ -0,0 +1,112 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+func statusVideoIds(jobs []StatusJob) string {
+	ids := []string{}
+	for _, j := range jobs {
+		ids = append(ids, j.VideoId)
+	}
+	return strings.Join(ids, " ")
+}
+
+func TestStatusTrack(t *testing.T) {
+	feed := ConfFeed{Name: "news", ChannelId: "UCnews"}
+	event := func(typ, videoId string) Event {
+		return Event{Type: typ, Time: time.Unix(1700000000, 0), Feed: feed.Name, ChannelId: feed.ChannelId,
+			VideoId: videoId, Error: "gone"}
+	}
+	for _, tc := range []struct {
+		name           string
+		events         []Event
+		active, queued string
+		lastError      string
+	}{
+		{"queued", nil, "", "v1 v2 v3", ""},
+		{"started", []Event{event(EventDownloadStarted, "v2")}, "v2", "v1 v3", ""},
+		{"finished", []Event{
+			event(EventDownloadStarted, "v1"),
+			event(EventDownloadStarted, "v2"),
+			event(EventDownloadFinished, "v1"),
+		}, "v2", "v3", ""},
+		{"failed", []Event{
+			event(EventDownloadStarted, "v3"),
+			event(EventDownloadFailed, "v3"),
+		}, "", "v1 v2", "v3: gone"},
+		{"unknown video", []Event{event(EventDownloadFinished, "v9")}, "", "v1 v2 v3", ""},
+	} {
+		s := StatusTracker{feeds: map[string]FeedStatus{}}
+		jobs := []Job{}
+		for _, videoId := range []string{"v1", "v2", "v3"} {
+			jobs = append(jobs, Job{Feed: feed, Entry: &youtube.Entry{VideoId: videoId}})
+		}
+		s.Start(jobs)
+		for _, e := range tc.events {
+			s.Track(e)
+		}
+		if got := statusVideoIds(s.active); got != tc.active {
+			t.Errorf("%s: active %q, want %q", tc.name, got, tc.active)
+		}
+		if got := statusVideoIds(s.queued); got != tc.queued {
+			t.Errorf("%s: queued %q, want %q", tc.name, got, tc.queued)
+		}
+		if got := s.feeds[feed.ChannelId].LastError; got != tc.lastError {
+			t.Errorf("%s: last error %q, want %q", tc.name, got, tc.lastError)
+		}
+		for _, j := range s.active {
+			if j.Started == nil || j.Feed != feed.Name {
+				t.Errorf("%s: active %+v", tc.name, j)
+			}
+		}
+		s.Finish()
+		if len(s.active) != 0 || len(s.queued) != 0 {
+			t.Errorf("%s: active %v and queued %v after the pass", tc.name, s.active, s.queued)
+		}
+	}
+}
+
+func TestStatusFetched(t *testing.T) {
+	feed := &ConfFeed{Name: "news", ChannelId: "UCnews"}
+	for _, tc := range []struct {
+		errs              []error
+		consecutiveErrors int
+		lastError         string
+		success           bool
+	}{
+		{nil, 0, "", false},
+		{[]error{nil}, 0, "", true},
+		{[]error{errors.New("404")}, 1, "404", false},
+		{[]error{errors.New("404"), errors.New("500")}, 2, "500", false},
+		// Successes reset the count but keep the last error.
+		{[]error{errors.New("404"), nil}, 0, "404", true},
+		{[]error{nil, errors.New("500")}, 1, "500", true},
+	} {
+		s := StatusTracker{feeds: map[string]FeedStatus{}}
+		for _, err := range tc.errs {
+			s.Fetched(feed, err)
+		}
+		conf := &Conf{ConfFeeds: ConfFeeds{Feeds: []ConfFeed{*feed, {Name: "talks", ChannelId: "UCtalks", Disabled: true}}}}
+		status := s.Get(conf)
+		if len(status.Feeds) != 2 {
+			t.Fatalf("%v: status of %d feeds", tc.errs, len(status.Feeds))
+		}
+		fs := status.Feeds[0]
+		if fs.Name != feed.Name || fs.ChannelId != feed.ChannelId || fs.ConsecutiveErrors != tc.consecutiveErrors ||
+			fs.LastError != tc.lastError || (fs.LastSuccess != nil) != tc.success || (fs.ErrorTime != nil) != (tc.lastError != "") {
+			t.Errorf("%v: status %+v", tc.errs, fs)
+		}
+		if fs := status.Feeds[1]; fs.Name != "talks" || !fs.Disabled || fs.LastSuccess != nil || fs.LastError != "" {
+			t.Errorf("%v: status of a feed not fetched %+v", tc.errs, fs)
+		}
+	}
+}
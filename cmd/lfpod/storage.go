@@ -0,0 +1,156 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Storage publishes audio files. Files are always recoded into the
+// audio directory, a remote storage uploads them afterwards and leaves a
+// hollow file of the same size behind, so the archive is still listed
+// and measured locally.
+type Storage interface {
+	// Store uploads an audio file, name is relative to the data
+	// directory, e.g. audio/<channel>/<video>.opus.
+	Store(name string) error
+	// Remove deletes an uploaded file, the caller deletes the local one.
+	Remove(name string) error
+	// URL returns the URL clients download an uploaded file from, false
+	// if the file is served from the audio directory.
+	URL(name string) (string, bool)
+}
+
+// localStorage serves audio files from the audio directory.
+type localStorage struct{}
+
+func (localStorage) Store(name string) error        { return nil }
+func (localStorage) Remove(name string) error       { return nil }
+func (localStorage) URL(name string) (string, bool) { return "", false }
+
+var storage Storage = localStorage{}
+
+// audioURL returns the enclosure URL of an audio file.
+func audioURL(conf *Conf, name string) string {
+	if u, ok := storage.URL(name); ok {
+		return u
+	}
+	return conf.URL("audio", conf.audioPathName(filepath.Base(filepath.Dir(name))), filepath.Base(name))
+}
+
+// storageObjectKey returns the object key of an audio file,
+// <channel>/<video>.<ext> under prefix.
+func storageObjectKey(prefix, name string) string {
+	key := filepath.Base(filepath.Dir(name)) + "/" + filepath.Base(name)
+	if prefix != "" {
+		key = strings.Trim(prefix, "/") + "/" + key
+	}
+	return key
+}
+
+// hollowProbeSize is the number of leading zero bytes that tell a hollow
+// file from an audio file, every container starts with a magic.
+const hollowProbeSize = 512
+
+// isHollow reports whether the content of an audio file was dropped
+// after upload.
+func isHollow(name string) bool {
+	f, err := os.Open(name)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, hollowProbeSize)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return false
+	}
+	return n > 0 && bytes.Count(buf[:n], []byte{0}) == n
+}
+
+// hollowFile replaces the content of an uploaded file by a sparse
+// hole of the same size, taking no disk space on most file systems.
+func hollowFile(name string) error {
+	info, err := os.Stat(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Truncate(name, 0); err != nil {
+		return err
+	}
+	if err := os.Truncate(name, info.Size()); err != nil {
+		return err
+	}
+	return os.Chtimes(name, info.ModTime(), info.ModTime())
+}
+
+// syncStorage uploads audio files not uploaded yet, e.g. the archive
+// downloaded before a remote storage was set or failed uploads.
+func syncStorage(conf *Conf) {
+	if _, ok := storage.(localStorage); ok {
+		return
+	}
+	files, err := scanArchive(conf)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	for _, f := range files {
+		if err := storage.Store(f.Path); err != nil {
+			log.Print(f.Path, " upload: ", err)
+		}
+	}
+}
+
+// redirectStored redirects requests of uploaded audio files to their
+// storage URL, the rest is served by next from the audio directory.
+func redirectStored(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := filepath.Join("audio", filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+		if u, ok := storage.URL(name); ok {
+			http.Redirect(w, r, u, http.StatusFound)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setStorage sets the storage for an s3://bucket/prefix location.
+func setStorage(location string, opts s3Options) error {
+	s, err := newStorage(location, opts)
+	if err != nil {
+		return err
+	}
+	if _, ok := s.(localStorage); !ok && blobDir != "" {
+		return errors.New("-storage cannot be combined with -blob-dir")
+	}
+	storage = s
+	return nil
+}
+
+// newStorage returns the storage for an s3://bucket/prefix location,
+// the local storage if empty.
+func newStorage(location string, opts s3Options) (Storage, error) {
+	if location == "" {
+		return localStorage{}, nil
+	}
+	rest, ok := strings.CutPrefix(location, "s3://")
+	if !ok {
+		return nil, fmt.Errorf("unsupported storage %q, want s3://bucket/prefix", location)
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("no bucket in storage %q", location)
+	}
+	return newS3Storage(bucket, prefix, opts)
+}
@@ -0,0 +1,66 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lfpod/store"
+)
+
+// SyncEpisode is an episode change with the URL of its audio file, if
+// it is ready.
+type SyncEpisode struct {
+	store.Episode
+	URL string `json:"url,omitempty"`
+}
+
+// SyncResult lists episode changes since a cursor. Cursors are only
+// valid until restart, Reset is set when the client has to drop its
+// copy as all known episodes are listed again.
+type SyncResult struct {
+	Cursor   string        `json:"cursor"`
+	Reset    bool          `json:"reset"`
+	Episodes []SyncEpisode `json:"episodes"`
+}
+
+func apiSyncHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	var since uint64
+	reset := true
+	if cursor := r.FormValue("since"); cursor != "" {
+		e, n, ok := strings.Cut(cursor, "-")
+		epoch, err1 := strconv.ParseInt(e, 16, 64)
+		seq, err2 := strconv.ParseUint(n, 16, 64)
+		if !ok || err1 != nil || err2 != nil {
+			apiError(w, http.StatusBadRequest, "invalid cursor", cursor)
+			return
+		}
+		if epoch == episodes.Epoch() {
+			since, reset = seq, false
+		}
+	}
+	changes, epoch, seq, err := episodes.Changes(r.Context(), since)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "episode store failed", err.Error())
+		return
+	}
+	result := SyncResult{
+		Cursor:   fmt.Sprintf("%x-%x", epoch, seq),
+		Reset:    reset,
+		Episodes: []SyncEpisode{},
+	}
+	for _, ep := range changes {
+		item := SyncEpisode{Episode: ep}
+		if feed, ok := conf.GetFeed(ep.ChannelId); ok && ep.Status == store.StatusReady {
+			if name, _, ok := feed.FindAudioFile(ep.VideoId); ok {
+				item.URL = audioURL(conf, name)
+			}
+		}
+		result.Episodes = append(result.Episodes, item)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
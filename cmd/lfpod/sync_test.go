@@ -0,0 +1,103 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/lfpod/store"
+	"github.com/lfpod/youtube"
+)
+
+func TestAPISync(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	saved := episodes
+	episodes = memoryEpisodes()
+	t.Cleanup(func() {
+		os.Chdir(wd)
+		episodes.Close()
+		episodes = saved
+	})
+	const channelId = "UCsync"
+	conf := &Conf{ServerAddress: "podcast.example", ConfFeeds: ConfFeeds{Feeds: []ConfFeed{{Name: "news", ChannelId: channelId}}}}
+	if err := os.MkdirAll(filepath.Join("audio", channelId), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join("audio", channelId, "vid00000001.opus"), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	setStatus := func(videoId, status string) {
+		if err := episodes.SetStatus(ctx, channelId, &youtube.Entry{VideoId: videoId, Title: videoId}, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sync := func(since string) (int, SyncResult) {
+		w := httptest.NewRecorder()
+		apiSyncHandler(conf, w, httptest.NewRequest("GET", "/api/sync?since="+since, nil))
+		result := SyncResult{}
+		if w.Code == http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, result
+	}
+	setStatus("vid00000001", store.StatusReady)
+	setStatus("vid00000002", store.StatusDownloading)
+	_, first := sync("")
+	setStatus("vid00000002", store.StatusFailed)
+
+	for _, tc := range []struct {
+		name, since string
+		status      int
+		reset       bool
+		videoIds    string
+	}{
+		{"no cursor", "", http.StatusOK, true, "vid00000001 vid00000002"},
+		{"current cursor", first.Cursor, http.StatusOK, false, "vid00000002"},
+		{"cursor before restart", fmt.Sprintf("%x-1", episodes.Epoch()+1), http.StatusOK, true, "vid00000001 vid00000002"},
+		{"no separator", "12", http.StatusBadRequest, false, ""},
+		{"invalid epoch", "x-1", http.StatusBadRequest, false, ""},
+		{"invalid sequence", "1-y", http.StatusBadRequest, false, ""},
+	} {
+		status, result := sync(tc.since)
+		ids := []string{}
+		for _, ep := range result.Episodes {
+			ids = append(ids, ep.VideoId)
+		}
+		if status != tc.status || result.Reset != tc.reset || fmt.Sprint(ids) != fmt.Sprint(strings.Fields(tc.videoIds)) {
+			t.Errorf("%s: status %d, reset %v, episodes %v", tc.name, status, result.Reset, ids)
+		}
+		if status == http.StatusOK && result.Cursor == tc.since {
+			t.Errorf("%s: cursor not advanced", tc.name)
+		}
+	}
+
+	// Only ready episodes have a URL.
+	_, all := sync("")
+	for _, ep := range all.Episodes {
+		want := ""
+		if ep.VideoId == "vid00000001" {
+			want = "http://podcast.example/audio/" + channelId + "/vid00000001.opus"
+		}
+		if ep.URL != want {
+			t.Errorf("%s URL %q, want %q", ep.VideoId, ep.URL, want)
+		}
+	}
+}
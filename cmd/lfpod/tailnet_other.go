@@ -0,0 +1,14 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build !tsnet
+
+package main
+
+import "net"
+
+const tailnetSupported = false
+
+func listenTailnet(hostname string) (net.Listener, error) {
+	return nil, errNoTailnet
+}
@@ -0,0 +1,38 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+//go:build tsnet
+
+package main
+
+import (
+	"log"
+	"net"
+	"path/filepath"
+
+	"tailscale.com/tsnet"
+)
+
+const tailnetSupported = true
+
+// listenTailnet joins the tailnet as a node of its own named hostname
+// and listens for HTTPS on it, with the certificate of the tailnet. The
+// node keys are kept in a directory per hostname under tailnetStateDir.
+// The first start needs TS_AUTHKEY or a login at the URL logged.
+func listenTailnet(hostname string) (net.Listener, error) {
+	s := &tsnet.Server{
+		Hostname: hostname,
+		Dir:      filepath.Join(tailnetStateDir, hostname),
+		UserLogf: log.Printf,
+		Logf:     func(string, ...interface{}) {},
+	}
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+	l, err := s.ListenTLS("tcp", ":443")
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	return l, nil
+}
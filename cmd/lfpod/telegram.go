@@ -0,0 +1,266 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+// A getUpdates request waits this long for messages.
+const telegramPollTimeout = 50 * time.Second
+
+const telegramHelp = `Send a YouTube video link to download it into the inbox feed, or a channel link or @handle to add the channel as a feed.
+
+/video <feed> <link> downloads a video into a feed
+/update [feed] updates all feeds or one
+/list lists the feeds
+/status shows what lfpod is doing`
+
+// TelegramBot takes links and commands from the chat of a telegram
+// notifier. Messages from other chats are ignored.
+type TelegramBot struct {
+	conf     *Conf
+	notifier *Notifier
+	client   *http.Client
+	// Id of the next update to receive.
+	offset int64
+}
+
+type telegramUpdate struct {
+	UpdateId int64 `json:"update_id"`
+	Message  *struct {
+		Text string `json:"text"`
+		Chat struct {
+			Id int64 `json:"id"`
+		} `json:"chat"`
+	} `json:"message"`
+}
+
+func newTelegramBot(conf *Conf, n *Notifier) *TelegramBot {
+	client := *fetchClient
+	client.Timeout = telegramPollTimeout + 10*time.Second
+	return &TelegramBot{conf: conf, notifier: n, client: &client}
+}
+
+// call calls a method of the Bot API, decoding its result into result
+// if not nil.
+func (b *TelegramBot) call(method string, params, result any) error {
+	data, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	// Errors name the method only, the URL contains the bot token.
+	res, err := b.client.Post(telegramAPI+"/bot"+b.notifier.BotToken+"/"+method, "application/json", bytes.NewReader(data))
+	if err != nil {
+		var urlErr *neturl.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram %s: %v", method, err)
+	}
+	defer res.Body.Close()
+	reply := struct {
+		Ok          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&reply); err != nil {
+		return fmt.Errorf("telegram %s: %s", method, res.Status)
+	}
+	if !reply.Ok {
+		return fmt.Errorf("telegram %s: %s", method, reply.Description)
+	}
+	if result != nil {
+		return json.Unmarshal(reply.Result, result)
+	}
+	return nil
+}
+
+// Poll waits for messages and answers those from the chat of the
+// notifier.
+func (b *TelegramBot) Poll(ctx context.Context) error {
+	updates := []telegramUpdate{}
+	params := map[string]any{"offset": b.offset, "timeout": int(telegramPollTimeout.Seconds()),
+		"allowed_updates": []string{"message"}}
+	if err := b.call("getUpdates", params, &updates); err != nil {
+		return err
+	}
+	for _, u := range updates {
+		b.offset = u.UpdateId + 1
+		if u.Message == nil || u.Message.Text == "" {
+			continue
+		}
+		if chat := strconv.FormatInt(u.Message.Chat.Id, 10); chat != b.notifier.ChatId {
+			log.Print("telegram: ignored message from chat ", chat)
+			continue
+		}
+		reply := map[string]string{"chat_id": b.notifier.ChatId, "text": b.handle(ctx, u.Message.Text)}
+		if err := b.call("sendMessage", reply, nil); err != nil {
+			log.Print(err)
+		}
+	}
+	return nil
+}
+
+// run polls until the process ends.
+func (b *TelegramBot) run(ctx context.Context) {
+	log.Print("telegram bot started")
+	for {
+		if err := b.Poll(ctx); err != nil {
+			log.Print(err)
+			time.Sleep(time.Minute)
+		}
+	}
+}
+
+// handle carries out a message and returns the reply.
+func (b *TelegramBot) handle(ctx context.Context, text string) string {
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return telegramHelp
+	}
+	// Commands in groups are addressed as /command@bot.
+	command, _, _ := strings.Cut(fields[0], "@")
+	args := fields[1:]
+	switch command {
+	case "/start", "/help":
+		return telegramHelp
+	case "/list":
+		names := []string{}
+		for _, feed := range b.conf.AllFeeds() {
+			names = append(names, feed.Title())
+		}
+		if len(names) == 0 {
+			return "No feeds yet, send a channel link."
+		}
+		return strings.Join(names, "\n")
+	case "/status":
+		return b.status()
+	case "/update":
+		req := UpdateRequest{}
+		if len(args) > 0 {
+			feed, ok := findFeed(b.conf.AllFeeds(), strings.Join(args, " "))
+			if !ok {
+				return fmt.Sprintf("Feed %q not found.", strings.Join(args, " "))
+			}
+			req.ChannelId = feed.ChannelId
+		}
+		if !triggerUpdate(req) {
+			return "An update is already queued."
+		}
+		return "Update started."
+	case "/video":
+		if len(args) < 2 {
+			return "Usage: /video <feed> <link>"
+		}
+		feed, err := videoFeed(b.conf, args[0])
+		if err != nil {
+			return err.Error()
+		}
+		return b.queueVideos(ctx, feed, inboxVideoIds(strings.Join(args[1:], "\n")))
+	}
+	if m := videoURLRegexp.FindAllStringSubmatch(text, -1); len(m) > 0 {
+		feed, err := videoFeed(b.conf, "")
+		if err != nil {
+			return "There is no inbox feed, send /video <feed> <link>."
+		}
+		ids := []string{}
+		for _, match := range m {
+			ids = append(ids, match[1])
+		}
+		return b.queueVideos(ctx, feed, ids)
+	}
+	if s := firstNonEmpty(youtube.ChannelIdRegexp.FindString(text), channelHandleRegexp.FindString(strings.TrimSpace(text))); s != "" {
+		return b.addChannel(ctx, s)
+	}
+	return "Send a YouTube video or channel link, or /help."
+}
+
+// queueVideos queues videos for download into a feed and starts an
+// update of it.
+func (b *TelegramBot) queueVideos(ctx context.Context, feed ConfFeed, videoIds []string) string {
+	if len(videoIds) == 0 {
+		return errNoVideo.Error()
+	}
+	lines := []string{}
+	queued := false
+	for _, videoId := range videoIds {
+		if err := queueVideo(ctx, feed, videoId, "telegram"); err != nil {
+			lines = append(lines, videoId+": "+err.Error())
+			continue
+		}
+		log.Print("telegram: queued ", videoId, " for ", feed.Name)
+		lines = append(lines, fmt.Sprintf("Queued %s for %s.", videoId, feed.Title()))
+		queued = true
+	}
+	if queued {
+		triggerUpdate(UpdateRequest{ChannelId: feed.ChannelId})
+	}
+	return strings.Join(lines, "\n")
+}
+
+// addChannel adds a channel id, handle or URL as a feed named after the
+// channel.
+func (b *TelegramBot) addChannel(ctx context.Context, s string) string {
+	c, err := resolveChannel(ctx, s)
+	if err != nil {
+		return err.Error()
+	}
+	if feed, ok := b.conf.GetFeed(c.ChannelId); ok {
+		return fmt.Sprintf("%s is already a feed.", feed.Title())
+	}
+	feed := ConfFeed{Name: firstNonEmpty(c.Title, c.ChannelId), ChannelId: c.ChannelId}
+	if msg := validateFeed(feed); msg != "" {
+		return msg
+	}
+	if err := b.conf.PutFeed(feed); err != nil {
+		log.Print(err)
+		return "Cannot save the configuration."
+	}
+	log.Print("feed ", feed.Name, " added")
+	triggerUpdate(UpdateRequest{ChannelId: feed.ChannelId})
+	return fmt.Sprintf("Added feed %s.\n%s", feed.Name, b.conf.URL("feed", feed.PathName()))
+}
+
+// status summarizes what the update loop is doing.
+func (b *TelegramBot) status() string {
+	s := loopStatus.Get(b.conf)
+	lines := []string{"Idle."}
+	switch {
+	case s.Paused:
+		lines[0] = "Paused."
+	case s.Running:
+		lines[0] = "Updating."
+	}
+	if s.ThrottledUntil != nil {
+		lines = append(lines, "Rate limited until "+s.ThrottledUntil.Local().Format("15:04")+".")
+	}
+	if s.QuietUntil != nil {
+		lines = append(lines, "Quiet hours until "+s.QuietUntil.Local().Format("15:04")+".")
+	}
+	for _, j := range s.Active {
+		lines = append(lines, fmt.Sprintf("Processing %s: %s", j.Feed, firstNonEmpty(j.Title, j.VideoId)))
+	}
+	if len(s.Queued) > 0 {
+		lines = append(lines, fmt.Sprintf("%d videos queued.", len(s.Queued)))
+	}
+	for _, f := range s.Feeds {
+		if f.LastError != "" {
+			lines = append(lines, fmt.Sprintf("%s failing: %s", f.Name, f.LastError))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/lfpod/pipeline"
+	"github.com/lfpod/youtube"
+)
+
+// Backoff of the first rate limit, doubled with each following one up
+// to throttleMax.
+const (
+	throttleBase = 5 * time.Minute
+	throttleMax  = 6 * time.Hour
+)
+
+// Throttle stops updates while YouTube rate limits lfpod, so the
+// remaining videos of a pass do not make it worse. Each rate limit in a
+// row doubles the backoff, a pass without one resets it.
+type Throttle struct {
+	mu     sync.Mutex
+	until  time.Time
+	level  int
+	hits   int
+	reason string
+}
+
+var throttle = Throttle{}
+
+// Hit records a rate limit, the backoff is at least retryAfter. It
+// returns the end of the backoff.
+func (t *Throttle) Hit(reason string, retryAfter time.Duration, now time.Time) time.Time {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.hits++
+	// Requests in flight when the first limit hit do not count twice.
+	if now.Before(t.until) {
+		return t.until
+	}
+	backoff := throttleBase << t.level
+	if backoff > throttleMax || backoff <= 0 {
+		backoff = throttleMax
+	}
+	if retryAfter > backoff {
+		backoff = retryAfter
+	}
+	t.level++
+	t.until, t.reason = now.Add(backoff), reason
+	log.Printf("rate limited by YouTube: %s, updates stopped for %s", reason, backoff)
+	metrics.Add("lfpod_rate_limited_total", "", 1)
+	metrics.Set("lfpod_throttled_until_timestamp_seconds", "", float64(t.until.Unix()))
+	return t.until
+}
+
+// Active returns the end of the backoff, if updates are stopped.
+func (t *Throttle) Active(now time.Time) (time.Time, string, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.until, t.reason, now.Before(t.until)
+}
+
+// Hits returns the number of rate limits so far.
+func (t *Throttle) Hits() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.hits
+}
+
+// Reset resets the backoff after a pass without rate limits.
+func (t *Throttle) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.level > 0 {
+		log.Print("no longer rate limited by YouTube")
+	}
+	t.level = 0
+}
+
+// throttled reports whether an error is a rate limit and records it.
+func throttled(err error) bool {
+	var rl *youtube.RateLimitError
+	switch {
+	case errors.As(err, &rl):
+		throttle.Hit("feed requests answered with 429", rl.RetryAfter, time.Now())
+	case errors.Is(err, pipeline.ErrRateLimited):
+		throttle.Hit(err.Error(), 0, time.Now())
+	default:
+		return false
+	}
+	return true
+}
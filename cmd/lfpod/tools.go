@@ -0,0 +1,69 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfTools are the external commands lfpod runs, names looked up in
+// PATH or paths, e.g. yt-dlp_x86 or /opt/ffmpeg/bin/ffmpeg. Relative
+// paths are relative to the configuration file.
+type ConfTools struct {
+	Downloader  string `json:"downloader,omitempty"`
+	Converter   string `json:"converter,omitempty"`
+	Probe       string `json:"probe,omitempty"`
+	Transcriber string `json:"transcriber,omitempty"`
+}
+
+// readConfTools returns the tools of a configuration file, none if it
+// cannot be read, the error shows when the feeds are read.
+func readConfTools(confFile string) ConfTools {
+	conf := ConfFeeds{}
+	if data, err := os.ReadFile(confFile); err == nil {
+		json.Unmarshal(data, &conf)
+	}
+	if conf.Tools == nil {
+		return ConfTools{}
+	}
+	return *conf.Tools
+}
+
+// applyTools sets the commands of external tools: a command line flag
+// wins, then an environment variable, then the configuration file, then
+// the default name. Relative paths are made absolute before lfpod
+// enters the data directory. flagged reports whether a flag was given.
+func applyTools(confFile string, tools ConfTools, flagged func(string) bool) {
+	for _, t := range []struct {
+		cmd  *string
+		flag string
+		env  string
+		conf string
+	}{
+		{&downloader, "downloader", "LFPOD_DOWNLOADER", tools.Downloader},
+		{&converter, "converter", "LFPOD_CONVERTER", tools.Converter},
+		{&probe, "probe", "LFPOD_PROBE", tools.Probe},
+		{&transcriber, "transcriber", "LFPOD_TRANSCRIBER", tools.Transcriber},
+	} {
+		dir := ""
+		switch {
+		case flagged(t.flag):
+		case os.Getenv(t.env) != "":
+			*t.cmd = os.Getenv(t.env)
+		case t.conf != "":
+			*t.cmd, dir = t.conf, filepath.Dir(confFile)
+		default:
+			continue
+		}
+		// Bare names are looked up in PATH.
+		if strings.ContainsRune(*t.cmd, filepath.Separator) && !filepath.IsAbs(*t.cmd) {
+			if abs, err := filepath.Abs(filepath.Join(dir, *t.cmd)); err == nil {
+				*t.cmd = abs
+			}
+		}
+	}
+}
@@ -0,0 +1,39 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestApplyTools(t *testing.T) {
+	saved := []string{downloader, converter, probe, transcriber}
+	t.Cleanup(func() {
+		downloader, converter, probe, transcriber = saved[0], saved[1], saved[2], saved[3]
+	})
+	dir := t.TempDir()
+	confFile := filepath.Join(dir, "ytfeeds.json")
+	conf := `{"ytfeeds": [], "tools": {"downloader": "bin/yt-dlp_x86", "converter": "avconv", "transcriber": "whisper"}}`
+	if err := os.WriteFile(confFile, []byte(conf), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("LFPOD_DOWNLOADER", "")
+	t.Setenv("LFPOD_CONVERTER", "")
+	t.Setenv("LFPOD_PROBE", "/opt/ffmpeg/bin/ffprobe")
+	downloader, converter, probe, transcriber = "yt-dlp", "ffmpeg", "ffprobe", "whisper-flag"
+	applyTools(confFile, readConfTools(confFile), func(name string) bool { return name == "transcriber" })
+
+	for _, tc := range []struct{ got, want string }{
+		{downloader, filepath.Join(dir, "bin", "yt-dlp_x86")},
+		{converter, "avconv"},
+		{probe, "/opt/ffmpeg/bin/ffprobe"},
+		{transcriber, "whisper-flag"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("tool %q, want %q", tc.got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Audio requested as <video id>.mp3 is transcoded from the stored file
+// for players that cannot play it, at transcodeRate.
+const (
+	transcodeExt  = "mp3"
+	transcodeRate = "64k"
+)
+
+// Transcoded files are cached here, least recently served deleted
+// first above transcodeCacheSize.
+const transcodeDir = "transcoded"
+
+// Size of the transcode cache in bytes, set with -transcode-cache. 0
+// streams every request from ffmpeg.
+var transcodeCacheSize int64
+
+// transcodeMu serializes cache evictions.
+var transcodeMu sync.Mutex
+
+// isTranscoded reports whether an audio URL path asks for a transcode.
+func isTranscoded(p string) bool {
+	return path.Ext(p) == "."+transcodeExt
+}
+
+// transcodeFileName returns the cached transcode of a stored file. The
+// name includes the modification time, so a recoded episode is
+// transcoded again.
+func transcodeFileName(videoId string, modTime time.Time) string {
+	return filepath.Join(transcodeDir, fmt.Sprintf("%s.%x.%s", videoId, modTime.Unix(), transcodeExt))
+}
+
+// transcodeHandler serves transcodes, with URL paths relative to the
+// audio directory like audioFiles, counting plays.
+func transcodeHandler(conf *Conf) http.Handler {
+	return countPlays(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		serveTranscoded(conf, w, r, r.URL.Path)
+	}))
+}
+
+// serveTranscoded serves the audio of an episode, file is
+// /<channel id>/<video id>.mp3, transcoded from the stored file. A
+// cached transcode is served like the stored files, with ranges,
+// otherwise ffmpeg output is streamed to the client and the cache.
+func serveTranscoded(conf *Conf, w http.ResponseWriter, r *http.Request, file string) {
+	channelId, name, _ := strings.Cut(strings.TrimPrefix(path.Clean("/"+file), "/"), "/")
+	videoId := strings.TrimSuffix(name, "."+transcodeExt)
+	feed, ok := conf.GetFeed(channelId)
+	if !ok || strings.ContainsAny(videoId, `/\.*?[`) {
+		http.NotFound(w, r)
+		return
+	}
+	src, _, f, ok := episodeIndex.FindAudio(&feed, videoId)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	cached := transcodeFileName(videoId, f.modTime)
+	if c, err := os.Open(cached); err == nil {
+		defer c.Close()
+		now := time.Now()
+		// The modification time orders evictions, the stored file
+		// dates the transcode.
+		os.Chtimes(cached, now, now)
+		w.Header().Set("Content-Type", "audio/mpeg")
+		http.ServeContent(w, r, name, f.modTime, c)
+		return
+	}
+	w.Header().Set("Content-Type", "audio/mpeg")
+	if r.Method == http.MethodHead {
+		return
+	}
+	input := src
+	if u, ok := storage.URL(src); ok {
+		input = u
+	}
+	cw := &completionWriter{ResponseWriter: w}
+	out := io.Writer(cw)
+	var tmp *os.File
+	if transcodeCacheSize > 0 {
+		if err := os.MkdirAll(transcodeDir, 0750); err != nil {
+			log.Print(err)
+		} else if tmp, err = os.CreateTemp(transcodeDir, videoId+".tmp-*"); err != nil {
+			log.Print(err)
+		} else {
+			defer os.Remove(tmp.Name())
+			defer tmp.Close()
+			out = io.MultiWriter(cw, tmp)
+		}
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", input, "-vn", "-c:a", "libmp3lame", "-b:a", transcodeRate}
+	cmd := exec.CommandContext(r.Context(), converter, append(append(args, threadArgs()...), "-f", transcodeExt, "pipe:1")...)
+	cmd.Stdout = out
+	if msg, err := runCommand(cmd, "", "transcode"); err != nil {
+		if r.Context().Err() == nil {
+			log.Printf("%s transcode: %v: %s", videoId, err, strings.TrimSpace(string(msg)))
+			if cw.written == 0 {
+				http.Error(w, "transcode failed", http.StatusInternalServerError)
+				return
+			}
+		}
+		// The status is sent already, the truncated download fails and
+		// is not counted as a play.
+		panic(http.ErrAbortHandler)
+	}
+	if tmp == nil {
+		return
+	}
+	if err := tmp.Close(); err != nil {
+		log.Print(err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), cached); err != nil {
+		log.Print(err)
+		return
+	}
+	evictTranscoded()
+}
+
+// evictTranscoded deletes the least recently served transcodes until
+// the cache fits its size.
+func evictTranscoded() {
+	transcodeMu.Lock()
+	defer transcodeMu.Unlock()
+	entries, err := os.ReadDir(transcodeDir)
+	if err != nil {
+		return
+	}
+	infos := []os.FileInfo{}
+	total := int64(0)
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || strings.Contains(e.Name(), ".tmp-") {
+			continue
+		}
+		infos = append(infos, info)
+		total += info.Size()
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ModTime().Before(infos[j].ModTime()) })
+	for _, info := range infos {
+		if total <= transcodeCacheSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(transcodeDir, info.Name())); err != nil {
+			log.Print(err)
+			continue
+		}
+		total -= info.Size()
+	}
+}
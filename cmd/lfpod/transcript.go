@@ -0,0 +1,75 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// whisper.cpp command line tool and model used for transcripts.
+var transcriber = "whisper-cli"
+var whisperModel string
+
+// Limits concurrent transcriptions, which take far more CPU than
+// downloads and recoding.
+var transcribeSlots = make(chan struct{}, 1)
+
+func setTranscribeConcurrency(n int) {
+	if n < 1 {
+		n = 1
+	}
+	transcribeSlots = make(chan struct{}, n)
+}
+
+// Transcript formats written by whisper.cpp and their MIME types.
+var transcriptFormats = []struct {
+	Ext      string
+	Flag     string
+	MimeType string
+}{
+	{"vtt", "-ovtt", "text/vtt"},
+	{"srt", "-osrt", "application/srt"},
+}
+
+// transcriptFileName returns the transcript of a video in format ext.
+func transcriptFileName(channelId, videoId, ext string) string {
+	return filepath.Join("audio", channelId, videoId+"."+ext)
+}
+
+// transcribeAudio writes VTT and SRT transcripts of an audio file next
+// to it. whisper.cpp reads 16 kHz mono WAV, so the audio is converted
+// first.
+func transcribeAudio(feed *ConfFeed, videoId, file string) error {
+	if whisperModel == "" {
+		return errors.New("no -whisper-model")
+	}
+	transcribeSlots <- struct{}{}
+	defer func() { <-transcribeSlots }()
+	wav := videoId + ".16k.wav"
+	defer os.Remove(wav)
+	cmd := exec.Command(converter, "-v", "error", "-i", file, "-ar", "16000", "-ac", "1",
+		"-c:a", "pcm_s16le", "-y", wav)
+	if out, err := runCommand(cmd, videoId, "transcribe"); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	language := feed.TranscriptLanguage
+	if language == "" {
+		language = "auto"
+	}
+	args := []string{"-m", whisperModel, "-l", language, "-f", wav,
+		"-of", strings.TrimSuffix(file, filepath.Ext(file))}
+	for _, f := range transcriptFormats {
+		args = append(args, f.Flag)
+	}
+	cmd = exec.Command(transcriber, args...)
+	if out, err := runCommand(cmd, videoId, "transcribe"); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
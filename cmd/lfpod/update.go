@@ -0,0 +1,157 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"time"
+)
+
+// Exit statuses of lfpod update, other errors exit with 1.
+const (
+	exitDownloadsFailed = 2
+	exitFeedsFailed     = 3
+)
+
+// updateExitError is an update pass that ran but did not fully succeed.
+type updateExitError struct {
+	Code int
+	Msg  string
+}
+
+func (e *updateExitError) Error() string {
+	return e.Msg
+}
+
+// runUpdate runs a single update pass, printing a row per processed
+// video and a summary. It fails if any download failed or channel feed
+// could not be fetched, so cron mails and timers show what happened.
+func runUpdate(ctx context.Context, conf *Conf, args []string) error {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	backfill := fs.Bool("backfill", false, "Download videos regardless of the feed ignore_older_than window.")
+	refresh := fs.Bool("refresh", false, "Read titles, descriptions and thumbnails of downloaded episodes from YouTube again.")
+	verbose := fs.Bool("v", false, "Log pipeline details to stderr.")
+	dryRun := fs.Bool("dry-run", false, "Print which videos would be downloaded and why others are skipped, without downloading.")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: lfpod update [-backfill] [-refresh] [-dry-run] [-v] [feed name or channel id]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	req := UpdateRequest{Backfill: *backfill, Refresh: *refresh}
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return errors.New("too many arguments")
+	} else if fs.NArg() == 1 {
+		feed, ok := conf.FindFeed(fs.Arg(0))
+		if !ok {
+			return fmt.Errorf("feed %q not found", fs.Arg(0))
+		}
+		req.ChannelId = feed.ChannelId
+	}
+	if !*verbose {
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	if *dryRun {
+		printDryRun(ctx, conf, req)
+		return nil
+	}
+	header := false
+	req.Progress = func(p JobProgress) {
+		if !header {
+			fmt.Printf("%-9s %-20s %-11s %9s  %s\n", "DONE", "FEED", "VIDEO", "SIZE", "STATUS")
+			header = true
+		}
+		status := "ok"
+		if p.Err != nil {
+			status = p.Err.Error()
+		}
+		fmt.Printf("%-9s %-20.20s %-11s %9s  %s\n", fmt.Sprintf("%d/%d", p.Done, p.Total),
+			p.Job.Feed.Title(), p.Job.Entry.VideoId, formatMB(p.Size), status)
+	}
+	result := doUpdate(ctx, conf, req)
+	fmt.Printf("%d new, %d skipped by filter, %d not ready, %d failed, %s downloaded\n",
+		result.New, result.Filtered, result.NotReady, result.Failed, formatMB(result.Bytes))
+	if result.Deferred > 0 {
+		fmt.Printf("%d videos deferred to the end of quiet hours\n", result.Deferred)
+	}
+	if result.Failed > 0 {
+		return &updateExitError{exitDownloadsFailed, fmt.Sprintf("%d downloads failed", result.Failed)}
+	}
+	if result.FeedErrors > 0 {
+		return &updateExitError{exitFeedsFailed, fmt.Sprintf("%d channel feeds not available", result.FeedErrors)}
+	}
+	return nil
+}
+
+func formatMB(size int64) string {
+	return fmt.Sprintf("%.1f MB", float64(size)/1e6)
+}
+
+// printDryRun fetches and filters the channel feeds and checks whether
+// selected videos are ready like an update, printing what it would do
+// with each video. Nothing is downloaded or changed.
+func printDryRun(ctx context.Context, conf *Conf, req UpdateRequest) {
+	feeds := []ConfFeed{}
+	for _, feed := range conf.AllFeeds() {
+		if !feed.Disabled && !feed.Inbox && (req.ChannelId == "" || feed.ChannelId == req.ChannelId) {
+			feeds = append(feeds, feed)
+		}
+	}
+	ytfeeds := fetchFeeds(ctx, feeds, conf.FetchConcurrency)
+	for i, ytfeed := range ytfeeds {
+		if ytfeed == nil {
+			fmt.Printf("%s: channel feed not available\n", feeds[i].Title())
+		}
+	}
+	decisions := selectVideos(conf, req, feeds, ytfeeds)
+	// Downloads in update order go first, priority videos first.
+	rank := func(d Decision) int {
+		switch {
+		case d.Skip != "":
+			return 2
+		case d.Job.Priority:
+			return 0
+		}
+		return 1
+	}
+	sort.SliceStable(decisions, func(i, j int) bool {
+		return rank(decisions[i]) < rank(decisions[j])
+	})
+	fmt.Printf("%-8s %-20s %-11s %-40s  %s\n", "ACTION", "FEED", "VIDEO", "TITLE", "REASON")
+	download, notReady := 0, 0
+	for _, d := range decisions {
+		action, reason := "skip", d.Skip
+		if d.Skip == "" {
+			action, reason = "download", "new"
+			if d.Retry != nil {
+				reason = fmt.Sprintf("retry %d, last error: %s", d.Retry.Attempts+1, d.Retry.LastError)
+			}
+			if d.Job.Priority {
+				reason += ", priority keyword"
+			}
+			if info, ready, available := isVideoReady(ctx, &d.Job.Feed, d.Job.Entry.VideoId); !ready && d.Job.Feed.RecordLive && info != nil && info.LiveStatus == "is_live" {
+				action, reason = "record", "live"
+				download++
+			} else if !ready {
+				action, reason = "wait", "not ready"
+				if !available.IsZero() {
+					reason += " until " + available.Local().Format(time.RFC3339)
+				}
+				notReady++
+			} else {
+				download++
+			}
+		}
+		fmt.Printf("%-8s %-20.20s %-11s %-40.40s  %s\n", action, d.Job.Feed.Title(), d.Job.Entry.VideoId, d.Job.Entry.Title, reason)
+	}
+	fmt.Printf("%d to download, %d not ready, %d skipped\n", download, notReady, len(decisions)-download-notReady)
+}
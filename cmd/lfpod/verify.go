@@ -0,0 +1,82 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Recoded files failing verification are moved here for inspection.
+const quarantineDir = "quarantine"
+
+// A recoded file may be this much shorter than expected, encoders pad
+// and trim a few frames.
+const durationTolerance = 2 * time.Second
+
+// expectedDuration returns the duration of fileIn recoded with the
+// feed trims and speed, 0 if unknown.
+func expectedDuration(feed *ConfFeed, fileIn string, trimStart, trimEnd time.Duration) time.Duration {
+	d, err := probeDuration(fileIn)
+	if err != nil {
+		log.Print(err)
+		return 0
+	}
+	if trimEnd != 0 && trimEnd < d {
+		d = trimEnd
+	}
+	d -= trimStart
+	if feed.Speed > 0 {
+		d = time.Duration(float64(d) / feed.Speed)
+	}
+	return d
+}
+
+// verifyAudio reads every packet of a recoded file with ffprobe. It
+// fails on read errors, which truncated files of a killed ffmpeg or a
+// full disk have, and if the file is shorter than expected.
+func verifyAudio(videoId, file string, expected time.Duration) error {
+	cmd := exec.Command(probe, "-v", "error", "-count_packets", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", file)
+	out, err := runCommand(cmd, videoId, "verify")
+	if err != nil {
+		return fmt.Errorf("%s: %v: %s", file, err, out)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) > 1 {
+		return fmt.Errorf("%s: %s", file, strings.Join(lines[:len(lines)-1], "; "))
+	}
+	sec, err := strconv.ParseFloat(lines[0], 64)
+	if err != nil {
+		return fmt.Errorf("%s: invalid duration %q", file, lines[0])
+	}
+	actual := time.Duration(sec * float64(time.Second))
+	if expected > 0 && actual < expected-durationTolerance {
+		return fmt.Errorf("%s: %s long, want %s", file, actual.Round(time.Second), expected.Round(time.Second))
+	}
+	return nil
+}
+
+// quarantine moves a bad file out of the way, keeping the last one of
+// each name.
+func quarantine(file string) {
+	if err := os.MkdirAll(quarantineDir, 0750); err != nil {
+		log.Print(err)
+		os.Remove(file)
+		return
+	}
+	dst := filepath.Join(quarantineDir, filepath.Base(file))
+	if err := os.Rename(file, dst); err != nil {
+		log.Print(err)
+		os.Remove(file)
+		return
+	}
+	log.Print("quarantined ", dst)
+}
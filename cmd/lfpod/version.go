@@ -0,0 +1,80 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set by
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse HEAD) -X main.buildDate=$(date -u +%FT%TZ)"
+//
+// and otherwise taken from the module and VCS information go embeds.
+var (
+	version   string
+	commit    string
+	buildDate string
+)
+
+type VersionInfo struct {
+	Version string `json:"version"`
+	Commit  string `json:"commit,omitempty"`
+	Date    string `json:"date,omitempty"`
+	Go      string `json:"go"`
+}
+
+func buildVersion() VersionInfo {
+	v := VersionInfo{Version: version, Commit: commit, Date: buildDate, Go: runtime.Version()}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		if v.Version == "" && info.Main.Version != "(devel)" {
+			v.Version = info.Main.Version
+		}
+		revision, modified := "", false
+		for _, s := range info.Settings {
+			switch s.Key {
+			case "vcs.revision":
+				revision = s.Value
+			case "vcs.time":
+				if v.Date == "" {
+					v.Date = s.Value
+				}
+			case "vcs.modified":
+				modified = s.Value == "true"
+			}
+		}
+		if v.Commit == "" && revision != "" {
+			v.Commit = revision
+			if modified {
+				v.Commit += "-dirty"
+			}
+		}
+	}
+	if v.Version == "" {
+		v.Version = "devel"
+	}
+	return v
+}
+
+func (v VersionInfo) String() string {
+	s := "lfpod " + v.Version
+	if v.Commit != "" {
+		commit := v.Commit
+		if len(commit) > 12 {
+			commit = commit[:12]
+		}
+		s += " " + commit
+	}
+	if v.Date != "" {
+		s += " built " + v.Date
+	}
+	return fmt.Sprintf("%s %s", s, v.Go)
+}
+
+func apiVersionHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, buildVersion())
+}
@@ -0,0 +1,52 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Video feeds publish small H.264 videos of this height unless set.
+const defaultVideoHeight = 360
+
+// Values of the media option of feeds.
+var mediaTypes = []string{"audio", "video"}
+
+// IsVideo reports whether the feed publishes video.
+func (f *ConfFeed) IsVideo() bool {
+	return f.Media == "video"
+}
+
+// VideoHeight returns the height of published videos.
+func (f *ConfFeed) VideoHeight() int {
+	if f.VideoHeightMax > 0 {
+		return f.VideoHeightMax
+	}
+	return defaultVideoHeight
+}
+
+// downloadFormatArgs returns the yt-dlp arguments selecting what to
+// download: the smallest audio, extracted, or for video feeds the best
+// video up to their height with the smallest audio, the smallest video
+// if there is none that low.
+func downloadFormatArgs(feed *ConfFeed) []string {
+	if !feed.IsVideo() {
+		return []string{"-f", "worstaudio", "-x"}
+	}
+	h := feed.VideoHeight()
+	return []string{"-f", fmt.Sprintf("bv*[height<=%d]+wa/b[height<=%d]/wv*+wa/w", h, h)}
+}
+
+// videoArgs returns the ffmpeg arguments of the video stream, none for
+// audio feeds. Videos are scaled down to the feed height, never up, and
+// start playing before they are fully downloaded.
+func videoArgs(feed *ConfFeed) []string {
+	if !feed.IsVideo() {
+		return nil
+	}
+	h := strconv.Itoa(feed.VideoHeight())
+	return []string{"-c:v", "libx264", "-preset", "veryfast", "-crf", "28", "-pix_fmt", "yuv420p",
+		"-vf", "scale=-2:'min(" + h + ",ih)'", "-movflags", "+faststart"}
+}
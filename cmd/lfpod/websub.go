@@ -0,0 +1,331 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/lfpod/youtube"
+)
+
+// websubHub is the WebSub (PubSubHubbub) hub YouTube publishes channel
+// uploads to.
+var websubHub = "https://pubsubhubbub.appspot.com/subscribe"
+
+// Topic of the uploads of a channel, followed by the channel id.
+const websubTopicURL = "https://www.youtube.com/xml/feeds/videos.xml?channel_id="
+
+// Subscriptions are requested for this long and renewed a day before
+// they expire.
+const websubLease = 5 * 24 * time.Hour
+
+const websubRenewBefore = 24 * time.Hour
+
+// A subscription not verified by the hub in time is considered failed,
+// the callback is probably not reachable, and requested again later.
+const websubVerifyTimeout = 5 * time.Minute
+
+const websubRetryInterval = time.Hour
+
+const websubCheckInterval = time.Minute
+
+// Notifications larger than this are ignored.
+const websubMaxBody = 1 << 20
+
+const (
+	websubRequested = "requested"
+	websubVerified  = "verified"
+	websubFailed    = "failed"
+)
+
+// WebSubSubscription is the push notification subscription of a channel.
+type WebSubSubscription struct {
+	ChannelId string    `json:"channel_id"`
+	State     string    `json:"state"`
+	Requested time.Time `json:"requested"`
+	// Lease end of a verified subscription.
+	Expires *time.Time `json:"expires,omitempty"`
+	// Time of the last notification received.
+	Notified *time.Time `json:"notified,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// WebSub keeps the subscriptions of the feeds, updating a feed as soon
+// as the hub pushes a notification of an upload. The update loop still
+// polls all feeds, which catches uploads missed when the callback is
+// not reachable.
+type WebSub struct {
+	mu     sync.Mutex
+	secret []byte
+	subs   map[string]*WebSubSubscription
+	// Channels unsubscribed by lfpod, by the time they were, until the
+	// hub verifies the unsubscription.
+	dropped map[string]time.Time
+}
+
+var websub = WebSub{subs: map[string]*WebSubSubscription{}, dropped: map[string]time.Time{}}
+
+// startWebSub subscribes the feeds to push notifications and keeps the
+// subscriptions renewed in the background.
+func startWebSub(conf *Conf) {
+	websub.mu.Lock()
+	websub.secret = make([]byte, 32)
+	if _, err := rand.Read(websub.secret); err != nil {
+		log.Fatal(err)
+	}
+	websub.mu.Unlock()
+	log.Print("receiving WebSub notifications at ", conf.URL("websub"))
+	go func() {
+		for {
+			websub.Maintain(conf, time.Now())
+			time.Sleep(websubCheckInterval)
+		}
+	}()
+}
+
+// Maintain subscribes new feeds, renews expiring subscriptions, retries
+// failed ones and unsubscribes removed and disabled feeds.
+func (s *WebSub) Maintain(conf *Conf, now time.Time) {
+	feeds := map[string]bool{}
+	for _, feed := range conf.AllFeeds() {
+		if !feed.Disabled && !feed.Inbox {
+			feeds[feed.ChannelId] = true
+		}
+	}
+	subscribe := []string{}
+	unsubscribe := []string{}
+	s.mu.Lock()
+	for id, sub := range s.subs {
+		if !feeds[id] {
+			delete(s.subs, id)
+			s.dropped[id] = now
+			unsubscribe = append(unsubscribe, id)
+			continue
+		}
+		if sub.State == websubRequested && now.Sub(sub.Requested) > websubVerifyTimeout {
+			sub.State = websubFailed
+			sub.Error = "not verified by the hub, is the callback reachable"
+			log.Printf("%s: WebSub subscription %s? Falling back to polling", id, sub.Error)
+		}
+	}
+	for id, dropped := range s.dropped {
+		if now.Sub(dropped) > websubVerifyTimeout {
+			delete(s.dropped, id)
+		}
+	}
+	for id := range feeds {
+		sub, ok := s.subs[id]
+		switch {
+		case !ok:
+		case sub.State == websubFailed && now.Sub(sub.Requested) > websubRetryInterval:
+		case sub.State == websubVerified && sub.Expires != nil && sub.Expires.Sub(now) < websubRenewBefore:
+		default:
+			continue
+		}
+		subscribe = append(subscribe, id)
+	}
+	s.mu.Unlock()
+	sort.Strings(subscribe)
+	for _, id := range subscribe {
+		s.request(conf, id, "subscribe", now)
+	}
+	for _, id := range unsubscribe {
+		s.request(conf, id, "unsubscribe", now)
+	}
+}
+
+// request asks the hub to subscribe or unsubscribe the callback of a
+// channel. The hub confirms with a verification request to the callback.
+func (s *WebSub) request(conf *Conf, channelId, mode string, now time.Time) {
+	form := url.Values{
+		"hub.mode":     {mode},
+		"hub.topic":    {websubTopicURL + channelId},
+		"hub.callback": {conf.URL("websub", channelId)},
+		"hub.verify":   {"async"},
+	}
+	if mode == "subscribe" {
+		form.Set("hub.lease_seconds", strconv.Itoa(int(websubLease.Seconds())))
+		s.mu.Lock()
+		form.Set("hub.secret", hex.EncodeToString(s.secret))
+		sub, ok := s.subs[channelId]
+		if !ok {
+			sub = &WebSubSubscription{ChannelId: channelId}
+			s.subs[channelId] = sub
+		}
+		// A renewal keeps the subscription verified until it expires.
+		if sub.State != websubVerified {
+			sub.State = websubRequested
+		}
+		sub.Requested = now
+		sub.Error = ""
+		s.mu.Unlock()
+	}
+	err := notifyPost(websubHub, "application/x-www-form-urlencoded", []byte(form.Encode()), nil)
+	if err == nil {
+		return
+	}
+	log.Printf("%s: WebSub %s: %v", channelId, mode, err)
+	if mode == "subscribe" {
+		s.mu.Lock()
+		if sub, ok := s.subs[channelId]; ok {
+			sub.State = websubFailed
+			sub.Error = err.Error()
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Verify answers a verification request of the hub, it reports whether
+// the subscription or unsubscription is expected. Only unsubscriptions
+// requested by lfpod itself are.
+func (s *WebSub) Verify(conf *Conf, channelId, mode, topic string, lease time.Duration, now time.Time) bool {
+	if topic != websubTopicURL+channelId {
+		return false
+	}
+	_, isFeed := conf.GetFeed(channelId)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[channelId]
+	switch mode {
+	case "subscribe":
+		if !ok || !isFeed {
+			return false
+		}
+		if lease <= 0 {
+			lease = websubLease
+		}
+		expires := now.Add(lease)
+		if sub.State != websubVerified {
+			log.Printf("%s: WebSub subscription verified", channelId)
+		}
+		sub.State = websubVerified
+		sub.Expires = &expires
+		sub.Error = ""
+		return true
+	case "unsubscribe":
+		if _, ok := s.dropped[channelId]; !ok || isFeed {
+			return false
+		}
+		delete(s.dropped, channelId)
+		return true
+	}
+	return false
+}
+
+// Deny marks a subscription refused by the hub.
+func (s *WebSub) Deny(channelId, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub, ok := s.subs[channelId]; ok {
+		sub.State = websubFailed
+		sub.Error = "denied by the hub: " + reason
+		log.Printf("%s: WebSub subscription %s", channelId, sub.Error)
+	}
+}
+
+// Notified checks the signature of a notification and records it, it
+// reports whether the notification is authentic.
+func (s *WebSub) Notified(channelId string, body []byte, signature string, now time.Time) bool {
+	algo, sig, ok := strings.Cut(signature, "=")
+	if !ok || algo != "sha1" {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub, ok := s.subs[channelId]
+	if !ok {
+		return false
+	}
+	mac := hmac.New(sha1.New, s.secret)
+	mac.Write(body)
+	if want, err := hex.DecodeString(sig); err != nil || !hmac.Equal(mac.Sum(nil), want) {
+		return false
+	}
+	sub.Notified = &now
+	return true
+}
+
+// List returns the subscriptions by channel id.
+func (s *WebSub) List() []WebSubSubscription {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list := []WebSubSubscription{}
+	for _, sub := range s.subs {
+		list = append(list, *sub)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ChannelId < list[j].ChannelId })
+	return list
+}
+
+// websubHandler is the callback of the subscriptions. It answers
+// verification requests of the hub and updates the feed of a channel
+// notified of an upload.
+func websubHandler(conf *Conf, w http.ResponseWriter, r *http.Request) {
+	channelId := mux.Vars(r)["channelId"]
+	if r.Method == http.MethodGet {
+		mode := r.FormValue("hub.mode")
+		if mode == "denied" {
+			websub.Deny(channelId, r.FormValue("hub.reason"))
+			return
+		}
+		lease, _ := strconv.Atoi(r.FormValue("hub.lease_seconds"))
+		if !websub.Verify(conf, channelId, mode, r.FormValue("hub.topic"), time.Duration(lease)*time.Second, time.Now()) {
+			http.NotFound(w, r)
+			return
+		}
+		// The challenge is echoed as is, it must never be taken for a page.
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		io.WriteString(w, r.FormValue("hub.challenge"))
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, websubMaxBody))
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	// Notifications failing the signature check are acknowledged and
+	// ignored, as the WebSub specification requires.
+	if !websub.Notified(channelId, body, r.Header.Get("X-Hub-Signature"), time.Now()) {
+		log.Printf("%s: WebSub notification with invalid signature ignored", channelId)
+		return
+	}
+	ytfeed, err := youtube.ParseFeed(body)
+	if err != nil {
+		log.Printf("%s: WebSub notification: %v", channelId, err)
+		return
+	}
+	// Deleted videos are notified without entries.
+	if len(ytfeed.Entries) == 0 {
+		return
+	}
+	feed, ok := conf.GetFeed(channelId)
+	if !ok {
+		return
+	}
+	for _, entry := range ytfeed.Entries {
+		log.Printf("%s: WebSub notification of %s %q", feed.Name, entry.VideoId, entry.Title)
+	}
+	if !triggerUpdate(UpdateRequest{ChannelId: channelId}) {
+		log.Printf("%s: update already pending, the video is picked up by polling", feed.Name)
+	}
+}
+
+func apiWebSubHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, websub.List())
+}
@@ -0,0 +1,190 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+// YouTube Data API key, channels are read from RSS feeds if empty.
+var youtubeAPIKey string
+
+// Number of most recent uploads read per channel from the API, in
+// pages of 50.
+var youtubeAPIMaxItems = 50
+
+// YouTube Data API endpoint, integration tests point it to a mock
+// server.
+var youtubeAPIBaseURL = "https://www.googleapis.com/youtube/v3"
+
+// Feed generation reads channels too, API results are reused for this
+// long to save quota.
+const youtubeAPICacheTTL = 10 * time.Minute
+
+type apiCacheItem struct {
+	feed    *youtube.Feed
+	fetched time.Time
+}
+
+var apiCache = struct {
+	mu    sync.Mutex
+	items map[string]apiCacheItem
+}{items: map[string]apiCacheItem{}}
+
+type apiThumbnail struct {
+	URL string `json:"url"`
+}
+
+type apiPlaylistItems struct {
+	NextPageToken string `json:"nextPageToken"`
+	Items         []struct {
+		Snippet struct {
+			Title       string                  `json:"title"`
+			Description string                  `json:"description"`
+			Thumbnails  map[string]apiThumbnail `json:"thumbnails"`
+		} `json:"snippet"`
+		ContentDetails struct {
+			VideoId          string `json:"videoId"`
+			VideoPublishedAt string `json:"videoPublishedAt"`
+		} `json:"contentDetails"`
+	} `json:"items"`
+}
+
+type apiVideos struct {
+	Items []struct {
+		Id             string `json:"id"`
+		ContentDetails struct {
+			Duration string `json:"duration"`
+		} `json:"contentDetails"`
+	} `json:"items"`
+}
+
+type youtubeAPIError struct {
+	Error struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Thumbnail sizes, largest first.
+var apiThumbnailSizes = []string{"maxres", "standard", "high", "medium", "default"}
+
+func apiGet(ctx context.Context, client *http.Client, path string, params url.Values, v interface{}) error {
+	params.Set("key", youtubeAPIKey)
+	req, err := http.NewRequestWithContext(ctx, "GET", youtubeAPIBaseURL+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		e := youtubeAPIError{}
+		if json.NewDecoder(res.Body).Decode(&e) == nil && e.Error.Message != "" {
+			return fmt.Errorf("YouTube API %s: %s", res.Status, e.Error.Message)
+		}
+		return errors.New("YouTube API " + res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// readChannelAPI returns the recent uploads of a channel with their
+// durations. Uploads are listed in the channel uploads playlist, its id
+// is the channel id with UU in place of UC.
+func readChannelAPI(ctx context.Context, client *http.Client, channelId string) (*youtube.Feed, error) {
+	apiCache.mu.Lock()
+	item, ok := apiCache.items[channelId]
+	apiCache.mu.Unlock()
+	if ok && time.Since(item.fetched) < youtubeAPICacheTTL {
+		return item.feed, nil
+	}
+	playlistId, ok := strings.CutPrefix(channelId, "UC")
+	if !ok {
+		return nil, fmt.Errorf("channel id %q does not start with UC", channelId)
+	}
+	ytfeed := &youtube.Feed{}
+	token := ""
+	for len(ytfeed.Entries) < youtubeAPIMaxItems {
+		params := url.Values{"part": {"snippet,contentDetails"}, "playlistId": {"UU" + playlistId}, "maxResults": {"50"}}
+		if token != "" {
+			params.Set("pageToken", token)
+		}
+		page := apiPlaylistItems{}
+		if err := apiGet(ctx, client, "/playlistItems", params, &page); err != nil {
+			return nil, err
+		}
+		for _, it := range page.Items {
+			// Private and deleted videos have no publication time.
+			if it.ContentDetails.VideoPublishedAt == "" || len(ytfeed.Entries) == youtubeAPIMaxItems {
+				continue
+			}
+			entry := &youtube.Entry{
+				Title:     it.Snippet.Title,
+				VideoId:   it.ContentDetails.VideoId,
+				Published: it.ContentDetails.VideoPublishedAt,
+				Media:     &youtube.Media{Description: it.Snippet.Description},
+			}
+			for _, size := range apiThumbnailSizes {
+				if t, ok := it.Snippet.Thumbnails[size]; ok {
+					entry.Media.Thumbnail.URL = t.URL
+					break
+				}
+			}
+			ytfeed.Entries = append(ytfeed.Entries, entry)
+		}
+		if token = page.NextPageToken; token == "" {
+			break
+		}
+	}
+	if err := apiDurations(ctx, client, ytfeed.Entries); err != nil {
+		return nil, err
+	}
+	apiCache.mu.Lock()
+	apiCache.items[channelId] = apiCacheItem{ytfeed, time.Now()}
+	apiCache.mu.Unlock()
+	return ytfeed, nil
+}
+
+// apiDurations sets the durations of entries, 50 videos per request.
+func apiDurations(ctx context.Context, client *http.Client, entries []*youtube.Entry) error {
+	for start := 0; start < len(entries); start += 50 {
+		end := start + 50
+		if end > len(entries) {
+			end = len(entries)
+		}
+		byId := map[string]*youtube.Entry{}
+		ids := []string{}
+		for _, e := range entries[start:end] {
+			byId[e.VideoId] = e
+			ids = append(ids, e.VideoId)
+		}
+		videos := apiVideos{}
+		params := url.Values{"part": {"contentDetails"}, "id": {strings.Join(ids, ",")}}
+		if err := apiGet(ctx, client, "/videos", params, &videos); err != nil {
+			return err
+		}
+		for _, v := range videos.Items {
+			if e, ok := byId[v.Id]; ok {
+				e.Duration = parseISODuration(v.ContentDetails.Duration)
+			}
+		}
+	}
+	return nil
+}
+
+// parseISODuration parses API durations like PT1H2M3S, 0 if invalid.
+func parseISODuration(s string) time.Duration {
+	return youtube.ParseDuration(s)
+}
@@ -5,6 +5,7 @@ go 1.20
 require (
 	github.com/gorilla/feeds v1.1.1
 	github.com/gorilla/mux v1.8.0
+	github.com/mattn/go-sqlite3 v1.14.22
 )
 
 require github.com/kr/pretty v0.3.1 // indirect
@@ -0,0 +1,205 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package pipeline downloads the audio of YouTube videos with yt-dlp
+// and recodes it with ffmpeg, the stages of turning them into podcast
+// episodes. Downloads continue partial files of earlier attempts, are
+// stopped when they stall rather than after a fixed time, and share an
+// overall download rate. Recodes replace the episode file only once
+// verified.
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrPermanent marks downloads that cannot succeed on retry, like of
+// deleted or private videos.
+var ErrPermanent = errors.New("permanent failure")
+
+// ErrRateLimited marks yt-dlp failures caused by YouTube rate limiting.
+var ErrRateLimited = errors.New("rate limited by YouTube")
+
+// yt-dlp messages of videos that are gone for good. Messages like
+// "This content isn't available" are rate limiting, not permanent.
+var permanentFailureMessages = []string{
+	"private video",
+	"this video has been removed",
+	"account associated with this video has been terminated",
+	"video is no longer available",
+	"copyright claim",
+	"copyright grounds",
+	"this video does not exist",
+}
+
+// yt-dlp messages of rate limiting and throttling.
+var rateLimitMessages = []string{
+	"http error 429",
+	"too many requests",
+	"sign in to confirm you're not a bot",
+	"sign in to confirm you’re not a bot",
+	"this content isn't available, try again later",
+	"rate-limited by youtube",
+}
+
+// findLine returns the first line of out containing one of messages,
+// compared in lower case, empty if none does.
+func findLine(out []byte, messages []string) string {
+	for _, line := range strings.Split(string(out), "\n") {
+		lower := strings.ToLower(line)
+		for _, m := range messages {
+			if strings.Contains(lower, m) {
+				return strings.TrimSpace(line)
+			}
+		}
+	}
+	return ""
+}
+
+// PermanentFailure returns the line of yt-dlp output telling a video is
+// gone for good, empty if the failure may be transient.
+func PermanentFailure(out []byte) string {
+	return findLine(out, permanentFailureMessages)
+}
+
+// RateLimited returns the line of yt-dlp output telling YouTube rate
+// limits the requests, empty if none.
+func RateLimited(out []byte) string {
+	return findLine(out, rateLimitMessages)
+}
+
+// RateLimitError returns ErrRateLimited with the rate limit message of
+// yt-dlp output, nil if there is none.
+func RateLimitError(out []byte) error {
+	if line := RateLimited(out); line != "" {
+		return fmt.Errorf("%w: %s", ErrRateLimited, line)
+	}
+	return nil
+}
+
+// TooLarge returns the line of yt-dlp output telling a download was
+// skipped for --max-filesize, empty if none.
+func TooLarge(out []byte) string {
+	return findLine(out, []string{"larger than max-filesize"})
+}
+
+// Downloader downloads the audio of videos with yt-dlp.
+type Downloader struct {
+	// Path of yt-dlp, "yt-dlp" if empty.
+	Path string
+	// Directory of downloads, partial downloads are kept there to be
+	// continued.
+	Dir string
+	// Rates limits the download rate, nothing if nil.
+	Rates *Rates
+	// Downloads whose files have not grown for this long are stopped,
+	// never if 0. Live recordings are not.
+	StallTimeout time.Duration
+	// Run, if not nil, runs yt-dlp and returns its combined output,
+	// e.g. to account its resource usage to the video. It calls started
+	// once the process started, the stall timeout counts from then on.
+	Run func(cmd *exec.Cmd, videoId string, started func()) ([]byte, error)
+	// Logf, if not nil, logs stalled downloads and the output of failed
+	// ones.
+	Logf func(format string, args ...interface{})
+}
+
+// Download downloads a video and returns the downloaded file, in Dir
+// named after the video id with the extension yt-dlp gave it. args are
+// yt-dlp options, like the format to download or --download-archive;
+// the output, partial download and rate limit options are added. A
+// partial download of an earlier attempt is continued. Failures are
+// ErrPermanent if the video is gone or too large, and ErrRateLimited
+// if YouTube rate limits the downloads.
+func (d *Downloader) Download(ctx context.Context, videoId string, args ...string) (string, error) {
+	return d.download(ctx, videoId, false, args)
+}
+
+// Record records a live stream until it ends or ctx is done, like
+// Download but without stall checks. args select what to record, e.g.
+// --live-from-start.
+func (d *Downloader) Record(ctx context.Context, videoId string, args ...string) (string, error) {
+	return d.download(ctx, videoId, true, args)
+}
+
+func (d *Downloader) download(ctx context.Context, videoId string, live bool, args []string) (string, error) {
+	if err := os.MkdirAll(d.Dir, 0750); err != nil {
+		return "", err
+	}
+	outFile := filepath.Join(d.Dir, videoId)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Partial downloads are kept and continued by the next attempt.
+	opts := []string{"-o", filepath.Join(d.Dir, "%(id)s"), "--continue", "--part"}
+	if d.Rates != nil {
+		rate, err := d.Rates.Acquire(ctx)
+		if err != nil {
+			return "", err
+		}
+		defer d.Rates.Release(rate)
+		if rate > 0 {
+			opts = append(opts, "--limit-rate", strconv.FormatInt(rate, 10))
+		}
+	}
+	path := d.Path
+	if path == "" {
+		path = "yt-dlp"
+	}
+	cmd := exec.CommandContext(ctx, path, append(append(opts, args...), "--", videoId)...)
+	stop := func() {}
+	started := func() {
+		if !live && d.StallTimeout > 0 {
+			stop = WatchStalled(outFile, d.StallTimeout, func() {
+				if d.Logf != nil {
+					d.Logf("%s: download made no progress for %s, stopped", videoId, d.StallTimeout)
+				}
+				cancel()
+			})
+		}
+	}
+	var out []byte
+	var err error
+	if d.Run != nil {
+		out, err = d.Run(cmd, videoId, started)
+	} else {
+		started()
+		out, err = cmd.CombinedOutput()
+	}
+	stop()
+	if err != nil {
+		os.Remove(outFile)
+		if d.Logf != nil {
+			d.Logf("%s", out)
+		}
+		if err := RateLimitError(out); err != nil {
+			return "", err
+		}
+		if reason := PermanentFailure(out); reason != "" {
+			return "", fmt.Errorf("%w: %s", ErrPermanent, reason)
+		}
+		return "", err
+	}
+	// yt-dlp appends the extension of the extracted audio to the
+	// output template.
+	if _, err := os.Stat(outFile); err != nil {
+		// yt-dlp skips files over --max-filesize without failing.
+		if line := TooLarge(out); line != "" {
+			return "", fmt.Errorf("%w: %s", ErrPermanent, line)
+		}
+		names, _ := filepath.Glob(outFile + ".*")
+		if len(names) != 1 {
+			// yt-dlp skips videos in the download archive.
+			return "", errors.New("nothing downloaded, video is in the download archive")
+		}
+		outFile = names[0]
+	}
+	return outFile, nil
+}
@@ -0,0 +1,143 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The test binary stands in for yt-dlp and ffmpeg, linked under those
+// names. yt-dlp writes the audio of the video id after "--" to the -o
+// template.
+func TestMain(m *testing.M) {
+	switch filepath.Base(os.Args[0]) {
+	case "yt-dlp":
+		os.Exit(stubDownloader(os.Args[1:]))
+	case "ffmpeg":
+		os.Exit(stubConverter(os.Args[1:]))
+	}
+	os.Exit(m.Run())
+}
+
+func stubDownloader(args []string) int {
+	out, videoId := "", args[len(args)-1]
+	for i, arg := range args {
+		if arg == "-o" && i+1 < len(args) {
+			out = strings.Replace(args[i+1], "%(id)s", videoId, 1)
+		}
+	}
+	switch videoId {
+	case "private":
+		os.Stderr.WriteString("ERROR: [youtube] private: Private video. Sign in if you've been granted access\n")
+		return 1
+	case "bot":
+		os.Stderr.WriteString("ERROR: [youtube] bot: Sign in to confirm you're not a bot\n")
+		return 1
+	case "large":
+		os.Stdout.WriteString("[download] File is larger than max-filesize (1000 bytes > 10 bytes). Aborting.\n")
+		return 0
+	case "stalled":
+		// Some progress, then none.
+		os.WriteFile(out+".webm.part", []byte("partial"), 0644)
+		time.Sleep(time.Minute)
+		return 0
+	case "silent":
+		// Hangs before writing anything.
+		time.Sleep(time.Minute)
+		return 0
+	}
+	// The partial file of an earlier attempt is continued.
+	part, _ := os.ReadFile(out + ".webm.part")
+	os.Remove(out + ".webm.part")
+	if err := os.WriteFile(out+".webm", append(part, "audio"...), 0644); err != nil {
+		return 1
+	}
+	return 0
+}
+
+func testDownloader(t *testing.T) *Downloader {
+	bin := t.TempDir()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(exe, filepath.Join(bin, "yt-dlp")); err != nil {
+		t.Fatal(err)
+	}
+	return &Downloader{Path: filepath.Join(bin, "yt-dlp"), Dir: t.TempDir(), Rates: &Rates{}}
+}
+
+func TestDownload(t *testing.T) {
+	ctx := context.Background()
+	d := testDownloader(t)
+	if err := os.WriteFile(filepath.Join(d.Dir, "vid00000001.webm.part"), []byte("partial "), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file, err := d.Download(ctx, "vid00000001", "-f", "worstaudio")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(file); file != filepath.Join(d.Dir, "vid00000001.webm") || string(data) != "partial audio" {
+		t.Errorf("downloaded %s with %q", file, data)
+	}
+
+	for videoId, want := range map[string]error{"private": ErrPermanent, "large": ErrPermanent, "bot": ErrRateLimited} {
+		if _, err := d.Download(ctx, videoId); !errors.Is(err, want) {
+			t.Errorf("%s download error %v, want %v", videoId, err, want)
+		}
+	}
+}
+
+func TestDownloadStalled(t *testing.T) {
+	d := testDownloader(t)
+	d.StallTimeout = 200 * time.Millisecond
+	start := time.Now()
+	if _, err := d.Download(context.Background(), "stalled"); err == nil {
+		t.Fatal("stalled download succeeded")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Error("stalled download not stopped")
+	}
+	if DownloadSize(filepath.Join(d.Dir, "stalled")) == 0 {
+		t.Error("partial download not kept")
+	}
+
+	start = time.Now()
+	if _, err := d.Download(context.Background(), "silent"); err == nil {
+		t.Fatal("download hanging before any progress succeeded")
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Error("download hanging before any progress not stopped")
+	}
+
+	// Waiting for the process to start does not count.
+	d.Run = func(cmd *exec.Cmd, videoId string, started func()) ([]byte, error) {
+		time.Sleep(3 * d.StallTimeout)
+		started()
+		return cmd.CombinedOutput()
+	}
+	if _, err := d.Download(context.Background(), "vid00000001"); err != nil {
+		t.Errorf("download waiting to start: %v", err)
+	}
+}
+
+func TestFailureMessages(t *testing.T) {
+	out := []byte("[youtube] vid00000001: Downloading webpage\nERROR: [youtube] vid00000001: Sign in to confirm you're not a bot\n")
+	if err := RateLimitError(out); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("yt-dlp output %q not taken for a rate limit", out)
+	}
+	if err := RateLimitError([]byte("ERROR: Private video")); err != nil {
+		t.Errorf("private video taken for a rate limit: %v", err)
+	}
+	if line := PermanentFailure([]byte("WARNING: slow\nERROR: This video has been removed by the uploader\n")); line != "ERROR: This video has been removed by the uploader" {
+		t.Errorf("permanent failure line %q", line)
+	}
+}
@@ -0,0 +1,115 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Rates hands out the rate limits of yt-dlp processes, so that all
+// downloads together, those outside a worker pool like priority videos
+// and live recordings included, stay within an overall cap. The zero
+// value limits nothing.
+type Rates struct {
+	mu sync.Mutex
+	// Closed and replaced when a share is released.
+	released chan struct{}
+	// Rate of each download and cap of all together in bytes per
+	// second, 0 for no limit, and the part of the cap in use.
+	each, total, used int64
+}
+
+// Set sets the rate of each download and the overall cap, see RateFor.
+// It returns the rate of each download.
+func (r *Rates) Set(perDownload, total int64, workers int) int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.each, r.total = RateFor(perDownload, total, workers), total
+	return r.each
+}
+
+// Acquire returns the rate limit of a download, 0 for none. With an
+// overall cap it waits until the running downloads leave room for
+// another one, or ctx is done. The rate is given back with Release
+// when the download ends.
+func (r *Rates) Acquire(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for r.total > 0 && r.used+r.each > r.total {
+		if r.released == nil {
+			r.released = make(chan struct{})
+		}
+		released := r.released
+		r.mu.Unlock()
+		select {
+		case <-released:
+		case <-ctx.Done():
+			r.mu.Lock()
+			return 0, ctx.Err()
+		}
+		r.mu.Lock()
+	}
+	if r.total > 0 {
+		r.used += r.each
+	}
+	return r.each, nil
+}
+
+// Release gives back the rate of a finished download.
+func (r *Rates) Release(rate int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.total > 0 {
+		r.used -= rate
+		if r.released != nil {
+			close(r.released)
+			r.released = nil
+		}
+	}
+}
+
+// ParseRate parses a rate in bytes per second with an optional K, M or
+// G suffix, as yt-dlp --limit-rate does.
+func ParseRate(s string) (int64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	num, mult := s, int64(1)
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		num = s[:len(s)-1]
+	}
+	v, err := strconv.ParseFloat(num, 64)
+	if err != nil || v < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int64(v * float64(mult)), nil
+}
+
+// RateFor returns the rate limit of each download, so that workers
+// downloads in parallel stay within total. It is at least 1 byte per
+// second, so total always leaves room for one download.
+func RateFor(perDownload, total int64, workers int) int64 {
+	if workers < 1 {
+		workers = 1
+	}
+	if share := total / int64(workers); total > 0 && (perDownload == 0 || share < perDownload) {
+		if share < 1 {
+			share = 1
+		}
+		return share
+	}
+	return perDownload
+}
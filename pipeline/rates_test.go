@@ -0,0 +1,71 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRates(t *testing.T) {
+	ctx := context.Background()
+	r := &Rates{}
+	if rate := r.Set(0, 4<<20, 2); rate != 2<<20 {
+		t.Fatalf("rate %d, want half the total", rate)
+	}
+	r.Acquire(ctx)
+	second, _ := r.Acquire(ctx)
+	// A priority download outside the worker pool waits for a share.
+	acquired := make(chan int64)
+	go func() {
+		rate, _ := r.Acquire(ctx)
+		acquired <- rate
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("third download exceeds the total rate")
+	case <-time.After(50 * time.Millisecond):
+	}
+	r.Release(second)
+	select {
+	case rate := <-acquired:
+		if rate != 2<<20 {
+			t.Errorf("third download rate %d", rate)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("released share not handed out")
+	}
+
+	// Waiting for a share ends with the context.
+	cancelled, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := r.Acquire(cancelled); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting download got %v", err)
+	}
+
+	if rate := r.Set(1<<20, 0, 2); rate != 1<<20 {
+		t.Error("per-download rate without a total")
+	}
+	if rate, err := r.Acquire(ctx); rate != 1<<20 || err != nil {
+		t.Errorf("rate %d without a total: %v", rate, err)
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	for s, want := range map[string]int64{"": 0, "500": 500, "50K": 50 << 10, "1.5M": 3 << 19, "1g": 1 << 30} {
+		if got, err := ParseRate(s); got != want || err != nil {
+			t.Errorf("rate %q is %d, %v, want %d", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"fast", "-1M", "M"} {
+		if _, err := ParseRate(s); err == nil {
+			t.Errorf("rate %q accepted", s)
+		}
+	}
+	if rate := RateFor(0, 3, 4); rate != 1 {
+		t.Errorf("rate %d of a tiny total, want 1", rate)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBadOutput marks recodes that produced no file or one failing
+// verification, worth another attempt.
+var ErrBadOutput = errors.New("bad recoded output")
+
+// Recoder recodes downloaded audio with ffmpeg, the second stage.
+type Recoder struct {
+	// Path of ffmpeg, "ffmpeg" if empty.
+	Path string
+	// Run, if not nil, runs ffmpeg and returns its combined output,
+	// e.g. to account its resource usage to the video.
+	Run func(cmd *exec.Cmd, videoId string) ([]byte, error)
+	// Verify, if not nil, checks a recoded file and returns the
+	// extension of the format it turned out to be in, that of the
+	// output file if empty.
+	Verify func(file string) (string, error)
+	// Quarantine, if not nil, takes recoded files failing verification
+	// for inspection, they are removed otherwise.
+	Quarantine func(file string)
+	// Logf, if not nil, logs the output of failed recodes and corrected
+	// extensions.
+	Logf func(format string, args ...interface{})
+}
+
+// Recode runs ffmpeg with args, the inputs and encoding options, and
+// returns the recoded file: fileOut, with the extension corrected if
+// the file turned out to be of another format. ffmpeg writes to a
+// temporary file of its own next to fileOut, renamed into place when
+// verified, so concurrent recodes and instances do not overwrite each
+// other. Failures of ffmpeg and verification are ErrBadOutput.
+func (r *Recoder) Recode(ctx context.Context, videoId, fileOut string, args ...string) (string, error) {
+	ext := filepath.Ext(fileOut)
+	// ffmpeg picks the muxer by the extension.
+	f, err := os.CreateTemp(filepath.Dir(fileOut), videoId+".tmp-*"+ext)
+	if err != nil {
+		return "", err
+	}
+	fileTmp := f.Name()
+	f.Close()
+	path := r.Path
+	if path == "" {
+		path = "ffmpeg"
+	}
+	cmd := exec.CommandContext(ctx, path, append(args, "-y", fileTmp)...)
+	var out []byte
+	if r.Run != nil {
+		out, err = r.Run(cmd, videoId)
+	} else {
+		out, err = cmd.CombinedOutput()
+	}
+	if err != nil {
+		if r.Logf != nil {
+			r.Logf("%s", out)
+		}
+		os.Remove(fileTmp)
+		return "", fmt.Errorf("%w: %s: %v", ErrBadOutput, path, err)
+	}
+	if r.Verify != nil {
+		actual, err := r.Verify(fileTmp)
+		if err != nil {
+			if r.Quarantine != nil {
+				r.Quarantine(fileTmp)
+			} else {
+				os.Remove(fileTmp)
+			}
+			return "", fmt.Errorf("%w: %v", ErrBadOutput, err)
+		}
+		if actual != "" && "."+actual != ext {
+			if r.Logf != nil {
+				r.Logf("%s: produced %s instead of %s, extension corrected", videoId, actual, strings.TrimPrefix(ext, "."))
+			}
+			fileOut = strings.TrimSuffix(fileOut, ext) + "." + actual
+		}
+	}
+	if err := os.Rename(fileTmp, fileOut); err != nil {
+		os.Remove(fileTmp)
+		return "", err
+	}
+	return fileOut, nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package pipeline
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// stubConverter copies the -i input to the last argument, prefixed
+// with "recoded ".
+func stubConverter(args []string) int {
+	in := ""
+	for i, arg := range args {
+		if arg == "-i" && i+1 < len(args) {
+			in = args[i+1]
+		}
+	}
+	data, err := os.ReadFile(in)
+	if err != nil {
+		os.Stderr.WriteString(err.Error() + "\n")
+		return 1
+	}
+	if err := os.WriteFile(args[len(args)-1], append([]byte("recoded "), data...), 0644); err != nil {
+		return 1
+	}
+	return 0
+}
+
+func testRecoder(t *testing.T) *Recoder {
+	bin := t.TempDir()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(exe, filepath.Join(bin, "ffmpeg")); err != nil {
+		t.Fatal(err)
+	}
+	return &Recoder{Path: filepath.Join(bin, "ffmpeg")}
+}
+
+func TestRecode(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	in := filepath.Join(dir, "vid00000001.webm")
+	if err := os.WriteFile(in, []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out := filepath.Join(dir, "vid00000001.opus")
+	for _, tc := range []struct {
+		name       string
+		verify     func(file string) (string, error)
+		args       []string
+		want       string
+		err        error
+		quarantine bool
+	}{
+		{name: "plain", args: []string{"-i", in}, want: out},
+		{name: "verified", args: []string{"-i", in}, want: out,
+			verify: func(file string) (string, error) { return "", nil }},
+		{name: "other format", args: []string{"-i", in}, want: filepath.Join(dir, "vid00000001.m4a"),
+			verify: func(file string) (string, error) { return "m4a", nil }},
+		{name: "failed", args: []string{"-i", filepath.Join(dir, "missing.webm")}, err: ErrBadOutput},
+		{name: "unverified", args: []string{"-i", in}, err: ErrBadOutput, quarantine: true,
+			verify: func(file string) (string, error) { return "", errors.New("too short") }},
+	} {
+		r := testRecoder(t)
+		r.Verify = tc.verify
+		quarantined := ""
+		r.Quarantine = func(file string) {
+			quarantined = file
+			os.Remove(file)
+		}
+		file, err := r.Recode(ctx, "vid00000001", out, tc.args...)
+		if !errors.Is(err, tc.err) || file != tc.want {
+			t.Errorf("%s: recoded %q, %v", tc.name, file, err)
+		}
+		if tc.want != "" {
+			if data, _ := os.ReadFile(file); string(data) != "recoded audio" {
+				t.Errorf("%s: recoded %q", tc.name, data)
+			}
+			os.Remove(file)
+		}
+		if (quarantined != "") != tc.quarantine {
+			t.Errorf("%s: quarantined %q", tc.name, quarantined)
+		}
+		names, _ := filepath.Glob(filepath.Join(dir, "*.tmp-*"))
+		if len(names) > 0 {
+			t.Errorf("%s: temporary files left: %s", tc.name, strings.Join(names, " "))
+		}
+	}
+}
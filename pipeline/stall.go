@@ -0,0 +1,52 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package pipeline
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WatchStalled calls stalled when the files of a download, name and
+// name.*, do not grow for timeout, counted from the call on. Start the
+// watch with the process of the download, so time spent waiting for a
+// process slot or a share of the download rate does not count but a
+// process hanging before it writes anything does. It returns a function
+// ending the watch.
+func WatchStalled(name string, timeout time.Duration, stalled func()) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(timeout / 4)
+		defer ticker.Stop()
+		size, progress := DownloadSize(name), time.Now()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				if s := DownloadSize(name); s != size {
+					size, progress = s, now
+				} else if now.Sub(progress) >= timeout {
+					stalled()
+					return
+				}
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// DownloadSize returns the size of the files of a download, the
+// partial and fragment files of yt-dlp included.
+func DownloadSize(name string) int64 {
+	names, _ := filepath.Glob(name + ".*")
+	var size int64
+	for _, n := range append(names, name) {
+		if fi, err := os.Stat(n); err == nil {
+			size += fi.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,270 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package server generates the podcast feeds lfpod serves, Atom feeds
+// with the iTunes and Podcasting 2.0 extensions and RFC 5005 paging,
+// and has the HTTP handlers around them: compression, conditional
+// requests and file serving without directory listings.
+package server
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/feeds"
+)
+
+// Chapters links the chapters document of an episode.
+type Chapters struct {
+	URL  string `xml:"url,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// Transcript links a transcript of an episode.
+type Transcript struct {
+	URL      string `xml:"url,attr"`
+	Type     string `xml:"type,attr"`
+	Language string `xml:"language,attr,omitempty"`
+	Rel      string `xml:"rel,attr,omitempty"`
+}
+
+// AlternateEnclosure offers the episode audio at a bitrate, apps
+// without support use the enclosure, the default one.
+type AlternateEnclosure struct {
+	Type    string `xml:"type,attr"`
+	Length  int64  `xml:"length,attr"`
+	Bitrate int    `xml:"bitrate,attr,omitempty"`
+	Title   string `xml:"title,attr,omitempty"`
+	Default bool   `xml:"default,attr,omitempty"`
+	Source  Source `xml:"https://podcastindex.org/namespace/1.0 source"`
+}
+
+type Source struct {
+	URI string `xml:"uri,attr"`
+}
+
+// NewAlternateEnclosure returns the alternate enclosure of audio at
+// url of a bitrate in bits per second.
+func NewAlternateEnclosure(url, mimeType string, size int64, bitrate int, isDefault bool) *AlternateEnclosure {
+	return &AlternateEnclosure{Type: mimeType, Length: size, Bitrate: bitrate,
+		Title: fmt.Sprintf("%d kbit/s", bitrate/1000), Default: isDefault, Source: Source{url}}
+}
+
+type Image struct {
+	Href string `xml:"href,attr"`
+}
+
+type Category struct {
+	Text        string    `xml:"text,attr"`
+	Subcategory *Category `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category,omitempty"`
+}
+
+// NewCategory returns the iTunes category of a name like
+// "Society & Culture/Documentary", with the subcategory after a slash.
+func NewCategory(name string) *Category {
+	category, sub, hasSub := strings.Cut(name, "/")
+	c := &Category{Text: category}
+	if hasSub {
+		c.Subcategory = &Category{Text: sub}
+	}
+	return c
+}
+
+type Owner struct {
+	Name  string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd name,omitempty"`
+	Email string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd email,omitempty"`
+}
+
+type Generator struct {
+	URI     string `xml:"uri,attr"`
+	Version string `xml:"version,attr"`
+	Name    string `xml:",chardata"`
+}
+
+// Entry is an Atom entry with podcast extensions.
+type Entry struct {
+	*feeds.AtomEntry
+	Duration    string                `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration,omitempty"`
+	Episode     int                   `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode,omitempty"`
+	Season      int                   `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season,omitempty"`
+	EpisodeType string                `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episodeType,omitempty"`
+	Explicit    string                `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit,omitempty"`
+	Chapters    *Chapters             `xml:"https://podcastindex.org/namespace/1.0 chapters"`
+	Image       *Image                `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	Transcripts []*Transcript         `xml:"https://podcastindex.org/namespace/1.0 transcript"`
+	Alternates  []*AlternateEnclosure `xml:"https://podcastindex.org/namespace/1.0 alternateEnclosure"`
+}
+
+// Feed is an Atom feed with RFC 5005 paging and archive links, and
+// podcast extensions of entries.
+type Feed struct {
+	*feeds.AtomFeed
+	Generator *Generator `xml:"generator"`
+	Image     *Image     `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	// itunes:type, serial or episodic if empty.
+	Type string `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd type,omitempty"`
+	// Podcast directory information of the feed of a channel.
+	ItunesCategory *Category `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd category,omitempty"`
+	Explicit       string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd explicit,omitempty"`
+	ItunesAuthor   string    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author,omitempty"`
+	Owner          *Owner    `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd owner,omitempty"`
+	Links          []feeds.AtomLink
+	Archive        *struct{} `xml:"http://purl.org/syndication/history/1.0 archive"`
+	Entries        []*Entry  `xml:"entry"`
+}
+
+// SetEntries sets the Atom feed, its entries can then be extended.
+func (f *Feed) SetEntries(feed *feeds.AtomFeed) {
+	f.AtomFeed = feed
+	f.Entries = []*Entry{}
+	for _, e := range feed.Entries {
+		f.Entries = append(f.Entries, &Entry{AtomEntry: e})
+	}
+	feed.Entries = nil
+}
+
+func (f *Feed) FeedXml() interface{} {
+	return f
+}
+
+// SetArchive marks the feed an archive document.
+func (f *Feed) SetArchive() {
+	f.Archive = &struct{}{}
+}
+
+// AddLink adds a link to another document of the feed, like the next
+// page or an archive.
+func (f *Feed) AddLink(rel, href string) {
+	f.Links = append(f.Links, feeds.AtomLink{Href: href, Rel: rel, Type: "application/atom+xml"})
+}
+
+// Podcast describes a feed document.
+type Podcast struct {
+	Title string
+	// URL of the document.
+	Link        string
+	Author      string
+	Description string
+	// Image of the podcast, none if empty.
+	Logo      string
+	Generator *Generator
+}
+
+// Episode is an episode of a feed, with the URLs of its files.
+type Episode struct {
+	Title       string
+	Description string
+	Published   time.Time
+	// Audio file, the enclosure of the entry.
+	URL      string
+	MimeType string
+	Size     int64
+	// Duration, unknown if 0.
+	Duration time.Duration
+	// itunes:episode and itunes:season, none if 0.
+	Number int
+	Season int
+	// Explicit content, itunes:explicit.
+	Explicit bool
+	// Chapters document and image, none if empty.
+	Chapters string
+	Image    string
+	// Transcripts, and the audio at other bitrates including the
+	// enclosure, if any.
+	Transcripts []*Transcript
+	Alternates  []*AlternateEnclosure
+}
+
+// SetEpisodes sets the podcast and its episodes, in the order given.
+// Links added before are kept.
+func (f *Feed) SetEpisodes(p Podcast, episodes []Episode) {
+	feed := &feeds.Feed{
+		Title:       p.Title,
+		Link:        &feeds.Link{Href: p.Link},
+		Description: p.Description,
+	}
+	if p.Author != "" {
+		feed.Author = &feeds.Author{Name: p.Author}
+	}
+	for _, ep := range episodes {
+		feed.Add(&feeds.Item{
+			Title:       ep.Title,
+			Link:        &feeds.Link{Href: ep.URL},
+			Description: ep.Description,
+			Updated:     ep.Published,
+			Created:     ep.Published,
+			Enclosure:   &feeds.Enclosure{Url: ep.URL, Length: strconv.FormatInt(ep.Size, 10), Type: ep.MimeType},
+		})
+	}
+	f.SetEntries((&feeds.Atom{Feed: feed}).AtomFeed())
+	f.Generator = p.Generator
+	if p.Logo != "" {
+		f.Logo, f.Image = p.Logo, &Image{Href: p.Logo}
+	}
+	for i, ep := range episodes {
+		e := f.Entries[i]
+		e.Episode, e.Season, e.EpisodeType = ep.Number, ep.Season, "full"
+		if ep.Explicit {
+			e.Explicit = "true"
+		}
+		if ep.Duration > 0 {
+			e.Duration = strconv.Itoa(int(ep.Duration.Round(time.Second).Seconds()))
+		}
+		if ep.Chapters != "" {
+			e.Chapters = &Chapters{URL: ep.Chapters, Type: "application/json+chapters"}
+		}
+		if ep.Image != "" {
+			e.Image = &Image{Href: ep.Image}
+		}
+		e.Transcripts, e.Alternates = ep.Transcripts, ep.Alternates
+	}
+}
+
+// Page returns the bounds of a page, from 1, of n items in pages of
+// size, and the number of pages, at least 1. ok is false if there is no
+// such page.
+func Page(n, size, page int) (start, end, pages int, ok bool) {
+	pages = (n + size - 1) / size
+	if pages == 0 {
+		pages = 1
+	}
+	if page < 1 || page > pages {
+		return 0, 0, pages, false
+	}
+	start, end = (page-1)*size, page*size
+	if end > n {
+		end = n
+	}
+	return start, end, pages, true
+}
+
+// AddPageLinks adds the links of a page of a paged feed, see RFC 5005,
+// with the URLs pageURL returns for page numbers.
+func (f *Feed) AddPageLinks(page, pages int, pageURL func(page int) string) {
+	f.AddLink("first", pageURL(1))
+	if page > 1 {
+		f.AddLink("previous", pageURL(page-1))
+	}
+	if page < pages {
+		f.AddLink("next", pageURL(page+1))
+	}
+	f.AddLink("last", pageURL(pages))
+}
+
+// WriteFeed writes the feed as the response and returns the error of a
+// failed generation. It is generated to a buffer first, so a failure is
+// an error response rather than a truncated feed.
+func WriteFeed(w http.ResponseWriter, f *Feed) error {
+	buf := &bytes.Buffer{}
+	if err := feeds.WriteXML(f, buf); err != nil {
+		http.Error(w, "feed generation failed", http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	buf.WriteTo(w)
+	return nil
+}
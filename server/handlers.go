@@ -0,0 +1,119 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"compress/gzip"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(status int) {
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", http.DetectContentType(b))
+	}
+	return w.gz.Write(b)
+}
+
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+		if strings.TrimSpace(name) == "gzip" && strings.ReplaceAll(params, " ", "") != "q=0" {
+			return true
+		}
+	}
+	return false
+}
+
+// Gzip compresses responses of next for clients accepting gzip.
+func Gzip(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(r) || r.Method == http.MethodHead {
+			next(w, r)
+			return
+		}
+		gz := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(gz)
+		gz.Reset(w)
+		defer gz.Close()
+		w.Header().Set("Content-Encoding", "gzip")
+		// The compressed body differs from the identity one, a strong
+		// tag set for that holds for it only as a weak one.
+		if etag := w.Header().Get("ETag"); strings.HasPrefix(etag, `"`) {
+			w.Header().Set("ETag", "W/"+etag)
+		}
+		next(&gzipResponseWriter{w, gz}, r)
+	}
+}
+
+// etagMatches compares the tags of an If-None-Match header with etag,
+// weakly as the header requires.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimPrefix(strings.TrimSpace(t), "W/")
+		if t == etag || t == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Conditional answers 304 Not Modified when the client already has the
+// current version of a document, without generating it with next.
+// version returns the entity tag and modification time of the current
+// version.
+func Conditional(version func(r *http.Request) (etag string, modified time.Time), next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		etag, modified := version(r)
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			if etagMatches(inm, etag) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		} else if ims, err := http.ParseTime(r.Header.Get("If-Modified-Since")); err == nil {
+			if !modified.After(ims) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// FilesOnly is a file system without directories, so a file server of
+// it has no directory listings.
+type FilesOnly struct{ http.FileSystem }
+
+func (fs FilesOnly) Open(name string) (http.File, error) {
+	f, err := fs.FileSystem.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi, err := f.Stat(); err != nil || fi.IsDir() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
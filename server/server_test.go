@@ -0,0 +1,204 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/feeds"
+)
+
+func TestWriteFeed(t *testing.T) {
+	published := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	atom := &feeds.Feed{Title: "test", Link: &feeds.Link{Href: "http://lfpod/feed"}, Updated: published}
+	atom.Add(&feeds.Item{Title: "first", Link: &feeds.Link{Href: "http://lfpod/audio/vid00000001.opus"}, Created: published,
+		Enclosure: &feeds.Enclosure{Url: "http://lfpod/audio/vid00000001.opus", Length: "100", Type: "audio/opus"}})
+	f := &Feed{}
+	f.AddLink("next", "http://lfpod/feed?page=2")
+	f.SetEntries((&feeds.Atom{Feed: atom}).AtomFeed())
+	f.SetArchive()
+	f.ItunesCategory = NewCategory("Society & Culture/Documentary")
+	f.Entries[0].Duration = "900"
+	f.Entries[0].Alternates = []*AlternateEnclosure{
+		NewAlternateEnclosure("http://lfpod/audio/vid00000001.opus", "audio/opus", 100, 16000, true),
+	}
+	w := httptest.NewRecorder()
+	if err := WriteFeed(w, f); err != nil {
+		t.Fatal(err)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/atom+xml; charset=utf-8" {
+		t.Errorf("content type %s", ct)
+	}
+	doc := w.Body.String()
+	for _, want := range []string{
+		`<link href="http://lfpod/feed?page=2" rel="next" type="application/atom+xml"`,
+		`<archive xmlns="http://purl.org/syndication/history/1.0"></archive>`,
+		`<category xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd" text="Society &amp; Culture">`,
+		`<category xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd" text="Documentary">`,
+		`<duration xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">900</duration>`,
+		`bitrate="16000" title="16 kbit/s" default="true"`,
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("feed lacks %s:\n%s", want, doc)
+		}
+	}
+}
+
+func TestSetEpisodes(t *testing.T) {
+	f := &Feed{}
+	f.AddLink("next", "http://lfpod/feed?page=2")
+	f.SetEpisodes(Podcast{Title: "test", Link: "http://lfpod/feed", Author: "channel", Logo: "http://lfpod/artwork/lfpod.png",
+		Generator: &Generator{Name: "lfpod", Version: "1.0"}}, []Episode{
+		{Title: "first", URL: "http://lfpod/audio/vid00000001.opus", MimeType: "audio/opus", Size: 100,
+			Published: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC), Duration: 899600 * time.Millisecond, Number: 3, Explicit: true,
+			Chapters: "http://lfpod/audio/vid00000001.chapters.json"},
+		{Title: "second", URL: "http://lfpod/audio/vid00000002.opus", MimeType: "audio/opus", Size: 200},
+	})
+	w := httptest.NewRecorder()
+	if err := WriteFeed(w, f); err != nil {
+		t.Fatal(err)
+	}
+	doc := w.Body.String()
+	for _, want := range []string{
+		`<link href="http://lfpod/feed?page=2" rel="next"`,
+		`<name>channel</name>`,
+		`<generator uri="" version="1.0">lfpod</generator>`,
+		`<image xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd" href="http://lfpod/artwork/lfpod.png">`,
+		`<link href="http://lfpod/audio/vid00000001.opus" rel="enclosure" type="audio/opus" length="100">`,
+		`<duration xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">900</duration>`,
+		`<episode xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">3</episode>`,
+		`<explicit xmlns="http://www.itunes.com/dtds/podcast-1.0.dtd">true</explicit>`,
+		`<chapters xmlns="https://podcastindex.org/namespace/1.0" url="http://lfpod/audio/vid00000001.chapters.json" type="application/json+chapters">`,
+	} {
+		if !strings.Contains(doc, want) {
+			t.Errorf("feed lacks %s:\n%s", want, doc)
+		}
+	}
+	if n := strings.Count(doc, "<duration"); n != 1 {
+		t.Errorf("%d durations, want only the known one", n)
+	}
+}
+
+func TestPage(t *testing.T) {
+	for _, tc := range []struct {
+		n, size, page     int
+		start, end, pages int
+		ok                bool
+	}{
+		{0, 10, 1, 0, 0, 1, true},
+		{25, 10, 1, 0, 10, 3, true},
+		{25, 10, 3, 20, 25, 3, true},
+		{25, 10, 4, 0, 0, 3, false},
+		{25, 10, 0, 0, 0, 3, false},
+	} {
+		start, end, pages, ok := Page(tc.n, tc.size, tc.page)
+		if start != tc.start || end != tc.end || pages != tc.pages || ok != tc.ok {
+			t.Errorf("page %d of %d by %d: %d:%d of %d, %v", tc.page, tc.n, tc.size, start, end, pages, ok)
+		}
+	}
+	f := &Feed{}
+	f.AddPageLinks(2, 3, func(page int) string { return "p" + strconv.Itoa(page) })
+	rels := []string{}
+	for _, l := range f.Links {
+		rels = append(rels, l.Rel+"="+l.Href)
+	}
+	if got := strings.Join(rels, " "); got != "first=p1 previous=p1 next=p3 last=p3" {
+		t.Errorf("page links %s", got)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	h := Gzip(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<feed></feed>")
+	})
+	r := httptest.NewRequest(http.MethodGet, "/feed", nil)
+	r.Header.Set("Accept-Encoding", "br, gzip")
+	w := httptest.NewRecorder()
+	w.Header().Set("ETag", `"v1"`)
+	h(w, r)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatal("response not compressed")
+	}
+	if etag := w.Header().Get("ETag"); etag != `W/"v1"` {
+		t.Errorf("compressed response tagged %s", etag)
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(gz); string(body) != "<feed></feed>" {
+		t.Errorf("body %q", body)
+	}
+
+	r.Header.Set("Accept-Encoding", "gzip;q=0")
+	w = httptest.NewRecorder()
+	w.Header().Set("ETag", `"v1"`)
+	h(w, r)
+	if w.Header().Get("Content-Encoding") != "" || w.Body.String() != "<feed></feed>" {
+		t.Error("response compressed for a client refusing gzip")
+	}
+	if etag := w.Header().Get("ETag"); etag != `"v1"` {
+		t.Errorf("identity response tagged %s", etag)
+	}
+}
+
+func TestConditional(t *testing.T) {
+	modified := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	generated := 0
+	h := Conditional(func(r *http.Request) (string, time.Time) {
+		return `W/"v1"`, modified
+	}, func(w http.ResponseWriter, r *http.Request) {
+		generated++
+	})
+	for header, want := range map[string]int{
+		"If-None-Match:W/\"v1\"":                          http.StatusNotModified,
+		"If-None-Match:\"v0\", \"v1\"":                    http.StatusNotModified,
+		"If-None-Match:\"v0\"":                            http.StatusOK,
+		"If-Modified-Since:Sun, 01 Jan 2023 00:00:00 GMT": http.StatusNotModified,
+		"If-Modified-Since:Sat, 31 Dec 2022 00:00:00 GMT": http.StatusOK,
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/feed", nil)
+		name, value, _ := strings.Cut(header, ":")
+		r.Header.Set(name, value)
+		w := httptest.NewRecorder()
+		h(w, r)
+		if w.Code != want {
+			t.Errorf("%s: status %d, want %d", header, w.Code, want)
+		}
+	}
+	if generated != 2 {
+		t.Errorf("generated %d times, want 2", generated)
+	}
+}
+
+func TestFilesOnly(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "UCtest"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "UCtest", "vid00000001.opus"), []byte("audio"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	h := http.FileServer(FilesOnly{FileSystem: http.Dir(dir)})
+	for path, want := range map[string]int{
+		"/UCtest/vid00000001.opus": http.StatusOK,
+		"/UCtest/":                 http.StatusNotFound,
+		"/":                        http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != want {
+			t.Errorf("%s: status %d, want %d", path, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,429 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package store keeps the pipeline status and metadata of videos turned
+// into podcast episodes in an SQLite database, so episodes keep their
+// metadata after leaving the channel feed, and processes sharing the
+// database see each other's changes.
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lfpod/youtube"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	StatusNotReady    = "not ready"
+	StatusDownloading = "downloading"
+	StatusRecoding    = "recoding"
+	StatusReady       = "ready"
+	StatusFailed      = "failed"
+	StatusDeleted     = "deleted"
+)
+
+// Usage is the resource usage of child processes of a pipeline stage.
+type Usage struct {
+	Runs    int     `json:"runs"`
+	WallSec float64 `json:"wall_sec"`
+	UserSec float64 `json:"user_sec"`
+	SysSec  float64 `json:"sys_sec"`
+	MaxRSS  int64   `json:"max_rss"`
+}
+
+func (u Usage) Add(v Usage) Usage {
+	u.Runs += v.Runs
+	u.WallSec += v.WallSec
+	u.UserSec += v.UserSec
+	u.SysSec += v.SysSec
+	if v.MaxRSS > u.MaxRSS {
+		u.MaxRSS = v.MaxRSS
+	}
+	return u
+}
+
+type Episode struct {
+	ChannelId   string           `json:"channel_id"`
+	VideoId     string           `json:"video_id"`
+	Title       string           `json:"title"`
+	Description string           `json:"description,omitempty"`
+	Published   string           `json:"published"`
+	Status      string           `json:"status"`
+	Updated     time.Time        `json:"updated"`
+	Usage       map[string]Usage `json:"usage,omitempty"`
+	// Audio file size and duration in seconds of ready episodes.
+	Size     int64   `json:"size,omitempty"`
+	Duration float64 `json:"duration,omitempty"`
+	// Change sequence number, see Changes.
+	Seq uint64 `json:"seq"`
+	// Added to the feed on its own, not from the channel feed.
+	Added bool `json:"added,omitempty"`
+}
+
+// Store is an episode database.
+type Store struct {
+	// mu serializes the changes of this process, which read an episode
+	// before writing it back.
+	mu sync.Mutex
+	db *sql.DB
+	// Epoch of the sequence numbers, set when the database is created.
+	epoch int64
+}
+
+const schema = `
+CREATE TABLE IF NOT EXISTS episodes (
+	video_id    TEXT PRIMARY KEY,
+	channel_id  TEXT NOT NULL DEFAULT '',
+	title       TEXT NOT NULL DEFAULT '',
+	description TEXT NOT NULL DEFAULT '',
+	published   TEXT NOT NULL DEFAULT '',
+	status      TEXT NOT NULL DEFAULT '',
+	updated     INTEGER NOT NULL DEFAULT 0,
+	usage       TEXT NOT NULL DEFAULT '',
+	size        INTEGER NOT NULL DEFAULT 0,
+	duration    REAL NOT NULL DEFAULT 0,
+	seq         INTEGER NOT NULL DEFAULT 0,
+	added       INTEGER NOT NULL DEFAULT 0
+);
+CREATE INDEX IF NOT EXISTS episodes_channel ON episodes (channel_id, published);
+CREATE INDEX IF NOT EXISTS episodes_seq ON episodes (seq);
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+const columns = `video_id, channel_id, title, description, published, status, updated, usage, size, duration, seq, added`
+
+// Open opens the database in file, creating it if needed. The file
+// ":memory:" is a database in memory.
+func Open(ctx context.Context, file string) (*Store, error) {
+	// The path is escaped, SQLite takes ?, # and % in file URIs for
+	// delimiters and escapes.
+	dsn := "file:" + (&url.URL{Path: file}).EscapedPath() + "?_busy_timeout=10000&_journal_mode=WAL&_synchronous=NORMAL"
+	if file == ":memory:" {
+		dsn = file
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection, an in-memory database exists once per
+	// connection, and SQLite serializes writers anyway.
+	db.SetMaxOpenConns(1)
+	s := &Store{db: db}
+	if err := s.init(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Store) init(ctx context.Context) error {
+	if _, err := s.db.ExecContext(ctx, schema); err != nil {
+		return err
+	}
+	epoch := strconv.FormatInt(time.Now().Unix(), 10)
+	if _, err := s.db.ExecContext(ctx, `INSERT OR IGNORE INTO meta (key, value) VALUES ('epoch', ?)`, epoch); err != nil {
+		return err
+	}
+	if err := s.db.QueryRowContext(ctx, `SELECT value FROM meta WHERE key = 'epoch'`).Scan(&epoch); err != nil {
+		return err
+	}
+	s.epoch, _ = strconv.ParseInt(epoch, 10, 64)
+	return nil
+}
+
+// Close closes the database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Epoch returns the epoch of the sequence numbers, which restart when
+// the database is created anew.
+func (s *Store) Epoch() int64 {
+	return s.epoch
+}
+
+// Snapshot writes a consistent copy of the database to file, which
+// must not exist.
+func (s *Store) Snapshot(ctx context.Context, file string) error {
+	_, err := s.db.ExecContext(ctx, `VACUUM INTO ?`, file)
+	return err
+}
+
+type scanner interface {
+	Scan(dest ...any) error
+}
+
+func scan(row scanner) (Episode, error) {
+	ep := Episode{}
+	var updated int64
+	var usage string
+	err := row.Scan(&ep.VideoId, &ep.ChannelId, &ep.Title, &ep.Description, &ep.Published, &ep.Status,
+		&updated, &usage, &ep.Size, &ep.Duration, &ep.Seq, &ep.Added)
+	if err != nil {
+		return ep, err
+	}
+	if updated != 0 {
+		ep.Updated = time.UnixMilli(updated)
+	}
+	if usage != "" {
+		if err := json.Unmarshal([]byte(usage), &ep.Usage); err != nil {
+			return ep, err
+		}
+	}
+	return ep, nil
+}
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+func put(ctx context.Context, db execer, ep Episode) error {
+	var updated int64
+	if !ep.Updated.IsZero() {
+		updated = ep.Updated.UnixMilli()
+	}
+	usage := ""
+	if len(ep.Usage) > 0 {
+		data, err := json.Marshal(ep.Usage)
+		if err != nil {
+			return err
+		}
+		usage = string(data)
+	}
+	_, err := db.ExecContext(ctx, `INSERT OR REPLACE INTO episodes (`+columns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		ep.VideoId, ep.ChannelId, ep.Title, ep.Description, ep.Published, ep.Status,
+		updated, usage, ep.Size, ep.Duration, ep.Seq, ep.Added)
+	return err
+}
+
+// Get returns a stored episode, and false if the video is unknown.
+func (s *Store) Get(ctx context.Context, videoId string) (Episode, bool, error) {
+	ep, err := scan(s.db.QueryRowContext(ctx, `SELECT `+columns+` FROM episodes WHERE video_id = ?`, videoId))
+	if errors.Is(err, sql.ErrNoRows) {
+		return Episode{VideoId: videoId}, false, nil
+	}
+	return ep, err == nil, err
+}
+
+// Put stores an episode as is, replacing a stored one.
+func (s *Store) Put(ctx context.Context, ep Episode) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return put(ctx, s.db, ep)
+}
+
+// Delete drops an episode from the store.
+func (s *Store) Delete(ctx context.Context, videoId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.db.ExecContext(ctx, `DELETE FROM episodes WHERE video_id = ?`, videoId)
+	return err
+}
+
+// update changes a stored episode, or a new one if the video is
+// unknown, with change. It is not stored if change returns false.
+func (s *Store) update(ctx context.Context, videoId string, change func(ep *Episode) (bool, error)) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ep, _, err := s.Get(ctx, videoId)
+	if err != nil {
+		return err
+	}
+	if ok, err := change(&ep); !ok || err != nil {
+		return err
+	}
+	return put(ctx, s.db, ep)
+}
+
+// query returns the episodes selected by a query of all columns.
+func (s *Store) query(ctx context.Context, query string, args ...any) ([]Episode, error) {
+	list := []Episode{}
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return list, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ep, err := scan(rows)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, ep)
+	}
+	return list, rows.Err()
+}
+
+// changed gives an episode the next sequence number and a status.
+func (s *Store) changed(ctx context.Context, ep *Episode, status string) error {
+	if err := s.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) + 1 FROM episodes`).Scan(&ep.Seq); err != nil {
+		return err
+	}
+	ep.Status = status
+	ep.Updated = time.Now()
+	return nil
+}
+
+// SetStatus records the status of the video of a channel feed entry
+// and its metadata. Only changes get a new sequence number.
+func (s *Store) SetStatus(ctx context.Context, channelId string, entry *youtube.Entry, status string) error {
+	return s.update(ctx, entry.VideoId, func(ep *Episode) (bool, error) {
+		description := ep.Description
+		if entry.Media != nil {
+			description = entry.Media.Description
+		}
+		if ep.ChannelId == channelId && ep.Title == entry.Title && ep.Description == description &&
+			ep.Published == entry.Published && ep.Status == status {
+			return false, nil
+		}
+		if ep.ChannelId != channelId {
+			ep.Added = false
+		}
+		ep.ChannelId = channelId
+		ep.Title = entry.Title
+		ep.Description = description
+		ep.Published = entry.Published
+		return true, s.changed(ctx, ep, status)
+	})
+}
+
+// SetFile records the audio file size and duration of a ready episode.
+func (s *Store) SetFile(ctx context.Context, videoId string, size int64, duration time.Duration) error {
+	return s.update(ctx, videoId, func(ep *Episode) (bool, error) {
+		ep.Size, ep.Duration = size, duration.Seconds()
+		return true, nil
+	})
+}
+
+// SetAdded records a video added to the feed of a channel on its own.
+func (s *Store) SetAdded(ctx context.Context, channelId, videoId string) error {
+	return s.update(ctx, videoId, func(ep *Episode) (bool, error) {
+		ep.ChannelId, ep.Added = channelId, true
+		if ep.Status == "" {
+			return true, s.changed(ctx, ep, StatusNotReady)
+		}
+		return true, nil
+	})
+}
+
+// Added returns the videos added to the feed of a channel on their own.
+func (s *Store) Added(ctx context.Context, channelId string) (map[string]bool, error) {
+	added := map[string]bool{}
+	rows, err := s.db.QueryContext(ctx, `SELECT video_id FROM episodes WHERE added AND channel_id = ?`, channelId)
+	if err != nil {
+		return added, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var videoId string
+		if err := rows.Scan(&videoId); err != nil {
+			return added, err
+		}
+		added[videoId] = true
+	}
+	return added, rows.Err()
+}
+
+// SetDeleted marks the audio file of a known episode deleted.
+func (s *Store) SetDeleted(ctx context.Context, videoId string) error {
+	return s.update(ctx, videoId, func(ep *Episode) (bool, error) {
+		if ep.ChannelId == "" || ep.Status == StatusDeleted {
+			return false, nil
+		}
+		return true, s.changed(ctx, ep, StatusDeleted)
+	})
+}
+
+// AddUsage accounts child process resource usage of a pipeline stage.
+func (s *Store) AddUsage(ctx context.Context, videoId, stage string, usage Usage) error {
+	return s.update(ctx, videoId, func(ep *Episode) (bool, error) {
+		if ep.Usage == nil {
+			ep.Usage = map[string]Usage{}
+		}
+		ep.Usage[stage] = ep.Usage[stage].Add(usage)
+		return true, nil
+	})
+}
+
+// Changes returns episodes changed after sequence number since in the
+// order of changes, and the current epoch and sequence number.
+func (s *Store) Changes(ctx context.Context, since uint64) ([]Episode, int64, uint64, error) {
+	// The episodes and the sequence number are read in one transaction,
+	// so no change falls between them.
+	tx, err := s.db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer tx.Rollback()
+	var seq uint64
+	if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) FROM episodes`).Scan(&seq); err != nil {
+		return nil, 0, 0, err
+	}
+	list := []Episode{}
+	rows, err := tx.QueryContext(ctx, `SELECT `+columns+` FROM episodes WHERE channel_id != '' AND seq > ? ORDER BY seq`, since)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		ep, err := scan(rows)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		ep.Usage = nil
+		list = append(list, ep)
+	}
+	return list, s.epoch, seq, rows.Err()
+}
+
+// List returns episodes of a channel, or of all channels if channelId
+// is empty, newest first.
+func (s *Store) List(ctx context.Context, channelId string) ([]Episode, error) {
+	list, err := s.query(ctx, `SELECT `+columns+` FROM episodes WHERE channel_id != '' AND (? = '' OR channel_id = ?)
+		ORDER BY published DESC`, channelId, channelId)
+	for i := range list {
+		if list[i].Usage == nil {
+			list[i].Usage = map[string]Usage{}
+		}
+	}
+	return list, err
+}
+
+// Prune drops episodes that are not ready and unchanged since before,
+// unless keep returns true for them, and returns how many. Videos only
+// known by their resource usage are never ready.
+func (s *Store) Prune(ctx context.Context, before time.Time, keep func(Episode) bool) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stale, err := s.query(ctx, `SELECT `+columns+` FROM episodes WHERE status != ? AND updated < ?`,
+		StatusReady, before.UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	pruned := 0
+	for _, ep := range stale {
+		if keep(ep) {
+			continue
+		}
+		// Another process may have changed it meanwhile.
+		res, err := s.db.ExecContext(ctx, `DELETE FROM episodes WHERE video_id = ? AND status != ? AND updated < ?`,
+			ep.VideoId, StatusReady, before.UnixMilli())
+		if err != nil {
+			return pruned, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			pruned++
+		}
+	}
+	return pruned, nil
+}
@@ -0,0 +1,146 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package store
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lfpod/youtube"
+)
+
+const testChannelId = "UCtest"
+
+func openTest(t *testing.T, file string) *Store {
+	s, err := Open(context.Background(), file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestStatusChanges(t *testing.T) {
+	ctx := context.Background()
+	s := openTest(t, ":memory:")
+	entry := &youtube.Entry{VideoId: "vid00000001", Title: "first", Published: "2023-01-01T00:00:00Z",
+		Media: &youtube.Media{Description: "about"}}
+	for _, status := range []string{StatusNotReady, StatusNotReady, StatusDownloading, StatusReady} {
+		if err := s.SetStatus(ctx, testChannelId, entry, status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetFile(ctx, entry.VideoId, 100, 90*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	ep, ok, err := s.Get(ctx, entry.VideoId)
+	if err != nil || !ok {
+		t.Fatal("episode not stored", err)
+	}
+	if ep.Status != StatusReady || ep.Description != "about" || ep.Size != 100 || ep.Duration != 90 || ep.Seq != 3 {
+		t.Errorf("stored %+v", ep)
+	}
+
+	changes, epoch, seq, err := s.Changes(ctx, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 1 || changes[0].VideoId != entry.VideoId || seq != 3 || epoch != s.Epoch() {
+		t.Errorf("changes %+v up to %d", changes, seq)
+	}
+	if err := s.SetDeleted(ctx, "unknown"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, _ := s.Get(ctx, "unknown"); ok {
+		t.Error("unknown video marked deleted")
+	}
+}
+
+func TestPrune(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	old := now.Add(-time.Hour)
+	s := openTest(t, ":memory:")
+	for _, ep := range []Episode{
+		{VideoId: "ready", ChannelId: testChannelId, Status: StatusReady, Updated: old},
+		{VideoId: "failed", ChannelId: testChannelId, Status: StatusFailed, Updated: old},
+		{VideoId: "recent", ChannelId: testChannelId, Status: StatusNotReady, Updated: now},
+		{VideoId: "current", ChannelId: testChannelId, Status: StatusNotReady, Updated: old},
+		{VideoId: "probed", Usage: map[string]Usage{"probe": {}}},
+	} {
+		if err := s.Put(ctx, ep); err != nil {
+			t.Fatal(err)
+		}
+	}
+	n, err := s.Prune(ctx, now.Add(-time.Minute), func(ep Episode) bool { return ep.VideoId == "current" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("pruned %d episodes, want 2", n)
+	}
+	for _, videoId := range []string{"ready", "recent", "current"} {
+		if _, ok, _ := s.Get(ctx, videoId); !ok {
+			t.Errorf("%s pruned", videoId)
+		}
+	}
+}
+
+func TestReopen(t *testing.T) {
+	ctx := context.Background()
+	// Characters of URI syntax are taken for part of the name.
+	file := filepath.Join(t.TempDir(), "episodes?v=1#a%20b.db")
+	s, err := Open(ctx, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &youtube.Entry{VideoId: "vid00000001", Title: "first", Published: "2023-01-01T00:00:00Z"}
+	if err := s.SetStatus(ctx, testChannelId, entry, StatusReady); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddUsage(ctx, entry.VideoId, "download", Usage{Runs: 1, UserSec: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetDeleted(ctx, entry.VideoId); err != nil {
+		t.Fatal(err)
+	}
+	epoch := s.Epoch()
+	s.Close()
+	if _, err := os.Stat(file); err != nil {
+		t.Fatal(err)
+	}
+
+	// Episodes, sequence numbers and the epoch survive a restart.
+	reopened := openTest(t, file)
+	ep, ok, err := reopened.Get(ctx, entry.VideoId)
+	if err != nil || !ok || ep.Status != StatusDeleted || ep.Seq != 2 || ep.Usage["download"].Runs != 1 {
+		t.Errorf("reopened episode %+v, %v", ep, err)
+	}
+	if reopened.Epoch() != epoch {
+		t.Errorf("epoch %d, want %d", reopened.Epoch(), epoch)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	ctx := context.Background()
+	s := openTest(t, filepath.Join(t.TempDir(), "episodes.db"))
+	if err := s.SetAdded(ctx, testChannelId, "vid00000001"); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "copy.db")
+	if err := s.Snapshot(ctx, file); err != nil {
+		t.Fatal(err)
+	}
+	copied := openTest(t, file)
+	added, err := copied.Added(ctx, testChannelId)
+	if err != nil || !added["vid00000001"] {
+		t.Errorf("snapshot has added %v: %v", added, err)
+	}
+	if copied.Epoch() != s.Epoch() {
+		t.Error("snapshot epoch differs")
+	}
+}
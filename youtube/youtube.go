@@ -0,0 +1,250 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+// Package youtube reads the RSS feeds of YouTube channels, the recent
+// videos lfpod turns into podcast episodes.
+package youtube
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FeedURL is the address of channel feeds, followed by the channel id.
+const FeedURL = "https://www.youtube.com/feeds/videos.xml?channel_id="
+
+// ChannelIdRegexp matches channel ids.
+var ChannelIdRegexp = regexp.MustCompile(`UC[A-Za-z0-9_-]{22}`)
+
+type Media struct {
+	XMLName     xml.Name `xml:"group"`
+	Description string   `xml:"description"`
+	Thumbnail   struct {
+		URL string `xml:"url,attr"`
+	} `xml:"thumbnail"`
+}
+
+// Entry is a video of a channel feed.
+type Entry struct {
+	XMLName   xml.Name `xml:"entry"`
+	Title     string   `xml:"title"`
+	VideoId   string   `xml:"videoId"`
+	Published string   `xml:"published"`
+	Media     *Media   `xml:"group"`
+	// Video duration, known from the YouTube Data API only.
+	Duration time.Duration `xml:"-"`
+}
+
+// Author is the channel a feed belongs to.
+type Author struct {
+	Name string `xml:"name"`
+	URI  string `xml:"uri"`
+}
+
+// Feed is a channel feed, the 15 most recent videos of the channel.
+type Feed struct {
+	XMLName xml.Name `xml:"feed"`
+	Title   string   `xml:"title"`
+	Author  Author   `xml:"author"`
+	Entries []*Entry `xml:"entry"`
+}
+
+// ParseFeed parses a channel feed document.
+func ParseFeed(data []byte) (*Feed, error) {
+	feed := &Feed{}
+	if err := xml.Unmarshal(data, feed); err != nil {
+		return nil, err
+	}
+	return feed, nil
+}
+
+// Filter returns the feed with the entries whose title contains any of
+// keywords, ignoring case, or all of them if keywords is nil.
+func (f Feed) Filter(keywords []string) Feed {
+	if keywords == nil {
+		return f
+	}
+	filtered := Feed{Title: f.Title, Author: f.Author}
+	for _, entry := range f.Entries {
+		if MatchKeywords(entry.Title, keywords) {
+			filtered.Entries = append(filtered.Entries, entry)
+		}
+	}
+	return filtered
+}
+
+// MatchKeywords reports whether title contains any of keywords,
+// ignoring case.
+func MatchKeywords(title string, keywords []string) bool {
+	tl := strings.ToLower(title)
+	for _, k := range keywords {
+		if strings.Contains(tl, strings.ToLower(k)) {
+			return true
+		}
+	}
+	return false
+}
+
+var isoDurationRegexp = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// ParseDuration parses Data API durations like PT1H2M3S, 0 if invalid.
+func ParseDuration(s string) time.Duration {
+	m := isoDurationRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return 0
+	}
+	var d time.Duration
+	for i, unit := range []time.Duration{24 * time.Hour, time.Hour, time.Minute, time.Second} {
+		n, _ := strconv.Atoi(m[i+1])
+		d += time.Duration(n) * unit
+	}
+	return d
+}
+
+// Client reads channel feeds, retrying requests failed with network
+// errors or server errors.
+type Client struct {
+	// HTTPClient makes the requests, http.DefaultClient if nil.
+	HTTPClient *http.Client
+	// BaseURL is FeedURL if empty.
+	BaseURL string
+	// Failed requests are retried this many times, waiting Backoff and
+	// twice as long before each next attempt.
+	Retries int
+	Backoff time.Duration
+	// Logf, if not nil, logs failures before retrying.
+	Logf func(format string, args ...interface{})
+	// Header is sent with every request, e.g. a User-Agent.
+	Header http.Header
+}
+
+// Validators identify a version of a feed document for conditional
+// requests.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// ErrNotModified is returned by ReadFeedIfChanged if the feed did not
+// change since the version read before.
+var ErrNotModified = errors.New("feed not modified")
+
+// ErrRateLimited is matched by the errors of requests YouTube answered
+// with 429 Too Many Requests.
+var ErrRateLimited = errors.New("rate limited")
+
+// RateLimitError is a request answered with 429 Too Many Requests.
+type RateLimitError struct {
+	// Delay asked for by Retry-After, 0 if none.
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	if e.RetryAfter > 0 {
+		return "rate limited, retry after " + e.RetryAfter.String()
+	}
+	return "rate limited"
+}
+
+func (e *RateLimitError) Is(target error) bool {
+	return target == ErrRateLimited
+}
+
+// retryAfter parses a Retry-After header in seconds or as HTTP date.
+func retryAfter(h string, now time.Time) time.Duration {
+	if s, err := strconv.Atoi(h); err == nil && s > 0 {
+		return time.Duration(s) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// ReadFeed returns the feed document of a channel.
+func (c *Client) ReadFeed(ctx context.Context, channelId string) ([]byte, error) {
+	data, _, err := c.ReadFeedIfChanged(ctx, channelId, Validators{})
+	return data, err
+}
+
+// ReadFeedIfChanged returns the feed document of a channel and its
+// validators, or ErrNotModified if it is the version of prev.
+func (c *Client) ReadFeedIfChanged(ctx context.Context, channelId string, prev Validators) ([]byte, Validators, error) {
+	for attempt := 0; ; attempt++ {
+		data, v, transient, err := c.readFeed(ctx, channelId, prev)
+		if err == nil || !transient || attempt >= c.Retries {
+			return data, v, err
+		}
+		backoff := c.Backoff << attempt
+		if c.Logf != nil {
+			c.Logf("%s feed: %v, retrying in %s", channelId, err, backoff)
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, Validators{}, ctx.Err()
+		}
+	}
+}
+
+// Channel returns the parsed feed of a channel.
+func (c *Client) Channel(ctx context.Context, channelId string) (*Feed, error) {
+	data, err := c.ReadFeed(ctx, channelId)
+	if err != nil {
+		return nil, err
+	}
+	return ParseFeed(data)
+}
+
+// readFeed reads a channel feed, it reports whether a failure may be
+// transient.
+func (c *Client) readFeed(ctx context.Context, channelId string, prev Validators) ([]byte, Validators, bool, error) {
+	base := c.BaseURL
+	if base == "" {
+		base = FeedURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, base+channelId, nil)
+	if err != nil {
+		return nil, Validators{}, false, err
+	}
+	for name, values := range c.Header {
+		req.Header[name] = append([]string(nil), values...)
+	}
+	if prev.ETag != "" {
+		req.Header.Set("If-None-Match", prev.ETag)
+	}
+	if prev.LastModified != "" {
+		req.Header.Set("If-Modified-Since", prev.LastModified)
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, Validators{}, ctx.Err() == nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotModified {
+		return nil, prev, false, ErrNotModified
+	}
+	if res.StatusCode == http.StatusTooManyRequests {
+		// Retrying right away makes it worse, the caller backs off.
+		return nil, Validators{}, false, &RateLimitError{RetryAfter: retryAfter(res.Header.Get("Retry-After"), time.Now())}
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, Validators{}, res.StatusCode >= 500, errors.New("server response status " + res.Status)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, Validators{}, true, err
+	}
+	return body, Validators{ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}, false, nil
+}
@@ -0,0 +1,214 @@
+// Copyright 2023 Mikhail Gruzdev <michail.gruzdev@gmail.com>
+// SPDX-License-Identifier: Apache-2.0
+
+package youtube
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testFeed = `<?xml version="1.0" encoding="UTF-8"?>
+<feed xmlns:yt="http://www.youtube.com/xml/schemas/2015" xmlns:media="http://search.yahoo.com/mrss/" xmlns="http://www.w3.org/2005/Atom">
+ <title>Test channel</title>
+ <entry>
+  <yt:videoId>vid00000001</yt:videoId>
+  <title>Daily News</title>
+  <published>2023-05-02T10:00:00+00:00</published>
+  <media:group>
+   <media:description>First episode</media:description>
+   <media:thumbnail url="https://i.ytimg.com/vi/vid00000001/hqdefault.jpg" width="480" height="360"/>
+  </media:group>
+ </entry>
+ <entry>
+  <yt:videoId>vid00000002</yt:videoId>
+  <title>Weekly review</title>
+  <published>2023-05-01T10:00:00+00:00</published>
+ </entry>
+</feed>
+`
+
+func TestParseFeed(t *testing.T) {
+	feed, err := ParseFeed([]byte(testFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if feed.Title != "Test channel" || len(feed.Entries) != 2 {
+		t.Fatalf("feed %q with %d entries", feed.Title, len(feed.Entries))
+	}
+	e := feed.Entries[0]
+	if e.VideoId != "vid00000001" || e.Title != "Daily News" || e.Published != "2023-05-02T10:00:00+00:00" {
+		t.Errorf("entry %+v", e)
+	}
+	if e.Media == nil || e.Media.Description != "First episode" || e.Media.Thumbnail.URL == "" {
+		t.Errorf("media %+v", e.Media)
+	}
+	if _, err := ParseFeed([]byte("<feed><entry>")); err == nil {
+		t.Error("truncated feed parsed")
+	}
+}
+
+func TestFilter(t *testing.T) {
+	feed, err := ParseFeed([]byte(testFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		keywords []string
+		want     int
+	}{
+		{nil, 2},
+		{[]string{}, 0},
+		{[]string{"news"}, 1},
+		{[]string{"NEWS", "review"}, 2},
+		{[]string{"sports"}, 0},
+	} {
+		filtered := feed.Filter(tt.keywords)
+		if len(filtered.Entries) != tt.want || filtered.Title != feed.Title {
+			t.Errorf("Filter(%q): %d entries, want %d", tt.keywords, len(filtered.Entries), tt.want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"PT1H2M3S": time.Hour + 2*time.Minute + 3*time.Second,
+		"PT15M":    15 * time.Minute,
+		"P1DT1S":   24*time.Hour + time.Second,
+		"P0D":      0,
+		"1:02:03":  0,
+		"":         0,
+	} {
+		if got := ParseDuration(s); got != want {
+			t.Errorf("ParseDuration(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestChannelIdRegexp(t *testing.T) {
+	if id := ChannelIdRegexp.FindString("https://www.youtube.com/channel/UCWjEiMNZv4g3P9BWbrtMjyA/videos"); id != "UCWjEiMNZv4g3P9BWbrtMjyA" {
+		t.Errorf("channel id %q", id)
+	}
+	if ChannelIdRegexp.MatchString("https://www.youtube.com/@handle") {
+		t.Error("handle matched as channel id")
+	}
+}
+
+func TestClientReadFeed(t *testing.T) {
+	var mu sync.Mutex
+	failures := map[string]int{"UCflaky": 2, "UCdown": 10}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.FormValue("channel_id")
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case id == "UCmissing":
+			http.NotFound(w, r)
+		case failures[id] > 0:
+			failures[id]--
+			http.Error(w, "overloaded", http.StatusServiceUnavailable)
+		default:
+			io.WriteString(w, testFeed)
+		}
+	}))
+	defer server.Close()
+	c := &Client{BaseURL: server.URL + "/?channel_id=", Retries: 2, Backoff: time.Millisecond}
+
+	feed, err := c.Channel(context.Background(), "UCflaky")
+	if err != nil || len(feed.Entries) != 2 {
+		t.Errorf("flaky channel: %v", err)
+	}
+	if _, err := c.ReadFeed(context.Background(), "UCdown"); err == nil {
+		t.Error("channel down read")
+	}
+	if failures["UCdown"] != 7 {
+		t.Errorf("%d requests to a channel down, want 3", 10-failures["UCdown"])
+	}
+	if _, err := c.ReadFeed(context.Background(), "UCmissing"); err == nil {
+		t.Error("missing channel read")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.ReadFeed(ctx, "UCflaky"); err == nil {
+		t.Error("canceled read succeeded")
+	}
+}
+
+func TestClientHeader(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.UserAgent() != "Mozilla/5.0 (test)" || r.Header.Get("Accept-Language") != "en-US" {
+			http.Error(w, "unexpected headers", http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, testFeed)
+	}))
+	defer server.Close()
+	c := &Client{BaseURL: server.URL + "/?channel_id=", Header: http.Header{
+		"User-Agent":      {"Mozilla/5.0 (test)"},
+		"Accept-Language": {"en-US"},
+	}}
+	if _, err := c.ReadFeed(context.Background(), "UCtest"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestClientReadFeedIfChanged(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Last-Modified", "Tue, 02 May 2023 10:00:00 GMT")
+		io.WriteString(w, testFeed)
+	}))
+	defer server.Close()
+	c := &Client{BaseURL: server.URL + "/?channel_id="}
+
+	data, v, err := c.ReadFeedIfChanged(context.Background(), "UCtest", Validators{})
+	if err != nil || string(data) != testFeed {
+		t.Fatalf("first read: %v", err)
+	}
+	if v.ETag != `"v1"` || v.LastModified == "" {
+		t.Errorf("validators %+v", v)
+	}
+	data, v2, err := c.ReadFeedIfChanged(context.Background(), "UCtest", v)
+	if !errors.Is(err, ErrNotModified) || data != nil || v2 != v {
+		t.Errorf("conditional read: %v, %d bytes", err, len(data))
+	}
+	if requests != 2 {
+		t.Errorf("%d requests, want 2", requests)
+	}
+}
+
+func TestClientRateLimited(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "120")
+		http.Error(w, "slow down", http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+	c := &Client{BaseURL: server.URL + "/?channel_id=", Retries: 2, Backoff: time.Millisecond}
+
+	_, err := c.ReadFeed(context.Background(), "UCtest")
+	var rl *RateLimitError
+	if !errors.Is(err, ErrRateLimited) || !errors.As(err, &rl) {
+		t.Fatalf("error %v, want rate limited", err)
+	}
+	if rl.RetryAfter != 2*time.Minute {
+		t.Errorf("retry after %s, want 2m", rl.RetryAfter)
+	}
+	if requests != 1 {
+		t.Errorf("%d requests, want 1, rate limits are not retried", requests)
+	}
+}